@@ -0,0 +1,196 @@
+package v1
+
+import (
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"gouse/internal/service"
+	"strconv"
+)
+
+// ListRoles 获取全部角色
+func ListRoles(c *gin.Context) {
+	rsp := &HttpResponse{}
+	roles, err := service.ListRoles(serviceContext(c))
+	if err != nil {
+		rsp.ResponseWithServiceError(c, CodeRoleErr, err)
+		return
+	}
+	rsp.ResponseWithData(c, roles)
+}
+
+// AssignUserRole 给用户分配角色
+func AssignUserRole(c *gin.Context) {
+	req := &service.AssignRoleRequest{}
+	rsp := &HttpResponse{}
+
+	if err := c.ShouldBindJSON(req); err != nil {
+		log.Errorf("bind assign role request json err %v", err)
+		rsp.ResponseWithError(c, CodeBodyBindErr, err.Error())
+		return
+	}
+
+	// 路径里的用户 ID
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		rsp.ResponseWithError(c, CodeParamErr, "invalid user id")
+		return
+	}
+	req.UserID = userID
+
+	if err := service.AssignUserRole(serviceContext(c), req); err != nil {
+		rsp.ResponseWithServiceError(c, CodeRoleErr, err)
+		return
+	}
+	rsp.ResponseSuccess(c)
+}
+
+// RemoveUserRole 回收用户的角色
+func RemoveUserRole(c *gin.Context) {
+	rsp := &HttpResponse{}
+
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		rsp.ResponseWithError(c, CodeParamErr, "invalid user id")
+		return
+	}
+	req := &service.AssignRoleRequest{
+		UserID: userID,
+		Role:   c.Param("role"),
+	}
+
+	if err := service.RemoveUserRole(serviceContext(c), req); err != nil {
+		rsp.ResponseWithServiceError(c, CodeRoleErr, err)
+		return
+	}
+	rsp.ResponseSuccess(c)
+}
+
+// ListUsers 管理员查询用户列表
+func ListUsers(c *gin.Context) {
+	req := &service.ListUsersRequest{}
+	rsp := &HttpResponse{}
+
+	// 过滤条件和游标都在查询参数里
+	if err := c.ShouldBindQuery(req); err != nil {
+		log.Errorf("bind list users request query err %v", err)
+		rsp.ResponseWithError(c, CodeBodyBindErr, err.Error())
+		return
+	}
+
+	users, err := service.ListUsers(serviceContext(c), req)
+	if err != nil {
+		rsp.ResponseWithServiceError(c, CodeAdminUserErr, err)
+		return
+	}
+	rsp.ResponseWithData(c, users)
+}
+
+// GetUser 管理员查看单个用户
+func GetUser(c *gin.Context) {
+	rsp := &HttpResponse{}
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		rsp.ResponseWithError(c, CodeParamErr, "invalid user id")
+		return
+	}
+
+	user, err := service.GetUser(serviceContext(c), userID)
+	if err != nil {
+		rsp.ResponseWithServiceError(c, CodeAdminUserErr, err)
+		return
+	}
+	rsp.ResponseWithData(c, user)
+}
+
+// DisableUser 管理员禁用用户
+func DisableUser(c *gin.Context) {
+	setUserDisabled(c, true)
+}
+
+// EnableUser 管理员启用用户
+func EnableUser(c *gin.Context) {
+	setUserDisabled(c, false)
+}
+
+func setUserDisabled(c *gin.Context, disabled bool) {
+	rsp := &HttpResponse{}
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		rsp.ResponseWithError(c, CodeParamErr, "invalid user id")
+		return
+	}
+
+	if err := service.SetUserDisabled(serviceContext(c), userID, disabled); err != nil {
+		rsp.ResponseWithServiceError(c, CodeAdminUserErr, err)
+		return
+	}
+	rsp.ResponseSuccess(c)
+}
+
+// DeleteUser 管理员删除用户（软删除）
+func DeleteUser(c *gin.Context) {
+	rsp := &HttpResponse{}
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		rsp.ResponseWithError(c, CodeParamErr, "invalid user id")
+		return
+	}
+
+	if err := service.DeleteUser(serviceContext(c), userID); err != nil {
+		rsp.ResponseWithServiceError(c, CodeAdminUserErr, err)
+		return
+	}
+	rsp.ResponseSuccess(c)
+}
+
+// UnlockUser 管理员解除用户的登录锁定
+func UnlockUser(c *gin.Context) {
+	rsp := &HttpResponse{}
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		rsp.ResponseWithError(c, CodeParamErr, "invalid user id")
+		return
+	}
+
+	if err := service.UnlockUser(serviceContext(c), userID); err != nil {
+		rsp.ResponseWithServiceError(c, CodeAdminUserErr, err)
+		return
+	}
+	rsp.ResponseSuccess(c)
+}
+
+// ResetUserMFA 管理员关闭用户的两步验证
+func ResetUserMFA(c *gin.Context) {
+	rsp := &HttpResponse{}
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		rsp.ResponseWithError(c, CodeParamErr, "invalid user id")
+		return
+	}
+
+	if err := service.ResetUserMFA(serviceContext(c), userID); err != nil {
+		rsp.ResponseWithServiceError(c, CodeAdminUserErr, err)
+		return
+	}
+	rsp.ResponseSuccess(c)
+}
+
+// IssueTempPassword 管理员给用户设置临时密码，用户登录后必须先修改密码
+// 临时密码的明文只在这次响应里返回，需要通过其他渠道交给用户
+func IssueTempPassword(c *gin.Context) {
+	rsp := &HttpResponse{}
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		rsp.ResponseWithError(c, CodeParamErr, "invalid user id")
+		return
+	}
+
+	result, err := service.IssueTempPassword(serviceContext(c), userID)
+	if err != nil {
+		rsp.ResponseWithServiceError(c, CodeAdminUserErr, err)
+		return
+	}
+	// 响应里有密码明文，不能被缓存
+	c.Header("Cache-Control", "no-store")
+	rsp.ResponseWithData(c, result)
+}
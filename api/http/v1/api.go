@@ -7,6 +7,7 @@ import (
 	"github.com/gin-gonic/gin"
 	log "github.com/sirupsen/logrus"
 	"gouse/config"
+	"gouse/internal/model"
 	"gouse/internal/service"
 	"gouse/pkg/constant"
 	"gouse/utils"
@@ -57,8 +58,8 @@ func Register(c *gin.Context) {
 
 	// 如果没有解析错误，则调用名为 Register 的服务函数处理注册业务逻辑。
 	// 如果处理过程中发生错误，将错误信息通过 rsp.ResponseWithError 方法返回给客户端。
-	if err := service.Register(req); err != nil {
-		rsp.ResponseWithError(c, CodeRegisterErr, err.Error())
+	if err := service.Register(serviceContext(c), req); err != nil {
+		rsp.ResponseWithServiceError(c, CodeRegisterErr, err)
 		return
 	}
 
@@ -88,39 +89,53 @@ func Login(c *gin.Context) {
 	// 这里使用用户名和当前时间拼接后进行 MD5 哈希算法生成
 	uuid := utils.Md5String(req.UserName + time.Now().GoString())
 
-	// 将生成的 uuid 存入上下文（context）中，以便后续使用
+	// 将生成的 uuid 和客户端 IP 存入上下文（context）中，以便后续使用
 	ctx := context.WithValue(context.Background(), "uuid", uuid)
+	ctx = context.WithValue(ctx, constant.ClientIPKey, c.ClientIP())
+	ctx = context.WithValue(ctx, constant.UserAgentKey, c.Request.UserAgent())
 
-	// 输出登录的开始日志，记录用户名和密码
-	log.Infof("loggin start,user:%s, password:%s", req.UserName, req.PassWord)
+	// 输出登录的开始日志，只记录用户名，密码不能写进日志
+	log.Infof("loggin start,user:%s", req.UserName)
 
 	// 调用 service.Login 函数（检查用户名和密码是否正确）
 	// 如果登录失败，将返回错误信息，并使用 rsp 对象构建错误响应
-	session, err := service.Login(ctx, req)
+	result, err := service.Login(ctx, req)
 	if err != nil {
-		rsp.ResponseWithError(c, CodeLoginErr, err.Error())
+		rsp.ResponseWithServiceError(c, CodeLoginErr, err)
 		return
 	}
 
-	// 登陆成功，使用 c.SetCookie 函数设置一个名为 constant.SessionKey 的 Cookie。下面是函数参数介绍：
-	// SessionKey 是 cookie 的名称; session 是登录成功生成的 session 值; CookieExpire 决定了 cookie 的有效期
-	// “/” 是 cookie 的路径（表示该 Cookie 对所有路径都有效）; "" 是 cookie 的域名（空字符串表示该 Cookie 对所有域名都有效）
-	// false 是指定该 Cookie 只能通过 HTTP 协议传输，不能通过 JavaScript 访问; true 是指定该 Cookie 在安全的 HTTPS 连接中也会被传输
-	c.SetCookie(constant.SessionKey, session, constant.CookieExpire, "/", "", false, true)
+	// 开启了两步验证，还没有创建会话，把 mfa_token 返回给客户端，等用户输入验证码
+	if result.MFARequired {
+		rsp.ResponseWithData(c, result)
+		return
+	}
 
-	// 如果注册逻辑执行成功，
+	loginResponse(c, rsp, result)
+}
+
+// 登录成功：有 session 就设置 cookie，有 token 就在 data 里返回
+func loginResponse(c *gin.Context, rsp *HttpResponse, result *service.LoginResult) {
+	if result.Session != "" {
+		SetSessionCookie(c, result.Session, result.SessionExpired)
+	}
+	// 需要先修改密码的，把标记连同 token 一起返回，客户端据此跳转到修改密码页面
+	if result.PasswordChangeRequired {
+		rsp.ResponseWithData(c, result)
+		return
+	}
+	if result.AccessToken != "" {
+		rsp.ResponseWithData(c, result.TokenResponse)
+		return
+	}
+
+	// 如果登录逻辑执行成功，
 	// 调用 rsp.ResponseSuccess 方法返回一个表示成功的 HTTP 响应给客户端
 	rsp.ResponseSuccess(c)
 }
 
 // Logout 登出
 func Logout(c *gin.Context) {
-	// 获取请求中的名为 SessionKey 的 cookie 值，并赋值给 session 变量
-	session, _ := c.Cookie(constant.SessionKey)
-
-	// 创建一个 SessionKey 和 session 的键值对到上下文中
-	ctx := context.WithValue(context.Background(), constant.SessionKey, session)
-
 	// req 存放登出请求的结构体对象指针
 	req := &service.LogoutRequest{}
 
@@ -135,20 +150,17 @@ func Logout(c *gin.Context) {
 		return
 	}
 
-	// 生成一个唯一的 uuid
-	uuid := utils.Md5String(req.UserName + time.Now().GoString())
-
-	// 更新上下文对象，把 uuid 存储进去（这样上下文就存在两个键值对了）
-	ctx = context.WithValue(ctx, "uuid", uuid)
+	// 组装上下文，里面有 uuid、session 和当前登录用户
+	ctx := serviceContext(c)
 
 	// 实现 Logout() 登出操作的具体逻辑
 	if err := service.Logout(ctx, req); err != nil {
-		rsp.ResponseWithError(c, CodeLogoutErr, err.Error())
+		rsp.ResponseWithServiceError(c, CodeLogoutErr, err)
 		return
 	}
 
-	// 设置一个过期时间为负值的 Cookie，实现删除客户端浏览器中存储的会话标识的目的，即实现用户的登出操作
-	c.SetCookie(constant.SessionKey, session, -1, "/", "", false, true)
+	// 删除客户端浏览器中存储的会话标识，即实现用户的登出操作
+	clearSessionCookie(c)
 	rsp.ResponseSuccess(c)
 }
 
@@ -157,12 +169,6 @@ func GetUserInfo(c *gin.Context) {
 	// 从 HTTP 请求的查询参数中获取用户名 userName
 	userName := c.Query("username")
 
-	// 从 HTTP 请求中获取会话标识 session
-	session, _ := c.Cookie(constant.SessionKey)
-
-	// 创建了个上下文，往里面存了个键值对
-	ctx := context.WithValue(context.Background(), constant.SessionKey, session)
-
 	// req 存的是获取用户请求的结构体对象，顺带初始化了 UserName 字段
 	req := &service.GetUserInfoRequest{
 		UserName: userName,
@@ -171,16 +177,13 @@ func GetUserInfo(c *gin.Context) {
 	// rsp 存储 HTTP请求的响应信息
 	rsp := &HttpResponse{}
 
-	// 生成一个唯一的 uuid
-	uuid := utils.Md5String(req.UserName + time.Now().GoString())
-
-	// 把 uuid 也存进上下文
-	ctx = context.WithValue(ctx, "uuid", uuid)
+	// 组装上下文，里面有 uuid、session 和当前登录用户
+	ctx := serviceContext(c)
 
-	// 从缓存中获取用户信息
+	// 获取用户信息
 	userInfo, err := service.GetUserInfo(ctx, req)
 	if err != nil {
-		rsp.ResponseWithError(c, CodeGetUserInfoErr, err.Error())
+		rsp.ResponseWithServiceError(c, CodeGetUserInfoErr, err)
 		return
 	}
 
@@ -204,21 +207,137 @@ func UpdateNickName(c *gin.Context) {
 		return
 	}
 
-	// 获取 Cookie 值
-	session, _ := c.Cookie(constant.SessionKey)
-	log.Infof("UpdateNickName|session=%s", session)
-
-	// 创建了个上下文，往里面存了个键值对
-	ctx := context.WithValue(context.Background(), constant.SessionKey, session)
-
-	// 生成唯一的 uuid，也存进上下文中
-	uuid := utils.Md5String(req.UserName + time.Now().GoString())
-	ctx = context.WithValue(ctx, "uuid", uuid)
+	// 组装上下文，里面有 uuid、session 和当前登录用户
+	ctx := serviceContext(c)
 
 	// 更改用户信息
 	if err := service.UpdateUserNickName(ctx, req); err != nil {
-		rsp.ResponseWithError(c, CodeUpdateUserInfoErr, err.Error())
+		rsp.ResponseWithServiceError(c, CodeUpdateUserInfoErr, err)
+		return
+	}
+	rsp.ResponseSuccess(c)
+}
+
+// ChangePassword 修改密码
+func ChangePassword(c *gin.Context) {
+	req := &service.ChangePasswordRequest{}
+	rsp := &HttpResponse{}
+
+	if err := c.ShouldBindJSON(req); err != nil {
+		log.Errorf("bind change password request json err %v", err)
+		rsp.ResponseWithError(c, CodeBodyBindErr, err.Error())
+		return
+	}
+
+	keep, err := service.ChangePassword(serviceContext(c), req)
+	if err != nil {
+		rsp.ResponseWithServiceError(c, CodeChangePasswordErr, err)
 		return
 	}
+
+	// 当前会话没有保留时，顺带删除客户端的 cookie
+	if !keep {
+		clearSessionCookie(c)
+	}
 	rsp.ResponseSuccess(c)
 }
+
+// ForgotPassword 申请找回密码
+func ForgotPassword(c *gin.Context) {
+	req := &service.ForgotPasswordRequest{}
+	rsp := &HttpResponse{}
+
+	if err := c.ShouldBindJSON(req); err != nil {
+		log.Errorf("bind forgot password request json err %v", err)
+		rsp.ResponseWithError(c, CodeBodyBindErr, err.Error())
+		return
+	}
+
+	if err := service.ForgotPassword(serviceContext(c), req); err != nil {
+		rsp.ResponseWithServiceError(c, CodeForgotPasswordErr, err)
+		return
+	}
+	rsp.ResponseSuccess(c)
+}
+
+// ResetPassword 通过重置链接设置新密码
+func ResetPassword(c *gin.Context) {
+	req := &service.ResetPasswordRequest{}
+	rsp := &HttpResponse{}
+
+	if err := c.ShouldBindJSON(req); err != nil {
+		log.Errorf("bind reset password request json err %v", err)
+		rsp.ResponseWithError(c, CodeBodyBindErr, err.Error())
+		return
+	}
+
+	if err := service.ResetPassword(serviceContext(c), req); err != nil {
+		rsp.ResponseWithServiceError(c, CodeResetPasswordErr, err)
+		return
+	}
+	rsp.ResponseSuccess(c)
+}
+
+// RefreshToken 用 refresh token 换发新的 token
+func RefreshToken(c *gin.Context) {
+	req := &service.RefreshTokenRequest{}
+	rsp := &HttpResponse{}
+
+	if err := c.ShouldBindJSON(req); err != nil {
+		log.Errorf("bind refresh token request json err %v", err)
+		rsp.ResponseWithError(c, CodeBodyBindErr, err.Error())
+		return
+	}
+
+	tokens, err := service.RefreshToken(serviceContext(c), req)
+	if err != nil {
+		rsp.ResponseWithServiceError(c, CodeTokenErr, err)
+		return
+	}
+	rsp.ResponseWithData(c, tokens)
+}
+
+// RevokeToken 吊销 refresh token 以及同一次登录签发的全部 token
+func RevokeToken(c *gin.Context) {
+	req := &service.RefreshTokenRequest{}
+	rsp := &HttpResponse{}
+
+	if err := c.ShouldBindJSON(req); err != nil {
+		log.Errorf("bind revoke token request json err %v", err)
+		rsp.ResponseWithError(c, CodeBodyBindErr, err.Error())
+		return
+	}
+
+	if err := service.RevokeToken(serviceContext(c), req); err != nil {
+		rsp.ResponseWithServiceError(c, CodeTokenErr, err)
+		return
+	}
+	rsp.ResponseSuccess(c)
+}
+
+// serviceContext 组装传给 service 层的上下文
+// 认证中间件校验通过后，会把 session（或者 Bearer token 所属的家族）和当前登录用户存进 gin 上下文，这里连同请求 uuid 一起传下去，
+// service 层只信任上下文里的用户，不信任请求参数里的用户名
+func serviceContext(c *gin.Context) context.Context {
+	ctx := context.Background()
+	name := ""
+	if session, ok := c.Get(constant.SessionKey); ok {
+		ctx = context.WithValue(ctx, constant.SessionKey, session)
+	}
+	if family, ok := c.Get(constant.TokenFamilyKey); ok {
+		ctx = context.WithValue(ctx, constant.TokenFamilyKey, family)
+	}
+	if keyID, ok := c.Get(constant.APIKeyIDKey); ok {
+		ctx = context.WithValue(ctx, constant.APIKeyIDKey, keyID)
+	}
+	if user, ok := c.Get(constant.AuthUserKey); ok {
+		ctx = context.WithValue(ctx, constant.AuthUserKey, user)
+		name = user.(*model.User).Name
+	}
+
+	// 生成一个唯一的 uuid，也存进上下文中
+	uuid := utils.Md5String(name + time.Now().GoString())
+	ctx = context.WithValue(ctx, constant.ClientIPKey, c.ClientIP())
+	ctx = context.WithValue(ctx, constant.UserAgentKey, c.Request.UserAgent())
+	return context.WithValue(ctx, constant.ReqUuid, uuid)
+}
@@ -0,0 +1,109 @@
+package v1
+
+import (
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"gouse/internal/service"
+	"strconv"
+)
+
+// CreateAPIKey 当前用户创建 API key，key 只在这次返回
+func CreateAPIKey(c *gin.Context) {
+	req := &service.CreateAPIKeyRequest{}
+	rsp := &HttpResponse{}
+	if err := c.ShouldBindJSON(req); err != nil {
+		log.Errorf("bind create api key request json err %v", err)
+		rsp.ResponseWithError(c, CodeBodyBindErr, err.Error())
+		return
+	}
+
+	result, err := service.CreateAPIKey(serviceContext(c), req)
+	if err != nil {
+		rsp.ResponseWithServiceError(c, CodeAPIKeyErr, err)
+		return
+	}
+	rsp.ResponseWithData(c, result)
+}
+
+// ListAPIKeys 当前用户的 API key 列表
+func ListAPIKeys(c *gin.Context) {
+	rsp := &HttpResponse{}
+	keys, err := service.ListAPIKeys(serviceContext(c))
+	if err != nil {
+		rsp.ResponseWithServiceError(c, CodeAPIKeyErr, err)
+		return
+	}
+	rsp.ResponseWithData(c, keys)
+}
+
+// RevokeAPIKey 当前用户吊销自己的 API key
+func RevokeAPIKey(c *gin.Context) {
+	rsp := &HttpResponse{}
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		rsp.ResponseWithError(c, CodeParamErr, "invalid api key id")
+		return
+	}
+
+	if err := service.RevokeAPIKey(serviceContext(c), id); err != nil {
+		rsp.ResponseWithServiceError(c, CodeAPIKeyErr, err)
+		return
+	}
+	rsp.ResponseSuccess(c)
+}
+
+// AdminCreateAPIKey 管理员给指定用户创建 API key
+func AdminCreateAPIKey(c *gin.Context) {
+	req := &service.CreateAPIKeyRequest{}
+	rsp := &HttpResponse{}
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		rsp.ResponseWithError(c, CodeParamErr, "invalid user id")
+		return
+	}
+	if err := c.ShouldBindJSON(req); err != nil {
+		log.Errorf("bind create api key request json err %v", err)
+		rsp.ResponseWithError(c, CodeBodyBindErr, err.Error())
+		return
+	}
+
+	result, err := service.AdminCreateAPIKey(serviceContext(c), userID, req)
+	if err != nil {
+		rsp.ResponseWithServiceError(c, CodeAPIKeyErr, err)
+		return
+	}
+	rsp.ResponseWithData(c, result)
+}
+
+// AdminListAPIKeys 管理员查看指定用户的 API key
+func AdminListAPIKeys(c *gin.Context) {
+	rsp := &HttpResponse{}
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		rsp.ResponseWithError(c, CodeParamErr, "invalid user id")
+		return
+	}
+
+	keys, err := service.AdminListAPIKeys(serviceContext(c), userID)
+	if err != nil {
+		rsp.ResponseWithServiceError(c, CodeAPIKeyErr, err)
+		return
+	}
+	rsp.ResponseWithData(c, keys)
+}
+
+// AdminRevokeAPIKey 管理员吊销任意用户的 API key
+func AdminRevokeAPIKey(c *gin.Context) {
+	rsp := &HttpResponse{}
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		rsp.ResponseWithError(c, CodeParamErr, "invalid api key id")
+		return
+	}
+
+	if err := service.AdminRevokeAPIKey(serviceContext(c), id); err != nil {
+		rsp.ResponseWithServiceError(c, CodeAPIKeyErr, err)
+		return
+	}
+	rsp.ResponseSuccess(c)
+}
@@ -0,0 +1,99 @@
+package v1
+
+import (
+	"github.com/gin-gonic/gin"
+	"gouse/config"
+	"gouse/pkg/constant"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// __Host- 前缀，带这个前缀的 cookie 浏览器只接受 secure、path 为 / 并且没有 domain 的
+const hostCookiePrefix = "__Host-"
+
+// cookie 策略：按配置和运行模式算出来的 cookie 属性
+type cookiePolicy struct {
+	prefix   string
+	domain   string
+	path     string
+	secure   bool
+	sameSite http.SameSite
+}
+
+// 读取配置里的 cookie 属性，没有填写的按运行模式取默认值
+func getCookiePolicy() *cookiePolicy {
+	conf := config.GetGlobalConf()
+	cookieConf := conf.Cookie
+	policy := &cookiePolicy{
+		domain:   cookieConf.Domain,
+		path:     cookieConf.Path,
+		secure:   conf.AppConfig.RunMode == "release",
+		sameSite: http.SameSiteLaxMode,
+	}
+	if cookieConf.Secure != nil {
+		policy.secure = *cookieConf.Secure
+	}
+	if policy.path == "" {
+		policy.path = "/"
+	}
+	switch strings.ToLower(cookieConf.SameSite) {
+	case "strict":
+		policy.sameSite = http.SameSiteStrictMode
+	case "none":
+		// SameSite=None 的 cookie 浏览器要求必须是 secure 的
+		policy.sameSite = http.SameSiteNoneMode
+		policy.secure = true
+	}
+	if cookieConf.HostPrefix {
+		policy.prefix = hostCookiePrefix
+		policy.domain, policy.path, policy.secure = "", "/", true
+	}
+	return policy
+}
+
+// SessionCookieName 会话 cookie 的名字，包括 __Host- 前缀
+func SessionCookieName() string {
+	name := config.GetGlobalConf().Cookie.Name
+	if name == "" {
+		name = constant.SessionKey
+	}
+	return getCookiePolicy().prefix + name
+}
+
+// CSRFCookieName 防 CSRF 的 token 所在 cookie 的名字，包括 __Host- 前缀
+func CSRFCookieName() string {
+	return getCookiePolicy().prefix + constant.CSRFCookie
+}
+
+// SetSessionCookie 登录成功或者会话续期后设置会话 cookie，有效期和会话剩余的有效期一致
+// 会话 cookie 设置 HttpOnly，页面脚本读不到，其余属性按 cookie 策略
+func SetSessionCookie(c *gin.Context, session string, expired time.Duration) {
+	setCookie(c, SessionCookieName(), session, int(expired.Seconds()), true)
+}
+
+// 设置一个过期时间为负值的 Cookie，删除客户端浏览器中存储的会话标识
+func clearSessionCookie(c *gin.Context) {
+	setCookie(c, SessionCookieName(), "", -1, true)
+}
+
+// SetCSRFCookie 下发防 CSRF 的 token，页面里的脚本要读取它放进请求头，所以不能设置 HttpOnly
+// 有效期为 0 表示浏览器关闭后失效，下次访问时重新生成
+func SetCSRFCookie(c *gin.Context, token string) {
+	setCookie(c, CSRFCookieName(), token, 0, false)
+}
+
+// 按 cookie 策略写 cookie，gin 的 c.SetCookie 没法单独设置 SameSite，这里直接用 http.SetCookie
+func setCookie(c *gin.Context, name, value string, maxAge int, httpOnly bool) {
+	policy := getCookiePolicy()
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		MaxAge:   maxAge,
+		Path:     policy.path,
+		Domain:   policy.domain,
+		Secure:   policy.secure,
+		HttpOnly: httpOnly,
+		SameSite: policy.sameSite,
+	})
+}
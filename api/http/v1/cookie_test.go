@@ -0,0 +1,67 @@
+package v1
+
+import (
+	"gouse/config"
+	"net/http"
+	"testing"
+)
+
+func TestGetCookiePolicy(t *testing.T) {
+	yes, no := true, false
+	cases := []struct {
+		name    string
+		runMode string
+		cookie  config.CookieConf
+		want    cookiePolicy
+	}{
+		{name: "dev defaults", runMode: "dev",
+			want: cookiePolicy{path: "/", secure: false, sameSite: http.SameSiteLaxMode}},
+		{name: "release defaults", runMode: "release",
+			want: cookiePolicy{path: "/", secure: true, sameSite: http.SameSiteLaxMode}},
+		{name: "release secure off", runMode: "release", cookie: config.CookieConf{Secure: &no},
+			want: cookiePolicy{path: "/", secure: false, sameSite: http.SameSiteLaxMode}},
+		{name: "dev secure on", runMode: "dev", cookie: config.CookieConf{Secure: &yes},
+			want: cookiePolicy{path: "/", secure: true, sameSite: http.SameSiteLaxMode}},
+		{name: "domain and path", runMode: "dev", cookie: config.CookieConf{Domain: "example.com", Path: "/app"},
+			want: cookiePolicy{domain: "example.com", path: "/app", sameSite: http.SameSiteLaxMode}},
+		{name: "samesite strict", runMode: "dev", cookie: config.CookieConf{SameSite: "Strict"},
+			want: cookiePolicy{path: "/", sameSite: http.SameSiteStrictMode}},
+		{name: "samesite none forces secure", runMode: "dev", cookie: config.CookieConf{SameSite: "none", Secure: &no},
+			want: cookiePolicy{path: "/", secure: true, sameSite: http.SameSiteNoneMode}},
+		{name: "unknown samesite falls back to lax", runMode: "release", cookie: config.CookieConf{SameSite: "bogus"},
+			want: cookiePolicy{path: "/", secure: true, sameSite: http.SameSiteLaxMode}},
+		{name: "host prefix in dev", runMode: "dev", cookie: config.CookieConf{HostPrefix: true},
+			want: cookiePolicy{prefix: hostCookiePrefix, path: "/", secure: true, sameSite: http.SameSiteLaxMode}},
+		{name: "host prefix drops domain, path and insecure", runMode: "release",
+			cookie: config.CookieConf{HostPrefix: true, Domain: "example.com", Path: "/app", Secure: &no, SameSite: "strict"},
+			want:   cookiePolicy{prefix: hostCookiePrefix, path: "/", secure: true, sameSite: http.SameSiteStrictMode}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			conf := &config.GlobalConfig{}
+			conf.AppConfig.RunMode = tc.runMode
+			conf.Cookie = tc.cookie
+			config.SetGlobalConf(conf)
+			if got := getCookiePolicy(); *got != tc.want {
+				t.Errorf("getCookiePolicy() = %+v, want %+v", *got, tc.want)
+			}
+		})
+	}
+}
+
+func TestCookieNames(t *testing.T) {
+	conf := &config.GlobalConfig{}
+	conf.Cookie = config.CookieConf{Name: "sid", HostPrefix: true}
+	config.SetGlobalConf(conf)
+	if got := SessionCookieName(); got != "__Host-sid" {
+		t.Errorf("SessionCookieName() = %q, want __Host-sid", got)
+	}
+	if got := CSRFCookieName(); got != "__Host-csrf_token" {
+		t.Errorf("CSRFCookieName() = %q, want __Host-csrf_token", got)
+	}
+
+	config.SetGlobalConf(&config.GlobalConfig{})
+	if got := SessionCookieName(); got != "user_session" {
+		t.Errorf("SessionCookieName() = %q, want user_session", got)
+	}
+}
@@ -0,0 +1,64 @@
+package v1
+
+import (
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"gouse/internal/service"
+)
+
+// GetEmail 查看当前用户的邮箱以及是否已经验证
+func GetEmail(c *gin.Context) {
+	rsp := &HttpResponse{}
+	info, err := service.GetEmail(serviceContext(c))
+	if err != nil {
+		rsp.ResponseWithServiceError(c, CodeEmailErr, err)
+		return
+	}
+	rsp.ResponseWithData(c, info)
+}
+
+// ChangeEmail 修改邮箱，验证邮件发到新邮箱
+func ChangeEmail(c *gin.Context) {
+	req := &service.ChangeEmailRequest{}
+	rsp := &HttpResponse{}
+
+	if err := c.ShouldBindJSON(req); err != nil {
+		log.Errorf("bind change email request json err %v", err)
+		rsp.ResponseWithError(c, CodeBodyBindErr, err.Error())
+		return
+	}
+
+	if err := service.ChangeEmail(serviceContext(c), req); err != nil {
+		rsp.ResponseWithServiceError(c, CodeEmailErr, err)
+		return
+	}
+	rsp.ResponseSuccess(c)
+}
+
+// ResendEmailVerification 重新发送验证邮件
+func ResendEmailVerification(c *gin.Context) {
+	rsp := &HttpResponse{}
+	if err := service.ResendEmailVerification(serviceContext(c)); err != nil {
+		rsp.ResponseWithServiceError(c, CodeEmailErr, err)
+		return
+	}
+	rsp.ResponseSuccess(c)
+}
+
+// VerifyEmail 通过验证邮件里的链接完成邮箱验证
+func VerifyEmail(c *gin.Context) {
+	req := &service.VerifyEmailRequest{}
+	rsp := &HttpResponse{}
+
+	if err := c.ShouldBindJSON(req); err != nil {
+		log.Errorf("bind verify email request json err %v", err)
+		rsp.ResponseWithError(c, CodeBodyBindErr, err.Error())
+		return
+	}
+
+	if err := service.VerifyEmail(serviceContext(c), req); err != nil {
+		rsp.ResponseWithServiceError(c, CodeEmailErr, err)
+		return
+	}
+	rsp.ResponseSuccess(c)
+}
@@ -1,8 +1,14 @@
 package v1
 
 import (
+	"errors"
 	"github.com/gin-gonic/gin"
+	"gouse/internal/authz"
+	"gouse/internal/service"
+	"gouse/pkg/redact"
+	"math"
 	"net/http"
+	"strconv"
 )
 
 // 全局常量，用于设置错误码
@@ -15,6 +21,33 @@ const (
 	CodeLogoutErr         ErrCode = 10004 // 登出错误
 	CodeGetUserInfoErr    ErrCode = 10005 // 获取用户信息错误
 	CodeUpdateUserInfoErr ErrCode = 10006 // 更新用户信息错误
+	CodeUnauthorized      ErrCode = 10007 // 未登录或者登录已失效
+	CodeSessionErr        ErrCode = 10008 // 会话校验出错
+	CodeForbidden         ErrCode = 10009 // 无权操作其他用户的数据
+	CodeRoleErr           ErrCode = 10010 // 角色管理错误
+	CodeUserDisabled      ErrCode = 10011 // 账号已被禁用
+	CodeUserNotFound      ErrCode = 10012 // 用户不存在
+	CodeAdminUserErr      ErrCode = 10013 // 用户管理错误
+	CodeChangePasswordErr ErrCode = 10014 // 修改密码错误
+	CodeWrongPassword     ErrCode = 10015 // 密码不正确
+	CodePasswordPolicy    ErrCode = 10016 // 新密码不符合密码策略
+	CodeForgotPasswordErr ErrCode = 10017 // 申请找回密码错误
+	CodeResetPasswordErr  ErrCode = 10018 // 重置密码错误
+	CodeInvalidToken      ErrCode = 10019 // 一次性 token 无效或已过期
+	CodeAccountLocked     ErrCode = 10020 // 登录失败次数过多，已被临时锁定
+	CodeMFAErr            ErrCode = 10021 // 两步验证设置错误
+	CodeInvalidMFACode    ErrCode = 10022 // 验证码或恢复码不正确
+	CodeMFAStateErr       ErrCode = 10023 // 两步验证已经开启或者还没有开启
+	CodeTokenErr          ErrCode = 10024 // 刷新、吊销 token 错误
+	CodeOAuthErr          ErrCode = 10025 // OAuth 授权请求不合法
+	CodeOAuthClientErr    ErrCode = 10026 // OAuth 接入应用管理错误
+	CodeAPIKeyErr         ErrCode = 10027 // API key 管理错误
+	CodeCSRFErr           ErrCode = 10028 // 缺少防 CSRF 的 token 或者 token 不正确
+	CodeEmailErr          ErrCode = 10029 // 邮箱设置、验证错误
+	CodeMagicLinkErr      ErrCode = 10030 // 免密码登录错误
+	CodePhoneErr          ErrCode = 10031 // 手机号绑定、短信验证码错误
+	CodePasswordExpired   ErrCode = 10032 // 临时密码已经过期
+	CodePasswordChange    ErrCode = 10033 // 需要先修改密码，修改之前只能访问修改密码和登出接口
 )
 
 type (
@@ -40,6 +73,72 @@ func (rsp *HttpResponse) ResponseWithError(c *gin.Context, code ErrCode, msg str
 	c.JSON(http.StatusInternalServerError, rsp)
 }
 
+// ResponseWithStatus 返回指定 HTTP 状态码的错误响应，例如 401 未登录
+func (rsp *HttpResponse) ResponseWithStatus(c *gin.Context, status int, code ErrCode, msg string) {
+	rsp.Code = code
+	rsp.Msg = msg
+	c.JSON(status, rsp)
+}
+
+// ResponseWithServiceError 根据 service 层返回的错误选择错误码和 HTTP 状态码
+// 能识别的错误（例如越权）使用专门的错误码，其余的使用调用方传入的 code
+func (rsp *HttpResponse) ResponseWithServiceError(c *gin.Context, code ErrCode, err error) {
+	switch {
+	case errors.Is(err, authz.ErrForbidden):
+		rsp.ResponseWithStatus(c, http.StatusForbidden, CodeForbidden, err.Error())
+	case errors.Is(err, service.ErrUserDisabled):
+		rsp.ResponseWithStatus(c, http.StatusForbidden, CodeUserDisabled, err.Error())
+	case errors.Is(err, service.ErrUserNotFound):
+		rsp.ResponseWithStatus(c, http.StatusNotFound, CodeUserNotFound, err.Error())
+	case errors.Is(err, service.ErrWrongPassword):
+		rsp.ResponseWithError(c, CodeWrongPassword, err.Error())
+	case errors.Is(err, service.ErrPasswordPolicy):
+		// 违反的全部规则放在 data 里，客户端可以逐条提示
+		var policy *service.PasswordPolicyError
+		if errors.As(err, &policy) {
+			rsp.Data = policy.Violations
+		}
+		rsp.ResponseWithStatus(c, http.StatusBadRequest, CodePasswordPolicy, err.Error())
+	case errors.Is(err, service.ErrInvalidToken):
+		rsp.ResponseWithStatus(c, http.StatusBadRequest, CodeInvalidToken, err.Error())
+	case errors.Is(err, service.ErrInvalidMFACode):
+		rsp.ResponseWithStatus(c, http.StatusUnauthorized, CodeInvalidMFACode, err.Error())
+	case errors.Is(err, service.ErrMFAAlreadyEnabled), errors.Is(err, service.ErrMFANotEnabled):
+		rsp.ResponseWithStatus(c, http.StatusConflict, CodeMFAStateErr, err.Error())
+	case errors.Is(err, service.ErrSessionNotFound):
+		rsp.ResponseWithStatus(c, http.StatusNotFound, CodeSessionErr, err.Error())
+	case errors.Is(err, service.ErrAPIKeyNotFound):
+		rsp.ResponseWithStatus(c, http.StatusNotFound, CodeAPIKeyErr, err.Error())
+	case errors.Is(err, service.ErrAPIKeyInvalid):
+		rsp.ResponseWithStatus(c, http.StatusBadRequest, CodeAPIKeyErr, err.Error())
+	case errors.Is(err, service.ErrEmailInvalid):
+		rsp.ResponseWithStatus(c, http.StatusBadRequest, CodeEmailErr, err.Error())
+	case errors.Is(err, service.ErrEmailTaken):
+		rsp.ResponseWithStatus(c, http.StatusConflict, CodeEmailErr, err.Error())
+	case errors.Is(err, service.ErrEmailThrottled):
+		rsp.ResponseWithStatus(c, http.StatusTooManyRequests, CodeEmailErr, err.Error())
+	case errors.Is(err, service.ErrPhoneInvalid):
+		rsp.ResponseWithStatus(c, http.StatusBadRequest, CodePhoneErr, err.Error())
+	case errors.Is(err, service.ErrPhoneTaken):
+		rsp.ResponseWithStatus(c, http.StatusConflict, CodePhoneErr, err.Error())
+	case errors.Is(err, service.ErrPasswordExpired):
+		rsp.ResponseWithStatus(c, http.StatusUnauthorized, CodePasswordExpired, err.Error())
+	case errors.Is(err, service.ErrTooManyRequests):
+		rsp.ResponseWithStatus(c, http.StatusTooManyRequests, code, err.Error())
+	case errors.As(err, new(*service.OAuthError)):
+		rsp.ResponseWithStatus(c, http.StatusBadRequest, CodeOAuthErr, err.Error())
+	case errors.Is(err, service.ErrAccountLocked):
+		// 告诉客户端多久之后可以重试
+		var locked *service.LockedError
+		if errors.As(err, &locked) {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(locked.RetryAfter.Seconds()))))
+		}
+		rsp.ResponseWithStatus(c, http.StatusTooManyRequests, CodeAccountLocked, err.Error())
+	default:
+		rsp.ResponseWithError(c, code, err.Error())
+	}
+}
+
 func (rsp *HttpResponse) ResponseSuccess(c *gin.Context) {
 	// 将成功的信息填充到 HttpResponse 结构体中的 Code 和 Msg 字段
 	rsp.Code = CodeSuccess
@@ -51,9 +150,10 @@ func (rsp *HttpResponse) ResponseSuccess(c *gin.Context) {
 }
 
 // 这个返回函数给客户端多返回了一个 data 也就是实际的数据（从缓存中获取的用户信息）
+// 带 redact 标签的敏感字段在序列化之前会被替换掉，防止密码之类的数据误返回给客户端
 func (rsp *HttpResponse) ResponseWithData(c *gin.Context, data interface{}) {
 	rsp.Code = CodeSuccess
 	rsp.Msg = "success"
-	rsp.Data = data
+	rsp.Data = redact.Sanitize(data)
 	c.JSON(http.StatusOK, rsp)
 }
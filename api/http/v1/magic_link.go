@@ -0,0 +1,50 @@
+package v1
+
+import (
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"gouse/internal/service"
+)
+
+// RequestMagicLink 申请免密码登录，登录链接和验证码发到用户验证过的邮箱
+func RequestMagicLink(c *gin.Context) {
+	req := &service.MagicLinkRequest{}
+	rsp := &HttpResponse{}
+
+	if err := c.ShouldBindJSON(req); err != nil {
+		log.Errorf("bind magic link request json err %v", err)
+		rsp.ResponseWithError(c, CodeBodyBindErr, err.Error())
+		return
+	}
+
+	if err := service.RequestMagicLink(serviceContext(c), req); err != nil {
+		rsp.ResponseWithServiceError(c, CodeMagicLinkErr, err)
+		return
+	}
+	rsp.ResponseSuccess(c)
+}
+
+// MagicLogin 使用登录链接或者验证码登录，成功后和密码登录一样设置会话 cookie 或者返回 token
+func MagicLogin(c *gin.Context) {
+	req := &service.MagicLoginRequest{}
+	rsp := &HttpResponse{}
+
+	if err := c.ShouldBindJSON(req); err != nil {
+		log.Errorf("bind magic login request json err %v", err)
+		rsp.ResponseWithError(c, CodeBodyBindErr, err.Error())
+		return
+	}
+
+	result, err := service.MagicLogin(serviceContext(c), req)
+	if err != nil {
+		rsp.ResponseWithServiceError(c, CodeMagicLinkErr, err)
+		return
+	}
+
+	// 开启了两步验证，把 mfa_token 返回给客户端，接着调用 /user/login/mfa
+	if result.MFARequired {
+		rsp.ResponseWithData(c, result)
+		return
+	}
+	loginResponse(c, rsp, result)
+}
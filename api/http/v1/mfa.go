@@ -0,0 +1,93 @@
+package v1
+
+import (
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"gouse/internal/service"
+)
+
+// LoginMFA 两步验证登录的第二步，验证码正确后设置会话 cookie 或者返回 token
+func LoginMFA(c *gin.Context) {
+	req := &service.LoginMFARequest{}
+	rsp := &HttpResponse{}
+
+	if err := c.ShouldBindJSON(req); err != nil {
+		log.Errorf("bind login mfa request json err %v", err)
+		rsp.ResponseWithError(c, CodeBodyBindErr, err.Error())
+		return
+	}
+
+	result, err := service.LoginMFA(serviceContext(c), req)
+	if err != nil {
+		rsp.ResponseWithServiceError(c, CodeLoginErr, err)
+		return
+	}
+	loginResponse(c, rsp, result)
+}
+
+// EnrollTOTP 开始绑定 TOTP，返回密钥和 otpauth:// 链接
+func EnrollTOTP(c *gin.Context) {
+	rsp := &HttpResponse{}
+	data, err := service.EnrollTOTP(serviceContext(c))
+	if err != nil {
+		rsp.ResponseWithServiceError(c, CodeMFAErr, err)
+		return
+	}
+	rsp.ResponseWithData(c, data)
+}
+
+// ConfirmTOTP 输入验证码确认绑定，返回恢复码
+func ConfirmTOTP(c *gin.Context) {
+	req := &service.ConfirmTOTPRequest{}
+	rsp := &HttpResponse{}
+
+	if err := c.ShouldBindJSON(req); err != nil {
+		log.Errorf("bind confirm totp request json err %v", err)
+		rsp.ResponseWithError(c, CodeBodyBindErr, err.Error())
+		return
+	}
+
+	data, err := service.ConfirmTOTP(serviceContext(c), req)
+	if err != nil {
+		rsp.ResponseWithServiceError(c, CodeMFAErr, err)
+		return
+	}
+	rsp.ResponseWithData(c, data)
+}
+
+// DisableTOTP 关闭两步验证
+func DisableTOTP(c *gin.Context) {
+	req := &service.DisableTOTPRequest{}
+	rsp := &HttpResponse{}
+
+	if err := c.ShouldBindJSON(req); err != nil {
+		log.Errorf("bind disable totp request json err %v", err)
+		rsp.ResponseWithError(c, CodeBodyBindErr, err.Error())
+		return
+	}
+
+	if err := service.DisableTOTP(serviceContext(c), req); err != nil {
+		rsp.ResponseWithServiceError(c, CodeMFAErr, err)
+		return
+	}
+	rsp.ResponseSuccess(c)
+}
+
+// RegenerateRecoveryCodes 重新生成恢复码
+func RegenerateRecoveryCodes(c *gin.Context) {
+	req := &service.ConfirmTOTPRequest{}
+	rsp := &HttpResponse{}
+
+	if err := c.ShouldBindJSON(req); err != nil {
+		log.Errorf("bind regenerate recovery codes request json err %v", err)
+		rsp.ResponseWithError(c, CodeBodyBindErr, err.Error())
+		return
+	}
+
+	data, err := service.RegenerateRecoveryCodes(serviceContext(c), req)
+	if err != nil {
+		rsp.ResponseWithServiceError(c, CodeMFAErr, err)
+		return
+	}
+	rsp.ResponseWithData(c, data)
+}
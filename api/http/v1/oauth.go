@@ -0,0 +1,218 @@
+package v1
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	log "github.com/sirupsen/logrus"
+	"gouse/internal/service"
+	"gouse/pkg/constant"
+	"net/http"
+	"net/url"
+)
+
+// OAuth 2.0 授权服务的接口
+// /oauth/token、/oauth/revoke、/oauth/introspect 是给接入应用调用的，请求和返回都按 RFC 的格式，不使用 HttpResponse；
+// /oauth/consent 是给授权页调用的，和其他页面接口一样使用 HttpResponse
+
+// OAuthErrorResponse RFC 6749 5.2 的错误返回结构
+type OAuthErrorResponse struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+// OAuthAuthorize 授权入口：校验请求，没有登录时跳转登录页，登录了跳转授权确认页
+func OAuthAuthorize(c *gin.Context) {
+	req := &service.AuthorizeRequest{}
+	if err := c.ShouldBindQuery(req); err != nil {
+		oauthErrorResponse(c, &service.OAuthError{Code: "invalid_request", Description: err.Error()})
+		return
+	}
+
+	if _, _, err := service.CheckAuthorizeRequest(req); err != nil {
+		var oauthErr *service.OAuthError
+		if errors.As(err, &oauthErr) && oauthErr.RedirectURI != "" {
+			c.Redirect(http.StatusFound, oauthErr.RedirectURL())
+			return
+		}
+		oauthErrorResponse(c, err)
+		return
+	}
+
+	// 复用登录页和会话：没有登录先去登录，登录后回到这里
+	if _, ok := c.Get(constant.AuthUserKey); !ok {
+		c.Redirect(http.StatusFound, service.OAuthLoginURL(c.Request.URL.RequestURI()))
+		return
+	}
+	c.Redirect(http.StatusFound, service.OAuthConsentURL(c.Request.URL.RawQuery))
+}
+
+// GetOAuthConsent 授权确认页展示的信息
+func GetOAuthConsent(c *gin.Context) {
+	req := &service.AuthorizeRequest{}
+	rsp := &HttpResponse{}
+	if err := c.ShouldBindQuery(req); err != nil {
+		log.Errorf("bind oauth consent request query err %v", err)
+		rsp.ResponseWithError(c, CodeBodyBindErr, err.Error())
+		return
+	}
+
+	info, err := service.GetConsentInfo(serviceContext(c), req)
+	if err != nil {
+		rsp.ResponseWithServiceError(c, CodeOAuthErr, err)
+		return
+	}
+	rsp.ResponseWithData(c, info)
+}
+
+// OAuthConsent 用户同意或者拒绝授权，返回浏览器要跳转的地址
+func OAuthConsent(c *gin.Context) {
+	req := &service.ConsentRequest{}
+	rsp := &HttpResponse{}
+	if err := c.ShouldBindJSON(req); err != nil {
+		log.Errorf("bind oauth consent request json err %v", err)
+		rsp.ResponseWithError(c, CodeBodyBindErr, err.Error())
+		return
+	}
+
+	result, err := service.Authorize(serviceContext(c), req)
+	if err != nil {
+		rsp.ResponseWithServiceError(c, CodeOAuthErr, err)
+		return
+	}
+	rsp.ResponseWithData(c, result)
+}
+
+// OAuthToken 用授权码或者客户端凭证换取 access token
+func OAuthToken(c *gin.Context) {
+	req := &service.OAuthTokenRequest{}
+	if err := c.ShouldBindWith(req, binding.FormPost); err != nil {
+		oauthErrorResponse(c, &service.OAuthError{Code: "invalid_request", Description: err.Error()})
+		return
+	}
+	if !clientBasicAuth(c, &req.ClientID, &req.ClientSecret) {
+		oauthErrorResponse(c, &service.OAuthError{Code: "invalid_request", Description: "malformed basic authorization"})
+		return
+	}
+
+	token, err := service.IssueOAuthToken(serviceContext(c), req)
+	if err != nil {
+		oauthErrorResponse(c, err)
+		return
+	}
+	// RFC 6749 5.1：返回 token 的响应不能被缓存
+	c.Header("Cache-Control", "no-store")
+	c.Header("Pragma", "no-cache")
+	c.JSON(http.StatusOK, token)
+}
+
+// OAuthRevoke 吊销 access token
+func OAuthRevoke(c *gin.Context) {
+	req := &service.OAuthTokenActionRequest{}
+	if !bindTokenAction(c, req) {
+		return
+	}
+	if err := service.RevokeOAuthToken(serviceContext(c), req); err != nil {
+		oauthErrorResponse(c, err)
+		return
+	}
+	c.Status(http.StatusOK)
+}
+
+// OAuthIntrospect 查询 access token 是否有效，以及它的用户和权限范围
+func OAuthIntrospect(c *gin.Context) {
+	req := &service.OAuthTokenActionRequest{}
+	if !bindTokenAction(c, req) {
+		return
+	}
+	result, err := service.IntrospectOAuthToken(serviceContext(c), req)
+	if err != nil {
+		oauthErrorResponse(c, err)
+		return
+	}
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, result)
+}
+
+func bindTokenAction(c *gin.Context, req *service.OAuthTokenActionRequest) bool {
+	if err := c.ShouldBindWith(req, binding.FormPost); err != nil {
+		oauthErrorResponse(c, &service.OAuthError{Code: "invalid_request", Description: err.Error()})
+		return false
+	}
+	if !clientBasicAuth(c, &req.ClientID, &req.ClientSecret) {
+		oauthErrorResponse(c, &service.OAuthError{Code: "invalid_request", Description: "malformed basic authorization"})
+		return false
+	}
+	return true
+}
+
+// 接入应用可以通过 HTTP Basic 认证提供 client_id 和密钥（RFC 6749 2.3.1，两者都要先做表单编码），
+// 有 Basic 认证时以它为准
+func clientBasicAuth(c *gin.Context, clientID, clientSecret *string) bool {
+	user, pass, ok := c.Request.BasicAuth()
+	if !ok {
+		return true
+	}
+	id, err1 := url.QueryUnescape(user)
+	secret, err2 := url.QueryUnescape(pass)
+	if err1 != nil || err2 != nil {
+		return false
+	}
+	*clientID, *clientSecret = id, secret
+	return true
+}
+
+// 按 RFC 6749 5.2 的格式返回错误，应用认证失败时返回 401
+func oauthErrorResponse(c *gin.Context, err error) {
+	var oauthErr *service.OAuthError
+	if !errors.As(err, &oauthErr) {
+		log.Errorf("oauth request failed, path=%s|err=%v", c.FullPath(), err)
+		c.JSON(http.StatusInternalServerError, &OAuthErrorResponse{Error: "server_error"})
+		return
+	}
+	status := http.StatusBadRequest
+	if oauthErr.Code == "invalid_client" {
+		status = http.StatusUnauthorized
+		c.Header("WWW-Authenticate", `Basic realm="gouse"`)
+	}
+	c.JSON(status, &OAuthErrorResponse{Error: oauthErr.Code, ErrorDescription: oauthErr.Description})
+}
+
+// ListOAuthClients 管理员查看全部接入应用
+func ListOAuthClients(c *gin.Context) {
+	rsp := &HttpResponse{}
+	clients, err := service.ListOAuthClients(serviceContext(c))
+	if err != nil {
+		rsp.ResponseWithServiceError(c, CodeOAuthClientErr, err)
+		return
+	}
+	rsp.ResponseWithData(c, clients)
+}
+
+// CreateOAuthClient 管理员注册接入应用
+func CreateOAuthClient(c *gin.Context) {
+	req := &service.CreateOAuthClientRequest{}
+	rsp := &HttpResponse{}
+	if err := c.ShouldBindJSON(req); err != nil {
+		log.Errorf("bind create oauth client request json err %v", err)
+		rsp.ResponseWithError(c, CodeBodyBindErr, err.Error())
+		return
+	}
+
+	client, err := service.CreateOAuthClient(serviceContext(c), req)
+	if err != nil {
+		rsp.ResponseWithServiceError(c, CodeOAuthClientErr, err)
+		return
+	}
+	rsp.ResponseWithData(c, client)
+}
+
+// DeleteOAuthClient 管理员删除接入应用
+func DeleteOAuthClient(c *gin.Context) {
+	rsp := &HttpResponse{}
+	if err := service.DeleteOAuthClient(serviceContext(c), c.Param("client_id")); err != nil {
+		rsp.ResponseWithServiceError(c, CodeOAuthClientErr, err)
+		return
+	}
+	rsp.ResponseSuccess(c)
+}
@@ -0,0 +1,76 @@
+package v1
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"gouse/internal/service"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// OpenID Connect 的接口，和 /oauth/token 一样按规范的格式返回，不使用 HttpResponse
+
+// OIDCDiscovery /.well-known/openid-configuration
+func OIDCDiscovery(c *gin.Context) {
+	c.JSON(http.StatusOK, service.GetOIDCDiscovery(serviceContext(c)))
+}
+
+// JWKS /.well-known/jwks.json，密钥轮换后接入应用需要重新拉取，这里只允许短时间缓存
+func JWKS(c *gin.Context) {
+	jwks, err := service.GetJWKS(serviceContext(c))
+	if err != nil {
+		log.Errorf("JWKS|%v", err)
+		c.JSON(http.StatusInternalServerError, &OAuthErrorResponse{Error: "server_error"})
+		return
+	}
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, jwks)
+}
+
+// OIDCUserInfo /userinfo，access token 放在 Authorization: Bearer 请求头里
+func OIDCUserInfo(c *gin.Context) {
+	token := ""
+	if scheme, value, ok := strings.Cut(c.GetHeader("Authorization"), " "); ok && strings.EqualFold(scheme, "Bearer") {
+		token = strings.TrimSpace(value)
+	}
+	info, err := service.GetOIDCUserInfo(serviceContext(c), token)
+	if err != nil {
+		userInfoErrorResponse(c, err)
+		return
+	}
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, info)
+}
+
+// RFC 6750 3.1：token 的错误放在 WWW-Authenticate 里返回
+func userInfoErrorResponse(c *gin.Context, err error) {
+	var oauthErr *service.OAuthError
+	if !errors.As(err, &oauthErr) {
+		oauthErrorResponse(c, err)
+		return
+	}
+	status := http.StatusUnauthorized
+	if oauthErr.Code == "insufficient_scope" {
+		status = http.StatusForbidden
+	}
+	c.Header("WWW-Authenticate", `Bearer realm="gouse", error="`+oauthErr.Code+`"`)
+	c.JSON(status, &OAuthErrorResponse{Error: oauthErr.Code, ErrorDescription: oauthErr.Description})
+}
+
+// RotateOIDCKey 管理员立即轮换 ID token 签名密钥，请求体可以省略
+func RotateOIDCKey(c *gin.Context) {
+	req := &service.RotateOIDCKeyRequest{}
+	rsp := &HttpResponse{}
+	if err := c.ShouldBindJSON(req); err != nil && !errors.Is(err, io.EOF) {
+		log.Errorf("bind rotate oidc key request json err %v", err)
+		rsp.ResponseWithError(c, CodeBodyBindErr, err.Error())
+		return
+	}
+	if err := service.RotateOIDCKey(serviceContext(c), req); err != nil {
+		rsp.ResponseWithServiceError(c, CodeOAuthClientErr, err)
+		return
+	}
+	rsp.ResponseSuccess(c)
+}
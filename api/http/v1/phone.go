@@ -0,0 +1,97 @@
+package v1
+
+import (
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"gouse/internal/service"
+)
+
+// GetPhone 查看当前用户绑定的手机号
+func GetPhone(c *gin.Context) {
+	rsp := &HttpResponse{}
+	info, err := service.GetPhone(serviceContext(c))
+	if err != nil {
+		rsp.ResponseWithServiceError(c, CodePhoneErr, err)
+		return
+	}
+	rsp.ResponseWithData(c, info)
+}
+
+// SendBindPhoneCode 向要绑定的手机号发送验证码
+func SendBindPhoneCode(c *gin.Context) {
+	req := &service.SendPhoneCodeRequest{}
+	rsp := &HttpResponse{}
+
+	if err := c.ShouldBindJSON(req); err != nil {
+		log.Errorf("bind send phone code request json err %v", err)
+		rsp.ResponseWithError(c, CodeBodyBindErr, err.Error())
+		return
+	}
+
+	if err := service.SendBindPhoneCode(serviceContext(c), req); err != nil {
+		rsp.ResponseWithServiceError(c, CodePhoneErr, err)
+		return
+	}
+	rsp.ResponseSuccess(c)
+}
+
+// BindPhone 校验验证码并绑定手机号
+func BindPhone(c *gin.Context) {
+	req := &service.BindPhoneRequest{}
+	rsp := &HttpResponse{}
+
+	if err := c.ShouldBindJSON(req); err != nil {
+		log.Errorf("bind phone request json err %v", err)
+		rsp.ResponseWithError(c, CodeBodyBindErr, err.Error())
+		return
+	}
+
+	if err := service.BindPhone(serviceContext(c), req); err != nil {
+		rsp.ResponseWithServiceError(c, CodePhoneErr, err)
+		return
+	}
+	rsp.ResponseSuccess(c)
+}
+
+// SendLoginSMSCode 申请手机号登录的验证码
+func SendLoginSMSCode(c *gin.Context) {
+	req := &service.SendPhoneCodeRequest{}
+	rsp := &HttpResponse{}
+
+	if err := c.ShouldBindJSON(req); err != nil {
+		log.Errorf("bind send login sms code request json err %v", err)
+		rsp.ResponseWithError(c, CodeBodyBindErr, err.Error())
+		return
+	}
+
+	if err := service.SendLoginSMSCode(serviceContext(c), req); err != nil {
+		rsp.ResponseWithServiceError(c, CodePhoneErr, err)
+		return
+	}
+	rsp.ResponseSuccess(c)
+}
+
+// PhoneLogin 手机号加验证码登录，成功后和密码登录一样设置会话 cookie 或者返回 token
+func PhoneLogin(c *gin.Context) {
+	req := &service.PhoneLoginRequest{}
+	rsp := &HttpResponse{}
+
+	if err := c.ShouldBindJSON(req); err != nil {
+		log.Errorf("bind phone login request json err %v", err)
+		rsp.ResponseWithError(c, CodeBodyBindErr, err.Error())
+		return
+	}
+
+	result, err := service.PhoneLogin(serviceContext(c), req)
+	if err != nil {
+		rsp.ResponseWithServiceError(c, CodeLoginErr, err)
+		return
+	}
+
+	// 开启了两步验证，把 mfa_token 返回给客户端，接着调用 /user/login/mfa
+	if result.MFARequired {
+		rsp.ResponseWithData(c, result)
+		return
+	}
+	loginResponse(c, rsp, result)
+}
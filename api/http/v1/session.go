@@ -0,0 +1,42 @@
+package v1
+
+import (
+	"github.com/gin-gonic/gin"
+	"gouse/internal/service"
+)
+
+// ListSessions 当前用户在各个设备上的登录会话
+func ListSessions(c *gin.Context) {
+	rsp := &HttpResponse{}
+	sessions, err := service.ListSessions(serviceContext(c))
+	if err != nil {
+		rsp.ResponseWithServiceError(c, CodeSessionErr, err)
+		return
+	}
+	rsp.ResponseWithData(c, sessions)
+}
+
+// RevokeSession 让某个会话下线，下线的是当前会话时顺带删除 cookie
+func RevokeSession(c *gin.Context) {
+	rsp := &HttpResponse{}
+	current, err := service.RevokeSession(serviceContext(c), c.Param("id"))
+	if err != nil {
+		rsp.ResponseWithServiceError(c, CodeSessionErr, err)
+		return
+	}
+	if current {
+		clearSessionCookie(c)
+	}
+	rsp.ResponseSuccess(c)
+}
+
+// LogoutEverywhere 在所有设备上退出登录
+func LogoutEverywhere(c *gin.Context) {
+	rsp := &HttpResponse{}
+	if err := service.LogoutEverywhere(serviceContext(c)); err != nil {
+		rsp.ResponseWithServiceError(c, CodeLogoutErr, err)
+		return
+	}
+	clearSessionCookie(c)
+	rsp.ResponseSuccess(c)
+}
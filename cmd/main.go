@@ -2,12 +2,51 @@ package main
 
 import (
 	"gouse/config"
+	"gouse/internal/cache"
 	"gouse/internal/router"
+	"gouse/internal/service"
+	"gouse/utils"
 )
 
 func Init() {
 	// 调用了 config 包中的 InitConfig 函数，用于初始化日志信息。
 	config.InitConfig()
+
+	// 发件器、短信发送器的驱动配置不对就不启动，不要等到第一次发送时才发现
+	if err := utils.InitMailer(); err != nil {
+		panic("init mailer err:" + err.Error())
+	}
+	if err := utils.InitSmsSender(); err != nil {
+		panic("init sms sender err:" + err.Error())
+	}
+
+	// 会话存储的配置不对也不启动，否则每个需要登录的请求都会失败
+	if err := cache.InitSessionStore(); err != nil {
+		panic("init session store err:" + err.Error())
+	}
+	if err := cache.InitCacheStores(); err != nil {
+		panic("init cache store err:" + err.Error())
+	}
+
+	// 配置了泄露密码文件却加载不了时不启动，避免泄露密码检查悄悄失效
+	if err := service.InitPasswordPolicy(); err != nil {
+		panic("init password policy err:" + err.Error())
+	}
+
+	// 同步内置的角色权限，并按配置创建第一个管理员
+	if err := service.InitRBAC(); err != nil {
+		panic("init rbac err:" + err.Error())
+	}
+
+	// 开启了 token 模式时加载签名密钥，密钥配置不对就不启动
+	if err := service.InitTokenAuth(); err != nil {
+		panic("init token auth err:" + err.Error())
+	}
+
+	// 加载 ID token 的签名密钥，第一次启动时生成
+	if err := service.InitOIDC(); err != nil {
+		panic("init oidc err:" + err.Error())
+	}
 }
 
 func main() {
@@ -2,6 +2,7 @@ package config
 
 import (
 	log "github.com/sirupsen/logrus"
+	"gouse/pkg/redact"
 
 	"sync"
 
@@ -20,14 +21,14 @@ var (
 // 空闲连接是指：处于连接池中且当前没有被使用的数据库连接
 // 连接最大空闲时间是指：连接可以保持空闲的最长时间
 type DbConf struct {
-	Host        string `yaml:"host" mapstructure:"host"`                   // db主机地址
-	Port        string `yaml:"port" mapstructure:"port"`                   // db端口
-	User        string `yaml:"user" mapstructure:"user"`                   // 用户名
-	Password    string `yaml:"password" mapstructure:"password"`           // 密码
-	Dbname      string `yaml:"dbname" mapstructure:"dbname"`               // db名
-	MaxIdleConn int    `yaml:"max_idle_conn" mapstructure:"max_idle_conn"` // 最大空闲连接数
-	MaxOpenConn int    `yaml:"max_open_conn" mapstructure:"max_open_conn"` // 最大打开的连接数
-	MaxIdleTime int64  `yaml:"max_idle_time" mapstructure:"max_idle_time"` // 连接最大空闲时间
+	Host        string `yaml:"host" mapstructure:"host"`                       // db主机地址
+	Port        string `yaml:"port" mapstructure:"port"`                       // db端口
+	User        string `yaml:"user" mapstructure:"user"`                       // 用户名
+	Password    string `yaml:"password" mapstructure:"password" redact:"true"` // 密码
+	Dbname      string `yaml:"dbname" mapstructure:"dbname"`                   // db名
+	MaxIdleConn int    `yaml:"max_idle_conn" mapstructure:"max_idle_conn"`     // 最大空闲连接数
+	MaxOpenConn int    `yaml:"max_open_conn" mapstructure:"max_open_conn"`     // 最大打开的连接数
+	MaxIdleTime int64  `yaml:"max_idle_time" mapstructure:"max_idle_time"`     // 连接最大空闲时间
 }
 
 // 补充知识：标签
@@ -52,31 +53,221 @@ type AppConf struct {
 	Version string `yaml:"version" mapstructure:"version"`   // 版本号
 	Port    int    `yaml:"port" mapstructure:"port"`         // 端口号
 	RunMode string `yaml:"run_mode" mapstructure:"run_mode"` // 运行模式
+	// 可信的反向代理地址或网段，只有来自这些地址的请求才采信 X-Forwarded-For 等头里的客户端 IP
+	// 默认为空，不信任任何代理，客户端 IP 取 TCP 连接的对端地址
+	TrustedProxies []string `yaml:"trusted_proxies" mapstructure:"trusted_proxies"`
 }
 
 // RedisConf Redis 配置
 type RedisConf struct {
-	Host     string `yaml:"rhost" mapstructure:"rhost"`       // 主机地址
-	Port     int    `yaml:"rport" mapstructure:"rport"`       // 端口
-	DB       int    `yaml:"rdb" mapstructure:"rdb"`           // 编号
-	PassWord string `yaml:"passwd" mapstructure:"passwd"`     // 密码
-	PoolSize int    `yaml:"poolsize" mapstructure:"poolsize"` // 连接池大小
+	Host     string `yaml:"rhost" mapstructure:"rhost"`                 // 主机地址
+	Port     int    `yaml:"rport" mapstructure:"rport"`                 // 端口
+	DB       int    `yaml:"rdb" mapstructure:"rdb"`                     // 编号
+	PassWord string `yaml:"passwd" mapstructure:"passwd" redact:"true"` // 密码
+	PoolSize int    `yaml:"poolsize" mapstructure:"poolsize"`           // 连接池大小
 }
 
 // 缓存配置
 type Cache struct {
-	SessionExpired int `yaml:"session_expired" mapstructure:"session_expired"` // 会话缓存过期时间
-	UserExpired    int `yaml:"user_expired" mapstructure:"user_expired"`       // 用户缓存过期时间
+	SessionExpired int    `yaml:"session_expired" mapstructure:"session_expired"` // 已废弃，改用 session.idle_timeout；没有配置 session.idle_timeout 时仍然使用它
+	UserExpired    int    `yaml:"user_expired" mapstructure:"user_expired"`       // 用户缓存过期时间
+	Store          string `yaml:"store" mapstructure:"store"`                     // 用户缓存、登录保护、refresh token 的存储：redis 或 memory
+}
+
+// SessionConf 登录会话的有效期
+// 每次访问都会把会话的有效期续到 idle_timeout，但从登录算起最长不超过 absolute_timeout；
+// 登录时勾选了"记住我"的会话使用 remember_ 开头的两项。cookie 的有效期始终和会话剩余的有效期一致
+type SessionConf struct {
+	Store                   string `yaml:"store" mapstructure:"store"`                                         // 会话存储：redis、memory 或 sql
+	IdleTimeout             int    `yaml:"idle_timeout" mapstructure:"idle_timeout"`                           // 多久不访问就过期，单位秒
+	AbsoluteTimeout         int    `yaml:"absolute_timeout" mapstructure:"absolute_timeout"`                   // 从登录算起的最长有效期，单位秒
+	RememberIdleTimeout     int    `yaml:"remember_idle_timeout" mapstructure:"remember_idle_timeout"`         // 记住我：多久不访问就过期，单位秒
+	RememberAbsoluteTimeout int    `yaml:"remember_absolute_timeout" mapstructure:"remember_absolute_timeout"` // 记住我：从登录算起的最长有效期，单位秒
+}
+
+// CookieConf 会话和防 CSRF 的 cookie 属性，没有填写的项按 app.run_mode 取默认值：
+// release 模式下 secure 默认为 true，dev 模式下为 false，方便在 http://localhost 上调试；samesite 默认为 lax。
+// host_prefix 为 true 时 cookie 名字加上 __Host- 前缀，浏览器会要求 secure、path 为 / 并且不能设置 domain，
+// 这几项会被强制成符合要求的值，这样子域名下的页面就没法覆盖这些 cookie
+type CookieConf struct {
+	Name       string `yaml:"name" mapstructure:"name"`               // 会话 cookie 的名字，默认 user_session
+	Domain     string `yaml:"domain" mapstructure:"domain"`           // cookie 的域名，为空表示只对当前域名有效
+	Path       string `yaml:"path" mapstructure:"path"`               // cookie 的路径，默认 /
+	Secure     *bool  `yaml:"secure" mapstructure:"secure"`           // 是否只通过 HTTPS 发送，不填时按运行模式决定
+	SameSite   string `yaml:"samesite" mapstructure:"samesite"`       // lax、strict 或 none，为 none 时强制 secure
+	HostPrefix bool   `yaml:"host_prefix" mapstructure:"host_prefix"` // 是否使用 __Host- 前缀
+}
+
+// PasswordConf 密码哈希配置
+// 调高参数之后，老的哈希会在用户下一次登录时自动按新参数重新计算
+type PasswordConf struct {
+	Algorithm     string `yaml:"algorithm" mapstructure:"algorithm"`           // 哈希算法：argon2id 或 bcrypt
+	BcryptCost    int    `yaml:"bcrypt_cost" mapstructure:"bcrypt_cost"`       // bcrypt 计算成本
+	Argon2Memory  uint32 `yaml:"argon2_memory" mapstructure:"argon2_memory"`   // argon2id 内存，单位 KiB
+	Argon2Time    uint32 `yaml:"argon2_time" mapstructure:"argon2_time"`       // argon2id 迭代次数
+	Argon2Threads uint8  `yaml:"argon2_threads" mapstructure:"argon2_threads"` // argon2id 并行度
+	MinLength     int    `yaml:"min_length" mapstructure:"min_length"`         // 已废弃，改用 password_policy.min_length；没有配置 password_policy.min_length 时仍然使用它
+}
+
+// PasswordPolicyConf 密码策略，注册、修改密码、重置密码时都会检查
+type PasswordPolicyConf struct {
+	MinLength       int      `yaml:"min_length" mapstructure:"min_length"`             // 最小长度
+	MaxLength       int      `yaml:"max_length" mapstructure:"max_length"`             // 最大长度
+	MinClasses      int      `yaml:"min_classes" mapstructure:"min_classes"`           // 小写字母、大写字母、数字、符号四类里至少包含几类，0 表示不限制
+	RequiredClasses []string `yaml:"required_classes" mapstructure:"required_classes"` // 必须包含的字符类别：lower、upper、digit、symbol
+	UserSimilarity  bool     `yaml:"user_similarity" mapstructure:"user_similarity"`   // 不能包含用户名、邮箱、昵称
+	History         int      `yaml:"history" mapstructure:"history"`                   // 不能和最近几次用过的密码相同（包括当前密码），0 表示只检查当前密码
+	BreachList      string   `yaml:"breach_list" mapstructure:"breach_list"`           // 泄露密码、常用密码文件，为空时不检查
+
+	MaxAgeDays          map[string]int `yaml:"max_age_days" mapstructure:"max_age_days"`                   // 角色 -> 密码最长使用天数，用户有多个角色时取最短的，没有配置的角色不过期
+	TempPasswordExpired int            `yaml:"temp_password_expired" mapstructure:"temp_password_expired"` // 管理员设置的临时密码的有效期，单位秒
+}
+
+// RBACConf 角色权限配置
+type RBACConf struct {
+	BootstrapAdmin string `yaml:"bootstrap_admin" mapstructure:"bootstrap_admin"` // 启动时如果还没有管理员，把这个已注册的用户设为管理员
+}
+
+// MailConf 邮件配置
+type MailConf struct {
+	Driver       string `yaml:"driver" mapstructure:"driver"`                             // 发送方式：smtp 或 outbox（写本地文件，用于开发测试）
+	From         string `yaml:"from" mapstructure:"from"`                                 // 发件人
+	SMTPHost     string `yaml:"smtp_host" mapstructure:"smtp_host"`                       // SMTP 服务器地址
+	SMTPPort     int    `yaml:"smtp_port" mapstructure:"smtp_port"`                       // SMTP 端口
+	SMTPUser     string `yaml:"smtp_user" mapstructure:"smtp_user"`                       // SMTP 用户名
+	SMTPPassword string `yaml:"smtp_password" mapstructure:"smtp_password" redact:"true"` // SMTP 密码
+	OutboxDir    string `yaml:"outbox_dir" mapstructure:"outbox_dir"`                     // outbox 方式下邮件写入的目录
+}
+
+// SMSConf 短信配置：发送方式，以及短信验证码的有效期和发送频率限制
+type SMSConf struct {
+	Driver      string `yaml:"driver" mapstructure:"driver"`             // 发送方式：none（不发送）、log（只打日志）或 file（写本地文件），后两种只用于开发测试
+	OutboxFile  string `yaml:"outbox_file" mapstructure:"outbox_file"`   // file 方式下短信写入的文件
+	CountryCode string `yaml:"country_code" mapstructure:"country_code"` // 用户输入的手机号不带国家码时使用的国家码，例如 86
+	CodeLength  int    `yaml:"code_length" mapstructure:"code_length"`   // 验证码位数
+	CodeExpired int    `yaml:"code_expired" mapstructure:"code_expired"` // 验证码有效期，单位秒
+	ResendLimit int    `yaml:"resend_limit" mapstructure:"resend_limit"` // 同一个号码两次发送之间的最小间隔，单位秒
+	HourlyLimit int    `yaml:"hourly_limit" mapstructure:"hourly_limit"` // 同一个号码每小时最多发送几条
+	IPLimit     int    `yaml:"ip_limit" mapstructure:"ip_limit"`         // 同一 IP 网段（IPv4 /24，IPv6 /64）每小时最多发送几条
+	MaxAttempts int    `yaml:"max_attempts" mapstructure:"max_attempts"` // 验证码最多可以输错几次，超过后作废
+	// 全部号码加起来每小时最多发送几条，超过后所有人都要等到下一个小时，用来给短信费用设一个上限
+	GlobalHourlyLimit int `yaml:"global_hourly_limit" mapstructure:"global_hourly_limit"`
+}
+
+// PasswordResetConf 找回密码配置
+type PasswordResetConf struct {
+	TokenExpired int    `yaml:"token_expired" mapstructure:"token_expired"` // 重置链接的有效期，单位秒
+	ResendLimit  int    `yaml:"resend_limit" mapstructure:"resend_limit"`   // 同一个用户两次申请之间的最小间隔，单位秒
+	ResetURL     string `yaml:"reset_url" mapstructure:"reset_url"`         // 重置密码页面地址，token 会拼在查询参数里
+}
+
+// EmailVerifyConf 邮箱验证配置
+type EmailVerifyConf struct {
+	TokenExpired int    `yaml:"token_expired" mapstructure:"token_expired"` // 验证链接的有效期，单位秒
+	ResendLimit  int    `yaml:"resend_limit" mapstructure:"resend_limit"`   // 同一个用户两次发送验证邮件之间的最小间隔，单位秒
+	VerifyURL    string `yaml:"verify_url" mapstructure:"verify_url"`       // 验证邮箱页面地址，token 会拼在查询参数里
+}
+
+// MagicLinkConf 免密码登录配置：登录链接和一次性验证码通过邮件或者短信发送
+type MagicLinkConf struct {
+	TokenExpired  int    `yaml:"token_expired" mapstructure:"token_expired"`     // 登录链接和验证码的有效期，单位秒
+	ResendLimit   int    `yaml:"resend_limit" mapstructure:"resend_limit"`       // 同一个用户两次申请之间的最小间隔，单位秒
+	IPLimit       int    `yaml:"ip_limit" mapstructure:"ip_limit"`               // 同一个 IP 每小时最多申请几次
+	IPPrefixLimit int    `yaml:"ip_prefix_limit" mapstructure:"ip_prefix_limit"` // 同一 IP 网段（IPv4 /24，IPv6 /64）每小时最多申请几次，应该比 ip_limit 大
+	MaxAttempts   int    `yaml:"max_attempts" mapstructure:"max_attempts"`       // 验证码最多可以输错几次，超过后作废
+	CodeLength    int    `yaml:"code_length" mapstructure:"code_length"`         // 验证码位数
+	LoginURL      string `yaml:"login_url" mapstructure:"login_url"`             // 免密码登录页面地址，token 会拼在查询参数里
+}
+
+// LoginProtectConf 登录保护配置，连续登录失败会被临时锁定，多次锁定时锁定时长指数增长
+type LoginProtectConf struct {
+	UserMaxFailures int `yaml:"user_max_failures" mapstructure:"user_max_failures"` // 同一用户名在统计窗口内失败多少次后锁定
+	IPMaxFailures   int `yaml:"ip_max_failures" mapstructure:"ip_max_failures"`     // 同一 IP 在统计窗口内失败多少次后锁定
+	FailureWindow   int `yaml:"failure_window" mapstructure:"failure_window"`       // 失败次数的统计窗口，单位秒
+	LockoutBase     int `yaml:"lockout_base" mapstructure:"lockout_base"`           // 第一次锁定的时长，单位秒，之后每次翻倍
+	LockoutMax      int `yaml:"lockout_max" mapstructure:"lockout_max"`             // 锁定时长上限，单位秒
+	LockoutReset    int `yaml:"lockout_reset" mapstructure:"lockout_reset"`         // 多长时间内没有再被锁定，锁定时长就恢复到初始值，单位秒
+}
+
+// MFAConf 两步验证配置
+type MFAConf struct {
+	Issuer         string `yaml:"issuer" mapstructure:"issuer"`                   // 身份验证器 App 里显示的服务名
+	PendingExpired int    `yaml:"pending_expired" mapstructure:"pending_expired"` // 密码校验通过后，输入验证码的时限，单位秒
+	MaxAttempts    int    `yaml:"max_attempts" mapstructure:"max_attempts"`       // 一次登录最多可以输错几次验证码
+	RecoveryCodes  int    `yaml:"recovery_codes" mapstructure:"recovery_codes"`   // 绑定时生成的恢复码个数
+}
+
+// SigningKey JWT 签名密钥
+type SigningKey struct {
+	Kid    string `yaml:"kid" mapstructure:"kid"`                     // 密钥 ID，写在 token 的 header 里
+	Secret string `yaml:"secret" mapstructure:"secret" redact:"true"` // HS256 密钥，至少 32 字节
+}
+
+// AuthConf 登录凭证配置
+// mode 为 session 时只使用 cookie 会话；为 jwt 时登录返回 access token 和 refresh token，不再创建 cookie 会话；
+// 为 both 时两种都发放，认证中间件两种凭证都接受
+type AuthConf struct {
+	Mode                string       `yaml:"mode" mapstructure:"mode"`                                   // session、jwt 或 both
+	Issuer              string       `yaml:"issuer" mapstructure:"issuer"`                               // token 的签发方
+	AccessTokenExpired  int          `yaml:"access_token_expired" mapstructure:"access_token_expired"`   // access token 有效期，单位秒
+	RefreshTokenExpired int          `yaml:"refresh_token_expired" mapstructure:"refresh_token_expired"` // refresh token 有效期，单位秒，每次刷新重新计算
+	ActiveKey           string       `yaml:"active_key" mapstructure:"active_key"`                       // 签发新 token 使用的密钥 ID
+	SigningKeys         []SigningKey `yaml:"signing_keys" mapstructure:"signing_keys"`                   // 全部可用于校验的密钥，轮换时新旧密钥同时保留
+}
+
+// OAuthConf OAuth 2.0 授权服务配置
+type OAuthConf struct {
+	CodeExpired        int    `yaml:"code_expired" mapstructure:"code_expired"`                 // 授权码有效期，单位秒
+	AccessTokenExpired int    `yaml:"access_token_expired" mapstructure:"access_token_expired"` // access token 有效期，单位秒
+	LoginURL           string `yaml:"login_url" mapstructure:"login_url"`                       // 没有登录时跳转的登录页，登录后回到授权页
+	ConsentURL         string `yaml:"consent_url" mapstructure:"consent_url"`                   // 授权确认页
+}
+
+// OIDCConf OpenID Connect 配置
+type OIDCConf struct {
+	Issuer         string `yaml:"issuer" mapstructure:"issuer"`                     // 对外的访问地址，例如 https://id.example.com，ID token 的 iss 和 discovery 里的地址都以它为准
+	IDTokenExpired int    `yaml:"id_token_expired" mapstructure:"id_token_expired"` // ID token 有效期，单位秒
+	KeyRotation    int    `yaml:"key_rotation" mapstructure:"key_rotation"`         // 签名密钥的轮换周期，单位秒
+	KeyRetention   int    `yaml:"key_retention" mapstructure:"key_retention"`       // 轮换下来的密钥继续在 JWKS 里公布的时长，单位秒，不能小于 ID token 有效期
+}
+
+// APIKeyConf 个人访问令牌配置
+type APIKeyConf struct {
+	MaxPerUser    int `yaml:"max_per_user" mapstructure:"max_per_user"`       // 每个用户最多同时拥有几个有效的 key
+	MaxExpireDays int `yaml:"max_expire_days" mapstructure:"max_expire_days"` // key 的最长有效期，单位天
 }
 
 // GlobalConfig 业务配置结构体
 type GlobalConfig struct {
-	AppConfig   AppConf   `yaml:"app" mapstructure:"app"`     // 服务配置
-	DbConfig    DbConf    `yaml:"db" mapstructure:"db"`       // 数据库配置
-	RedisConfig RedisConf `yaml:"redis" mapstructure:"redis"` // redis 配置
-	Cache       Cache     `yaml:"cache" mapstructure:"cache"` // cache 配置
+	AppConfig      AppConf            `yaml:"app" mapstructure:"app"`                         // 服务配置
+	DbConfig       DbConf             `yaml:"db" mapstructure:"db"`                           // 数据库配置
+	RedisConfig    RedisConf          `yaml:"redis" mapstructure:"redis"`                     // redis 配置
+	Cache          Cache              `yaml:"cache" mapstructure:"cache"`                     // cache 配置
+	Session        SessionConf        `yaml:"session" mapstructure:"session"`                 // 登录会话有效期配置
+	Cookie         CookieConf         `yaml:"cookie" mapstructure:"cookie"`                   // cookie 属性配置
+	PasswordConfig PasswordConf       `yaml:"password" mapstructure:"password"`               // 密码哈希配置
+	PasswordPolicy PasswordPolicyConf `yaml:"password_policy" mapstructure:"password_policy"` // 密码策略配置
+	RBACConfig     RBACConf           `yaml:"rbac" mapstructure:"rbac"`                       // 角色权限配置
+	MailConfig     MailConf           `yaml:"mail" mapstructure:"mail"`                       // 邮件配置
+	SMS            SMSConf            `yaml:"sms" mapstructure:"sms"`                         // 短信配置
+	PasswordReset  PasswordResetConf  `yaml:"password_reset" mapstructure:"password_reset"`   // 找回密码配置
+	EmailVerify    EmailVerifyConf    `yaml:"email_verify" mapstructure:"email_verify"`       // 邮箱验证配置
+	MagicLink      MagicLinkConf      `yaml:"magic_link" mapstructure:"magic_link"`           // 免密码登录配置
+	LoginProtect   LoginProtectConf   `yaml:"login_protect" mapstructure:"login_protect"`     // 登录保护配置
+	MFA            MFAConf            `yaml:"mfa" mapstructure:"mfa"`                         // 两步验证配置
+	Auth           AuthConf           `yaml:"auth" mapstructure:"auth"`                       // 登录凭证配置
+	OAuth          OAuthConf          `yaml:"oauth" mapstructure:"oauth"`                     // OAuth 2.0 授权服务配置
+	OIDC           OIDCConf           `yaml:"oidc" mapstructure:"oidc"`                       // OpenID Connect 配置
+	APIKey         APIKeyConf         `yaml:"api_key" mapstructure:"api_key"`                 // 个人访问令牌配置
 }
 
+// 带密码的配置打印时隐藏密码
+func (c DbConf) String() string       { return redact.String(c) }
+func (c RedisConf) String() string    { return redact.String(c) }
+func (c MailConf) String() string     { return redact.String(c) }
+func (c SigningKey) String() string   { return redact.String(c) }
+func (c GlobalConfig) String() string { return redact.String(c) }
+
 // GetGlobalConf 获取全局配置文件
 func GetGlobalConf() *GlobalConfig {
 	// 通过 sync.Once 包的 Do 方法，确保 readConf 函数只会被执行一次
@@ -85,6 +276,12 @@ func GetGlobalConf() *GlobalConfig {
 	return &config
 }
 
+// SetGlobalConf 替换全局配置，单元测试里用它代替读取配置文件
+func SetGlobalConf(c *GlobalConfig) {
+	once.Do(func() {})
+	config = *c
+}
+
 // 读取配置信息
 func readConf() {
 	// 使用 viper 包设置配置文件的名称为 "app"，格式为 YAML
@@ -116,5 +313,6 @@ func readConf() {
 
 // InitConfig 初始化日志
 func InitConfig() {
-
+	// 所有日志在输出前都经过脱敏钩子，避免密码、token 之类的数据被写进日志
+	log.AddHook(redact.Hook{})
 }
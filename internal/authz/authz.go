@@ -0,0 +1,92 @@
+package authz
+
+import (
+	"errors"
+	log "github.com/sirupsen/logrus"
+	"gouse/internal/model"
+)
+
+// 提供用户维度的访问控制
+// 规则很简单：用户总是可以访问自己的数据；访问别人的数据必须显式拥有对应的权限。
+// 所有按用户名操作数据的 service 函数都应该先调用 CheckUserAccess，再去读写目标用户。
+
+// 权限标识，格式为 资源:动作:范围
+const (
+	PermUserReadAny  = "user:read:any"  // 读取任意用户的资料
+	PermUserWriteAny = "user:write:any" // 修改任意用户的资料
+	PermRoleManage   = "role:manage"    // 给用户分配、回收角色
+	PermUserManage   = "user:manage"    // 管理用户账号：查询列表、禁用、启用、删除
+	PermOAuthManage  = "oauth:manage"   // 管理 OAuth 接入应用
+	PermAPIKeyManage = "apikey:manage"  // 管理任意用户的 API key
+)
+
+// AdminPermissions /admin 下的管理接口要求的权限，拥有其中任何一个才能调用管理接口
+var AdminPermissions = []string{PermUserManage, PermRoleManage, PermOAuthManage, PermAPIKeyManage}
+
+// RoleAdmin 内置的管理员角色，拥有全部内置权限
+const RoleAdmin = "admin"
+
+// PermissionDef 权限定义
+type PermissionDef struct {
+	Code        string
+	Description string
+}
+
+// BuiltinPermissions 内置权限，服务启动时会同步到数据库，并全部授予管理员角色
+var BuiltinPermissions = []PermissionDef{
+	{PermUserReadAny, "读取任意用户的资料"},
+	{PermUserWriteAny, "修改任意用户的资料"},
+	{PermRoleManage, "给用户分配、回收角色"},
+	{PermUserManage, "管理用户账号：查询列表、禁用、启用、删除"},
+	{PermOAuthManage, "管理 OAuth 接入应用：注册、查询、删除"},
+	{PermAPIKeyManage, "管理任意用户的 API key：创建、查询、吊销"},
+}
+
+// ErrForbidden 调用者无权执行该操作
+var ErrForbidden = errors.New("permission denied")
+
+// PermissionResolver 查询用户拥有的全部权限
+type PermissionResolver func(user *model.User) ([]string, error)
+
+// 权限查询函数，没有设置时任何用户都没有额外权限
+var resolver PermissionResolver
+
+// SetPermissionResolver 设置权限查询函数
+func SetPermissionResolver(r PermissionResolver) {
+	resolver = r
+}
+
+// HasPermission 判断用户是否拥有某个权限，查询出错时按没有权限处理
+func HasPermission(user *model.User, perm string) bool {
+	if user == nil || resolver == nil {
+		return false
+	}
+	perms, err := resolver(user)
+	if err != nil {
+		log.Errorf("HasPermission|resolve permissions failed, user_name=%s|err=%v", user.Name, err)
+		return false
+	}
+	for _, p := range perms {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckUserAccess 检查 actor 能否操作用户名为 target 的用户
+// 操作自己总是允许的，操作别人需要 perm 权限，否则返回 ErrForbidden
+func CheckUserAccess(actor *model.User, target string, perm string) error {
+	if actor == nil {
+		return ErrForbidden
+	}
+	if actor.Name == target {
+		return nil
+	}
+	if HasPermission(actor, perm) {
+		log.Infof("CheckUserAccess|%s access %s with permission %s", actor.Name, target, perm)
+		return nil
+	}
+	log.Warnf("CheckUserAccess|%s denied access to %s, missing permission %s", actor.Name, target, perm)
+	return ErrForbidden
+}
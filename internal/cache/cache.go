@@ -2,16 +2,73 @@ package cache
 
 import (
 	"encoding/json"
+	"errors"
+	"github.com/redis/go-redis/v9"
 	"golang.org/x/net/context"
 	"gouse/config"
 	"gouse/internal/model"
 	"gouse/pkg/constant"
 	"gouse/utils"
+	"strconv"
 	"time"
 )
 
+var (
+	// ErrInvalidSession session 格式不合法
+	ErrInvalidSession = errors.New("invalid session")
+	// ErrSessionNotFound session 不存在或者已经过期
+	ErrSessionNotFound = errors.New("session not found or expired")
+	// ErrCacheMiss 缓存里没有这条数据，调用方应该回源到数据库
+	ErrCacheMiss = errors.New("cache miss")
+)
+
+// UserCache 用户信息和用户权限的缓存，保存在哪里由配置 cache.store 决定
+type UserCache interface {
+	// GetUser 按用户名取出缓存的用户信息，没有缓存时返回 ErrCacheMiss
+	GetUser(userName string) (*model.User, error)
+	// SetUser 缓存用户信息，过期时间由配置 cache.user_expired 决定
+	SetUser(user *model.User) error
+	// DelUser 删除缓存的用户信息，下次读取时会重新从数据库加载
+	DelUser(userName string) error
+	// GetPermissions 取出缓存的用户权限列表，没有缓存时返回 ErrCacheMiss
+	GetPermissions(userID int) ([]string, error)
+	// SetPermissions 缓存用户的权限列表，过期时间和用户缓存一致
+	SetPermissions(userID int, perms []string) error
+	// DelPermissions 删除缓存的用户权限列表和角色列表，用户的角色变化后调用
+	DelPermissions(userID int) error
+	// GetRoles 取出缓存的用户角色名列表，没有缓存时返回 ErrCacheMiss
+	GetRoles(userID int) ([]string, error)
+	// SetRoles 缓存用户的角色名列表，过期时间和用户缓存一致
+	SetRoles(userID int, roles []string) error
+}
+
+// 用户缓存的过期时间
+func userCacheExpired() time.Duration {
+	return time.Second * time.Duration(config.GetGlobalConf().Cache.UserExpired)
+}
+
+// 再将新的用户信息更新到缓存
+func UpdateCachedUserInfo(user *model.User) error {
+	// 将用户信息存入缓存
+	err := GetUserCache().SetUser(user)
+
+	// 如果将用户信息存入缓存时发生了问题就把对应的缓存删了
+	if err != nil {
+		GetUserCache().DelUser(user.Name)
+	}
+	return err
+}
+
+// redisUserCache 把用户信息和权限缓存在 Redis 里
+type redisUserCache struct{}
+
+// NewRedisUserCache 创建 Redis 用户缓存
+func NewRedisUserCache() UserCache {
+	return &redisUserCache{}
+}
+
 // 将用户信息存入 Redis 缓存
-func SetUserCacheInfo(user *model.User) error {
+func (c *redisUserCache) SetUser(user *model.User) error {
 	// 用全局常量 constant.UserInfoPrefix + 用户名拼装一个 Redis 的 key（redisKey）
 	redisKey := constant.UserInfoPrefix + user.Name
 
@@ -21,21 +78,17 @@ func SetUserCacheInfo(user *model.User) error {
 		return err
 	}
 
-	// 从配置中获取用户缓存过期时间，并将其转换为 time.Duration 类型
-	// 通过将过期时间与 time.Second 相乘，得到过期时间的秒数
-	expired := time.Second * time.Duration(config.GetGlobalConf().Cache.UserExpired)
-
 	// 最后，执行 utils.GetRedisCli().Set() 方法将用户信息存入 Redis 中，该方法接受四个参数：
 	// 第一个参数是一个上下文对象，可以使用 context.Background() 创建一个空的上下文对象
 	// 第二个参数是要设置的键名，这里是 redisKey
 	// 第三个参数是要设置的键值，这里是 val，即用户信息的 JSON 字符串表示
-	// 第四个参数是过期时间，以秒为单位，这里是通过过期时间的秒数计算得到
-	_, err = utils.GetRedisCli().Set(context.Background(), redisKey, val, expired*time.Second).Result()
+	// 第四个参数是过期时间，从配置中的用户缓存过期时间换算成 time.Duration
+	_, err = utils.GetRedisCli().Set(context.Background(), redisKey, val, userCacheExpired()).Result()
 	return err
 }
 
 // 从 Redis 缓存中获取用户信息
-func GetUserInfoFromCache(username string) (*model.User, error) {
+func (c *redisUserCache) GetUser(username string) (*model.User, error) {
 	// 用全局常量 constant.UserInfoPrefix + 用户名拼装一个 Redis 的 key（redisKey）
 	redisKey := constant.UserInfoPrefix + username
 
@@ -45,6 +98,9 @@ func GetUserInfoFromCache(username string) (*model.User, error) {
 	// 调用 utils.GetRedisCli() 获取一个 Redis 客户端实例
 	// 使用 Get 方法从 Redis 中根据 redisKey 获取相应的值，并将结果赋给变量 val
 	val, err := utils.GetRedisCli().Get(context.Background(), redisKey).Result()
+	if err == redis.Nil {
+		return nil, ErrCacheMiss
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -61,63 +117,63 @@ func GetUserInfoFromCache(username string) (*model.User, error) {
 	return user, err
 }
 
-// 将用户信息与会话字符串存入 Redis 缓存中
-func SetSessionInfo(user *model.User, session string) error {
-	// 用全局常量 constant.UserInfoPrefix + 会话字符串拼装一个 Redis 的 key（redisKey）
-	redisKey := constant.SessionKeyPrefix + session
+// 删除缓存中的用户信息，下次读取时会重新从数据库加载
+func (c *redisUserCache) DelUser(userName string) error {
+	return utils.GetRedisCli().Del(context.Background(), constant.UserInfoPrefix+userName).Err()
+}
 
-	// json.Marshal() 将用户对象转换为 JSON 字符串表示，并将其存储在变量 val 中
-	val, err := json.Marshal(&user)
+// 缓存用户的权限列表，过期时间和用户缓存一致
+func (c *redisUserCache) SetPermissions(userID int, perms []string) error {
+	redisKey := constant.UserPermPrefix + strconv.Itoa(userID)
+	val, err := json.Marshal(perms)
 	if err != nil {
 		return err
 	}
-
-	// 从配置中获取会话缓存过期时间，并将其转换为 time.Duration 类型
-	// 通过将过期时间与 time.Second 相乘，得到过期时间的秒数
-	expired := time.Second * time.Duration(config.GetGlobalConf().Cache.SessionExpired)
-
-	// 最后，执行 utils.GetRedisCli().Set() 方法将用户信息存入 Redis 中
-	_, err = utils.GetRedisCli().Set(context.Background(), redisKey, val, expired*time.Second).Result()
-	return err
+	return utils.GetRedisCli().Set(context.Background(), redisKey, val, userCacheExpired()).Err()
 }
 
-// 根据 session 从缓存中获取用户信息
-func GetSessionInfo(session string) (*model.User, error) {
-	// 构建 Redis 中存储会话信息的键 redisKey
-	redisKey := constant.SessionKeyPrefix + session
-
-	//  获取 Redis 的客户端连接实例，并使用 Get() 方法从 Redis 中获取与 redisKey 对应的值
+// 从缓存中获取用户的权限列表
+func (c *redisUserCache) GetPermissions(userID int) ([]string, error) {
+	redisKey := constant.UserPermPrefix + strconv.Itoa(userID)
 	val, err := utils.GetRedisCli().Get(context.Background(), redisKey).Result()
+	if err == redis.Nil {
+		return nil, ErrCacheMiss
+	}
 	if err != nil {
 		return nil, err
 	}
-
-	// 将获取到的会话信息值 val 转换为 model.User 的结构体指针类型
-	// 并使用 json.Unmarshal() 方法将 val 反序列化为 model.User 结构体
-	user := &model.User{}
-	err = json.Unmarshal([]byte(val), &user)
-	return user, err
+	var perms []string
+	err = json.Unmarshal([]byte(val), &perms)
+	return perms, err
 }
 
-// 再将新的用户信息更新到缓存
-func UpdateCachedUserInfo(user *model.User) error {
-	// 将用户信息存入缓存
-	err := SetUserCacheInfo(user)
+// 删除缓存中用户的权限列表和角色列表，用户的角色变化后调用
+func (c *redisUserCache) DelPermissions(userID int) error {
+	id := strconv.Itoa(userID)
+	return utils.GetRedisCli().Del(context.Background(), constant.UserPermPrefix+id, constant.UserRolePrefix+id).Err()
+}
 
-	// 如果将用户信息存入缓存时发生了问题就把对应的缓存键删了
+// 缓存用户的角色名列表，过期时间和用户缓存一致
+func (c *redisUserCache) SetRoles(userID int, roles []string) error {
+	redisKey := constant.UserRolePrefix + strconv.Itoa(userID)
+	val, err := json.Marshal(roles)
 	if err != nil {
-		redisKey := constant.UserInfoPrefix + user.Name
-		utils.GetRedisCli().Del(context.Background(), redisKey).Result()
+		return err
 	}
-	return err
+	return utils.GetRedisCli().Set(context.Background(), redisKey, val, userCacheExpired()).Err()
 }
 
-// 删除缓存中的会话信息
-func DelSessionInfo(session string) error {
-	// 构建 Redis 中存储会话信息的键 redisKey
-	redisKey := constant.SessionKeyPrefix + session
-
-	// Del() 方法返回删除的键的数量和可能的错误信息
-	_, err := utils.GetRedisCli().Del(context.Background(), redisKey).Result()
-	return err
+// 从缓存中获取用户的角色名列表
+func (c *redisUserCache) GetRoles(userID int) ([]string, error) {
+	redisKey := constant.UserRolePrefix + strconv.Itoa(userID)
+	val, err := utils.GetRedisCli().Get(context.Background(), redisKey).Result()
+	if err == redis.Nil {
+		return nil, ErrCacheMiss
+	}
+	if err != nil {
+		return nil, err
+	}
+	var roles []string
+	err = json.Unmarshal([]byte(val), &roles)
+	return roles, err
 }
@@ -0,0 +1,74 @@
+package cache
+
+import (
+	"encoding/json"
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/net/context"
+	"gouse/pkg/constant"
+	"gouse/utils"
+	"time"
+)
+
+// EmailVerification 一次待完成的邮箱验证：注册时填写的邮箱，或者修改后的新邮箱
+type EmailVerification struct {
+	UserName string `json:"user_name"`
+	Email    string `json:"email"`
+}
+
+// 限制发送验证邮件的频率，interval 内同一个用户只允许发送一次
+func AllowEmailVerifyMail(userName string, interval time.Duration) (bool, error) {
+	redisKey := constant.EmailVerifyThrottlePrefix + userName
+	return utils.GetRedisCli().SetNX(context.Background(), redisKey, 1, interval).Result()
+}
+
+// 保存验证邮箱 token 的哈希，同一个用户之前发出的验证链接会一并作废，
+// 连续修改了两次邮箱时，只有最后一次的新邮箱能完成验证
+func SetEmailVerifyToken(v *EmailVerification, tokenHash string, expired time.Duration) error {
+	ctx := context.Background()
+	userKey := constant.EmailVerifyUserPrefix + v.UserName
+	val, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	old, err := utils.GetRedisCli().Get(ctx, userKey).Result()
+	if err != nil && err != redis.Nil {
+		return err
+	}
+
+	_, err = utils.GetRedisCli().TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		if old != "" {
+			pipe.Del(ctx, constant.EmailVerifyTokenPrefix+old)
+		}
+		pipe.Set(ctx, constant.EmailVerifyTokenPrefix+tokenHash, val, expired)
+		pipe.Set(ctx, userKey, tokenHash, expired)
+		return nil
+	})
+	return err
+}
+
+// 取出并删除验证邮箱 token，token 只能使用一次
+func TakeEmailVerifyToken(tokenHash string) (*EmailVerification, error) {
+	ctx := context.Background()
+	tokenKey := constant.EmailVerifyTokenPrefix + tokenHash
+
+	var get *redis.StringCmd
+	_, err := utils.GetRedisCli().TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		get = pipe.Get(ctx, tokenKey)
+		pipe.Del(ctx, tokenKey)
+		return nil
+	})
+	if err == redis.Nil {
+		return nil, ErrTokenNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	v := &EmailVerification{}
+	if err := json.Unmarshal([]byte(get.Val()), v); err != nil {
+		return nil, err
+	}
+	utils.GetRedisCli().Del(ctx, constant.EmailVerifyUserPrefix+v.UserName)
+	return v, nil
+}
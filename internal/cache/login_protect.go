@@ -0,0 +1,112 @@
+package cache
+
+import (
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/net/context"
+	"gouse/pkg/constant"
+	"gouse/utils"
+	"time"
+)
+
+// LoginProtectStore 登录保护的失败次数和锁定状态，保存在哪里由配置 cache.store 决定
+// subject 是统计对象，例如 "user_alice"、"ip_127.0.0.1"
+type LoginProtectStore interface {
+	// GetLockTTL 获取登录锁定的剩余时间，没有被锁定时返回 0
+	GetLockTTL(subject string) (time.Duration, error)
+	// IncrFailure 登录失败次数加一，返回统计窗口内的失败次数，窗口从第一次失败开始计算
+	IncrFailure(subject string, window time.Duration) (int64, error)
+	// Lock 锁定登录，锁定时长为 base * 2^(第几次锁定-1)，不超过 max
+	// 锁定次数在 reset 时间内没有新的锁定就会过期清零；锁定之后失败次数重新统计
+	Lock(subject string, base, max, reset time.Duration) (time.Duration, error)
+	// ClearFailures 登录成功后清零失败次数，锁定次数保留到自然过期
+	ClearFailures(subject string) error
+	// Unlock 解除锁定，失败次数和锁定次数一并清零，用于管理员手动解锁
+	Unlock(subject string) error
+}
+
+// 第几次锁定对应的锁定时长：base * 2^(level-1)，不超过 max
+func lockDuration(level int64, base, max time.Duration) time.Duration {
+	duration := base
+	for i := int64(1); i < level && duration < max; i++ {
+		duration *= 2
+	}
+	if duration > max {
+		duration = max
+	}
+	return duration
+}
+
+// redisLoginProtectStore 把登录保护的计数保存在 Redis 里，多个实例共用
+type redisLoginProtectStore struct{}
+
+// NewRedisLoginProtectStore 创建 Redis 登录保护存储
+func NewRedisLoginProtectStore() LoginProtectStore {
+	return &redisLoginProtectStore{}
+}
+
+// GetLockTTL 获取登录锁定的剩余时间
+func (s *redisLoginProtectStore) GetLockTTL(subject string) (time.Duration, error) {
+	ttl, err := utils.GetRedisCli().PTTL(context.Background(), constant.LoginLockPrefix+subject).Result()
+	if err != nil {
+		return 0, err
+	}
+	// key 不存在时返回 -2，没有过期时间时返回 -1，都当作没有锁定
+	if ttl < 0 {
+		return 0, nil
+	}
+	return ttl, nil
+}
+
+// 计数加一，第一次计数时设置过期时间；INCR 和 EXPIRE 在一个脚本里执行，
+// 不会因为进程在两步之间退出而留下一个永不过期的计数
+var incrWithExpireScript = redis.NewScript(`
+local count = redis.call("INCR", KEYS[1])
+if count == 1 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[1])
+end
+return count
+`)
+
+// 计数加一并返回计数，窗口从第一次计数开始
+func incrWithExpire(redisKey string, window time.Duration) (int64, error) {
+	return incrWithExpireScript.Run(context.Background(), utils.GetRedisCli(), []string{redisKey}, window.Milliseconds()).Int64()
+}
+
+// IncrFailure 登录失败次数加一
+func (s *redisLoginProtectStore) IncrFailure(subject string, window time.Duration) (int64, error) {
+	return incrWithExpire(constant.LoginFailPrefix+subject, window)
+}
+
+// Lock 锁定登录
+func (s *redisLoginProtectStore) Lock(subject string, base, max, reset time.Duration) (time.Duration, error) {
+	ctx := context.Background()
+	levelKey := constant.LoginLockLevelPrefix + subject
+
+	level, err := utils.GetRedisCli().Incr(ctx, levelKey).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	duration := lockDuration(level, base, max)
+
+	_, err = utils.GetRedisCli().TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Expire(ctx, levelKey, reset)
+		pipe.Set(ctx, constant.LoginLockPrefix+subject, level, duration)
+		pipe.Del(ctx, constant.LoginFailPrefix+subject)
+		return nil
+	})
+	return duration, err
+}
+
+// ClearFailures 登录成功后清零失败次数
+func (s *redisLoginProtectStore) ClearFailures(subject string) error {
+	return utils.GetRedisCli().Del(context.Background(), constant.LoginFailPrefix+subject).Err()
+}
+
+// Unlock 解除锁定
+func (s *redisLoginProtectStore) Unlock(subject string) error {
+	return utils.GetRedisCli().Del(context.Background(),
+		constant.LoginFailPrefix+subject,
+		constant.LoginLockPrefix+subject,
+		constant.LoginLockLevelPrefix+subject).Err()
+}
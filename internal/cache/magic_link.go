@@ -0,0 +1,104 @@
+package cache
+
+import (
+	"errors"
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/net/context"
+	"gouse/pkg/constant"
+	"gouse/utils"
+	"time"
+)
+
+// ErrCodeMismatch 一次性验证码不正确，免密码登录和短信验证码共用
+var ErrCodeMismatch = errors.New("code does not match")
+
+// 免密码登录：每个用户同一时间只有一个有效的登录，用一个 hash 保存，
+// token 是登录链接里 token 的哈希，code 是验证码的哈希，remember 是是否勾选了"记住我"，attempts 是验证码输错的次数。
+// 另外用 magic_token_<token 的哈希> 记录登录链接属于哪个用户
+
+// 限制申请免密码登录的频率，interval 内同一个用户只允许申请一次，不区分邮件还是短信
+func AllowMagicLink(userName string, interval time.Duration) (bool, error) {
+	redisKey := constant.MagicThrottlePrefix + userName
+	return utils.GetRedisCli().SetNX(context.Background(), redisKey, 1, interval).Result()
+}
+
+// 同一个 IP 或者 IP 网段申请免密码登录的次数加一，返回统计窗口内的次数，窗口从第一次申请开始计算
+// subject 是统计对象，例如 "ip:1.2.3.4"、"net:1.2.3.0/24"
+func IncrMagicLinkIPRequests(subject string, window time.Duration) (int64, error) {
+	return incrWithExpire(constant.MagicIPThrottlePrefix+subject, window)
+}
+
+// 保存新的免密码登录，同一个用户之前发出的登录链接和验证码一并作废
+func SetMagicLogin(userName, tokenHash, codeHash string, remember bool, expired time.Duration) error {
+	ctx := context.Background()
+	loginKey := constant.MagicLoginPrefix + userName
+
+	old, err := utils.GetRedisCli().HGet(ctx, loginKey, "token").Result()
+	if err != nil && err != redis.Nil {
+		return err
+	}
+
+	_, err = utils.GetRedisCli().TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		if old != "" {
+			pipe.Del(ctx, constant.MagicTokenPrefix+old)
+		}
+		pipe.Del(ctx, loginKey)
+		pipe.HSet(ctx, loginKey, "token", tokenHash, "code", codeHash, "remember", remember, "attempts", 0)
+		pipe.Expire(ctx, loginKey, expired)
+		pipe.Set(ctx, constant.MagicTokenPrefix+tokenHash, userName, expired)
+		return nil
+	})
+	return err
+}
+
+// 根据登录链接 token 的哈希找到所属的用户名，不存在或者已经过期时返回 ErrTokenNotFound
+func GetMagicTokenUser(tokenHash string) (string, error) {
+	userName, err := utils.GetRedisCli().Get(context.Background(), constant.MagicTokenPrefix+tokenHash).Result()
+	if err == redis.Nil {
+		return "", ErrTokenNotFound
+	}
+	return userName, err
+}
+
+// KEYS[1] 是用户的免密码登录；ARGV 依次是要比对的字段（token 或 code）、哈希、验证码最多输错几次
+// 比对和删除在一个脚本里完成：同一个链接或者验证码并发提交时只有一个请求能成功，
+// 并发猜验证码也会被准确计数，输错次数达到上限时整个登录作废。
+// 返回 {1, remember, token} 表示成功，{0} 表示不存在，{-1} 表示不匹配
+var takeMagicLoginScript = redis.NewScript(`
+local vals = redis.call("HMGET", KEYS[1], ARGV[1], "remember", "token")
+if not vals[1] then
+	return {0}
+end
+if vals[1] ~= ARGV[2] then
+	if ARGV[1] == "code" and redis.call("HINCRBY", KEYS[1], "attempts", 1) >= tonumber(ARGV[3]) then
+		redis.call("DEL", KEYS[1])
+	end
+	return {-1}
+end
+redis.call("DEL", KEYS[1])
+return {1, vals[2], vals[3]}
+`)
+
+// 取出并删除用户的免密码登录，field 为 token 时按登录链接比对，为 code 时按验证码比对
+// 返回登录时是否勾选了"记住我"；不存在时返回 ErrTokenNotFound，不匹配时返回 ErrCodeMismatch
+func TakeMagicLogin(userName, field, hash string, maxAttempts int) (bool, error) {
+	ctx := context.Background()
+	res, err := takeMagicLoginScript.Run(ctx, utils.GetRedisCli(), []string{constant.MagicLoginPrefix + userName},
+		field, hash, maxAttempts).Slice()
+	if err != nil {
+		return false, err
+	}
+	status, _ := res[0].(int64)
+	switch status {
+	case 0:
+		return false, ErrTokenNotFound
+	case -1:
+		return false, ErrCodeMismatch
+	}
+
+	remember, _ := res[1].(string)
+	if token, _ := res[2].(string); token != "" {
+		utils.GetRedisCli().Del(ctx, constant.MagicTokenPrefix+token)
+	}
+	return remember == "1", nil
+}
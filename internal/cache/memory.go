@@ -0,0 +1,337 @@
+package cache
+
+import (
+	"encoding/json"
+	"gouse/internal/model"
+	"gouse/pkg/constant"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// 用户缓存、登录保护、refresh token 的内存实现，对应配置 cache.store: memory
+// 数据只在当前进程里有效，重启后全部丢失，多实例部署时各个实例互相看不到，只适合单实例、本地开发和单元测试。
+// key 的格式和 Redis 实现一致，每个存储一把锁，需要同时改几个 key 的操作在一把锁里完成，效果和 Redis 的事务一样
+
+// 内存存储多久清理一次已经过期的数据
+const memoryPurgeInterval = time.Minute
+
+// 内存里的一条数据，expireAt 为零值表示不过期
+type memoryEntry struct {
+	value    interface{}
+	expireAt time.Time
+}
+
+// 带过期时间的 map，调用方自己持有 mu 再调用其他方法
+type memoryMap struct {
+	mu    sync.Mutex
+	items map[string]*memoryEntry
+}
+
+func newMemoryMap() *memoryMap {
+	m := &memoryMap{items: make(map[string]*memoryEntry)}
+	go m.purgeLoop()
+	return m
+}
+
+// 取出还没过期的数据，已经过期的顺带删掉
+func (m *memoryMap) get(key string, now time.Time) *memoryEntry {
+	item, ok := m.items[key]
+	if !ok {
+		return nil
+	}
+	if !item.expireAt.IsZero() && !now.Before(item.expireAt) {
+		delete(m.items, key)
+		return nil
+	}
+	return item
+}
+
+// 保存数据，ttl 不大于 0 时不过期，和 Redis 的 SET 一样
+func (m *memoryMap) set(key string, value interface{}, now time.Time, ttl time.Duration) *memoryEntry {
+	item := &memoryEntry{value: value}
+	if ttl > 0 {
+		item.expireAt = now.Add(ttl)
+	}
+	m.items[key] = item
+	return item
+}
+
+func (m *memoryMap) del(keys ...string) {
+	for _, key := range keys {
+		delete(m.items, key)
+	}
+}
+
+// 定期清理过期的数据，避免不再访问的 key 一直占着内存
+func (m *memoryMap) purgeLoop() {
+	ticker := time.NewTicker(memoryPurgeInterval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		m.mu.Lock()
+		for key := range m.items {
+			m.get(key, now)
+		}
+		m.mu.Unlock()
+	}
+}
+
+// memoryUserCache 把用户信息和权限缓存在进程内存里
+// 用户信息保存成 JSON，取出来的是新对象，调用方修改它不会影响缓存里的数据
+type memoryUserCache struct {
+	m *memoryMap
+}
+
+// NewMemoryUserCache 创建内存用户缓存
+func NewMemoryUserCache() UserCache {
+	return &memoryUserCache{m: newMemoryMap()}
+}
+
+// GetUser 按用户名取出缓存的用户信息
+func (c *memoryUserCache) GetUser(userName string) (*model.User, error) {
+	c.m.mu.Lock()
+	item := c.m.get(constant.UserInfoPrefix+userName, time.Now())
+	c.m.mu.Unlock()
+	if item == nil {
+		return nil, ErrCacheMiss
+	}
+	user := &model.User{}
+	err := json.Unmarshal(item.value.([]byte), user)
+	return user, err
+}
+
+// SetUser 缓存用户信息
+func (c *memoryUserCache) SetUser(user *model.User) error {
+	val, err := json.Marshal(user)
+	if err != nil {
+		return err
+	}
+	c.m.mu.Lock()
+	defer c.m.mu.Unlock()
+	c.m.set(constant.UserInfoPrefix+user.Name, val, time.Now(), userCacheExpired())
+	return nil
+}
+
+// DelUser 删除缓存的用户信息
+func (c *memoryUserCache) DelUser(userName string) error {
+	c.m.mu.Lock()
+	defer c.m.mu.Unlock()
+	c.m.del(constant.UserInfoPrefix + userName)
+	return nil
+}
+
+// GetPermissions 取出缓存的用户权限列表
+func (c *memoryUserCache) GetPermissions(userID int) ([]string, error) {
+	c.m.mu.Lock()
+	defer c.m.mu.Unlock()
+	item := c.m.get(constant.UserPermPrefix+strconv.Itoa(userID), time.Now())
+	if item == nil {
+		return nil, ErrCacheMiss
+	}
+	return append([]string(nil), item.value.([]string)...), nil
+}
+
+// SetPermissions 缓存用户的权限列表
+func (c *memoryUserCache) SetPermissions(userID int, perms []string) error {
+	c.m.mu.Lock()
+	defer c.m.mu.Unlock()
+	c.m.set(constant.UserPermPrefix+strconv.Itoa(userID), append([]string(nil), perms...), time.Now(), userCacheExpired())
+	return nil
+}
+
+// DelPermissions 删除缓存的用户权限列表和角色列表
+func (c *memoryUserCache) DelPermissions(userID int) error {
+	id := strconv.Itoa(userID)
+	c.m.mu.Lock()
+	defer c.m.mu.Unlock()
+	c.m.del(constant.UserPermPrefix+id, constant.UserRolePrefix+id)
+	return nil
+}
+
+// GetRoles 取出缓存的用户角色名列表
+func (c *memoryUserCache) GetRoles(userID int) ([]string, error) {
+	c.m.mu.Lock()
+	defer c.m.mu.Unlock()
+	item := c.m.get(constant.UserRolePrefix+strconv.Itoa(userID), time.Now())
+	if item == nil {
+		return nil, ErrCacheMiss
+	}
+	return append([]string(nil), item.value.([]string)...), nil
+}
+
+// SetRoles 缓存用户的角色名列表
+func (c *memoryUserCache) SetRoles(userID int, roles []string) error {
+	c.m.mu.Lock()
+	defer c.m.mu.Unlock()
+	c.m.set(constant.UserRolePrefix+strconv.Itoa(userID), append([]string(nil), roles...), time.Now(), userCacheExpired())
+	return nil
+}
+
+// memoryLoginProtectStore 把登录保护的计数保存在进程内存里
+// 多实例部署时每个实例各自计数，攻击者把请求分散到各个实例上就能多试几次
+type memoryLoginProtectStore struct {
+	m *memoryMap
+}
+
+// NewMemoryLoginProtectStore 创建内存登录保护存储
+func NewMemoryLoginProtectStore() LoginProtectStore {
+	return &memoryLoginProtectStore{m: newMemoryMap()}
+}
+
+// GetLockTTL 获取登录锁定的剩余时间
+func (s *memoryLoginProtectStore) GetLockTTL(subject string) (time.Duration, error) {
+	now := time.Now()
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	item := s.m.get(constant.LoginLockPrefix+subject, now)
+	if item == nil {
+		return 0, nil
+	}
+	return item.expireAt.Sub(now), nil
+}
+
+// IncrFailure 登录失败次数加一
+func (s *memoryLoginProtectStore) IncrFailure(subject string, window time.Duration) (int64, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	return s.incr(constant.LoginFailPrefix+subject, window), nil
+}
+
+// Lock 锁定登录
+func (s *memoryLoginProtectStore) Lock(subject string, base, max, reset time.Duration) (time.Duration, error) {
+	now := time.Now()
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	levelKey := constant.LoginLockLevelPrefix + subject
+	level := s.incr(levelKey, 0)
+	s.m.get(levelKey, now).expireAt = now.Add(reset)
+
+	duration := lockDuration(level, base, max)
+	s.m.set(constant.LoginLockPrefix+subject, level, now, duration)
+	s.m.del(constant.LoginFailPrefix + subject)
+	return duration, nil
+}
+
+// ClearFailures 登录成功后清零失败次数
+func (s *memoryLoginProtectStore) ClearFailures(subject string) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	s.m.del(constant.LoginFailPrefix + subject)
+	return nil
+}
+
+// Unlock 解除锁定
+func (s *memoryLoginProtectStore) Unlock(subject string) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	s.m.del(constant.LoginFailPrefix+subject, constant.LoginLockPrefix+subject, constant.LoginLockLevelPrefix+subject)
+	return nil
+}
+
+// 计数加一，第一次计数时设置过期时间，和 Redis 实现里的 incrWithExpire 一致，调用方需要持有锁
+func (s *memoryLoginProtectStore) incr(key string, window time.Duration) int64 {
+	now := time.Now()
+	item := s.m.get(key, now)
+	if item == nil {
+		item = s.m.set(key, int64(0), now, window)
+	}
+	count := item.value.(int64) + 1
+	item.value = count
+	return count
+}
+
+// memoryRefreshTokenStore 把 refresh token 保存在进程内存里
+type memoryRefreshTokenStore struct {
+	m *memoryMap
+}
+
+// NewMemoryRefreshTokenStore 创建内存 refresh token 存储
+func NewMemoryRefreshTokenStore() RefreshTokenStore {
+	return &memoryRefreshTokenStore{m: newMemoryMap()}
+}
+
+// Add 保存 refresh token
+func (s *memoryRefreshTokenStore) Add(userName, family, tokenHash string, expired time.Duration) error {
+	now := time.Now()
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	s.m.set(constant.RefreshFamilyPrefix+family, userName, now, expired)
+	s.m.set(constant.RefreshTokenPrefix+tokenHash, &RefreshToken{Name: userName, Family: family}, now, expired)
+
+	indexKey := constant.UserRefreshFamiliesKey + userName
+	index := s.m.get(indexKey, now)
+	if index == nil {
+		index = s.m.set(indexKey, map[string]bool{}, now, expired)
+	}
+	index.value.(map[string]bool)[family] = true
+	index.expireAt = now.Add(expired)
+	return nil
+}
+
+// Get 查询 refresh token
+func (s *memoryRefreshTokenStore) Get(tokenHash string) (*RefreshToken, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	item := s.m.get(constant.RefreshTokenPrefix+tokenHash, time.Now())
+	if item == nil {
+		return nil, ErrTokenNotFound
+	}
+	record := *item.value.(*RefreshToken)
+	return &record, nil
+}
+
+// MarkUsed 把 refresh token 标记为已使用
+func (s *memoryRefreshTokenStore) MarkUsed(tokenHash string) (bool, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	item := s.m.get(constant.RefreshTokenPrefix+tokenHash, time.Now())
+	if item == nil {
+		return false, ErrTokenNotFound
+	}
+	record := item.value.(*RefreshToken)
+	first := !record.Used
+	record.Used = true
+	return first, nil
+}
+
+// GetFamily 查询家族所属的用户名
+func (s *memoryRefreshTokenStore) GetFamily(family string) (string, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	item := s.m.get(constant.RefreshFamilyPrefix+family, time.Now())
+	if item == nil {
+		return "", ErrTokenNotFound
+	}
+	return item.value.(string), nil
+}
+
+// RevokeFamily 吊销一个家族
+func (s *memoryRefreshTokenStore) RevokeFamily(userName, family string) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	s.m.del(constant.RefreshFamilyPrefix + family)
+	if index := s.m.get(constant.UserRefreshFamiliesKey+userName, time.Now()); index != nil {
+		delete(index.value.(map[string]bool), family)
+	}
+	return nil
+}
+
+// RevokeUserFamilies 吊销用户除 keep 以外的全部家族
+func (s *memoryRefreshTokenStore) RevokeUserFamilies(userName, keep string) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	index := s.m.get(constant.UserRefreshFamiliesKey+userName, time.Now())
+	if index == nil {
+		return nil
+	}
+	families := index.value.(map[string]bool)
+	for family := range families {
+		if family == keep {
+			continue
+		}
+		s.m.del(constant.RefreshFamilyPrefix + family)
+		delete(families, family)
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package cache
+
+import (
+	"errors"
+	"gouse/config"
+	"testing"
+	"time"
+)
+
+func TestMemoryLoginProtectStore(t *testing.T) {
+	store := NewMemoryLoginProtectStore()
+	base, max := time.Minute, 5*time.Minute
+
+	if n, _ := store.IncrFailure("user_alice", time.Hour); n != 1 {
+		t.Fatalf("IncrFailure = %d, want 1", n)
+	}
+	if n, _ := store.IncrFailure("user_alice", time.Hour); n != 2 {
+		t.Fatalf("IncrFailure = %d, want 2", n)
+	}
+
+	// 每锁定一次时长翻倍，不超过 max；锁定后失败次数重新统计
+	for i, want := range []time.Duration{base, 2 * base, 4 * base, max} {
+		got, err := store.Lock("user_alice", base, max, time.Hour)
+		if err != nil || got != want {
+			t.Errorf("Lock #%d = %v, %v, want %v", i+1, got, err, want)
+		}
+	}
+	if n, _ := store.IncrFailure("user_alice", time.Hour); n != 1 {
+		t.Errorf("IncrFailure after Lock = %d, want 1", n)
+	}
+	if ttl, _ := store.GetLockTTL("user_alice"); ttl <= 0 || ttl > max {
+		t.Errorf("GetLockTTL = %v, want (0, %v]", ttl, max)
+	}
+
+	// 解锁后锁定次数也清零
+	if err := store.Unlock("user_alice"); err != nil {
+		t.Fatalf("Unlock err: %v", err)
+	}
+	if ttl, _ := store.GetLockTTL("user_alice"); ttl != 0 {
+		t.Errorf("GetLockTTL after Unlock = %v, want 0", ttl)
+	}
+	if got, _ := store.Lock("user_alice", base, max, time.Hour); got != base {
+		t.Errorf("Lock after Unlock = %v, want %v", got, base)
+	}
+}
+
+func TestMemoryRefreshTokenStore(t *testing.T) {
+	store := NewMemoryRefreshTokenStore()
+	if err := store.Add("alice", "f1", "h1", time.Hour); err != nil {
+		t.Fatalf("Add err: %v", err)
+	}
+	if err := store.Add("alice", "f2", "h2", time.Hour); err != nil {
+		t.Fatalf("Add err: %v", err)
+	}
+
+	// 只有第一次标记返回 true
+	if first, err := store.MarkUsed("h1"); err != nil || !first {
+		t.Errorf("MarkUsed = %v, %v, want true, nil", first, err)
+	}
+	if first, err := store.MarkUsed("h1"); err != nil || first {
+		t.Errorf("MarkUsed twice = %v, %v, want false, nil", first, err)
+	}
+	if record, err := store.Get("h1"); err != nil || !record.Used || record.Family != "f1" {
+		t.Errorf("Get = %+v, %v", record, err)
+	}
+	if _, err := store.MarkUsed("unknown"); !errors.Is(err, ErrTokenNotFound) {
+		t.Errorf("MarkUsed(unknown) err = %v, want ErrTokenNotFound", err)
+	}
+
+	if err := store.RevokeUserFamilies("alice", "f2"); err != nil {
+		t.Fatalf("RevokeUserFamilies err: %v", err)
+	}
+	if _, err := store.GetFamily("f1"); !errors.Is(err, ErrTokenNotFound) {
+		t.Errorf("GetFamily(revoked) err = %v, want ErrTokenNotFound", err)
+	}
+	if name, err := store.GetFamily("f2"); err != nil || name != "alice" {
+		t.Errorf("GetFamily(kept) = %q, %v", name, err)
+	}
+}
+
+func TestMemoryUserCacheRoles(t *testing.T) {
+	conf := &config.GlobalConfig{}
+	conf.Cache.UserExpired = 300
+	config.SetGlobalConf(conf)
+	c := NewMemoryUserCache()
+
+	if _, err := c.GetRoles(1); !errors.Is(err, ErrCacheMiss) {
+		t.Fatalf("GetRoles(empty) err = %v, want ErrCacheMiss", err)
+	}
+	if err := c.SetRoles(1, []string{"admin"}); err != nil {
+		t.Fatalf("SetRoles err: %v", err)
+	}
+	if err := c.SetPermissions(1, []string{"user:manage"}); err != nil {
+		t.Fatalf("SetPermissions err: %v", err)
+	}
+	roles, err := c.GetRoles(1)
+	if err != nil || len(roles) != 1 || roles[0] != "admin" {
+		t.Fatalf("GetRoles = %v, %v, want [admin]", roles, err)
+	}
+
+	// 角色变化后权限和角色一起删除
+	if err := c.DelPermissions(1); err != nil {
+		t.Fatalf("DelPermissions err: %v", err)
+	}
+	if _, err := c.GetRoles(1); !errors.Is(err, ErrCacheMiss) {
+		t.Errorf("GetRoles(after DelPermissions) err = %v, want ErrCacheMiss", err)
+	}
+	if _, err := c.GetPermissions(1); !errors.Is(err, ErrCacheMiss) {
+		t.Errorf("GetPermissions(after DelPermissions) err = %v, want ErrCacheMiss", err)
+	}
+}
@@ -0,0 +1,65 @@
+package cache
+
+import (
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/net/context"
+	"gouse/pkg/constant"
+	"gouse/utils"
+	"time"
+)
+
+// 两步验证的待完成登录：密码已经校验通过，还差验证码
+// 用一个 hash 保存，name 是用户名，remember 是登录时是否勾选了"记住我"，attempts 是已经输错的次数
+
+// 保存待完成的登录，tokenHash 是发给客户端的 token 的哈希
+func SetMFAPending(tokenHash, userName string, remember bool, expired time.Duration) error {
+	ctx := context.Background()
+	redisKey := constant.MFAPendingPrefix + tokenHash
+	_, err := utils.GetRedisCli().TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, redisKey, "name", userName, "remember", remember, "attempts", 0)
+		pipe.Expire(ctx, redisKey, expired)
+		return nil
+	})
+	return err
+}
+
+// 获取待完成的登录对应的用户名以及是否勾选了"记住我"，不存在或者已经过期时返回 ErrTokenNotFound
+func GetMFAPending(tokenHash string) (string, bool, error) {
+	vals, err := utils.GetRedisCli().HMGet(context.Background(), constant.MFAPendingPrefix+tokenHash, "name", "remember").Result()
+	if err != nil {
+		return "", false, err
+	}
+	name, _ := vals[0].(string)
+	if name == "" {
+		return "", false, ErrTokenNotFound
+	}
+	remember, _ := vals[1].(string)
+	return name, remember == "1", nil
+}
+
+// 只在 key 还存在的时候累加，避免 key 刚好过期时 HINCRBY 重新建出一个没有过期时间的 key
+var incrAttemptsScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 0 then
+	return -1
+end
+return redis.call("HINCRBY", KEYS[1], "attempts", 1)
+`)
+
+// 验证码输错一次，返回这次登录累计输错的次数；待完成的登录已经不存在时返回 ErrTokenNotFound
+func IncrMFAPendingAttempts(tokenHash string) (int64, error) {
+	n, err := incrAttemptsScript.Run(context.Background(), utils.GetRedisCli(), []string{constant.MFAPendingPrefix + tokenHash}).Int64()
+	if err != nil {
+		return 0, err
+	}
+	if n < 0 {
+		return 0, ErrTokenNotFound
+	}
+	return n, nil
+}
+
+// 删除待完成的登录，验证通过或者输错次数过多时调用
+// 返回 false 表示已经被删除了，并发提交同一个 token 时只有一个请求能继续
+func DelMFAPending(tokenHash string) (bool, error) {
+	n, err := utils.GetRedisCli().Del(context.Background(), constant.MFAPendingPrefix+tokenHash).Result()
+	return n == 1, err
+}
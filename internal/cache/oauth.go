@@ -0,0 +1,133 @@
+package cache
+
+import (
+	"encoding/json"
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/net/context"
+	"gouse/pkg/constant"
+	"gouse/utils"
+	"time"
+)
+
+// OAuthGrant 一次 OAuth 授权的信息，授权码和 access token 都用它保存
+type OAuthGrant struct {
+	ClientID      string `json:"client_id"`
+	UserID        int    `json:"user_id,omitempty"`   // client_credentials 方式没有用户
+	UserName      string `json:"user_name,omitempty"` // 授权的用户
+	Scope         string `json:"scope"`               // 授予的权限范围，空格分隔
+	RedirectURI   string `json:"redirect_uri,omitempty"`
+	CodeChallenge string `json:"code_challenge,omitempty"` // PKCE S256 的 code_challenge
+	Nonce         string `json:"nonce,omitempty"`          // OpenID Connect 的 nonce，签发 ID token 时带上
+	IssuedAt      int64  `json:"iat"`
+	ExpiresAt     int64  `json:"exp"`
+}
+
+// 保存授权码
+func SetOAuthCode(codeHash string, grant *OAuthGrant, expired time.Duration) error {
+	return setOAuthGrant(constant.OAuthCodePrefix+codeHash, grant, expired)
+}
+
+// 取出并删除授权码，授权码只能使用一次；不存在或者已经过期时返回 ErrTokenNotFound
+func TakeOAuthCode(codeHash string) (*OAuthGrant, error) {
+	ctx := context.Background()
+	redisKey := constant.OAuthCodePrefix + codeHash
+
+	var get *redis.StringCmd
+	_, err := utils.GetRedisCli().TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		get = pipe.Get(ctx, redisKey)
+		pipe.Del(ctx, redisKey)
+		return nil
+	})
+	if err == redis.Nil {
+		return nil, ErrTokenNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	grant := &OAuthGrant{}
+	err = json.Unmarshal([]byte(get.Val()), grant)
+	return grant, err
+}
+
+// 保存 access token，同时记到应用的 token 索引里
+func SetOAuthToken(tokenHash string, grant *OAuthGrant, expired time.Duration) error {
+	ctx := context.Background()
+	val, err := json.Marshal(grant)
+	if err != nil {
+		return err
+	}
+
+	// 索引的有效期取最后一个 token 的过期时间，不缩短已有的有效期
+	indexKey := constant.OAuthClientTokensPrefix + grant.ClientID
+	indexExpired := expired
+	if ttl, err := utils.GetRedisCli().TTL(ctx, indexKey).Result(); err == nil && ttl > indexExpired {
+		indexExpired = ttl
+	}
+	_, err = utils.GetRedisCli().TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, constant.OAuthTokenPrefix+tokenHash, val, expired)
+		pipe.SAdd(ctx, indexKey, tokenHash)
+		pipe.Expire(ctx, indexKey, indexExpired)
+		return nil
+	})
+	return err
+}
+
+// 查询 access token，不存在或者已经过期时返回 ErrTokenNotFound
+func GetOAuthToken(tokenHash string) (*OAuthGrant, error) {
+	val, err := utils.GetRedisCli().Get(context.Background(), constant.OAuthTokenPrefix+tokenHash).Result()
+	if err == redis.Nil {
+		return nil, ErrTokenNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	grant := &OAuthGrant{}
+	err = json.Unmarshal([]byte(val), grant)
+	return grant, err
+}
+
+// 删除 access token
+func DelOAuthToken(clientID, tokenHash string) error {
+	ctx := context.Background()
+	_, err := utils.GetRedisCli().TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, constant.OAuthTokenPrefix+tokenHash)
+		pipe.SRem(ctx, constant.OAuthClientTokensPrefix+clientID, tokenHash)
+		return nil
+	})
+	return err
+}
+
+// 删除发给某个应用的全部 access token，返回删除的个数（不含已经过期的）
+func DelOAuthClientTokens(clientID string) (int64, error) {
+	ctx := context.Background()
+	indexKey := constant.OAuthClientTokensPrefix + clientID
+	hashes, err := utils.GetRedisCli().SMembers(ctx, indexKey).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	keys := make([]string, 0, len(hashes)+1)
+	for _, hash := range hashes {
+		keys = append(keys, constant.OAuthTokenPrefix+hash)
+	}
+	var del *redis.IntCmd
+	_, err = utils.GetRedisCli().TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		if len(keys) > 0 {
+			del = pipe.Del(ctx, keys...)
+		}
+		pipe.Del(ctx, indexKey)
+		return nil
+	})
+	if err != nil || del == nil {
+		return 0, err
+	}
+	return del.Val(), nil
+}
+
+func setOAuthGrant(redisKey string, grant *OAuthGrant, expired time.Duration) error {
+	val, err := json.Marshal(grant)
+	if err != nil {
+		return err
+	}
+	return utils.GetRedisCli().Set(context.Background(), redisKey, val, expired).Err()
+}
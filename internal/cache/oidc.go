@@ -0,0 +1,49 @@
+package cache
+
+import (
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/net/context"
+	"gouse/pkg/constant"
+	"gouse/utils"
+	"strconv"
+	"time"
+)
+
+// ID token 签名密钥相关的缓存：多个实例之间的轮换锁，以及吊销密钥时通知各个实例重新加载
+
+// 锁还是自己持有的才删除，锁过期后被别的实例拿走时不能误删
+var releaseLockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// 获取轮换签名密钥的锁，返回 false 表示其他实例正在轮换
+// 锁在 ttl 之后自动释放，持有锁的实例中途退出也不会一直锁着
+func LockOIDCKeyRotation(owner string, ttl time.Duration) (bool, error) {
+	return utils.GetRedisCli().SetNX(context.Background(), constant.OIDCKeyRotationLockKey, owner, ttl).Result()
+}
+
+// 释放轮换签名密钥的锁
+func UnlockOIDCKeyRotation(owner string) error {
+	return releaseLockScript.Run(context.Background(), utils.GetRedisCli(),
+		[]string{constant.OIDCKeyRotationLockKey}, owner).Err()
+}
+
+// 获取签名密钥的版本号，还没有吊销过密钥时返回 0
+func GetOIDCKeysVersion() (int64, error) {
+	val, err := utils.GetRedisCli().Get(context.Background(), constant.OIDCKeysVersionKey).Result()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(val, 10, 64)
+}
+
+// 签名密钥的版本号加一，各个实例下一次使用密钥时发现版本号变了就重新加载
+func IncrOIDCKeysVersion() error {
+	return utils.GetRedisCli().Incr(context.Background(), constant.OIDCKeysVersionKey).Err()
+}
@@ -0,0 +1,73 @@
+package cache
+
+import (
+	"errors"
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/net/context"
+	"gouse/pkg/constant"
+	"gouse/utils"
+	"time"
+)
+
+// ErrTokenNotFound 一次性 token 不存在、已过期或者已经被使用过
+var ErrTokenNotFound = errors.New("token not found or expired")
+
+// 限制发送重置密码邮件的频率，interval 内同一个用户只允许申请一次
+func AllowPasswordResetMail(userName string, interval time.Duration) (bool, error) {
+	redisKey := constant.PwdResetThrottlePrefix + userName
+	return utils.GetRedisCli().SetNX(context.Background(), redisKey, 1, interval).Result()
+}
+
+// 保存重置密码 token 的哈希，同一个用户之前申请的 token 会一并作废，保证同一时间只有最新的链接有效
+func SetPasswordResetToken(userName, tokenHash string, expired time.Duration) error {
+	ctx := context.Background()
+	userKey := constant.PwdResetUserPrefix + userName
+
+	old, err := utils.GetRedisCli().Get(ctx, userKey).Result()
+	if err != nil && err != redis.Nil {
+		return err
+	}
+
+	_, err = utils.GetRedisCli().TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		if old != "" {
+			pipe.Del(ctx, constant.PwdResetTokenPrefix+old)
+		}
+		pipe.Set(ctx, constant.PwdResetTokenPrefix+tokenHash, userName, expired)
+		pipe.Set(ctx, userKey, tokenHash, expired)
+		return nil
+	})
+	return err
+}
+
+// 查看重置密码 token 所属的用户名，不消耗 token，用于设置新密码之前先检查密码策略
+func PeekPasswordResetToken(tokenHash string) (string, error) {
+	userName, err := utils.GetRedisCli().Get(context.Background(), constant.PwdResetTokenPrefix+tokenHash).Result()
+	if err == redis.Nil {
+		return "", ErrTokenNotFound
+	}
+	return userName, err
+}
+
+// 取出并删除重置密码 token，返回 token 所属的用户名
+// 读取和删除在同一个事务里完成，同一个 token 并发使用时只有一个请求能拿到用户名
+func TakePasswordResetToken(tokenHash string) (string, error) {
+	ctx := context.Background()
+	tokenKey := constant.PwdResetTokenPrefix + tokenHash
+
+	var get *redis.StringCmd
+	_, err := utils.GetRedisCli().TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		get = pipe.Get(ctx, tokenKey)
+		pipe.Del(ctx, tokenKey)
+		return nil
+	})
+	if err == redis.Nil {
+		return "", ErrTokenNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+
+	userName := get.Val()
+	utils.GetRedisCli().Del(ctx, constant.PwdResetUserPrefix+userName)
+	return userName, nil
+}
@@ -0,0 +1,208 @@
+package cache
+
+import (
+	"encoding/json"
+	"gouse/internal/model"
+	"gouse/utils"
+	"sort"
+	"sync"
+	"time"
+)
+
+// 内存会话存储多久清理一次已经过期的会话
+const memorySessionPurgeInterval = time.Minute
+
+// 内存里的一个会话
+// 用户信息和 Redis 里一样保存成 JSON，取出来的是新对象，调用方修改它不会影响存储里的数据
+type memorySession struct {
+	userName string
+	user     []byte
+	meta     SessionMeta
+	expireAt time.Time
+}
+
+// memorySessionStore 把会话保存在进程内存里
+// 过期的会话读取时按不存在处理，后台每分钟清理一次；会话只在当前进程里有效，多实例部署时不能使用
+type memorySessionStore struct {
+	mu       sync.Mutex
+	sessions map[string]*memorySession  // key 是 session
+	users    map[string]map[string]bool // 用户的会话索引，用户名 -> session 集合
+}
+
+// NewMemorySessionStore 创建内存会话存储
+func NewMemorySessionStore() SessionStore {
+	s := &memorySessionStore{
+		sessions: make(map[string]*memorySession),
+		users:    make(map[string]map[string]bool),
+	}
+	go s.purgeLoop()
+	return s
+}
+
+// Create 保存新登录的会话
+func (s *memorySessionStore) Create(user *model.User, session string, meta *SessionMeta, expired time.Duration) error {
+	val, err := json.Marshal(user)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	meta.Session = session
+	meta.CreatedAt, meta.LastSeen, meta.LastIP = now.Unix(), now.Unix(), meta.IP
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sessions[session] = &memorySession{userName: user.Name, user: val, meta: *meta, expireAt: now.Add(expired)}
+	if s.users[user.Name] == nil {
+		s.users[user.Name] = make(map[string]bool)
+	}
+	s.users[user.Name][session] = true
+	return nil
+}
+
+// Get 根据 session 取出会话里的用户
+func (s *memorySessionStore) Get(session string) (*model.User, error) {
+	if !utils.IsValidSession(session) {
+		return nil, ErrInvalidSession
+	}
+	s.mu.Lock()
+	item := s.live(session, time.Now())
+	s.mu.Unlock()
+	if item == nil {
+		return nil, ErrSessionNotFound
+	}
+	user := &model.User{}
+	err := json.Unmarshal(item.user, user)
+	return user, err
+}
+
+// Update 更新会话里保存的用户信息，不改变会话剩余的有效期
+func (s *memorySessionStore) Update(user *model.User, session string) error {
+	val, err := json.Marshal(user)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	item := s.live(session, time.Now())
+	if item == nil {
+		return ErrSessionNotFound
+	}
+	item.user = val
+	return nil
+}
+
+// Touch 记录会话最近一次访问的时间和 IP，并续期
+func (s *memorySessionStore) Touch(userName, session, ip string, interval time.Duration) (time.Duration, error) {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	item := s.live(session, now)
+	if item == nil || item.userName != userName {
+		return 0, nil
+	}
+	ttl := touchSessionMeta(&item.meta, ip, now.Unix(), interval)
+	if ttl < 0 {
+		s.remove(session)
+		return 0, ErrSessionNotFound
+	}
+	expired := time.Duration(ttl) * time.Second
+	if expired > 0 {
+		item.expireAt = now.Add(expired)
+	}
+	return expired, nil
+}
+
+// Delete 删除一个会话
+func (s *memorySessionStore) Delete(session string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.remove(session)
+	return nil
+}
+
+// List 列出用户还有效的会话
+func (s *memorySessionStore) List(userName string) ([]*SessionMeta, error) {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	metas := make([]*SessionMeta, 0, len(s.users[userName]))
+	for session := range s.users[userName] {
+		if item := s.live(session, now); item != nil {
+			meta := item.meta
+			meta.ID = SessionID(session)
+			metas = append(metas, &meta)
+		}
+	}
+	sort.Slice(metas, func(i, j int) bool { return metas[i].LastSeen > metas[j].LastSeen })
+	return metas, nil
+}
+
+// DeleteByID 按会话 ID 删除用户的一个会话
+func (s *memorySessionStore) DeleteByID(userName, id string) (bool, error) {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for session := range s.users[userName] {
+		if SessionID(session) != id {
+			continue
+		}
+		found := s.live(session, now) != nil
+		s.remove(session)
+		return found, nil
+	}
+	return false, nil
+}
+
+// DeleteUserSessions 删除用户除 keep 以外的全部会话
+func (s *memorySessionStore) DeleteUserSessions(userName, keep string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for session := range s.users[userName] {
+		if keep != "" && session == keep {
+			continue
+		}
+		s.remove(session)
+	}
+	return nil
+}
+
+// 取出还没过期的会话，已经过期的顺带删掉，调用方需要持有锁
+func (s *memorySessionStore) live(session string, now time.Time) *memorySession {
+	item, ok := s.sessions[session]
+	if !ok {
+		return nil
+	}
+	if !now.Before(item.expireAt) {
+		s.remove(session)
+		return nil
+	}
+	return item
+}
+
+// 删除会话并从用户的索引里去掉，调用方需要持有锁
+func (s *memorySessionStore) remove(session string) {
+	item, ok := s.sessions[session]
+	if !ok {
+		return
+	}
+	delete(s.sessions, session)
+	if sessions := s.users[item.userName]; sessions != nil {
+		delete(sessions, session)
+		if len(sessions) == 0 {
+			delete(s.users, item.userName)
+		}
+	}
+}
+
+// 定期清理过期的会话，避免不再访问的会话一直占着内存
+func (s *memorySessionStore) purgeLoop() {
+	ticker := time.NewTicker(memorySessionPurgeInterval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		s.mu.Lock()
+		for session := range s.sessions {
+			s.live(session, now)
+		}
+		s.mu.Unlock()
+	}
+}
@@ -0,0 +1,284 @@
+package cache
+
+import (
+	"encoding/json"
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/net/context"
+	"gouse/internal/model"
+	"gouse/pkg/constant"
+	"gouse/utils"
+	"sort"
+	"time"
+)
+
+// redisSessionStore 把会话保存在 Redis 里
+// 会话本身是 session_<session> 这个 key，值是用户信息的 JSON，key 的过期时间就是会话的有效期；
+// 另外每个用户一个会话索引 hash：field 是会话 ID，value 是 SessionMeta 的 JSON。
+// 会话自己过期以后索引里的记录不会自动消失，列出会话时会顺带清理掉
+type redisSessionStore struct{}
+
+// NewRedisSessionStore 创建 Redis 会话存储
+func NewRedisSessionStore() SessionStore {
+	return &redisSessionStore{}
+}
+
+// Create 保存新登录的会话
+// 索引的过期时间取最长的那个会话，所有会话都过期之后索引也会被清理掉
+func (s *redisSessionStore) Create(user *model.User, session string, meta *SessionMeta, expired time.Duration) error {
+	ctx := context.Background()
+	val, err := json.Marshal(user)
+	if err != nil {
+		return err
+	}
+	now := time.Now().Unix()
+	meta.Session = session
+	meta.CreatedAt, meta.LastSeen, meta.LastIP = now, now, meta.IP
+	metaVal, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+
+	indexKey := constant.UserSessionsKey + user.Name
+	indexExpired := expired
+	if ttl, err := utils.GetRedisCli().TTL(ctx, indexKey).Result(); err == nil && ttl > indexExpired {
+		indexExpired = ttl
+	}
+	_, err = utils.GetRedisCli().TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, constant.SessionKeyPrefix+session, val, expired)
+		pipe.HSet(ctx, indexKey, SessionID(session), metaVal)
+		pipe.Expire(ctx, indexKey, indexExpired)
+		return nil
+	})
+	return err
+}
+
+// Get 根据 session 从缓存中获取用户信息
+func (s *redisSessionStore) Get(session string) (*model.User, error) {
+	// 格式不对的 session（包括旧版本可以推算出来的 session）直接拒绝，不去查 Redis
+	if !utils.IsValidSession(session) {
+		return nil, ErrInvalidSession
+	}
+
+	// 构建 Redis 中存储会话信息的键 redisKey
+	redisKey := constant.SessionKeyPrefix + session
+
+	//  获取 Redis 的客户端连接实例，并使用 Get() 方法从 Redis 中获取与 redisKey 对应的值
+	// redis.Nil 表示 key 不存在，也就是 session 未知或者已经过期
+	val, err := utils.GetRedisCli().Get(context.Background(), redisKey).Result()
+	if err == redis.Nil {
+		return nil, ErrSessionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// 将获取到的会话信息值 val 转换为 model.User 的结构体指针类型
+	// 并使用 json.Unmarshal() 方法将 val 反序列化为 model.User 结构体
+	user := &model.User{}
+	err = json.Unmarshal([]byte(val), &user)
+	return user, err
+}
+
+// Update 更新会话里保存的用户信息
+func (s *redisSessionStore) Update(user *model.User, session string) error {
+	// 用全局常量 constant.SessionKeyPrefix + 会话字符串拼装一个 Redis 的 key（redisKey）
+	redisKey := constant.SessionKeyPrefix + session
+
+	// json.Marshal() 将用户对象转换为 JSON 字符串表示，并将其存储在变量 val 中
+	val, err := json.Marshal(&user)
+	if err != nil {
+		return err
+	}
+
+	// SetXX 只在 key 存在时写入，KeepTTL 保留原来的过期时间
+	ok, err := utils.GetRedisCli().SetXX(context.Background(), redisKey, val, redis.KeepTTL).Result()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+// KEYS[1] 是用户的会话索引，KEYS[2] 是会话本身；ARGV 依次是会话 ID、当前时间、记录精度、IP
+// 距离上次记录超过记录精度才更新最近访问时间并续期，避免每个请求都改写 Redis。
+// 续期的时长是 idle_timeout，但不超过绝对过期时间；返回续期后的有效期（秒），
+// 没有续期返回 0，已经到了绝对过期时间返回 -1 并删除会话
+var touchSessionScript = redis.NewScript(`
+local raw = redis.call("HGET", KEYS[1], ARGV[1])
+if not raw then
+	return 0
+end
+local meta = cjson.decode(raw)
+local now = tonumber(ARGV[2])
+if meta.expires_at and meta.expires_at > 0 and meta.expires_at <= now then
+	redis.call("DEL", KEYS[2])
+	redis.call("HDEL", KEYS[1], ARGV[1])
+	return -1
+end
+if meta.last_seen and now - meta.last_seen < tonumber(ARGV[3]) then
+	return 0
+end
+meta.last_seen = now
+meta.last_ip = ARGV[4]
+redis.call("HSET", KEYS[1], ARGV[1], cjson.encode(meta))
+
+local ttl = meta.idle_timeout or 0
+if meta.expires_at and meta.expires_at > 0 and (ttl <= 0 or meta.expires_at - now < ttl) then
+	ttl = meta.expires_at - now
+end
+if ttl <= 0 or redis.call("EXPIRE", KEYS[2], ttl) == 0 then
+	return 0
+end
+if redis.call("TTL", KEYS[1]) < ttl then
+	redis.call("EXPIRE", KEYS[1], ttl)
+end
+return ttl
+`)
+
+// Touch 记录会话最近一次访问的时间和 IP，并续期
+func (s *redisSessionStore) Touch(userName, session, ip string, interval time.Duration) (time.Duration, error) {
+	keys := []string{constant.UserSessionsKey + userName, constant.SessionKeyPrefix + session}
+	ttl, err := touchSessionScript.Run(context.Background(), utils.GetRedisCli(), keys,
+		SessionID(session), time.Now().Unix(), int64(interval.Seconds()), ip).Int64()
+	if err != nil {
+		return 0, err
+	}
+	if ttl < 0 {
+		return 0, ErrSessionNotFound
+	}
+	return time.Duration(ttl) * time.Second, nil
+}
+
+// Delete 删除缓存中的会话信息，同时把它从用户的会话索引里去掉
+func (s *redisSessionStore) Delete(session string) error {
+	// 构建 Redis 中存储会话信息的键 redisKey
+	redisKey := constant.SessionKeyPrefix + session
+
+	// 先取出会话对应的用户，顺带把 session 从该用户的索引里去掉
+	if user, err := s.Get(session); err == nil {
+		utils.GetRedisCli().HDel(context.Background(), constant.UserSessionsKey+user.Name, SessionID(session))
+	}
+
+	// Del() 方法返回删除的键的数量和可能的错误信息
+	_, err := utils.GetRedisCli().Del(context.Background(), redisKey).Result()
+	return err
+}
+
+// List 列出用户还有效的会话，已经过期的会话顺带从索引里删掉
+func (s *redisSessionStore) List(userName string) ([]*SessionMeta, error) {
+	ctx := context.Background()
+	indexKey := constant.UserSessionsKey + userName
+	metas, err := userSessionMetas(indexKey)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(metas))
+	exists := make([]*redis.IntCmd, 0, len(metas))
+	_, err = utils.GetRedisCli().Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for id, meta := range metas {
+			ids = append(ids, id)
+			exists = append(exists, pipe.Exists(ctx, constant.SessionKeyPrefix+meta.Session))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	live := make([]*SessionMeta, 0, len(metas))
+	stale := make([]string, 0)
+	for i, id := range ids {
+		if exists[i].Val() == 0 {
+			stale = append(stale, id)
+			continue
+		}
+		live = append(live, metas[id])
+	}
+	if len(stale) > 0 {
+		utils.GetRedisCli().HDel(ctx, indexKey, stale...)
+	}
+	sort.Slice(live, func(i, j int) bool { return live[i].LastSeen > live[j].LastSeen })
+	return live, nil
+}
+
+// DeleteByID 按会话 ID 删除用户的一个会话
+func (s *redisSessionStore) DeleteByID(userName, id string) (bool, error) {
+	ctx := context.Background()
+	indexKey := constant.UserSessionsKey + userName
+	raw, err := utils.GetRedisCli().HGet(ctx, indexKey, id).Result()
+	if err == redis.Nil {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	meta := &SessionMeta{}
+	if err := json.Unmarshal([]byte(raw), meta); err != nil {
+		return false, err
+	}
+
+	cmds, err := utils.GetRedisCli().TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, constant.SessionKeyPrefix+meta.Session)
+		pipe.HDel(ctx, indexKey, id)
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	// 会话本身已经过期的，也按不存在处理
+	return cmds[0].(*redis.IntCmd).Val() == 1, nil
+}
+
+// DeleteUserSessions 删除用户除 keep 以外的全部会话
+func (s *redisSessionStore) DeleteUserSessions(userName, keep string) error {
+	indexKey := constant.UserSessionsKey + userName
+	metas, err := userSessionMetas(indexKey)
+	if err != nil {
+		return err
+	}
+
+	keys := make([]string, 0, len(metas))
+	fields := make([]string, 0, len(metas))
+	for id, meta := range metas {
+		if keep != "" && meta.Session == keep {
+			continue
+		}
+		keys = append(keys, constant.SessionKeyPrefix+meta.Session)
+		fields = append(fields, id)
+	}
+
+	// 不保留任何会话时索引也一起删掉，否则只从索引里移除被删掉的会话
+	_, err = utils.GetRedisCli().TxPipelined(context.Background(), func(pipe redis.Pipeliner) error {
+		if keep == "" {
+			pipe.Del(context.Background(), append(keys, indexKey)...)
+			return nil
+		}
+		if len(keys) > 0 {
+			pipe.Del(context.Background(), keys...)
+			pipe.HDel(context.Background(), indexKey, fields...)
+		}
+		return nil
+	})
+	return err
+}
+
+// 读出索引里的全部会话，格式不对的记录忽略
+func userSessionMetas(indexKey string) (map[string]*SessionMeta, error) {
+	all, err := utils.GetRedisCli().HGetAll(context.Background(), indexKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	metas := make(map[string]*SessionMeta, len(all))
+	for id, raw := range all {
+		meta := &SessionMeta{}
+		if err := json.Unmarshal([]byte(raw), meta); err != nil || meta.Session == "" {
+			continue
+		}
+		meta.ID = id
+		metas[id] = meta
+	}
+	return metas, nil
+}
@@ -0,0 +1,184 @@
+package cache
+
+import (
+	"encoding/json"
+	log "github.com/sirupsen/logrus"
+	"gouse/internal/dao"
+	"gouse/internal/model"
+	"gouse/utils"
+	"time"
+)
+
+// sql 会话存储多久清理一次已经过期的会话
+const sqlSessionPurgeInterval = 10 * time.Minute
+
+// sqlSessionStore 把会话保存在数据库的 sessions 表里
+// 表里只保存会话 ID，session 本身不落库，数据库泄露也拿不到可以直接使用的 session；
+// 过期的会话查询时按不存在处理，后台定期删除
+type sqlSessionStore struct{}
+
+// NewSQLSessionStore 创建 sql 会话存储
+func NewSQLSessionStore() SessionStore {
+	s := &sqlSessionStore{}
+	go s.purgeLoop()
+	return s
+}
+
+// Create 保存新登录的会话
+func (s *sqlSessionStore) Create(user *model.User, session string, meta *SessionMeta, expired time.Duration) error {
+	val, err := json.Marshal(user)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	meta.Session = session
+	meta.CreatedAt, meta.LastSeen, meta.LastIP = now.Unix(), now.Unix(), meta.IP
+	return dao.CreateSession(&model.Session{
+		ID:          SessionID(session),
+		UserName:    user.Name,
+		UserInfo:    string(val),
+		Device:      meta.Device,
+		UserAgent:   meta.UserAgent,
+		IP:          meta.IP,
+		LastIP:      meta.LastIP,
+		Remember:    meta.Remember,
+		IdleTimeout: meta.IdleTimeout,
+		LoginTime:   now,
+		LastSeen:    now,
+		MaxExpireAt: time.Unix(meta.ExpiresAt, 0),
+		ExpireAt:    now.Add(expired),
+	})
+}
+
+// Get 根据 session 取出会话里的用户
+func (s *sqlSessionStore) Get(session string) (*model.User, error) {
+	if !utils.IsValidSession(session) {
+		return nil, ErrInvalidSession
+	}
+	record, err := dao.GetSession(SessionID(session))
+	if err != nil {
+		return nil, err
+	}
+	if record == nil {
+		return nil, ErrSessionNotFound
+	}
+	user := &model.User{}
+	err = json.Unmarshal([]byte(record.UserInfo), user)
+	return user, err
+}
+
+// Update 更新会话里保存的用户信息，不改变会话剩余的有效期
+func (s *sqlSessionStore) Update(user *model.User, session string) error {
+	val, err := json.Marshal(user)
+	if err != nil {
+		return err
+	}
+	rows, err := dao.UpdateSession(SessionID(session), map[string]interface{}{"user_info": string(val)})
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+// Touch 记录会话最近一次访问的时间和 IP，并续期
+func (s *sqlSessionStore) Touch(userName, session, ip string, interval time.Duration) (time.Duration, error) {
+	id := SessionID(session)
+	record, err := dao.GetSession(id)
+	if err != nil {
+		return 0, err
+	}
+	if record == nil || record.UserName != userName {
+		return 0, nil
+	}
+
+	now := time.Now()
+	meta := toSessionMeta(record)
+	ttl := touchSessionMeta(meta, ip, now.Unix(), interval)
+	if ttl < 0 {
+		if err := dao.DeleteSessionByID(id); err != nil {
+			return 0, err
+		}
+		return 0, ErrSessionNotFound
+	}
+	// 不到记录精度，什么都不用改
+	if meta.LastSeen != now.Unix() {
+		return 0, nil
+	}
+
+	fields := map[string]interface{}{"last_seen": now, "last_ip": ip}
+	expired := time.Duration(ttl) * time.Second
+	if expired > 0 {
+		fields["expire_at"] = now.Add(expired)
+	}
+	if _, err := dao.UpdateSession(id, fields); err != nil {
+		return 0, err
+	}
+	return expired, nil
+}
+
+// Delete 删除一个会话
+func (s *sqlSessionStore) Delete(session string) error {
+	return dao.DeleteSessionByID(SessionID(session))
+}
+
+// List 列出用户还有效的会话
+func (s *sqlSessionStore) List(userName string) ([]*SessionMeta, error) {
+	records, err := dao.ListUserSessions(userName)
+	if err != nil {
+		return nil, err
+	}
+	metas := make([]*SessionMeta, 0, len(records))
+	for _, record := range records {
+		metas = append(metas, toSessionMeta(record))
+	}
+	return metas, nil
+}
+
+// DeleteByID 按会话 ID 删除用户的一个会话
+func (s *sqlSessionStore) DeleteByID(userName, id string) (bool, error) {
+	return dao.DeleteSession(userName, id)
+}
+
+// DeleteUserSessions 删除用户除 keep 以外的全部会话
+func (s *sqlSessionStore) DeleteUserSessions(userName, keep string) error {
+	keepID := ""
+	if keep != "" {
+		keepID = SessionID(keep)
+	}
+	return dao.DeleteUserSessions(userName, keepID)
+}
+
+// 定期删除已经过期的会话
+func (s *sqlSessionStore) purgeLoop() {
+	ticker := time.NewTicker(sqlSessionPurgeInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		n, err := dao.DeleteExpiredSessions()
+		if err != nil {
+			log.Errorf("sqlSessionStore|DeleteExpiredSessions failed, err=%v", err)
+			continue
+		}
+		if n > 0 {
+			log.Infof("sqlSessionStore|%d expired sessions deleted", n)
+		}
+	}
+}
+
+// 表里的会话转换成 SessionMeta，表里没有 session 本身，Session 字段为空
+func toSessionMeta(record *model.Session) *SessionMeta {
+	return &SessionMeta{
+		ID:          record.ID,
+		Device:      record.Device,
+		UserAgent:   record.UserAgent,
+		IP:          record.IP,
+		LastIP:      record.LastIP,
+		CreatedAt:   record.LoginTime.Unix(),
+		LastSeen:    record.LastSeen.Unix(),
+		Remember:    record.Remember,
+		IdleTimeout: record.IdleTimeout,
+		ExpiresAt:   record.MaxExpireAt.Unix(),
+	}
+}
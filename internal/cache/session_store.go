@@ -0,0 +1,130 @@
+package cache
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"gouse/config"
+	"gouse/internal/model"
+	"gouse/utils"
+	"sync"
+	"time"
+)
+
+// 会话存储的类型，对应配置 session.store
+const (
+	SessionStoreRedis  = "redis"
+	SessionStoreMemory = "memory"
+	SessionStoreSQL    = "sql"
+)
+
+// SessionStore 登录会话的存储
+// 业务代码只依赖这个接口，会话保存在哪里由配置 session.store 决定：
+// redis 适合多实例部署；memory 保存在进程内存里，不依赖外部服务，适合单实例和单元测试，重启后会话全部失效；
+// sql 保存在数据库的 sessions 表里，不想为会话单独维护 Redis 时使用
+type SessionStore interface {
+	// Create 保存新登录的会话，并记到用户的会话索引里，会话的有效期是 expired
+	Create(user *model.User, session string, meta *SessionMeta, expired time.Duration) error
+	// Get 根据 session 取出会话里的用户
+	// session 格式不对时返回 ErrInvalidSession，不存在或者已经过期时返回 ErrSessionNotFound
+	Get(session string) (*model.User, error)
+	// Update 更新会话里保存的用户信息，例如用户修改了昵称
+	// 只替换内容，不改变会话剩余的有效期；会话已经不存在时返回 ErrSessionNotFound
+	Update(user *model.User, session string) error
+	// Touch 记录会话最近一次访问的时间和 IP，并按会话的有效期策略续期，精度为 interval
+	// 返回续期后的有效期，为 0 表示这次没有续期；会话已经到了绝对过期时间时返回 ErrSessionNotFound
+	Touch(userName, session, ip string, interval time.Duration) (time.Duration, error)
+	// Delete 删除一个会话，会话不存在时不报错
+	Delete(session string) error
+	// List 列出用户还有效的会话，最近访问的在前面
+	List(userName string) ([]*SessionMeta, error)
+	// DeleteByID 按会话 ID 删除用户的一个会话，返回 false 表示没有这个会话
+	DeleteByID(userName, id string) (bool, error)
+	// DeleteUserSessions 删除用户除 keep 以外的全部会话，keep 为空时全部删除
+	// 用于禁用用户、修改密码、在所有设备上退出登录等场景
+	DeleteUserSessions(userName, keep string) error
+}
+
+// SessionMeta 会话的登录设备等信息
+type SessionMeta struct {
+	ID        string `json:"-"`       // 会话 ID，列出会话时填写
+	Session   string `json:"session"` // session 本身，只在服务端使用；sql 存储不保存它，列出的会话里为空
+	Device    string `json:"device"`  // 从 User-Agent 识别出来的设备，例如 Chrome on Windows
+	UserAgent string `json:"user_agent"`
+	IP        string `json:"ip"`      // 登录时的 IP
+	LastIP    string `json:"last_ip"` // 最近一次访问的 IP
+	CreatedAt int64  `json:"created_at"`
+	LastSeen  int64  `json:"last_seen"`
+
+	Remember    bool  `json:"remember"`     // 登录时是否勾选了"记住我"
+	IdleTimeout int64 `json:"idle_timeout"` // 每次访问续期的时长，单位秒
+	ExpiresAt   int64 `json:"expires_at"`   // 绝对过期时间，到了这个时间不再续期
+}
+
+// SessionID 会话对外的 ID
+// 会话 ID 是 session 的哈希，可以放心地返回给前端、放在 URL 里；session 本身只保存在服务端
+func SessionID(session string) string {
+	return utils.Sha256String(session)[:32]
+}
+
+var (
+	sessionStore     SessionStore // 全局的会话存储
+	sessionStoreErr  error        // 按配置创建会话存储时的错误
+	sessionStoreOnce sync.Once
+)
+
+// 根据配置创建会话存储
+func initSessionStore() {
+	kind := config.GetGlobalConf().Session.Store
+	switch kind {
+	case SessionStoreRedis, "":
+		sessionStore = NewRedisSessionStore()
+	case SessionStoreMemory:
+		sessionStore = NewMemorySessionStore()
+	case SessionStoreSQL:
+		sessionStore = NewSQLSessionStore()
+	default:
+		sessionStoreErr = fmt.Errorf("unknown session store %q", kind)
+		return
+	}
+	log.Infof("session store=%s", kind)
+}
+
+// InitSessionStore 启动时按配置创建会话存储，session.store 配置不对时返回错误，服务不启动
+func InitSessionStore() error {
+	sessionStoreOnce.Do(initSessionStore)
+	return sessionStoreErr
+}
+
+// GetSessionStore 获取会话存储，启动时已经通过 InitSessionStore 校验过配置
+func GetSessionStore() SessionStore {
+	sessionStoreOnce.Do(initSessionStore)
+	return sessionStore
+}
+
+// SetSessionStore 替换全局的会话存储，单元测试里可以换成 NewMemorySessionStore()，不用连接 Redis
+func SetSessionStore(store SessionStore) {
+	sessionStoreOnce.Do(func() {})
+	sessionStore = store
+}
+
+// 按会话的有效期策略处理一次访问，逻辑和 Redis 存储里的 Lua 脚本一致，供 memory 和 sql 存储使用
+// 返回 -1 表示已经到了绝对过期时间；距离上次记录不到 interval 时返回 0，meta 不变；
+// 否则更新 meta 里的最近访问时间和 IP，返回续期后的有效期（秒），为 0 表示不需要续期
+func touchSessionMeta(meta *SessionMeta, ip string, now int64, interval time.Duration) int64 {
+	if meta.ExpiresAt > 0 && meta.ExpiresAt <= now {
+		return -1
+	}
+	if now-meta.LastSeen < int64(interval.Seconds()) {
+		return 0
+	}
+	meta.LastSeen, meta.LastIP = now, ip
+
+	ttl := meta.IdleTimeout
+	if meta.ExpiresAt > 0 && (ttl <= 0 || meta.ExpiresAt-now < ttl) {
+		ttl = meta.ExpiresAt - now
+	}
+	if ttl < 0 {
+		return 0
+	}
+	return ttl
+}
@@ -0,0 +1,239 @@
+package cache
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"gouse/internal/model"
+	"gouse/utils"
+)
+
+// 连接测试库的 DSN，例如 root:123456@(127.0.0.1:3306)/camps_user_test?charset=utf8&parseTime=True&loc=Local
+// 没有设置时跳过 sql 存储的测试；测试会在这个库里建 sessions 表并写入数据，不要指向线上库
+const testMySQLDSNEnv = "GOUSE_TEST_MYSQL_DSN"
+
+// 每个存储实现都要满足同样的约定，新增实现时在这里加一项
+func TestSessionStoreContract(t *testing.T) {
+	stores := []struct {
+		name string
+		new  func(t *testing.T) SessionStore
+	}{
+		{SessionStoreMemory, func(t *testing.T) SessionStore { return NewMemorySessionStore() }},
+		{SessionStoreSQL, newTestSQLSessionStore},
+	}
+	for _, st := range stores {
+		t.Run(st.name, func(t *testing.T) {
+			store := st.new(t)
+			t.Run("CreateGet", func(t *testing.T) { testSessionCreateGet(t, store) })
+			t.Run("Update", func(t *testing.T) { testSessionUpdate(t, store) })
+			t.Run("Touch", func(t *testing.T) { testSessionTouch(t, store) })
+			t.Run("ListDelete", func(t *testing.T) { testSessionListDelete(t, store) })
+			t.Run("DeleteUserSessions", func(t *testing.T) { testSessionDeleteUserSessions(t, store) })
+		})
+	}
+}
+
+func newTestSQLSessionStore(t *testing.T) SessionStore {
+	dsn := os.Getenv(testMySQLDSNEnv)
+	if dsn == "" {
+		t.Skipf("%s is not set", testMySQLDSNEnv)
+	}
+	conn, err := gorm.Open(mysql.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("open test db err: %v", err)
+	}
+	if err := conn.AutoMigrate(&model.Session{}); err != nil {
+		t.Fatalf("migrate sessions err: %v", err)
+	}
+	utils.SetDB(conn)
+	return NewSQLSessionStore()
+}
+
+// 每个用例用不同的用户名，sql 存储的测试库重复跑时互不影响
+func testUserName(t *testing.T) string {
+	t.Helper()
+	suffix, err := utils.RandomToken(6)
+	if err != nil {
+		t.Fatalf("RandomToken err: %v", err)
+	}
+	return "test_" + suffix
+}
+
+func newTestSession(t *testing.T, store SessionStore, user *model.User, meta *SessionMeta, expired time.Duration) string {
+	t.Helper()
+	session, err := utils.GenerateSession()
+	if err != nil {
+		t.Fatalf("GenerateSession err: %v", err)
+	}
+	if meta == nil {
+		meta = &SessionMeta{Device: "Chrome on Windows", IP: "10.0.0.1", IdleTimeout: 3600, ExpiresAt: time.Now().Add(time.Hour).Unix()}
+	}
+	if err := store.Create(user, session, meta, expired); err != nil {
+		t.Fatalf("Create err: %v", err)
+	}
+	return session
+}
+
+func testSessionCreateGet(t *testing.T, store SessionStore) {
+	user := &model.User{ID: 1, Name: testUserName(t), NickName: "alice"}
+	session := newTestSession(t, store, user, nil, time.Hour)
+
+	got, err := store.Get(session)
+	if err != nil {
+		t.Fatalf("Get err: %v", err)
+	}
+	if got.ID != user.ID || got.Name != user.Name || got.NickName != user.NickName {
+		t.Errorf("Get = %+v, want %+v", got, user)
+	}
+
+	// 取出来的是新对象，修改它不影响存储里的数据
+	got.NickName = "changed"
+	if again, _ := store.Get(session); again.NickName != "alice" {
+		t.Errorf("Get after modifying the returned user = %q, want alice", again.NickName)
+	}
+
+	if _, err := store.Get("not-a-session"); !errors.Is(err, ErrInvalidSession) {
+		t.Errorf("Get(invalid) err = %v, want ErrInvalidSession", err)
+	}
+	unknown, _ := utils.GenerateSession()
+	if _, err := store.Get(unknown); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("Get(unknown) err = %v, want ErrSessionNotFound", err)
+	}
+}
+
+func testSessionUpdate(t *testing.T, store SessionStore) {
+	user := &model.User{ID: 2, Name: testUserName(t), NickName: "bob"}
+	session := newTestSession(t, store, user, nil, time.Hour)
+
+	user.NickName = "robert"
+	if err := store.Update(user, session); err != nil {
+		t.Fatalf("Update err: %v", err)
+	}
+	if got, _ := store.Get(session); got == nil || got.NickName != "robert" {
+		t.Errorf("Get after Update = %+v", got)
+	}
+
+	unknown, _ := utils.GenerateSession()
+	if err := store.Update(user, unknown); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("Update(unknown) err = %v, want ErrSessionNotFound", err)
+	}
+}
+
+func testSessionTouch(t *testing.T, store SessionStore) {
+	user := &model.User{ID: 3, Name: testUserName(t)}
+	session := newTestSession(t, store, user, nil, time.Minute)
+
+	// 刚登录，距离上次记录不到 interval，不续期
+	if ttl, err := store.Touch(user.Name, session, "10.0.0.2", time.Hour); err != nil || ttl != 0 {
+		t.Errorf("Touch within interval = %v, %v, want 0, nil", ttl, err)
+	}
+
+	// 续期到 idle_timeout，记录最近访问的 IP
+	ttl, err := store.Touch(user.Name, session, "10.0.0.2", 0)
+	if err != nil {
+		t.Fatalf("Touch err: %v", err)
+	}
+	if ttl <= time.Minute || ttl > time.Hour {
+		t.Errorf("Touch ttl = %v, want (1m, 1h]", ttl)
+	}
+	metas, err := store.List(user.Name)
+	if err != nil || len(metas) != 1 {
+		t.Fatalf("List = %v, %v, want 1 session", metas, err)
+	}
+	if metas[0].LastIP != "10.0.0.2" || metas[0].IP != "10.0.0.1" {
+		t.Errorf("after Touch ip = %s, last_ip = %s", metas[0].IP, metas[0].LastIP)
+	}
+
+	// 别人的会话不续期
+	if ttl, err := store.Touch("someone_else", session, "10.0.0.3", 0); err != nil || ttl != 0 {
+		t.Errorf("Touch(other user) = %v, %v, want 0, nil", ttl, err)
+	}
+
+	// 到了绝对过期时间的会话不再续期，并且被删除
+	expiredMeta := &SessionMeta{IP: "10.0.0.1", IdleTimeout: 3600, ExpiresAt: time.Now().Add(-time.Second).Unix()}
+	expired := newTestSession(t, store, user, expiredMeta, time.Minute)
+	if _, err := store.Touch(user.Name, expired, "10.0.0.1", 0); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("Touch(past absolute timeout) err = %v, want ErrSessionNotFound", err)
+	}
+	if _, err := store.Get(expired); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("Get after absolute timeout err = %v, want ErrSessionNotFound", err)
+	}
+}
+
+func testSessionListDelete(t *testing.T, store SessionStore) {
+	user := &model.User{ID: 4, Name: testUserName(t)}
+	first := newTestSession(t, store, user, nil, time.Hour)
+	second := newTestSession(t, store, user, nil, time.Hour)
+
+	metas, err := store.List(user.Name)
+	if err != nil {
+		t.Fatalf("List err: %v", err)
+	}
+	ids := map[string]bool{}
+	for _, meta := range metas {
+		ids[meta.ID] = true
+	}
+	if len(metas) != 2 || !ids[SessionID(first)] || !ids[SessionID(second)] {
+		t.Fatalf("List = %v, want the ids of both sessions", ids)
+	}
+
+	// 按会话 ID 删除，只能删除自己的会话
+	if found, err := store.DeleteByID("someone_else", SessionID(first)); err != nil || found {
+		t.Errorf("DeleteByID(other user) = %v, %v, want false, nil", found, err)
+	}
+	if found, err := store.DeleteByID(user.Name, SessionID(first)); err != nil || !found {
+		t.Errorf("DeleteByID = %v, %v, want true, nil", found, err)
+	}
+	if found, _ := store.DeleteByID(user.Name, SessionID(first)); found {
+		t.Errorf("DeleteByID twice = true, want false")
+	}
+	if _, err := store.Get(first); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("Get after DeleteByID err = %v, want ErrSessionNotFound", err)
+	}
+
+	if err := store.Delete(second); err != nil {
+		t.Fatalf("Delete err: %v", err)
+	}
+	if err := store.Delete(second); err != nil {
+		t.Errorf("Delete twice err = %v, want nil", err)
+	}
+	if metas, _ := store.List(user.Name); len(metas) != 0 {
+		t.Errorf("List after Delete = %d sessions, want 0", len(metas))
+	}
+}
+
+func testSessionDeleteUserSessions(t *testing.T, store SessionStore) {
+	user := &model.User{ID: 5, Name: testUserName(t)}
+	other := &model.User{ID: 6, Name: testUserName(t)}
+	keep := newTestSession(t, store, user, nil, time.Hour)
+	drop := newTestSession(t, store, user, nil, time.Hour)
+	others := newTestSession(t, store, other, nil, time.Hour)
+
+	if err := store.DeleteUserSessions(user.Name, keep); err != nil {
+		t.Fatalf("DeleteUserSessions err: %v", err)
+	}
+	if _, err := store.Get(keep); err != nil {
+		t.Errorf("Get(kept session) err = %v", err)
+	}
+	if _, err := store.Get(drop); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("Get(dropped session) err = %v, want ErrSessionNotFound", err)
+	}
+	if _, err := store.Get(others); err != nil {
+		t.Errorf("Get(other user's session) err = %v", err)
+	}
+
+	if err := store.DeleteUserSessions(user.Name, ""); err != nil {
+		t.Fatalf("DeleteUserSessions(all) err: %v", err)
+	}
+	if _, err := store.Get(keep); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("Get after deleting all sessions err = %v, want ErrSessionNotFound", err)
+	}
+	if err := store.DeleteUserSessions(other.Name, ""); err != nil {
+		t.Errorf("DeleteUserSessions(other) err = %v", err)
+	}
+}
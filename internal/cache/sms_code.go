@@ -0,0 +1,75 @@
+package cache
+
+import (
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/net/context"
+	"gouse/pkg/constant"
+	"gouse/utils"
+	"time"
+)
+
+// 短信验证码：同一个用途、同一个号码同一时间只有一个有效的验证码，用一个 hash 保存，
+// code 是验证码的哈希，owner 是验证码属于哪个用户（绑定手机号时是发起绑定的用户，登录时是手机号所属的用户），
+// attempts 是已经输错的次数
+
+// 限制发送短信验证码的频率，interval 内同一个号码只允许发送一次
+func AllowSMSCode(phone string, interval time.Duration) (bool, error) {
+	redisKey := constant.SMSThrottlePrefix + phone
+	return utils.GetRedisCli().SetNX(context.Background(), redisKey, 1, interval).Result()
+}
+
+// 发送短信验证码的次数加一，返回统计窗口内的次数，窗口从第一次发送开始计算
+// subject 区分统计的对象，例如 "phone:+8613812345678"、"ip:127.0.0.0/24"、"global"
+func IncrSMSCount(subject string, window time.Duration) (int64, error) {
+	return incrWithExpire(constant.SMSCountPrefix+subject, window)
+}
+
+// 保存新的短信验证码，同一个用途、同一个号码之前发出的验证码一并作废
+func SetSMSCode(purpose, phone, codeHash, owner string, expired time.Duration) error {
+	ctx := context.Background()
+	redisKey := constant.SMSCodePrefix + purpose + "_" + phone
+	_, err := utils.GetRedisCli().TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, redisKey)
+		pipe.HSet(ctx, redisKey, "code", codeHash, "owner", owner, "attempts", 0)
+		pipe.Expire(ctx, redisKey, expired)
+		return nil
+	})
+	return err
+}
+
+// KEYS[1] 是验证码；ARGV 依次是验证码的哈希、最多输错几次
+// 比对和删除在一个脚本里完成，验证码只能使用一次，并发猜验证码也会被准确计数。
+// 返回 {1, owner} 表示成功，{0} 表示不存在，{-1} 表示不匹配
+var checkSMSCodeScript = redis.NewScript(`
+local vals = redis.call("HMGET", KEYS[1], "code", "owner")
+if not vals[1] then
+	return {0}
+end
+if vals[1] ~= ARGV[1] then
+	if redis.call("HINCRBY", KEYS[1], "attempts", 1) >= tonumber(ARGV[2]) then
+		redis.call("DEL", KEYS[1])
+	end
+	return {-1}
+end
+redis.call("DEL", KEYS[1])
+return {1, vals[2]}
+`)
+
+// 校验并删除短信验证码，返回验证码所属的用户名
+// 不存在或者已经过期时返回 ErrTokenNotFound，不匹配时返回 ErrCodeMismatch，输错次数达到 maxAttempts 时验证码作废
+func CheckSMSCode(purpose, phone, codeHash string, maxAttempts int) (string, error) {
+	redisKey := constant.SMSCodePrefix + purpose + "_" + phone
+	res, err := checkSMSCodeScript.Run(context.Background(), utils.GetRedisCli(), []string{redisKey}, codeHash, maxAttempts).Slice()
+	if err != nil {
+		return "", err
+	}
+	status, _ := res[0].(int64)
+	switch status {
+	case 0:
+		return "", ErrTokenNotFound
+	case -1:
+		return "", ErrCodeMismatch
+	}
+	owner, _ := res[1].(string)
+	return owner, nil
+}
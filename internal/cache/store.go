@@ -0,0 +1,84 @@
+package cache
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"gouse/config"
+	"sync"
+)
+
+// 用户缓存、登录保护、refresh token 的存储类型，对应配置 cache.store
+const (
+	CacheStoreRedis  = "redis"
+	CacheStoreMemory = "memory"
+)
+
+var (
+	userCache         UserCache         // 全局的用户缓存
+	loginProtectStore LoginProtectStore // 全局的登录保护存储
+	refreshTokenStore RefreshTokenStore // 全局的 refresh token 存储
+	cacheStoreErr     error             // 按配置创建存储时的错误
+	cacheStoreOnce    sync.Once
+)
+
+// 根据配置创建用户缓存、登录保护和 refresh token 的存储
+// 和 session.store 配成 memory 或者 sql 一起使用时，登录、登出、查询和修改用户信息都不再需要 Redis
+func initCacheStores() {
+	kind := config.GetGlobalConf().Cache.Store
+	switch kind {
+	case CacheStoreRedis, "":
+		userCache = NewRedisUserCache()
+		loginProtectStore = NewRedisLoginProtectStore()
+		refreshTokenStore = NewRedisRefreshTokenStore()
+	case CacheStoreMemory:
+		userCache = NewMemoryUserCache()
+		loginProtectStore = NewMemoryLoginProtectStore()
+		refreshTokenStore = NewMemoryRefreshTokenStore()
+	default:
+		cacheStoreErr = fmt.Errorf("unknown cache store %q", kind)
+		return
+	}
+	log.Infof("cache store=%s", kind)
+}
+
+// InitCacheStores 启动时按配置创建存储，cache.store 配置不对时返回错误，服务不启动
+func InitCacheStores() error {
+	cacheStoreOnce.Do(initCacheStores)
+	return cacheStoreErr
+}
+
+// GetUserCache 获取用户缓存，启动时已经通过 InitCacheStores 校验过配置
+func GetUserCache() UserCache {
+	cacheStoreOnce.Do(initCacheStores)
+	return userCache
+}
+
+// GetLoginProtectStore 获取登录保护存储
+func GetLoginProtectStore() LoginProtectStore {
+	cacheStoreOnce.Do(initCacheStores)
+	return loginProtectStore
+}
+
+// GetRefreshTokenStore 获取 refresh token 存储
+func GetRefreshTokenStore() RefreshTokenStore {
+	cacheStoreOnce.Do(initCacheStores)
+	return refreshTokenStore
+}
+
+// SetUserCache 替换全局的用户缓存，单元测试里可以换成 NewMemoryUserCache()，不用连接 Redis
+func SetUserCache(c UserCache) {
+	cacheStoreOnce.Do(func() {})
+	userCache = c
+}
+
+// SetLoginProtectStore 替换全局的登录保护存储，单元测试里可以换成 NewMemoryLoginProtectStore()
+func SetLoginProtectStore(s LoginProtectStore) {
+	cacheStoreOnce.Do(func() {})
+	loginProtectStore = s
+}
+
+// SetRefreshTokenStore 替换全局的 refresh token 存储，单元测试里可以换成 NewMemoryRefreshTokenStore()
+func SetRefreshTokenStore(s RefreshTokenStore) {
+	cacheStoreOnce.Do(func() {})
+	refreshTokenStore = s
+}
@@ -0,0 +1,139 @@
+package cache
+
+import (
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/net/context"
+	"gouse/pkg/constant"
+	"gouse/utils"
+	"time"
+)
+
+// refresh token 的存储
+//
+// 一次登录对应一个 refresh token 家族，每次刷新都会换发新的 refresh token，旧的标记为已使用但保留到过期。
+// 已使用的 refresh token 再次出现，说明它被人盗用了，此时吊销整个家族，盗用者和本人都需要重新登录。
+// access token 里带有家族 ID，家族被吊销后它签发过的 access token 也立即失效。
+
+// RefreshToken 一个 refresh token 的记录
+type RefreshToken struct {
+	Name   string // 用户名
+	Family string // 所属家族
+	Used   bool   // 是否已经换发过
+}
+
+// RefreshTokenStore refresh token 和家族的存储，保存在哪里由配置 cache.store 决定
+type RefreshTokenStore interface {
+	// Add 保存 refresh token，同时创建家族或者把家族的有效期顺延到 expired
+	Add(userName, family, tokenHash string, expired time.Duration) error
+	// Get 查询 refresh token，不存在或者已经过期时返回 ErrTokenNotFound
+	Get(tokenHash string) (*RefreshToken, error)
+	// MarkUsed 把 refresh token 标记为已使用，返回 false 表示它之前已经被用过了（重放）
+	// 并发刷新同一个 token 时只有一个请求能拿到 true；token 已经过期或者被删除时返回 ErrTokenNotFound
+	MarkUsed(tokenHash string) (bool, error)
+	// GetFamily 查询家族所属的用户名，家族已经被吊销或者过期时返回 ErrTokenNotFound
+	GetFamily(family string) (string, error)
+	// RevokeFamily 吊销一个家族，家族里的 refresh token 和它签发过的 access token 全部失效
+	RevokeFamily(userName, family string) error
+	// RevokeUserFamilies 吊销用户除 keep 以外的全部家族，keep 为空时全部吊销
+	RevokeUserFamilies(userName, keep string) error
+}
+
+// redisRefreshTokenStore 把 refresh token 保存在 Redis 里
+type redisRefreshTokenStore struct{}
+
+// NewRedisRefreshTokenStore 创建 Redis refresh token 存储
+func NewRedisRefreshTokenStore() RefreshTokenStore {
+	return &redisRefreshTokenStore{}
+}
+
+// Add 保存 refresh token
+func (s *redisRefreshTokenStore) Add(userName, family, tokenHash string, expired time.Duration) error {
+	ctx := context.Background()
+	tokenKey := constant.RefreshTokenPrefix + tokenHash
+	indexKey := constant.UserRefreshFamiliesKey + userName
+	_, err := utils.GetRedisCli().TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, constant.RefreshFamilyPrefix+family, userName, expired)
+		pipe.HSet(ctx, tokenKey, "name", userName, "family", family, "used", 0)
+		pipe.Expire(ctx, tokenKey, expired)
+		pipe.SAdd(ctx, indexKey, family)
+		pipe.Expire(ctx, indexKey, expired)
+		return nil
+	})
+	return err
+}
+
+// Get 查询 refresh token
+func (s *redisRefreshTokenStore) Get(tokenHash string) (*RefreshToken, error) {
+	vals, err := utils.GetRedisCli().HGetAll(context.Background(), constant.RefreshTokenPrefix+tokenHash).Result()
+	if err != nil {
+		return nil, err
+	}
+	if vals["name"] == "" || vals["family"] == "" {
+		return nil, ErrTokenNotFound
+	}
+	return &RefreshToken{Name: vals["name"], Family: vals["family"], Used: vals["used"] != "0"}, nil
+}
+
+// key 存在时才给 used 加一，不存在时返回 0；
+// 直接 HINCRBY 会在 token 过期或者被删除后重新建一个没有过期时间的 key
+var markRefreshTokenUsedScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 0 then
+	return 0
+end
+return redis.call("HINCRBY", KEYS[1], "used", 1)
+`)
+
+// MarkUsed 把 refresh token 标记为已使用
+func (s *redisRefreshTokenStore) MarkUsed(tokenHash string) (bool, error) {
+	n, err := markRefreshTokenUsedScript.Run(context.Background(), utils.GetRedisCli(),
+		[]string{constant.RefreshTokenPrefix + tokenHash}).Int64()
+	if err != nil {
+		return false, err
+	}
+	if n == 0 {
+		return false, ErrTokenNotFound
+	}
+	return n == 1, nil
+}
+
+// GetFamily 查询家族所属的用户名
+func (s *redisRefreshTokenStore) GetFamily(family string) (string, error) {
+	name, err := utils.GetRedisCli().Get(context.Background(), constant.RefreshFamilyPrefix+family).Result()
+	if err == redis.Nil {
+		return "", ErrTokenNotFound
+	}
+	return name, err
+}
+
+// RevokeFamily 吊销一个家族
+func (s *redisRefreshTokenStore) RevokeFamily(userName, family string) error {
+	ctx := context.Background()
+	_, err := utils.GetRedisCli().TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, constant.RefreshFamilyPrefix+family)
+		pipe.SRem(ctx, constant.UserRefreshFamiliesKey+userName, family)
+		return nil
+	})
+	return err
+}
+
+// RevokeUserFamilies 吊销用户除 keep 以外的全部家族
+func (s *redisRefreshTokenStore) RevokeUserFamilies(userName, keep string) error {
+	ctx := context.Background()
+	indexKey := constant.UserRefreshFamiliesKey + userName
+	families, err := utils.GetRedisCli().SMembers(ctx, indexKey).Result()
+	if err != nil {
+		return err
+	}
+
+	_, err = utils.GetRedisCli().TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, family := range families {
+			if family == keep {
+				continue
+			}
+			pipe.Del(ctx, constant.RefreshFamilyPrefix+family)
+			pipe.SRem(ctx, indexKey, family)
+		}
+		return nil
+	})
+	return err
+}
@@ -0,0 +1,87 @@
+package dao
+
+import (
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gouse/internal/model"
+	"gouse/utils"
+	"time"
+)
+
+// CreateAPIKey 保存新的 API key
+func CreateAPIKey(key *model.APIKey) error {
+	if err := utils.GetDB().Create(key).Error; err != nil {
+		log.Errorf("CreateAPIKey fail:%v", err)
+		return fmt.Errorf("CreateAPIKey fail:%v", err)
+	}
+	return nil
+}
+
+// GetAPIKeyByHash 根据 key 的哈希获取 API key，不存在时返回 nil, nil
+func GetAPIKeyByHash(keyHash string) (*model.APIKey, error) {
+	return getAPIKey("key_hash = ?", keyHash)
+}
+
+// GetAPIKeyByID 根据 ID 获取 API key，不存在时返回 nil, nil
+func GetAPIKeyByID(id int) (*model.APIKey, error) {
+	return getAPIKey("id = ?", id)
+}
+
+func getAPIKey(query string, arg interface{}) (*model.APIKey, error) {
+	key := &model.APIKey{}
+	if err := utils.GetDB().Model(&model.APIKey{}).Where(query, arg).First(key).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		log.Errorf("getAPIKey fail:%v", err)
+		return nil, fmt.Errorf("getAPIKey fail:%v", err)
+	}
+	return key, nil
+}
+
+// ListUserAPIKeys 获取用户没有吊销的 API key，包括已经过期的
+func ListUserAPIKeys(userID int) ([]*model.APIKey, error) {
+	var keys []*model.APIKey
+	err := utils.GetDB().Model(&model.APIKey{}).Where("user_id = ? AND revoked_at IS NULL", userID).Order("id").Find(&keys).Error
+	if err != nil {
+		log.Errorf("ListUserAPIKeys fail:%v", err)
+		return nil, fmt.Errorf("ListUserAPIKeys fail:%v", err)
+	}
+	return keys, nil
+}
+
+// CountActiveAPIKeys 统计用户还能使用的 API key 个数
+func CountActiveAPIKeys(userID int) (int64, error) {
+	var count int64
+	err := utils.GetDB().Model(&model.APIKey{}).
+		Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, time.Now()).Count(&count).Error
+	if err != nil {
+		log.Errorf("CountActiveAPIKeys fail:%v", err)
+		return 0, fmt.Errorf("CountActiveAPIKeys fail:%v", err)
+	}
+	return count, nil
+}
+
+// RevokeAPIKey 吊销 API key，返回受影响的行数，已经吊销过的返回 0
+func RevokeAPIKey(id int) (int64, error) {
+	result := utils.GetDB().Model(&model.APIKey{}).Where("id = ? AND revoked_at IS NULL", id).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		log.Errorf("RevokeAPIKey fail:%v", result.Error)
+		return 0, fmt.Errorf("RevokeAPIKey fail:%v", result.Error)
+	}
+	return result.RowsAffected, nil
+}
+
+// TouchAPIKey 记录 API key 的使用时间和 IP
+// 上一次记录的时间晚于 before 时不更新，避免每个请求都写一次数据库
+func TouchAPIKey(id int, ip string, before time.Time) {
+	err := utils.GetDB().Model(&model.APIKey{}).
+		Where("id = ? AND (last_used_at IS NULL OR last_used_at < ?)", id, before).
+		Updates(map[string]interface{}{"last_used_at": time.Now(), "last_used_ip": ip}).Error
+	if err != nil {
+		log.Errorf("TouchAPIKey fail:%v", err)
+	}
+}
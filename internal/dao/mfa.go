@@ -0,0 +1,117 @@
+package dao
+
+import (
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gouse/internal/model"
+	"gouse/utils"
+	"time"
+)
+
+// GetUserTOTP 获取用户的 TOTP 绑定信息，没有绑定时返回 nil, nil
+func GetUserTOTP(userID int) (*model.UserTOTP, error) {
+	t := &model.UserTOTP{}
+	if err := utils.GetDB().Model(&model.UserTOTP{}).Where("user_id = ?", userID).First(t).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		log.Errorf("GetUserTOTP fail:%v", err)
+		return nil, fmt.Errorf("GetUserTOTP fail:%v", err)
+	}
+	return t, nil
+}
+
+// ResetUserTOTP 保存一个新的待确认的 TOTP 密钥，之前没有确认的密钥作废
+// 已经确认绑定的记录不会被覆盖，调用方需要先判断
+func ResetUserTOTP(t *model.UserTOTP) error {
+	err := utils.GetDB().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ? AND enabled = ?", t.UserID, false).Delete(&model.UserTOTP{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(t).Error
+	})
+	if err != nil {
+		log.Errorf("ResetUserTOTP fail:%v", err)
+		return fmt.Errorf("ResetUserTOTP fail:%v", err)
+	}
+	return nil
+}
+
+// EnableUserTOTP 确认绑定 TOTP，同时记录验证通过的时间步并替换全部恢复码
+func EnableUserTOTP(userID int, step int64, codeHashes []string) error {
+	now := time.Now()
+	err := utils.GetDB().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&model.UserTOTP{}).Where("user_id = ?", userID).Updates(map[string]interface{}{
+			"enabled":        true,
+			"last_used_step": step,
+			"confirmed_at":   now,
+		}).Error; err != nil {
+			return err
+		}
+		return replaceRecoveryCodes(tx, userID, codeHashes)
+	})
+	if err != nil {
+		log.Errorf("EnableUserTOTP fail:%v", err)
+		return fmt.Errorf("EnableUserTOTP fail:%v", err)
+	}
+	return nil
+}
+
+// DeleteUserTOTP 解除 TOTP 绑定，恢复码一并删除
+func DeleteUserTOTP(userID int) error {
+	err := utils.GetDB().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&model.UserTOTP{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", userID).Delete(&model.RecoveryCode{}).Error
+	})
+	if err != nil {
+		log.Errorf("DeleteUserTOTP fail:%v", err)
+		return fmt.Errorf("DeleteUserTOTP fail:%v", err)
+	}
+	return nil
+}
+
+// UseTOTPStep 记录验证通过的时间步，只有比上一次更新的时间步才能更新成功
+// 返回 false 表示这个时间步的验证码已经用过了（重放）
+func UseTOTPStep(userID int, step int64) bool {
+	return utils.GetDB().Model(&model.UserTOTP{}).
+		Where("user_id = ? AND enabled = ? AND last_used_step < ?", userID, true, step).
+		Update("last_used_step", step).RowsAffected == 1
+}
+
+// UseRecoveryCode 使用一个恢复码，返回 false 表示恢复码不存在或者已经用过
+func UseRecoveryCode(userID int, codeHash string) bool {
+	return utils.GetDB().Model(&model.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, codeHash).
+		Update("used_at", time.Now()).RowsAffected == 1
+}
+
+// ReplaceRecoveryCodes 重新生成恢复码，之前的恢复码全部作废
+func ReplaceRecoveryCodes(userID int, codeHashes []string) error {
+	err := utils.GetDB().Transaction(func(tx *gorm.DB) error {
+		return replaceRecoveryCodes(tx, userID, codeHashes)
+	})
+	if err != nil {
+		log.Errorf("ReplaceRecoveryCodes fail:%v", err)
+		return fmt.Errorf("ReplaceRecoveryCodes fail:%v", err)
+	}
+	return nil
+}
+
+// 删除用户之前的恢复码，换成新的一批
+func replaceRecoveryCodes(tx *gorm.DB, userID int, codeHashes []string) error {
+	if err := tx.Where("user_id = ?", userID).Delete(&model.RecoveryCode{}).Error; err != nil {
+		return err
+	}
+	codes := make([]*model.RecoveryCode, 0, len(codeHashes))
+	for _, h := range codeHashes {
+		codes = append(codes, &model.RecoveryCode{UserID: userID, CodeHash: h})
+	}
+	if len(codes) == 0 {
+		return nil
+	}
+	return tx.Create(&codes).Error
+}
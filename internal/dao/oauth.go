@@ -0,0 +1,52 @@
+package dao
+
+import (
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gouse/internal/model"
+	"gouse/utils"
+)
+
+// CreateOAuthClient 注册接入应用
+func CreateOAuthClient(client *model.OAuthClient) error {
+	if err := utils.GetDB().Create(client).Error; err != nil {
+		log.Errorf("CreateOAuthClient fail:%v", err)
+		return fmt.Errorf("CreateOAuthClient fail:%v", err)
+	}
+	return nil
+}
+
+// GetOAuthClient 根据 client_id 获取接入应用，不存在时返回 nil, nil
+func GetOAuthClient(clientID string) (*model.OAuthClient, error) {
+	client := &model.OAuthClient{}
+	if err := utils.GetDB().Model(&model.OAuthClient{}).Where("client_id = ?", clientID).First(client).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		log.Errorf("GetOAuthClient fail:%v", err)
+		return nil, fmt.Errorf("GetOAuthClient fail:%v", err)
+	}
+	return client, nil
+}
+
+// ListOAuthClients 获取全部接入应用
+func ListOAuthClients() ([]*model.OAuthClient, error) {
+	var clients []*model.OAuthClient
+	if err := utils.GetDB().Model(&model.OAuthClient{}).Order("id").Find(&clients).Error; err != nil {
+		log.Errorf("ListOAuthClients fail:%v", err)
+		return nil, fmt.Errorf("ListOAuthClients fail:%v", err)
+	}
+	return clients, nil
+}
+
+// DeleteOAuthClient 删除接入应用，返回被删除的行数
+func DeleteOAuthClient(clientID string) (int64, error) {
+	result := utils.GetDB().Where("client_id = ?", clientID).Delete(&model.OAuthClient{})
+	if result.Error != nil {
+		log.Errorf("DeleteOAuthClient fail:%v", result.Error)
+		return 0, fmt.Errorf("DeleteOAuthClient fail:%v", result.Error)
+	}
+	return result.RowsAffected, nil
+}
@@ -0,0 +1,45 @@
+package dao
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gouse/internal/model"
+	"gouse/utils"
+	"time"
+)
+
+// ListOIDCKeys 获取全部 ID token 签名密钥，最新的在前面
+func ListOIDCKeys() ([]*model.OIDCKey, error) {
+	var keys []*model.OIDCKey
+	if err := utils.GetDB().Model(&model.OIDCKey{}).Order("id desc").Find(&keys).Error; err != nil {
+		log.Errorf("ListOIDCKeys fail:%v", err)
+		return nil, fmt.Errorf("ListOIDCKeys fail:%v", err)
+	}
+	return keys, nil
+}
+
+// RotateOIDCKey 保存新的签名密钥，之前正在使用的密钥标记为已轮换，并删除保留期之前轮换下来的密钥
+// revoke 为 true 时直接删除之前正在使用的密钥，不再通过 JWKS 公布，用于私钥泄露的情况
+func RotateOIDCKey(key *model.OIDCKey, retireBefore time.Time, revoke bool) error {
+	now := time.Now()
+	err := utils.GetDB().Transaction(func(tx *gorm.DB) error {
+		current := tx.Model(&model.OIDCKey{}).Where("retired_at IS NULL")
+		if revoke {
+			if err := current.Delete(&model.OIDCKey{}).Error; err != nil {
+				return err
+			}
+		} else if err := current.Update("retired_at", now).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("retired_at < ?", retireBefore).Delete(&model.OIDCKey{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(key).Error
+	})
+	if err != nil {
+		log.Errorf("RotateOIDCKey fail:%v", err)
+		return fmt.Errorf("RotateOIDCKey fail:%v", err)
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package dao
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gouse/internal/model"
+	"gouse/utils"
+)
+
+// ListPasswordHistory 获取用户最近用过的 limit 个密码哈希，最近的在前面
+func ListPasswordHistory(userID, limit int) ([]string, error) {
+	var hashes []string
+	err := utils.GetDB().Model(&model.PasswordHistory{}).Where("user_id = ?", userID).
+		Order("id desc").Limit(limit).Pluck("password", &hashes).Error
+	if err != nil {
+		log.Errorf("ListPasswordHistory fail:%v", err)
+		return nil, fmt.Errorf("ListPasswordHistory fail:%v", err)
+	}
+	return hashes, nil
+}
+
+// AddPasswordHistory 记录用户新设置的密码哈希，只保留最近的 keep 个
+func AddPasswordHistory(history *model.PasswordHistory, keep int) error {
+	if err := addPasswordHistory(utils.GetDB(), history, keep); err != nil {
+		log.Errorf("AddPasswordHistory fail:%v", err)
+		return fmt.Errorf("AddPasswordHistory fail:%v", err)
+	}
+	return nil
+}
+
+// 在 db（可以是事务）里写入密码历史，并删掉比最近 keep 个更早的记录
+func addPasswordHistory(db *gorm.DB, history *model.PasswordHistory, keep int) error {
+	if err := db.Create(history).Error; err != nil {
+		return err
+	}
+
+	// 找到第 keep 新的记录，比它更早的都删掉
+	var ids []int
+	err := db.Model(&model.PasswordHistory{}).Where("user_id = ?", history.UserID).
+		Order("id desc").Offset(keep-1).Limit(1).Pluck("id", &ids).Error
+	if err != nil {
+		return fmt.Errorf("find oldest kept err:%v", err)
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	if err := db.Where("user_id = ? AND id < ?", history.UserID, ids[0]).Delete(&model.PasswordHistory{}).Error; err != nil {
+		return fmt.Errorf("prune err:%v", err)
+	}
+	return nil
+}
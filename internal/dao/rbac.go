@@ -0,0 +1,139 @@
+package dao
+
+import (
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gouse/internal/model"
+	"gouse/utils"
+)
+
+// EnsurePermission 按权限标识查询权限，不存在就创建
+func EnsurePermission(code, description string) (*model.Permission, error) {
+	perm := &model.Permission{}
+	err := utils.GetDB().Model(&model.Permission{}).
+		Where(model.Permission{Code: code}).
+		Attrs(model.Permission{Description: description, CreateModel: model.CreateModel{Creator: "system"}}).
+		FirstOrCreate(perm).Error
+	if err != nil {
+		log.Errorf("EnsurePermission fail:%v", err)
+		return nil, fmt.Errorf("EnsurePermission fail:%v", err)
+	}
+	return perm, nil
+}
+
+// EnsureRole 按角色名查询角色，不存在就创建
+func EnsureRole(name, description string) (*model.Role, error) {
+	role := &model.Role{}
+	err := utils.GetDB().Model(&model.Role{}).
+		Where(model.Role{Name: name}).
+		Attrs(model.Role{
+			Description: description,
+			CreateModel: model.CreateModel{Creator: "system"},
+			ModifyModel: model.ModifyModel{Modifier: "system"},
+		}).
+		FirstOrCreate(role).Error
+	if err != nil {
+		log.Errorf("EnsureRole fail:%v", err)
+		return nil, fmt.Errorf("EnsureRole fail:%v", err)
+	}
+	return role, nil
+}
+
+// GrantPermission 给角色授予权限，已经授予过的不会重复插入
+func GrantPermission(roleID, permissionID int) error {
+	rp := &model.RolePermission{RoleID: roleID, PermissionID: permissionID}
+	if err := utils.GetDB().Model(&model.RolePermission{}).Where(rp).FirstOrCreate(rp).Error; err != nil {
+		log.Errorf("GrantPermission fail:%v", err)
+		return fmt.Errorf("GrantPermission fail:%v", err)
+	}
+	return nil
+}
+
+// GetRoleByName 根据角色名获取角色，不存在时返回 nil, nil
+func GetRoleByName(name string) (*model.Role, error) {
+	role := &model.Role{}
+	if err := utils.GetDB().Model(&model.Role{}).Where("name=?", name).First(role).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		log.Errorf("GetRoleByName fail:%v", err)
+		return nil, fmt.Errorf("GetRoleByName fail:%v", err)
+	}
+	return role, nil
+}
+
+// ListRoles 获取全部角色
+func ListRoles() ([]*model.Role, error) {
+	var roles []*model.Role
+	if err := utils.GetDB().Model(&model.Role{}).Order("id").Find(&roles).Error; err != nil {
+		log.Errorf("ListRoles fail:%v", err)
+		return nil, fmt.Errorf("ListRoles fail:%v", err)
+	}
+	return roles, nil
+}
+
+// AssignRole 给用户分配角色，已经分配过的不会重复插入
+func AssignRole(userID, roleID int, operator string) error {
+	ur := &model.UserRole{UserID: userID, RoleID: roleID}
+	err := utils.GetDB().Model(&model.UserRole{}).Where(ur).
+		Attrs(model.UserRole{CreateModel: model.CreateModel{Creator: operator}}).
+		FirstOrCreate(ur).Error
+	if err != nil {
+		log.Errorf("AssignRole fail:%v", err)
+		return fmt.Errorf("AssignRole fail:%v", err)
+	}
+	return nil
+}
+
+// RemoveRole 回收用户的角色，返回被删除的行数
+func RemoveRole(userID, roleID int) (int64, error) {
+	result := utils.GetDB().Where("user_id = ? AND role_id = ?", userID, roleID).Delete(&model.UserRole{})
+	if result.Error != nil {
+		log.Errorf("RemoveRole fail:%v", result.Error)
+		return 0, fmt.Errorf("RemoveRole fail:%v", result.Error)
+	}
+	return result.RowsAffected, nil
+}
+
+// CountRoleUsers 统计拥有某个角色的用户数
+func CountRoleUsers(roleID int) (int64, error) {
+	var count int64
+	if err := utils.GetDB().Model(&model.UserRole{}).Where("role_id = ?", roleID).Count(&count).Error; err != nil {
+		log.Errorf("CountRoleUsers fail:%v", err)
+		return 0, fmt.Errorf("CountRoleUsers fail:%v", err)
+	}
+	return count, nil
+}
+
+// GetUserRoles 获取用户拥有的全部角色
+func GetUserRoles(userID int) ([]*model.Role, error) {
+	var roles []*model.Role
+	err := utils.GetDB().Model(&model.Role{}).
+		Joins("JOIN user_roles ON user_roles.role_id = roles.id").
+		Where("user_roles.user_id = ?", userID).
+		Order("roles.id").
+		Find(&roles).Error
+	if err != nil {
+		log.Errorf("GetUserRoles fail:%v", err)
+		return nil, fmt.Errorf("GetUserRoles fail:%v", err)
+	}
+	return roles, nil
+}
+
+// GetUserPermissions 获取用户通过角色获得的全部权限标识
+func GetUserPermissions(userID int) ([]string, error) {
+	var codes []string
+	err := utils.GetDB().Model(&model.Permission{}).
+		Distinct("permissions.code").
+		Joins("JOIN role_permissions ON role_permissions.permission_id = permissions.id").
+		Joins("JOIN user_roles ON user_roles.role_id = role_permissions.role_id").
+		Where("user_roles.user_id = ?", userID).
+		Pluck("permissions.code", &codes).Error
+	if err != nil {
+		log.Errorf("GetUserPermissions fail:%v", err)
+		return nil, fmt.Errorf("GetUserPermissions fail:%v", err)
+	}
+	return codes, nil
+}
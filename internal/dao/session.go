@@ -0,0 +1,98 @@
+package dao
+
+import (
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gouse/internal/model"
+	"gouse/utils"
+	"time"
+)
+
+// CreateSession 保存新登录的会话
+func CreateSession(session *model.Session) error {
+	if err := utils.GetDB().Create(session).Error; err != nil {
+		log.Errorf("CreateSession fail:%v", err)
+		return fmt.Errorf("CreateSession fail:%v", err)
+	}
+	return nil
+}
+
+// GetSession 根据会话 ID 获取还没过期的会话，不存在或者已经过期时返回 nil, nil
+func GetSession(id string) (*model.Session, error) {
+	session := &model.Session{}
+	err := utils.GetDB().Model(&model.Session{}).Where("id = ? AND expire_at > ?", id, time.Now()).First(session).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		log.Errorf("GetSession fail:%v", err)
+		return nil, fmt.Errorf("GetSession fail:%v", err)
+	}
+	return session, nil
+}
+
+// UpdateSession 更新还没过期的会话，返回受影响的行数
+func UpdateSession(id string, fields map[string]interface{}) (int64, error) {
+	result := utils.GetDB().Model(&model.Session{}).Where("id = ? AND expire_at > ?", id, time.Now()).Updates(fields)
+	if result.Error != nil {
+		log.Errorf("UpdateSession fail:%v", result.Error)
+		return 0, fmt.Errorf("UpdateSession fail:%v", result.Error)
+	}
+	return result.RowsAffected, nil
+}
+
+// ListUserSessions 获取用户还没过期的会话，最近访问的在前面
+func ListUserSessions(userName string) ([]*model.Session, error) {
+	var sessions []*model.Session
+	err := utils.GetDB().Model(&model.Session{}).Where("user_name = ? AND expire_at > ?", userName, time.Now()).
+		Order("last_seen desc").Find(&sessions).Error
+	if err != nil {
+		log.Errorf("ListUserSessions fail:%v", err)
+		return nil, fmt.Errorf("ListUserSessions fail:%v", err)
+	}
+	return sessions, nil
+}
+
+// DeleteSession 删除用户的一个还没过期的会话，返回 false 表示没有这个会话
+func DeleteSession(userName, id string) (bool, error) {
+	result := utils.GetDB().Where("id = ? AND user_name = ? AND expire_at > ?", id, userName, time.Now()).Delete(&model.Session{})
+	if result.Error != nil {
+		log.Errorf("DeleteSession fail:%v", result.Error)
+		return false, fmt.Errorf("DeleteSession fail:%v", result.Error)
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// DeleteSessionByID 根据会话 ID 删除会话
+func DeleteSessionByID(id string) error {
+	if err := utils.GetDB().Where("id = ?", id).Delete(&model.Session{}).Error; err != nil {
+		log.Errorf("DeleteSessionByID fail:%v", err)
+		return fmt.Errorf("DeleteSessionByID fail:%v", err)
+	}
+	return nil
+}
+
+// DeleteUserSessions 删除用户除 keepID 以外的全部会话，keepID 为空时全部删除
+func DeleteUserSessions(userName, keepID string) error {
+	db := utils.GetDB().Where("user_name = ?", userName)
+	if keepID != "" {
+		db = db.Where("id <> ?", keepID)
+	}
+	if err := db.Delete(&model.Session{}).Error; err != nil {
+		log.Errorf("DeleteUserSessions fail:%v", err)
+		return fmt.Errorf("DeleteUserSessions fail:%v", err)
+	}
+	return nil
+}
+
+// DeleteExpiredSessions 清理已经过期的会话，返回删除的行数
+func DeleteExpiredSessions() (int64, error) {
+	result := utils.GetDB().Where("expire_at <= ?", time.Now()).Delete(&model.Session{})
+	if result.Error != nil {
+		log.Errorf("DeleteExpiredSessions fail:%v", result.Error)
+		return 0, fmt.Errorf("DeleteExpiredSessions fail:%v", result.Error)
+	}
+	return result.RowsAffected, nil
+}
@@ -1,13 +1,26 @@
 package dao
 
 import (
+	"errors"
 	"fmt"
+	"github.com/go-sql-driver/mysql"
 	log "github.com/sirupsen/logrus"
 	"gorm.io/gorm"
 	"gouse/internal/model"
 	"gouse/utils"
+	"strings"
+	"time"
 )
 
+// ErrDuplicateKey 违反了唯一索引，例如邮箱、手机号在检查之后、写入之前被其他用户抢先占用
+var ErrDuplicateKey = errors.New("duplicate key")
+
+// 是否违反了唯一索引（MySQL 错误码 1062）
+func isDuplicateKey(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	return errors.As(err, &mysqlErr) && mysqlErr.Number == 1062
+}
+
 // GetUserByName 根据姓名获取用户
 func GetUserByName(name string) (*model.User, error) {
 	// user 变量存储查询结果
@@ -43,3 +56,255 @@ func UpdateUserInfo(userName string, user *model.User) int64 {
 	// Updates方法用于更新满足条件的记录，参数user包含新的用户信息，RowsAffected返回被影响的行数
 	return utils.GetDB().Model(&model.User{}).Where("`name` = ?", userName).Updates(user).RowsAffected
 }
+
+// GetUserByID 根据 ID 获取用户，不存在时返回 nil, nil
+func GetUserByID(id int) (*model.User, error) {
+	user := &model.User{}
+	if err := utils.GetDB().Model(model.User{}).Where("id=?", id).First(user).Error; err != nil {
+		if err.Error() == gorm.ErrRecordNotFound.Error() {
+			return nil, nil
+		}
+		log.Errorf("GetUserByID fail:%v", err)
+		return nil, fmt.Errorf("GetUserByID fail:%v", err)
+	}
+	return user, nil
+}
+
+// UserNameExists 判断用户名是否被占用，包括已经被软删除的用户，避免删除后的用户名被他人重新注册
+func UserNameExists(name string) (bool, error) {
+	var count int64
+	if err := utils.GetDB().Unscoped().Model(&model.User{}).Where("name=?", name).Count(&count).Error; err != nil {
+		log.Errorf("UserNameExists fail:%v", err)
+		return false, fmt.Errorf("UserNameExists fail:%v", err)
+	}
+	return count > 0, nil
+}
+
+// UserFilter 用户列表的过滤条件，零值表示不过滤
+type UserFilter struct {
+	NamePrefix    string     // 用户名前缀
+	Gender        string     // 性别
+	MinAge        int        // 最小年龄（含）
+	MaxAge        int        // 最大年龄（含）
+	CreatedAfter  *time.Time // 创建时间下限（含）
+	CreatedBefore *time.Time // 创建时间上限（不含）
+	AfterID       int        // 游标，只返回 ID 大于它的用户
+	Limit         int        // 返回条数
+}
+
+// ListUsers 按过滤条件查询用户列表，按 ID 升序返回
+func ListUsers(filter *UserFilter) ([]*model.User, error) {
+	query := utils.GetDB().Model(&model.User{})
+	if filter.NamePrefix != "" {
+		query = query.Where("name LIKE ?", escapeLike(filter.NamePrefix)+"%")
+	}
+	if filter.Gender != "" {
+		query = query.Where("gender = ?", filter.Gender)
+	}
+	if filter.MinAge > 0 {
+		query = query.Where("age >= ?", filter.MinAge)
+	}
+	if filter.MaxAge > 0 {
+		query = query.Where("age <= ?", filter.MaxAge)
+	}
+	if filter.CreatedAfter != nil {
+		query = query.Where("create_time >= ?", *filter.CreatedAfter)
+	}
+	if filter.CreatedBefore != nil {
+		query = query.Where("create_time < ?", *filter.CreatedBefore)
+	}
+	if filter.AfterID > 0 {
+		query = query.Where("id > ?", filter.AfterID)
+	}
+
+	var users []*model.User
+	if err := query.Order("id").Limit(filter.Limit).Find(&users).Error; err != nil {
+		log.Errorf("ListUsers fail:%v", err)
+		return nil, fmt.Errorf("ListUsers fail:%v", err)
+	}
+	return users, nil
+}
+
+// UpdateUserStatus 修改用户的账号状态，返回被影响的行数
+func UpdateUserStatus(id, status int, operator string) (int64, error) {
+	result := utils.GetDB().Model(&model.User{}).Where("id = ?", id).
+		Updates(map[string]interface{}{"status": status, "modifier": operator})
+	if result.Error != nil {
+		log.Errorf("UpdateUserStatus fail:%v", result.Error)
+		return 0, fmt.Errorf("UpdateUserStatus fail:%v", result.Error)
+	}
+	return result.RowsAffected, nil
+}
+
+// DeleteUser 软删除用户，返回被影响的行数；任何一步失败都会回滚并返回错误
+func DeleteUser(id int, operator string) (int64, error) {
+	var affected int64
+	err := utils.GetDB().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&model.User{}).Where("id = ?", id).Update("modifier", operator).Error; err != nil {
+			return err
+		}
+		result := tx.Where("id = ?", id).Delete(&model.User{})
+		if result.Error != nil {
+			return result.Error
+		}
+		affected = result.RowsAffected
+		return nil
+	})
+	if err != nil {
+		log.Errorf("DeleteUser fail:%v", err)
+		return 0, fmt.Errorf("DeleteUser fail:%v", err)
+	}
+	return affected, nil
+}
+
+// 转义 LIKE 语句中的通配符，防止用户输入的 % 和 _ 被当成通配符
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// GetUserByVerifiedEmail 根据已经验证过的邮箱获取用户，不存在时返回 nil, nil
+func GetUserByVerifiedEmail(email string) (*model.User, error) {
+	user := &model.User{}
+	err := utils.GetDB().Model(&model.User{}).Where("email = ? AND email_verified = ?", email, true).First(user).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		log.Errorf("GetUserByVerifiedEmail fail:%v", err)
+		return nil, fmt.Errorf("GetUserByVerifiedEmail fail:%v", err)
+	}
+	return user, nil
+}
+
+// EmailExists 判断邮箱是否已经被其他用户验证占用，包括已经被软删除的用户，和数据库的唯一索引保持一致
+// 只查 email 列，别人注册时填了、还没有验证的邮箱（pending_email）不算占用
+func EmailExists(email string, exceptUserID int) (bool, error) {
+	var count int64
+	err := utils.GetDB().Unscoped().Model(&model.User{}).Where("email = ? AND id <> ?", email, exceptUserID).Count(&count).Error
+	if err != nil {
+		log.Errorf("EmailExists fail:%v", err)
+		return false, fmt.Errorf("EmailExists fail:%v", err)
+	}
+	return count > 0, nil
+}
+
+// UpdateUserEmail 把用户的邮箱改成已经验证过的 email，并清掉等待验证的邮箱，返回被影响的行数
+// 邮箱已经被其他用户验证占用时返回 ErrDuplicateKey
+func UpdateUserEmail(id int, email, operator string) (int64, error) {
+	result := utils.GetDB().Model(&model.User{}).Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"email":          email,
+			"email_verified": true,
+			"pending_email":  nil,
+			"modifier":       operator,
+		})
+	if isDuplicateKey(result.Error) {
+		log.Warnf("UpdateUserEmail fail:%v", result.Error)
+		return 0, fmt.Errorf("UpdateUserEmail fail:%w", ErrDuplicateKey)
+	}
+	if result.Error != nil {
+		log.Errorf("UpdateUserEmail fail:%v", result.Error)
+		return 0, fmt.Errorf("UpdateUserEmail fail:%v", result.Error)
+	}
+	return result.RowsAffected, nil
+}
+
+// GetUserByPhone 根据手机号获取用户，不存在时返回 nil, nil
+func GetUserByPhone(phone string) (*model.User, error) {
+	user := &model.User{}
+	err := utils.GetDB().Model(&model.User{}).Where("phone = ?", phone).First(user).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		log.Errorf("GetUserByPhone fail:%v", err)
+		return nil, fmt.Errorf("GetUserByPhone fail:%v", err)
+	}
+	return user, nil
+}
+
+// PhoneExists 判断手机号是否已经被其他用户绑定，包括已经被软删除的用户，和数据库的唯一索引保持一致
+func PhoneExists(phone string, exceptUserID int) (bool, error) {
+	var count int64
+	err := utils.GetDB().Unscoped().Model(&model.User{}).Where("phone = ? AND id <> ?", phone, exceptUserID).Count(&count).Error
+	if err != nil {
+		log.Errorf("PhoneExists fail:%v", err)
+		return false, fmt.Errorf("PhoneExists fail:%v", err)
+	}
+	return count > 0, nil
+}
+
+// UpdateUserPhone 把用户的手机号改成已经验证过的 phone，返回被影响的行数
+// 号码已经被其他用户绑定时返回 ErrDuplicateKey
+func UpdateUserPhone(id int, phone, operator string) (int64, error) {
+	result := utils.GetDB().Model(&model.User{}).Where("id = ?", id).
+		Updates(map[string]interface{}{"phone": phone, "modifier": operator})
+	if isDuplicateKey(result.Error) {
+		log.Warnf("UpdateUserPhone fail:%v", result.Error)
+		return 0, fmt.Errorf("UpdateUserPhone fail:%w", ErrDuplicateKey)
+	}
+	if result.Error != nil {
+		log.Errorf("UpdateUserPhone fail:%v", result.Error)
+		return 0, fmt.Errorf("UpdateUserPhone fail:%v", result.Error)
+	}
+	return result.RowsAffected, nil
+}
+
+// UpdateUserPassword 用户自己设置了新密码：更新密码哈希和设置时间，并清除必须修改密码的标记，返回被影响的行数
+// history 大于 0 时在同一个事务里记录密码历史并只保留最近的 history 个，任何一步失败都会回滚
+func UpdateUserPassword(id int, encoded, operator string, history int) (int64, error) {
+	var affected int64
+	err := utils.GetDB().Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&model.User{}).Where("id = ?", id).
+			Updates(map[string]interface{}{
+				"password":             encoded,
+				"password_changed_at":  time.Now(),
+				"must_change_password": false,
+				"password_expires_at":  nil,
+				"modifier":             operator,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		affected = result.RowsAffected
+		if affected == 0 || history <= 0 {
+			return nil
+		}
+		return addPasswordHistory(tx, &model.PasswordHistory{
+			UserID:      id,
+			PassWord:    encoded,
+			CreateModel: model.CreateModel{Creator: operator},
+		}, history)
+	})
+	if err != nil {
+		log.Errorf("UpdateUserPassword fail:%v", err)
+		return 0, fmt.Errorf("UpdateUserPassword fail:%v", err)
+	}
+	return affected, nil
+}
+
+// SetTempPassword 管理员给用户设置临时密码，用户登录后必须先修改密码，临时密码过了 expiresAt 不能再用来登录
+func SetTempPassword(id int, encoded string, expiresAt time.Time, operator string) (int64, error) {
+	result := utils.GetDB().Model(&model.User{}).Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"password":             encoded,
+			"password_changed_at":  time.Now(),
+			"must_change_password": true,
+			"password_expires_at":  expiresAt,
+			"modifier":             operator,
+		})
+	if result.Error != nil {
+		log.Errorf("SetTempPassword fail:%v", result.Error)
+		return 0, fmt.Errorf("SetTempPassword fail:%v", result.Error)
+	}
+	return result.RowsAffected, nil
+}
+
+// MarkMustChangePassword 标记用户下次登录后必须先修改密码，用于密码超过了最长使用时间
+func MarkMustChangePassword(id int) error {
+	if err := utils.GetDB().Model(&model.User{}).Where("id = ?", id).Update("must_change_password", true).Error; err != nil {
+		log.Errorf("MarkMustChangePassword fail:%v", err)
+		return fmt.Errorf("MarkMustChangePassword fail:%v", err)
+	}
+	return nil
+}
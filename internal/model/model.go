@@ -1,6 +1,10 @@
 package model
 
-import "time"
+import (
+	"gorm.io/gorm"
+	"gouse/pkg/redact"
+	"time"
+)
 
 // 补充知识：gorm 标签
 // gorm 标签被用于定义数据库表的列名和列属性，
@@ -41,10 +45,183 @@ type ModifyModel struct {
 type User struct {
 	CreateModel
 	ModifyModel
-	ID       int    `gorm:"column:id"`       // ID
-	Name     string `gorm:"column:name"`     // 姓名
-	Gender   string `gorm:"column:gender"`   // 性别
-	Age      int    `gorm:"column:age"`      // 年龄
-	PassWord string `gorm:"column:password"` // 密码
-	NickName string `gorm:"column:nickname"` // 昵称
-}
\ No newline at end of file
+	ID            int            `gorm:"column:id"`                              // ID
+	Name          string         `gorm:"column:name"`                            // 姓名
+	Gender        string         `gorm:"column:gender"`                          // 性别
+	Age           int            `gorm:"column:age"`                             // 年龄
+	PassWord      string         `gorm:"column:password" json:"-" redact:"true"` // 密码哈希，不能出现在缓存、响应和日志里
+	NickName      string         `gorm:"column:nickname"`                        // 昵称
+	Email         string         `gorm:"column:email;default:null"`              // 验证过的邮箱，全局唯一，没有时为 NULL；用于找回密码和登录
+	EmailVerified bool           `gorm:"column:email_verified"`                  // 邮箱是否已经验证，验证过的邮箱才能用来登录、找回密码
+	PendingEmail  string         `gorm:"column:pending_email;default:null"`      // 注册时填写、还没有验证的邮箱，不占用唯一索引，验证通过后移到 Email
+	Phone         string         `gorm:"column:phone;default:null"`              // 手机号，E.164 格式，全局唯一，通过短信验证码绑定，没有绑定时为 NULL
+	Status        int            `gorm:"column:status"`                          // 账号状态，见 constant.UserStatusXXX
+	DeletedAt     gorm.DeletedAt `gorm:"column:deleted_at;index"`                // 软删除时间，gorm 查询时会自动过滤已删除的用户
+
+	PasswordChangedAt  *time.Time `gorm:"column:password_changed_at"`  // 最近一次设置密码的时间，用来计算密码是否超过了最长使用时间
+	MustChangePassword bool       `gorm:"column:must_change_password"` // 下次登录后必须先修改密码：管理员设置了临时密码，或者密码已经过期
+	PasswordExpiresAt  *time.Time `gorm:"column:password_expires_at"`  // 临时密码的失效时间，过了这个时间不能再用它登录；普通密码为 NULL
+}
+
+// String 打印用户时隐藏敏感字段
+func (u User) String() string {
+	return redact.String(u)
+}
+
+// Role 角色，用户通过角色获得权限
+type Role struct {
+	CreateModel
+	ModifyModel
+	ID          int    `gorm:"column:id"`                                // ID
+	Name        string `gorm:"column:name;type:varchar(64);uniqueIndex"` // 角色名，例如 admin
+	Description string `gorm:"column:description;type:varchar(255)"`     // 描述
+}
+
+// Permission 权限，Code 的格式为 资源:动作:范围，例如 user:read:any
+type Permission struct {
+	CreateModel
+	ID          int    `gorm:"column:id"`                                 // ID
+	Code        string `gorm:"column:code;type:varchar(128);uniqueIndex"` // 权限标识
+	Description string `gorm:"column:description;type:varchar(255)"`      // 描述
+}
+
+// RolePermission 角色与权限的关联
+type RolePermission struct {
+	CreateModel
+	RoleID       int `gorm:"column:role_id;primaryKey"`       // 角色 ID
+	PermissionID int `gorm:"column:permission_id;primaryKey"` // 权限 ID
+}
+
+// UserRole 用户与角色的关联
+type UserRole struct {
+	CreateModel
+	UserID int `gorm:"column:user_id;primaryKey"` // 用户 ID
+	RoleID int `gorm:"column:role_id;primaryKey"` // 角色 ID
+}
+
+// UserTOTP 用户绑定的 TOTP 两步验证
+type UserTOTP struct {
+	CreateModel
+	ModifyModel
+	UserID       int        `gorm:"column:user_id;primaryKey"`                             // 用户 ID
+	Secret       string     `gorm:"column:secret;type:varchar(64)" json:"-" redact:"true"` // base32 编码的密钥
+	Enabled      bool       `gorm:"column:enabled"`                                        // 是否已经确认绑定，确认之前登录不需要验证码
+	LastUsedStep int64      `gorm:"column:last_used_step"`                                 // 最近一次验证通过的时间步，防止验证码重放
+	ConfirmedAt  *time.Time `gorm:"column:confirmed_at"`                                   // 确认绑定的时间
+}
+
+// TableName 指定表名
+func (UserTOTP) TableName() string {
+	return "user_totps"
+}
+
+func (t UserTOTP) String() string {
+	return redact.String(t)
+}
+
+// RecoveryCode 两步验证的恢复码，手机丢失时可以代替验证码登录，每个只能用一次
+type RecoveryCode struct {
+	CreateModel
+	ID       int        `gorm:"column:id"`                      // ID
+	UserID   int        `gorm:"column:user_id;index"`           // 用户 ID
+	CodeHash string     `gorm:"column:code_hash;type:char(64)"` // 恢复码的 SHA-256
+	UsedAt   *time.Time `gorm:"column:used_at"`                 // 使用时间，为空表示还没用过
+}
+
+// PasswordHistory 用户用过的密码哈希，用来阻止重复使用最近用过的密码
+type PasswordHistory struct {
+	CreateModel
+	ID       int    `gorm:"column:id"`                              // ID
+	UserID   int    `gorm:"column:user_id;index"`                   // 用户 ID
+	PassWord string `gorm:"column:password" json:"-" redact:"true"` // 密码哈希，和 users 表里的格式一样
+}
+
+// OAuthClient 接入 OAuth 2.0 的第三方应用
+// 重定向地址、授权方式和权限范围都用空格分隔保存
+type OAuthClient struct {
+	CreateModel
+	ModifyModel
+	ID           int    `gorm:"column:id"`                                               // ID
+	ClientID     string `gorm:"column:client_id;type:varchar(64);uniqueIndex"`           // 对外的应用 ID
+	SecretHash   string `gorm:"column:secret_hash;type:char(64)" json:"-" redact:"true"` // 应用密钥的 SHA-256，公开应用为空
+	Name         string `gorm:"column:name;type:varchar(100)"`                           // 应用名称，授权页面上展示给用户
+	RedirectURIs string `gorm:"column:redirect_uris;type:text"`                          // 允许的重定向地址
+	GrantTypes   string `gorm:"column:grant_types;type:varchar(255)"`                    // 允许的授权方式
+	Scopes       string `gorm:"column:scopes;type:varchar(255)"`                         // 允许申请的权限范围
+	Public       bool   `gorm:"column:public"`                                           // 公开应用（例如单页应用、移动端），没有密钥，必须使用 PKCE
+}
+
+// TableName 指定表名
+func (OAuthClient) TableName() string {
+	return "oauth_clients"
+}
+
+func (c OAuthClient) String() string {
+	return redact.String(c)
+}
+
+// OIDCKey 签发 ID token 的 RSA 密钥，定期轮换
+// 最新的一把用于签名；已经轮换下来的密钥在保留期内继续通过 JWKS 公布，保证之前签发的 ID token 还能被校验
+type OIDCKey struct {
+	CreateModel
+	ID         int        `gorm:"column:id"`                                           // ID
+	Kid        string     `gorm:"column:kid;type:varchar(64);uniqueIndex"`             // 密钥 ID
+	PrivateKey string     `gorm:"column:private_key;type:text" json:"-" redact:"true"` // PKCS#8 PEM 格式的私钥
+	RetiredAt  *time.Time `gorm:"column:retired_at"`                                   // 被新密钥替换下来的时间，为空表示当前正在使用
+}
+
+// TableName 指定表名
+func (OIDCKey) TableName() string {
+	return "oidc_keys"
+}
+
+func (k OIDCKey) String() string {
+	return redact.String(k)
+}
+
+// APIKey 个人访问令牌，给脚本和服务账号使用，只保存哈希
+type APIKey struct {
+	CreateModel
+	ID         int        `gorm:"column:id"`                                                        // ID
+	UserID     int        `gorm:"column:user_id;index"`                                             // 所属用户，请求以这个用户的身份执行
+	Name       string     `gorm:"column:name;type:varchar(64)"`                                     // 名称，方便用户区分
+	Prefix     string     `gorm:"column:prefix;type:varchar(16)"`                                   // key 的开头几位，列表里展示用
+	KeyHash    string     `gorm:"column:key_hash;type:char(64);uniqueIndex" json:"-" redact:"true"` // key 的 sha256
+	Scopes     string     `gorm:"column:scopes;type:varchar(255)"`                                  // 允许的权限范围，空格分隔
+	ExpiresAt  time.Time  `gorm:"column:expires_at"`                                                // 过期时间
+	LastUsedAt *time.Time `gorm:"column:last_used_at"`                                              // 最近一次使用的时间
+	LastUsedIP string     `gorm:"column:last_used_ip;type:varchar(64)"`                             // 最近一次使用的 IP
+	RevokedAt  *time.Time `gorm:"column:revoked_at"`                                                // 吊销时间，为空表示没有吊销
+}
+
+// TableName 指定表名
+func (APIKey) TableName() string {
+	return "api_keys"
+}
+
+func (k APIKey) String() string {
+	return redact.String(k)
+}
+
+// Session 登录会话，配置 session.store 为 sql 时使用
+// 主键是会话 ID（session 的哈希），数据库里不保存 session 本身
+type Session struct {
+	ID          string    `gorm:"column:id;type:char(32);primaryKey"`       // 会话 ID
+	UserName    string    `gorm:"column:user_name;type:varchar(100);index"` // 所属用户
+	UserInfo    string    `gorm:"column:user_info;type:text" json:"-"`      // 会话里保存的用户信息，JSON 格式，不含密码哈希
+	Device      string    `gorm:"column:device;type:varchar(128)"`          // 登录设备
+	UserAgent   string    `gorm:"column:user_agent;type:varchar(512)"`      // 登录时的 User-Agent
+	IP          string    `gorm:"column:ip;type:varchar(64)"`               // 登录时的 IP
+	LastIP      string    `gorm:"column:last_ip;type:varchar(64)"`          // 最近一次访问的 IP
+	Remember    bool      `gorm:"column:remember"`                          // 登录时是否勾选了"记住我"
+	IdleTimeout int64     `gorm:"column:idle_timeout"`                      // 每次访问续期的时长，单位秒
+	LoginTime   time.Time `gorm:"column:login_time"`                        // 登录时间
+	LastSeen    time.Time `gorm:"column:last_seen"`                         // 最近一次访问的时间
+	MaxExpireAt time.Time `gorm:"column:max_expire_at"`                     // 绝对过期时间，到了这个时间不再续期
+	ExpireAt    time.Time `gorm:"column:expire_at;index"`                   // 当前的过期时间，每次续期后延长
+}
+
+// TableName 指定表名
+func (Session) TableName() string {
+	return "sessions"
+}
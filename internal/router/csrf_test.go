@@ -0,0 +1,106 @@
+package router
+
+import (
+	"github.com/gin-gonic/gin"
+	api "gouse/api/http/v1"
+	"gouse/config"
+	"gouse/internal/service"
+	"gouse/pkg/constant"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// 开启 jwt 模式，Bearer token 才会被识别成 token 认证的请求
+func setupCSRFTest(t *testing.T) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	conf := &config.GlobalConfig{}
+	conf.Auth = config.AuthConf{
+		Mode:        "both",
+		ActiveKey:   "k1",
+		SigningKeys: []config.SigningKey{{Kid: "k1", Secret: "0123456789abcdef0123456789abcdef"}},
+	}
+	config.SetGlobalConf(conf)
+	if err := service.InitTokenAuth(); err != nil {
+		t.Fatalf("InitTokenAuth err: %v", err)
+	}
+}
+
+// 经过 CSRFMiddleWare 处理一个请求，返回响应状态码
+func serveCSRF(req *http.Request) int {
+	r := gin.New()
+	r.Use(CSRFMiddleWare())
+	handler := func(c *gin.Context) { c.Status(http.StatusOK) }
+	r.GET("/", handler)
+	r.POST("/", handler)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w.Code
+}
+
+func TestCSRFMiddleWare(t *testing.T) {
+	setupCSRFTest(t)
+	const csrfToken = "csrf-token-value"
+	cases := []struct {
+		name    string
+		method  string
+		session bool              // 是否带会话 cookie
+		cookie  string            // 防 CSRF 的 cookie
+		headers map[string]string // 额外的请求头
+		want    int
+	}{
+		{name: "missing header", method: http.MethodPost, session: true, cookie: csrfToken, want: http.StatusForbidden},
+		{name: "missing cookie", method: http.MethodPost, session: true,
+			headers: map[string]string{constant.CSRFHeader: csrfToken}, want: http.StatusForbidden},
+		{name: "header mismatch", method: http.MethodPost, session: true, cookie: csrfToken,
+			headers: map[string]string{constant.CSRFHeader: "other-token"}, want: http.StatusForbidden},
+		{name: "header match", method: http.MethodPost, session: true, cookie: csrfToken,
+			headers: map[string]string{constant.CSRFHeader: csrfToken}, want: http.StatusOK},
+		{name: "safe method", method: http.MethodGet, session: true, want: http.StatusOK},
+		{name: "no session cookie", method: http.MethodPost, want: http.StatusOK},
+		{name: "bearer token", method: http.MethodPost, session: true, cookie: csrfToken,
+			headers: map[string]string{"Authorization": "Bearer some.jwt.token"}, want: http.StatusOK},
+		{name: "api key header", method: http.MethodPost, session: true, cookie: csrfToken,
+			headers: map[string]string{"X-API-Key": service.APIKeyPrefix + "abc"}, want: http.StatusOK},
+		{name: "api key bearer", method: http.MethodPost, session: true, cookie: csrfToken,
+			headers: map[string]string{"Authorization": "Bearer " + service.APIKeyPrefix + "abc"}, want: http.StatusOK},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, "/", nil)
+			if tc.session {
+				req.AddCookie(&http.Cookie{Name: api.SessionCookieName(), Value: "session-value"})
+			}
+			if tc.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: api.CSRFCookieName(), Value: tc.cookie})
+			}
+			for k, v := range tc.headers {
+				req.Header.Set(k, v)
+			}
+			if got := serveCSRF(req); got != tc.want {
+				t.Errorf("status = %d, want %d", got, tc.want)
+			}
+		})
+	}
+}
+
+// 没有防 CSRF 的 cookie 时下发一个新的
+func TestCSRFMiddleWareIssuesCookie(t *testing.T) {
+	setupCSRFTest(t)
+	r := gin.New()
+	r.Use(CSRFMiddleWare())
+	r.GET("/", func(c *gin.Context) { c.Status(http.StatusOK) })
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == api.CSRFCookieName() {
+			if cookie.Value == "" || cookie.HttpOnly {
+				t.Errorf("csrf cookie = %+v, want a non-empty value readable by scripts", cookie)
+			}
+			return
+		}
+	}
+	t.Errorf("no %s cookie issued", api.CSRFCookieName())
+}
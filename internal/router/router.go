@@ -1,13 +1,22 @@
 package router
 
 import (
+	"crypto/subtle"
+	"errors"
 	"github.com/gin-gonic/gin"
 	log "github.com/sirupsen/logrus"
 	api "gouse/api/http/v1"
 	"gouse/config"
+	"gouse/internal/authz"
+	"gouse/internal/cache"
+	"gouse/internal/model"
+	"gouse/internal/service"
 	"gouse/pkg/constant"
+	"gouse/utils"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 )
 
 // InitRouterAndServe 路由配置、启动服务
@@ -18,6 +27,15 @@ func InitRouterAndServe() {
 	// 创建了一个默认的 gin 路由实例 r，用于处理请求和路由。
 	r := gin.Default()
 
+	// gin 默认信任所有代理，客户端可以自己带 X-Forwarded-For 伪造 c.ClientIP()，
+	// 所以只信任配置里的代理，没有配置时一个都不信任
+	if err := r.SetTrustedProxies(trustedProxies()); err != nil {
+		panic("set trusted proxies err:" + err.Error())
+	}
+
+	// 防跨站请求伪造：给每个浏览器下发 token，带着会话 cookie 修改数据的请求必须带回这个 token
+	r.Use(CSRFMiddleWare())
+
 	// 下面是注册路由处理函数，包括用户注册、用户登录、用户登出、获取用户信息、更新用户信息等。
 	// 当接收到 /ping GET 请求时，调用 api.Ping 函数来处理请求。(健康检查)
 	r.GET("ping", api.Ping)
@@ -28,14 +46,107 @@ func InitRouterAndServe() {
 	// 用户登录
 	r.POST("/user/login", api.Login)
 
+	// 开启了两步验证的用户，密码校验通过后再提交验证码
+	r.POST("/user/login/mfa", api.LoginMFA)
+
+	// 免密码登录：申请登录链接和验证码、使用链接或者验证码登录
+	r.POST("/user/login/magic", api.RequestMagicLink)
+	r.POST("/user/login/magic/redeem", api.MagicLogin)
+
+	// 手机号登录：申请短信验证码、手机号加验证码登录
+	r.POST("/user/login/phone/code", api.SendLoginSMSCode)
+	r.POST("/user/login/phone", api.PhoneLogin)
+
+	// 刷新、吊销 token（auth.mode 为 jwt 或 both 时可用）
+	r.POST("/user/token/refresh", api.RefreshToken)
+	r.POST("/user/token/revoke", api.RevokeToken)
+
+	// OAuth 2.0 授权服务
+	r.GET("/oauth/authorize", OptionalAuthMiddleWare(), api.OAuthAuthorize)
+	r.GET("/oauth/consent", AuthMiddleWare(), api.GetOAuthConsent)
+	r.POST("/oauth/consent", AuthMiddleWare(), api.OAuthConsent)
+	r.POST("/oauth/token", api.OAuthToken)
+	r.POST("/oauth/revoke", api.OAuthRevoke)
+	r.POST("/oauth/introspect", api.OAuthIntrospect)
+
+	// OpenID Connect：discovery、公钥和用户信息
+	r.GET("/.well-known/openid-configuration", api.OIDCDiscovery)
+	r.GET("/.well-known/jwks.json", api.JWKS)
+	r.GET("/userinfo", api.OIDCUserInfo)
+	r.POST("/userinfo", api.OIDCUserInfo)
+
+	// 找回密码：申请重置链接、通过链接重置密码
+	r.POST("/user/password/forgot", api.ForgotPassword)
+	r.POST("/user/password/reset", api.ResetPassword)
+
+	// 邮箱：查看、修改、重新发送验证邮件、通过验证链接完成验证
+	r.GET("/user/email", AuthMiddleWare(service.APIKeyScopeUserRead), api.GetEmail)
+	r.POST("/user/email", AuthMiddleWare(), api.ChangeEmail)
+	r.POST("/user/email/resend", AuthMiddleWare(), api.ResendEmailVerification)
+	r.POST("/user/email/verify", api.VerifyEmail)
+
+	// 手机号：查看、发送绑定验证码、校验验证码并绑定
+	r.GET("/user/phone", AuthMiddleWare(service.APIKeyScopeUserRead), api.GetPhone)
+	r.POST("/user/phone/code", AuthMiddleWare(), api.SendBindPhoneCode)
+	r.POST("/user/phone", AuthMiddleWare(), api.BindPhone)
+
 	// 用户登出
 	r.POST("/user/logout", AuthMiddleWare(), api.Logout)
 
+	// 登录会话管理：查看各个设备上的会话、让某个会话下线、在所有设备上退出登录
+	r.GET("/user/sessions", AuthMiddleWare(), api.ListSessions)
+	r.DELETE("/user/sessions/:id", AuthMiddleWare(), api.RevokeSession)
+	r.POST("/user/logout/all", AuthMiddleWare(), api.LogoutEverywhere)
+
 	// 获取用户信息
-	r.GET("/user/get_user_info", AuthMiddleWare(), api.GetUserInfo)
+	r.GET("/user/get_user_info", AuthMiddleWare(service.APIKeyScopeUserRead), api.GetUserInfo)
 
 	// 更新用户信息
-	r.POST("/user/update_nick_name", AuthMiddleWare(), api.UpdateNickName)
+	r.POST("/user/update_nick_name", AuthMiddleWare(service.APIKeyScopeUserWrite), api.UpdateNickName)
+
+	// 修改密码
+	r.POST("/user/change_password", AuthMiddleWare(), api.ChangePassword)
+
+	// 两步验证：绑定、确认、关闭、重新生成恢复码
+	r.POST("/user/mfa/totp/enroll", AuthMiddleWare(), api.EnrollTOTP)
+	r.POST("/user/mfa/totp/confirm", AuthMiddleWare(), api.ConfirmTOTP)
+	r.POST("/user/mfa/totp/disable", AuthMiddleWare(), api.DisableTOTP)
+	r.POST("/user/mfa/recovery_codes", AuthMiddleWare(), api.RegenerateRecoveryCodes)
+
+	// 个人访问令牌（API key）：创建、查看、吊销
+	r.GET("/user/api_keys", AuthMiddleWare(), api.ListAPIKeys)
+	r.POST("/user/api_keys", AuthMiddleWare(), api.CreateAPIKey)
+	r.DELETE("/user/api_keys/:id", AuthMiddleWare(), api.RevokeAPIKey)
+
+	// 管理接口，需要登录（或者带 admin 范围的 API key），并且每个接口都要求对应的权限
+	admin := r.Group("/admin", AuthMiddleWare(service.APIKeyScopeAdmin))
+	{
+		// 用户管理
+		admin.GET("/users", RequirePermission(authz.PermUserManage), api.ListUsers)
+		admin.GET("/users/:id", RequirePermission(authz.PermUserManage), api.GetUser)
+		admin.POST("/users/:id/disable", RequirePermission(authz.PermUserManage), api.DisableUser)
+		admin.POST("/users/:id/enable", RequirePermission(authz.PermUserManage), api.EnableUser)
+		admin.DELETE("/users/:id", RequirePermission(authz.PermUserManage), api.DeleteUser)
+		admin.POST("/users/:id/unlock", RequirePermission(authz.PermUserManage), api.UnlockUser)
+		admin.POST("/users/:id/mfa/reset", RequirePermission(authz.PermUserManage), api.ResetUserMFA)
+		admin.POST("/users/:id/temp_password", RequirePermission(authz.PermUserManage), api.IssueTempPassword)
+
+		// 角色管理
+		admin.GET("/roles", RequirePermission(authz.PermRoleManage), api.ListRoles)
+		admin.POST("/users/:id/roles", RequirePermission(authz.PermRoleManage), api.AssignUserRole)
+		admin.DELETE("/users/:id/roles/:role", RequirePermission(authz.PermRoleManage), api.RemoveUserRole)
+
+		// OAuth 接入应用管理
+		admin.GET("/oauth/clients", RequirePermission(authz.PermOAuthManage), api.ListOAuthClients)
+		admin.POST("/oauth/clients", RequirePermission(authz.PermOAuthManage), api.CreateOAuthClient)
+		admin.DELETE("/oauth/clients/:client_id", RequirePermission(authz.PermOAuthManage), api.DeleteOAuthClient)
+		admin.POST("/oidc/keys/rotate", RequirePermission(authz.PermOAuthManage), api.RotateOIDCKey)
+
+		// 给服务账号之类的用户管理 API key
+		admin.GET("/users/:id/api_keys", RequirePermission(authz.PermAPIKeyManage), api.AdminListAPIKeys)
+		admin.POST("/users/:id/api_keys", RequirePermission(authz.PermAPIKeyManage), api.AdminCreateAPIKey)
+		admin.DELETE("/api_keys/:id", RequirePermission(authz.PermAPIKeyManage), api.AdminRevokeAPIKey)
+	}
 
 	// 设置静态文件的路由，这里将 /static/ 映射到 ./web/static/ 目录，即 /static/ 为静态文件资源的访问路径。
 	r.Static("/static/", "./web/static/")
@@ -63,36 +174,300 @@ func setAppRunMode() {
 	}
 }
 
+// trustedProxies 配置的可信代理，没有配置时返回 nil，表示不信任任何代理
+func trustedProxies() []string {
+	proxies := config.GetGlobalConf().AppConfig.TrustedProxies
+	if len(proxies) == 0 {
+		return nil
+	}
+	return proxies
+}
+
+// 会话最近访问时间的记录精度，同一个会话在这段时间内的多次请求只记录、续期一次
+const sessionTouchInterval = time.Minute
+
+// 防 CSRF 的 token 的随机字节数
+const csrfTokenBytes = 32
+
+// CSRFMiddleWare 防跨站请求伪造的中间件，使用双重提交 cookie 的方式
+// 每个浏览器都会拿到一个随机 token，放在页面脚本能读到的 cookie 里；带着会话 cookie 的 POST、DELETE 等请求，
+// 必须把同一个 token 放在 X-CSRF-Token 请求头里带回来。其他网站的页面读不到这个 cookie，也就伪造不出请求头。
+// 用 Bearer token 或者 API key 认证的请求不会被浏览器自动带上凭证，不需要校验；没有会话 cookie 的请求也没有可以冒用的身份
+func CSRFMiddleWare() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token, _ := c.Cookie(api.CSRFCookieName())
+		if token == "" {
+			newToken, err := utils.RandomToken(csrfTokenBytes)
+			if err != nil {
+				log.Errorf("CSRFMiddleWare|RandomToken err:%v", err)
+			} else {
+				api.SetCSRFCookie(c, newToken)
+			}
+		}
+
+		if !csrfProtected(c) {
+			c.Next()
+			return
+		}
+		header := c.GetHeader(constant.CSRFHeader)
+		if token == "" || subtle.ConstantTimeCompare([]byte(header), []byte(token)) != 1 {
+			rsp := &api.HttpResponse{}
+			rsp.ResponseWithStatus(c, http.StatusForbidden, api.CodeCSRFErr, "csrf token missing or invalid")
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// 请求是否需要校验 CSRF token：修改数据的方法，并且是靠会话 cookie 认证的
+// 判断 Bearer token 和 API key 的方式和 AuthMiddleWare 一致，带了它们的请求不会再看 cookie
+func csrfProtected(c *gin.Context) bool {
+	switch c.Request.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return false
+	}
+	if _, ok := apiKeyCredential(c); ok {
+		return false
+	}
+	if _, ok := bearerToken(c); ok {
+		return false
+	}
+	session, err := c.Cookie(api.SessionCookieName())
+	return err == nil && session != ""
+}
+
 // 这是一个用于对请求进行身份验证的中间件函数
 // 补充知识：gin.HandlerFunc的参数为 *gin.Context
-func AuthMiddleWare() gin.HandlerFunc {
+//
+// apiKeyScopes 为空时不接受 API key；不为空时接受拥有这些权限范围的 API key。
+// 修改密码、两步验证、管理 API key 之类的账号安全操作不传，只能用真正的登录凭证调用
+func AuthMiddleWare(apiKeyScopes ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		rsp := &api.HttpResponse{}
+
+		// 带了 API key 的请求按 API key 认证
+		if key, ok := apiKeyCredential(c); ok {
+			authenticateAPIKey(c, key, apiKeyScopes)
+			return
+		}
+
+		// 带了 Bearer token 的请求按 token 认证，不再看 cookie
+		if token, ok := bearerToken(c); ok {
+			authenticateBearer(c, token)
+			return
+		}
+
+		// 补充：c.Cookie(）就是根据名字获取请求里的 Cookie 值
+		// 获取请求中的会话 cookie 值（名字由 cookie 配置决定），没有 cookie 说明没有登录
+		session, err := c.Cookie(api.SessionCookieName())
+		if err != nil || session == "" {
+			rsp.ResponseWithStatus(c, http.StatusUnauthorized, api.CodeUnauthorized, "not logged in")
+			// c.Abort() 是一个用于终止请求的函数，它可以停止请求链的继续处理，
+			// 确保本次请求不再继续向后执行其他的中间件或请求处理函数
+			c.Abort()
+			return
+		}
+
+		// 拿 session 去会话存储里查，查不到说明 session 是伪造的、已经过期或者已经登出
+		user, err := cache.GetSessionStore().Get(session)
+		if errors.Is(err, cache.ErrInvalidSession) || errors.Is(err, cache.ErrSessionNotFound) {
+			rsp.ResponseWithStatus(c, http.StatusUnauthorized, api.CodeUnauthorized, "session expired or invalid")
+			c.Abort()
+			return
+		}
+		if err != nil {
+			log.Errorf("AuthMiddleWare|Get session err:%v", err)
+			rsp.ResponseWithError(c, api.CodeSessionErr, "session check failed")
+			c.Abort()
+			return
+		}
+		// 禁用用户时会删除用户的会话，这里再挡一次，防止删除失败时留下的会话继续可用
+		if user.Status == constant.UserStatusDisabled {
+			rsp.ResponseWithStatus(c, http.StatusUnauthorized, api.CodeUnauthorized, "session expired or invalid")
+			c.Abort()
+			return
+		}
+
+		// 记录会话最近一次访问的时间和 IP 并续期，续期后 cookie 的有效期也跟着更新；
+		// 到了绝对过期时间的会话按过期处理，其他错误不影响本次请求
+		expired, err := cache.GetSessionStore().Touch(user.Name, session, c.ClientIP(), sessionTouchInterval)
+		if errors.Is(err, cache.ErrSessionNotFound) {
+			rsp.ResponseWithStatus(c, http.StatusUnauthorized, api.CodeUnauthorized, "session expired or invalid")
+			c.Abort()
+			return
+		}
+		if err != nil {
+			log.Errorf("AuthMiddleWare|Touch session err:%v", err)
+		}
+		if expired > 0 {
+			api.SetSessionCookie(c, session, expired)
+		}
+
+		if !passwordChangeAllows(c, user) {
+			return
+		}
+
+		// 校验通过，把 session 和登录用户存入 gin 上下文，后面的处理函数直接使用这个可信的身份
+		// 补充知识：c.Next() 的作用是能将多个中间件串联起来调用
+		c.Set(constant.SessionKey, session)
+		c.Set(constant.AuthUserKey, user)
+		c.Next()
+	}
+}
+
+// OptionalAuthMiddleWare 带着有效的会话 cookie 或者 Bearer token 时和 AuthMiddleWare 一样把登录用户存入上下文，没有登录也放行
+// 用于根据是否登录决定跳转到哪个页面，例如 OAuth 授权入口；这里不接受 API key
+func OptionalAuthMiddleWare() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// 补充：c.Cookie(）就是根据请求的 SessionKey 获取 Cookie 值
-		// 获取请求中的名为 SessionKey 的 cookie 值，并赋值给 session 变量
-		if session, err := c.Cookie(constant.SessionKey); err == nil {
-			// 如果没有出现错误且 session 不为空，说明存在有效的 session
-			// 则调用 c.Next() 继续处理后续的请求处理函数，即允许通过该中间件
-			// 补充知识：c.Next() 的作用是能将多个中间件串联起来调用
-			if session != "" {
-				c.Next()
-				return
+		if _, ok := apiKeyCredential(c); ok {
+			c.Next()
+			return
+		}
+
+		// 带了 Bearer token 的请求按 token 认证，不再看 cookie
+		if token, ok := bearerToken(c); ok {
+			if user, family, err := service.AuthenticateAccessToken(token); err == nil && optionalAuthAllows(user) {
+				c.Set(constant.TokenFamilyKey, family)
+				c.Set(constant.AuthUserKey, user)
 			}
+			c.Next()
+			return
 		}
 
-		// 补充知识：
-		// JSON 方法是 gin.Context 的方法，用于设置响应的内容为 JSON 格式
-		// 它接收两个参数：HTTP 状态码和要发送的 JSON 数据
-		// 1）http.StatusUnauthorized 是一个 net/http 包提供的常量，表示 HTTP 状态码 401（未授权）
-		// 2）gin.H{"error": "err"} 是一个 map 类型的数据，表示要发送的 JSON 数据
-		// 发送的 JSON 数据中包含一个名为 error 的字段，其值为 "err"
-		// 最终 Gin 框架会将 HTTP 状态码设置为 401（未授权），并将 JSON 数据发送给客户端作为响应
-		// 作用：
-		// 如果没有找到或者 session 为空，则返回一个未授权的错误响应
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "err"})
+		if session, err := c.Cookie(api.SessionCookieName()); err == nil && session != "" {
+			if user, err := cache.GetSessionStore().Get(session); err == nil && optionalAuthAllows(user) {
+				c.Set(constant.SessionKey, session)
+				c.Set(constant.AuthUserKey, user)
+			}
+		}
+		c.Next()
+	}
+}
+
+// 被禁用、或者需要先修改密码的用户按没有登录处理，不能用来授权第三方应用
+func optionalAuthAllows(user *model.User) bool {
+	return user.Status != constant.UserStatusDisabled && !user.MustChangePassword
+}
+
+// 需要先修改密码时仍然可以访问的接口
+var passwordChangeRoutes = map[string]bool{
+	"/user/change_password": true,
+	"/user/logout":          true,
+}
+
+// 登录时被要求修改密码（临时密码、密码过期）的用户，修改密码之前只能访问修改密码和登出接口
+// 会话、Bearer token 和 API key 都一样
+func passwordChangeAllows(c *gin.Context, user *model.User) bool {
+	if !user.MustChangePassword || passwordChangeRoutes[c.FullPath()] {
+		return true
+	}
+	rsp := &api.HttpResponse{}
+	rsp.ResponseWithStatus(c, http.StatusForbidden, api.CodePasswordChange, "password change required")
+	c.Abort()
+	return false
+}
+
+// 取出 Authorization: Bearer 里的 token，没有开启 token 模式时忽略
+func bearerToken(c *gin.Context) (string, bool) {
+	if !service.TokenAuthEnabled() {
+		return "", false
+	}
+	scheme, token, ok := strings.Cut(c.GetHeader("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return "", false
+	}
+	return strings.TrimSpace(token), true
+}
+
+// 校验 Bearer token，通过后把登录用户和 token 所属的家族存入 gin 上下文
+func authenticateBearer(c *gin.Context, token string) {
+	rsp := &api.HttpResponse{}
+	user, family, err := service.AuthenticateAccessToken(token)
+	if errors.Is(err, service.ErrInvalidToken) || errors.Is(err, service.ErrUserDisabled) {
+		c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+		rsp.ResponseWithStatus(c, http.StatusUnauthorized, api.CodeUnauthorized, "token expired or invalid")
+		c.Abort()
+		return
+	}
+	if err != nil {
+		log.Errorf("AuthMiddleWare|AuthenticateAccessToken err:%v", err)
+		rsp.ResponseWithError(c, api.CodeSessionErr, "token check failed")
+		c.Abort()
+		return
+	}
+	if !passwordChangeAllows(c, user) {
+		return
+	}
+
+	c.Set(constant.TokenFamilyKey, family)
+	c.Set(constant.AuthUserKey, user)
+	c.Next()
+}
+
+// 取出请求里的 API key：X-API-Key 请求头，或者 Authorization: Bearer 里以 gk_ 开头的凭证
+func apiKeyCredential(c *gin.Context) (string, bool) {
+	if key := strings.TrimSpace(c.GetHeader("X-API-Key")); key != "" {
+		return key, true
+	}
+	scheme, token, ok := strings.Cut(c.GetHeader("Authorization"), " ")
+	token = strings.TrimSpace(token)
+	if ok && strings.EqualFold(scheme, "Bearer") && service.IsAPIKey(token) {
+		return token, true
+	}
+	return "", false
+}
+
+// 校验 API key 以及它的权限范围，通过后把所属用户和 key 的 ID 存入 gin 上下文
+func authenticateAPIKey(c *gin.Context, plain string, scopes []string) {
+	rsp := &api.HttpResponse{}
+	if len(scopes) == 0 {
+		rsp.ResponseWithStatus(c, http.StatusForbidden, api.CodeForbidden, "api key is not accepted here")
+		c.Abort()
+		return
+	}
 
-		// c.Abort() 是一个用于终止请求的函数，它可以停止请求链的继续处理，
-		// 确保本次请求不再继续向后执行其他的中间件或请求处理函数
+	user, key, err := service.AuthenticateAPIKey(plain, c.ClientIP())
+	if errors.Is(err, service.ErrInvalidToken) || errors.Is(err, service.ErrUserDisabled) {
+		c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+		rsp.ResponseWithStatus(c, http.StatusUnauthorized, api.CodeUnauthorized, "api key expired or invalid")
+		c.Abort()
+		return
+	}
+	if err != nil {
+		log.Errorf("AuthMiddleWare|AuthenticateAPIKey err:%v", err)
+		rsp.ResponseWithError(c, api.CodeSessionErr, "api key check failed")
 		c.Abort()
 		return
 	}
+	if !service.APIKeyAllows(key, scopes...) {
+		c.Header("WWW-Authenticate", `Bearer error="insufficient_scope", scope="`+strings.Join(scopes, " ")+`"`)
+		rsp.ResponseWithStatus(c, http.StatusForbidden, api.CodeForbidden, "api key scope required: "+strings.Join(scopes, " "))
+		c.Abort()
+		return
+	}
+
+	// API key 同样受必须修改密码的限制，管理员设置了临时密码之后，拿着 key 的人不能绕过去继续访问
+	if !passwordChangeAllows(c, user) {
+		return
+	}
+
+	c.Set(constant.APIKeyIDKey, key.ID)
+	c.Set(constant.AuthUserKey, user)
+	c.Next()
+}
+
+// RequirePermission 要求当前登录用户拥有指定权限的中间件，必须放在 AuthMiddleWare 之后
+func RequirePermission(perm string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		value, _ := c.Get(constant.AuthUserKey)
+		user, _ := value.(*model.User)
+		if !authz.HasPermission(user, perm) {
+			rsp := &api.HttpResponse{}
+			rsp.ResponseWithStatus(c, http.StatusForbidden, api.CodeForbidden, "permission denied: "+perm)
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
 }
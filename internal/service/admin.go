@@ -0,0 +1,240 @@
+package service
+
+import (
+	"encoding/base64"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+	"gouse/internal/cache"
+	"gouse/internal/dao"
+	"gouse/internal/model"
+	"gouse/pkg/constant"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 用户列表分页大小
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// ListUsers 管理员按条件查询用户列表，使用游标分页
+// 游标里记录的是上一页最后一个用户的 ID，翻页期间有新用户注册也不会出现重复或遗漏
+func ListUsers(ctx context.Context, req *ListUsersRequest) (*ListUsersResponse, error) {
+	uuid := ctx.Value(constant.ReqUuid)
+
+	filter, err := buildUserFilter(req)
+	if err != nil {
+		return nil, fmt.Errorf("ListUsers|%v", err)
+	}
+
+	// 多查一条，用来判断还有没有下一页
+	filter.Limit++
+	users, err := dao.ListUsers(filter)
+	if err != nil {
+		return nil, fmt.Errorf("ListUsers|%v", err)
+	}
+	filter.Limit--
+
+	rsp := &ListUsersResponse{Users: make([]*AdminUserInfo, 0, len(users))}
+	if len(users) > filter.Limit {
+		users = users[:filter.Limit]
+		rsp.NextCursor = encodeCursor(users[len(users)-1].ID)
+	}
+	for _, user := range users {
+		rsp.Users = append(rsp.Users, toAdminUserInfo(user))
+	}
+	log.Infof("%s|ListUsers|filter=%+v|count=%d", uuid, req, len(rsp.Users))
+	return rsp, nil
+}
+
+// GetUser 管理员查看单个用户
+func GetUser(ctx context.Context, id int) (*AdminUserInfo, error) {
+	user, err := dao.GetUserByID(id)
+	if err != nil {
+		return nil, fmt.Errorf("GetUser|%v", err)
+	}
+	if user == nil {
+		return nil, fmt.Errorf("GetUser|%w", ErrUserNotFound)
+	}
+	return toAdminUserInfo(user), nil
+}
+
+// SetUserDisabled 管理员禁用或者启用用户，禁用时会让该用户的全部会话立即失效
+func SetUserDisabled(ctx context.Context, id int, disabled bool) error {
+	uuid := ctx.Value(constant.ReqUuid)
+	operator, user, err := adminTarget(ctx, id)
+	if err != nil {
+		return fmt.Errorf("SetUserDisabled|%w", err)
+	}
+
+	status := constant.UserStatusNormal
+	if disabled {
+		status = constant.UserStatusDisabled
+	}
+	affected, err := dao.UpdateUserStatus(user.ID, status, operator.Name)
+	if err != nil {
+		return fmt.Errorf("SetUserDisabled|%v", err)
+	}
+	if affected != 1 {
+		return fmt.Errorf("SetUserDisabled|update status failed, user_id=%d", id)
+	}
+
+	// 缓存里的用户信息已经过时，禁用时还要踢掉所有在线的会话
+	invalidateUser(uuid, user.Name, disabled)
+	log.Infof("%s|SetUserDisabled|%s set user %s disabled=%v", uuid, operator.Name, user.Name, disabled)
+	return nil
+}
+
+// DeleteUser 管理员软删除用户，并让该用户的全部会话立即失效
+func DeleteUser(ctx context.Context, id int) error {
+	uuid := ctx.Value(constant.ReqUuid)
+	operator, user, err := adminTarget(ctx, id)
+	if err != nil {
+		return fmt.Errorf("DeleteUser|%w", err)
+	}
+
+	affected, err := dao.DeleteUser(user.ID, operator.Name)
+	if err != nil {
+		return fmt.Errorf("DeleteUser|%v", err)
+	}
+	if affected != 1 {
+		return fmt.Errorf("DeleteUser|delete failed, user_id=%d", id)
+	}
+
+	invalidateUser(uuid, user.Name, true)
+	log.Infof("%s|DeleteUser|%s deleted user %s", uuid, operator.Name, user.Name)
+	return nil
+}
+
+// 取出当前管理员和要操作的用户，管理员不能对自己执行禁用、删除之类的操作
+func adminTarget(ctx context.Context, id int) (*model.User, *model.User, error) {
+	operator, err := currentUser(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	user, err := dao.GetUserByID(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	if user == nil {
+		return nil, nil, ErrUserNotFound
+	}
+	if user.ID == operator.ID {
+		return nil, nil, fmt.Errorf("can not operate on yourself")
+	}
+	return operator, user, nil
+}
+
+// 清理用户的缓存，kickSessions 为 true 时同时删除该用户的全部会话和 token
+func invalidateUser(uuid interface{}, userName string, kickSessions bool) {
+	if err := cache.GetUserCache().DelUser(userName); err != nil {
+		log.Errorf("%s|invalidateUser|DelUser failed, user_name=%s|err=%v", uuid, userName, err)
+	}
+	if !kickSessions {
+		return
+	}
+	if err := cache.GetSessionStore().DeleteUserSessions(userName, ""); err != nil {
+		log.Errorf("%s|invalidateUser|DeleteUserSessions failed, user_name=%s|err=%v", uuid, userName, err)
+	}
+	revokeUserTokens(uuid, userName, "")
+}
+
+// 把请求参数转换成 dao 层的过滤条件
+func buildUserFilter(req *ListUsersRequest) (*dao.UserFilter, error) {
+	filter := &dao.UserFilter{
+		NamePrefix: req.NamePrefix,
+		Gender:     req.Gender,
+		MinAge:     req.MinAge,
+		MaxAge:     req.MaxAge,
+		Limit:      req.Limit,
+	}
+	if filter.Limit <= 0 {
+		filter.Limit = defaultPageSize
+	}
+	if filter.Limit > maxPageSize {
+		filter.Limit = maxPageSize
+	}
+
+	if req.CreatedAfter != "" {
+		t, err := time.Parse(time.RFC3339, req.CreatedAfter)
+		if err != nil {
+			return nil, fmt.Errorf("invalid created_after: %v", err)
+		}
+		filter.CreatedAfter = &t
+	}
+	if req.CreatedBefore != "" {
+		t, err := time.Parse(time.RFC3339, req.CreatedBefore)
+		if err != nil {
+			return nil, fmt.Errorf("invalid created_before: %v", err)
+		}
+		filter.CreatedBefore = &t
+	}
+
+	if req.Cursor != "" {
+		id, err := decodeCursor(req.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		filter.AfterID = id
+	}
+	return filter, nil
+}
+
+// 游标对调用方是不透明的字符串，内部是 "id:<最后一个用户的 ID>"
+func encodeCursor(id int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte("id:" + strconv.Itoa(id)))
+}
+
+func decodeCursor(cursor string) (int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil || !strings.HasPrefix(string(b), "id:") {
+		return 0, fmt.Errorf("invalid cursor")
+	}
+	id, err := strconv.Atoi(strings.TrimPrefix(string(b), "id:"))
+	if err != nil || id <= 0 {
+		return 0, fmt.Errorf("invalid cursor")
+	}
+	return id, nil
+}
+
+func toAdminUserInfo(user *model.User) *AdminUserInfo {
+	// 没有验证过邮箱的显示等待验证的邮箱，Verified 为 false
+	email := user.Email
+	if email == "" {
+		email = user.PendingEmail
+	}
+	return &AdminUserInfo{
+		ID:         user.ID,
+		UserName:   user.Name,
+		NickName:   user.NickName,
+		Gender:     user.Gender,
+		Age:        user.Age,
+		Email:      email,
+		Verified:   user.EmailVerified,
+		Phone:      user.Phone,
+		Disabled:   user.Status == constant.UserStatusDisabled,
+		CreateTime: user.CreateTime,
+		ModifyTime: user.ModifyTime,
+
+		PasswordChangedAt:  user.PasswordChangedAt,
+		MustChangePassword: user.MustChangePassword,
+	}
+}
+
+// ResetUserMFA 管理员关闭用户的两步验证，用于用户手机和恢复码都丢失的情况
+func ResetUserMFA(ctx context.Context, id int) error {
+	uuid := ctx.Value(constant.ReqUuid)
+	operator, user, err := adminTarget(ctx, id)
+	if err != nil {
+		return fmt.Errorf("ResetUserMFA|%w", err)
+	}
+
+	if err := dao.DeleteUserTOTP(user.ID); err != nil {
+		return fmt.Errorf("ResetUserMFA|%v", err)
+	}
+	log.Infof("%s|ResetUserMFA|%s reset two-factor authentication of user %s", uuid, operator.Name, user.Name)
+	return nil
+}
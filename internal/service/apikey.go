@@ -0,0 +1,315 @@
+package service
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+	"gouse/config"
+	"gouse/internal/authz"
+	"gouse/internal/dao"
+	"gouse/internal/model"
+	"gouse/pkg/constant"
+	"gouse/utils"
+	"strings"
+	"time"
+)
+
+// API key 的权限范围
+// user:read 和 user:write 对应读取、修改 key 所属用户自己的资料；
+// admin 允许调用管理接口，能调用哪些管理接口仍然由所属用户的角色权限决定
+const (
+	APIKeyScopeUserRead  = "user:read"
+	APIKeyScopeUserWrite = "user:write"
+	APIKeyScopeAdmin     = "admin"
+)
+
+// APIKeyScopes 全部可以申请的权限范围
+var APIKeyScopes = []string{APIKeyScopeUserRead, APIKeyScopeUserWrite, APIKeyScopeAdmin}
+
+// API key 配置的默认值
+const (
+	defaultAPIKeyMaxPerUser    = 20
+	defaultAPIKeyMaxExpireDays = 365
+
+	// APIKeyPrefix 所有 API key 都以它开头，认证中间件据此区分 API key 和登录 token
+	APIKeyPrefix      = "gk_"
+	apiKeyBytes       = 32
+	apiKeyShownPrefix = 10 // 列表里展示 key 的前几位，包括 gk_
+	// 最近使用时间的记录精度，同一个 key 在这段时间内多次使用只写一次数据库
+	apiKeyTouchInterval = time.Minute
+)
+
+// CreateAPIKey 用户给自己创建 API key，key 只在返回结果里出现这一次
+func CreateAPIKey(ctx context.Context, req *CreateAPIKeyRequest) (*CreateAPIKeyResponse, error) {
+	user, err := currentUser(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("CreateAPIKey|%v", err)
+	}
+	rsp, err := createAPIKey(ctx, user, user, req)
+	if err != nil {
+		return nil, fmt.Errorf("CreateAPIKey|%w", err)
+	}
+	return rsp, nil
+}
+
+// ListAPIKeys 列出当前用户的 API key
+func ListAPIKeys(ctx context.Context) ([]*APIKeyInfo, error) {
+	user, err := currentUser(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("ListAPIKeys|%v", err)
+	}
+	infos, err := listAPIKeys(user.ID)
+	if err != nil {
+		return nil, fmt.Errorf("ListAPIKeys|%v", err)
+	}
+	return infos, nil
+}
+
+// RevokeAPIKey 用户吊销自己的 API key
+func RevokeAPIKey(ctx context.Context, id int) error {
+	uuid := ctx.Value(constant.ReqUuid)
+	user, err := currentUser(ctx)
+	if err != nil {
+		return fmt.Errorf("RevokeAPIKey|%v", err)
+	}
+	key, err := dao.GetAPIKeyByID(id)
+	if err != nil {
+		return fmt.Errorf("RevokeAPIKey|%v", err)
+	}
+	// 别人的 key 按不存在处理，不暴露 ID 是否存在
+	if key == nil || key.UserID != user.ID || key.RevokedAt != nil {
+		return fmt.Errorf("RevokeAPIKey|%w", ErrAPIKeyNotFound)
+	}
+	if _, err := dao.RevokeAPIKey(key.ID); err != nil {
+		return fmt.Errorf("RevokeAPIKey|%v", err)
+	}
+	log.Infof("%s|RevokeAPIKey|%s revoked api key %d", uuid, user.Name, key.ID)
+	return nil
+}
+
+// AdminCreateAPIKey 管理员给服务账号之类的用户创建 API key
+func AdminCreateAPIKey(ctx context.Context, userID int, req *CreateAPIKeyRequest) (*CreateAPIKeyResponse, error) {
+	operator, user, err := apiKeyAdminTarget(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("AdminCreateAPIKey|%w", err)
+	}
+	rsp, err := createAPIKey(ctx, operator, user, req)
+	if err != nil {
+		return nil, fmt.Errorf("AdminCreateAPIKey|%w", err)
+	}
+	return rsp, nil
+}
+
+// AdminListAPIKeys 管理员查看用户的 API key
+func AdminListAPIKeys(ctx context.Context, userID int) ([]*APIKeyInfo, error) {
+	_, user, err := apiKeyAdminTarget(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("AdminListAPIKeys|%w", err)
+	}
+	infos, err := listAPIKeys(user.ID)
+	if err != nil {
+		return nil, fmt.Errorf("AdminListAPIKeys|%v", err)
+	}
+	return infos, nil
+}
+
+// AdminRevokeAPIKey 管理员吊销任意用户的 API key
+func AdminRevokeAPIKey(ctx context.Context, id int) error {
+	uuid := ctx.Value(constant.ReqUuid)
+	if isAPIKeyRequest(ctx) {
+		return fmt.Errorf("AdminRevokeAPIKey|%w", authz.ErrForbidden)
+	}
+	operator, err := currentUser(ctx)
+	if err != nil {
+		return fmt.Errorf("AdminRevokeAPIKey|%v", err)
+	}
+	affected, err := dao.RevokeAPIKey(id)
+	if err != nil {
+		return fmt.Errorf("AdminRevokeAPIKey|%v", err)
+	}
+	if affected != 1 {
+		return fmt.Errorf("AdminRevokeAPIKey|%w", ErrAPIKeyNotFound)
+	}
+	log.Infof("%s|AdminRevokeAPIKey|%s revoked api key %d", uuid, operator.Name, id)
+	return nil
+}
+
+// 管理员操作 API key 的目标用户；用 API key 调用时不允许再管理 API key，避免一个 key 派生出更多的 key
+func apiKeyAdminTarget(ctx context.Context, userID int) (*model.User, *model.User, error) {
+	if isAPIKeyRequest(ctx) {
+		return nil, nil, authz.ErrForbidden
+	}
+	operator, err := currentUser(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	user, err := dao.GetUserByID(userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if user == nil {
+		return nil, nil, ErrUserNotFound
+	}
+	return operator, user, nil
+}
+
+func createAPIKey(ctx context.Context, operator, owner *model.User, req *CreateAPIKeyRequest) (*CreateAPIKeyResponse, error) {
+	uuid := ctx.Value(constant.ReqUuid)
+	if err := checkAPIKeyRequest(owner, req); err != nil {
+		return nil, err
+	}
+
+	conf := config.GetGlobalConf().APIKey
+	count, err := dao.CountActiveAPIKeys(owner.ID)
+	if err != nil {
+		return nil, err
+	}
+	if maxKeys := orDefault(conf.MaxPerUser, defaultAPIKeyMaxPerUser); count >= int64(maxKeys) {
+		return nil, fmt.Errorf("%w: at most %d active api keys", ErrAPIKeyInvalid, maxKeys)
+	}
+
+	secret, err := utils.RandomToken(apiKeyBytes)
+	if err != nil {
+		return nil, fmt.Errorf("generate api key err:%v", err)
+	}
+	plain := APIKeyPrefix + secret
+	key := &model.APIKey{
+		UserID:      owner.ID,
+		Name:        req.Name,
+		Prefix:      plain[:apiKeyShownPrefix],
+		KeyHash:     utils.Sha256String(plain),
+		Scopes:      strings.Join(req.Scopes, " "),
+		ExpiresAt:   time.Now().AddDate(0, 0, req.ExpiresInDays),
+		CreateModel: model.CreateModel{Creator: operator.Name},
+	}
+	if err := dao.CreateAPIKey(key); err != nil {
+		return nil, err
+	}
+
+	log.Infof("%s|createAPIKey|%s created api key %d for %s, scopes=%s", uuid, operator.Name, key.ID, owner.Name, key.Scopes)
+	return &CreateAPIKeyResponse{APIKeyInfo: *toAPIKeyInfo(key), Key: plain}, nil
+}
+
+// 名称必填，权限范围必须是已知的范围，有效期不能超过配置的上限
+// 申请 admin 范围时，所属用户本身必须拥有管理接口要求的权限，只有其他权限（例如读取任意用户的资料）不够
+func checkAPIKeyRequest(owner *model.User, req *CreateAPIKeyRequest) error {
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > 64 {
+		return fmt.Errorf("%w: name is required and at most 64 characters", ErrAPIKeyInvalid)
+	}
+	if len(req.Scopes) == 0 {
+		return fmt.Errorf("%w: at least one scope is required", ErrAPIKeyInvalid)
+	}
+	for _, scope := range req.Scopes {
+		if !utils.Contains(APIKeyScopes, scope) {
+			return fmt.Errorf("%w: unknown scope %q", ErrAPIKeyInvalid, scope)
+		}
+	}
+	if utils.Contains(req.Scopes, APIKeyScopeAdmin) {
+		perms, err := userPermissions(owner)
+		if err != nil {
+			return err
+		}
+		if !hasAnyPermission(perms, authz.AdminPermissions) {
+			return fmt.Errorf("%w: user %s has no admin permission", ErrAPIKeyInvalid, owner.Name)
+		}
+	}
+	maxDays := orDefault(config.GetGlobalConf().APIKey.MaxExpireDays, defaultAPIKeyMaxExpireDays)
+	if req.ExpiresInDays <= 0 || req.ExpiresInDays > maxDays {
+		return fmt.Errorf("%w: expires_in_days must be between 1 and %d", ErrAPIKeyInvalid, maxDays)
+	}
+	return nil
+}
+
+func hasAnyPermission(perms, wanted []string) bool {
+	for _, perm := range wanted {
+		if utils.Contains(perms, perm) {
+			return true
+		}
+	}
+	return false
+}
+
+func listAPIKeys(userID int) ([]*APIKeyInfo, error) {
+	keys, err := dao.ListUserAPIKeys(userID)
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]*APIKeyInfo, 0, len(keys))
+	for _, key := range keys {
+		infos = append(infos, toAPIKeyInfo(key))
+	}
+	return infos, nil
+}
+
+// IsAPIKey 判断凭证是不是 API key
+func IsAPIKey(token string) bool {
+	return strings.HasPrefix(token, APIKeyPrefix)
+}
+
+// AuthenticateAPIKey 校验 API key，返回所属用户和 key 本身
+// key 不存在、已吊销或者已过期时返回 ErrInvalidToken，所属用户被禁用时返回 ErrUserDisabled
+func AuthenticateAPIKey(plain, ip string) (*model.User, *model.APIKey, error) {
+	key, err := dao.GetAPIKeyByHash(utils.Sha256String(plain))
+	if err != nil {
+		return nil, nil, fmt.Errorf("AuthenticateAPIKey|%v", err)
+	}
+	if key == nil || key.RevokedAt != nil || time.Now().After(key.ExpiresAt) {
+		return nil, nil, fmt.Errorf("AuthenticateAPIKey|%w", ErrInvalidToken)
+	}
+
+	owner, err := dao.GetUserByID(key.UserID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("AuthenticateAPIKey|%v", err)
+	}
+	if owner == nil {
+		return nil, nil, fmt.Errorf("AuthenticateAPIKey|%w", ErrInvalidToken)
+	}
+	// 和会话里一样，从缓存取用户信息，不带密码哈希
+	user, err := getUserInfo(owner.Name)
+	if err != nil {
+		return nil, nil, fmt.Errorf("AuthenticateAPIKey|%v", err)
+	}
+	if user.Status == constant.UserStatusDisabled {
+		return nil, nil, fmt.Errorf("AuthenticateAPIKey|%w", ErrUserDisabled)
+	}
+	// 所属用户的密码到期后，API key 和其他凭证一样只能访问修改密码和登出接口
+	if _, err := passwordChangeRequired("", user); err != nil {
+		return nil, nil, fmt.Errorf("AuthenticateAPIKey|%v", err)
+	}
+
+	dao.TouchAPIKey(key.ID, ip, time.Now().Add(-apiKeyTouchInterval))
+	return user, key, nil
+}
+
+// APIKeyAllows 判断 API key 是否拥有全部指定的权限范围
+func APIKeyAllows(key *model.APIKey, scopes ...string) bool {
+	granted := strings.Fields(key.Scopes)
+	for _, scope := range scopes {
+		if !utils.Contains(granted, scope) {
+			return false
+		}
+	}
+	return true
+}
+
+// 当前请求是不是通过 API key 认证的
+func isAPIKeyRequest(ctx context.Context) bool {
+	_, ok := ctx.Value(constant.APIKeyIDKey).(int)
+	return ok
+}
+
+func toAPIKeyInfo(key *model.APIKey) *APIKeyInfo {
+	return &APIKeyInfo{
+		ID:         key.ID,
+		Name:       key.Name,
+		Prefix:     key.Prefix,
+		Scopes:     strings.Fields(key.Scopes),
+		ExpiresAt:  key.ExpiresAt,
+		Expired:    time.Now().After(key.ExpiresAt),
+		LastUsedAt: key.LastUsedAt,
+		LastUsedIP: key.LastUsedIP,
+		Creator:    key.Creator,
+		CreateTime: key.CreateTime,
+	}
+}
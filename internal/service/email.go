@@ -0,0 +1,247 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+	"gouse/config"
+	"gouse/internal/cache"
+	"gouse/internal/dao"
+	"gouse/internal/model"
+	"gouse/pkg/constant"
+	"gouse/pkg/mailer"
+	"gouse/utils"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// 邮箱验证配置的默认值
+const (
+	defaultEmailVerifyExpired     = 24 * 3600 // 秒
+	defaultEmailVerifyResendLimit = 60        // 秒
+	defaultEmailVerifyURL         = "http://localhost:8080/static/verify_email.html"
+	emailVerifyTokenBytes         = 32
+)
+
+// 检查并规范化邮箱地址：去掉首尾空白、统一小写，只接受不带显示名的纯地址
+func normalizeEmail(email string) (string, error) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email || len(email) > 255 {
+		return "", fmt.Errorf("%w: %q", ErrEmailInvalid, email)
+	}
+	return email, nil
+}
+
+// 邮箱没有被其他用户占用时返回 nil
+func checkEmailAvailable(email string, userID int) error {
+	exists, err := dao.EmailExists(email, userID)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return ErrEmailTaken
+	}
+	return nil
+}
+
+// GetEmail 查看当前用户的邮箱以及是否已经验证
+func GetEmail(ctx context.Context) (*EmailInfo, error) {
+	user, err := currentUser(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("GetEmail|%v", err)
+	}
+	// 会话里保存的是登录时的用户信息，邮箱可能已经验证或者换过了，从数据库取最新的
+	dbUser, err := dao.GetUserByID(user.ID)
+	if err != nil {
+		return nil, fmt.Errorf("GetEmail|%v", err)
+	}
+	if dbUser == nil {
+		return nil, fmt.Errorf("GetEmail|%w", ErrUserNotFound)
+	}
+	// 还没有验证过邮箱的，返回注册时填写、等待验证的邮箱
+	if dbUser.Email == "" {
+		return &EmailInfo{Email: dbUser.PendingEmail}, nil
+	}
+	return &EmailInfo{Email: dbUser.Email, Verified: dbUser.EmailVerified}, nil
+}
+
+// ChangeEmail 修改邮箱，需要输入当前密码
+// 验证链接发到新邮箱，验证完成之前原来的邮箱继续有效，新邮箱验证通过后才替换
+func ChangeEmail(ctx context.Context, req *ChangeEmailRequest) error {
+	uuid := ctx.Value(constant.ReqUuid)
+	user, err := currentUser(ctx)
+	if err != nil {
+		return fmt.Errorf("ChangeEmail|%v", err)
+	}
+	email, err := normalizeEmail(req.Email)
+	if err != nil {
+		return fmt.Errorf("ChangeEmail|%w", err)
+	}
+
+	// 缓存里的用户信息不包含密码哈希，校验密码要从数据库取
+	dbUser, err := dao.GetUserByName(user.Name)
+	if err != nil {
+		return fmt.Errorf("ChangeEmail|%v", err)
+	}
+	if dbUser == nil {
+		return fmt.Errorf("ChangeEmail|%w", ErrUserNotFound)
+	}
+	match, _, err := verifyPassword(req.PassWord, dbUser.PassWord)
+	if err != nil {
+		return fmt.Errorf("ChangeEmail|verify password err:%v", err)
+	}
+	if !match {
+		log.Warnf("%s|ChangeEmail|password not match, user_name=%s", uuid, user.Name)
+		return fmt.Errorf("ChangeEmail|%w", ErrWrongPassword)
+	}
+	if email == dbUser.Email && dbUser.EmailVerified {
+		return fmt.Errorf("ChangeEmail|%w: email is not changed", ErrEmailInvalid)
+	}
+	if err := checkEmailAvailable(email, dbUser.ID); err != nil {
+		return fmt.Errorf("ChangeEmail|%w", err)
+	}
+
+	if err := sendEmailVerification(uuid, dbUser.Name, email, true); err != nil {
+		return fmt.Errorf("ChangeEmail|%w", err)
+	}
+	log.Infof("%s|ChangeEmail|verification mail sent to the new email, user_name=%s", uuid, user.Name)
+	return nil
+}
+
+// ResendEmailVerification 重新发送验证邮件，用于注册时的验证邮件过期或者没有收到
+func ResendEmailVerification(ctx context.Context) error {
+	uuid := ctx.Value(constant.ReqUuid)
+	user, err := currentUser(ctx)
+	if err != nil {
+		return fmt.Errorf("ResendEmailVerification|%v", err)
+	}
+	dbUser, err := dao.GetUserByID(user.ID)
+	if err != nil {
+		return fmt.Errorf("ResendEmailVerification|%v", err)
+	}
+	if dbUser == nil {
+		return fmt.Errorf("ResendEmailVerification|%w", ErrUserNotFound)
+	}
+	if dbUser.PendingEmail == "" {
+		return fmt.Errorf("ResendEmailVerification|%w: no email waiting for verification", ErrEmailInvalid)
+	}
+	if err := sendEmailVerification(uuid, dbUser.Name, dbUser.PendingEmail, true); err != nil {
+		return fmt.Errorf("ResendEmailVerification|%w", err)
+	}
+	return nil
+}
+
+// VerifyEmail 打开验证链接后完成邮箱验证
+// token 只能使用一次；邮箱在这期间被其他用户验证占用时返回 ErrEmailTaken，同一个邮箱谁先验证归谁
+func VerifyEmail(ctx context.Context, req *VerifyEmailRequest) error {
+	uuid := ctx.Value(constant.ReqUuid)
+	if req.Token == "" {
+		return fmt.Errorf("VerifyEmail|%w", ErrInvalidToken)
+	}
+	v, err := cache.TakeEmailVerifyToken(utils.Sha256String(req.Token))
+	if errors.Is(err, cache.ErrTokenNotFound) {
+		return fmt.Errorf("VerifyEmail|%w", ErrInvalidToken)
+	}
+	if err != nil {
+		return fmt.Errorf("VerifyEmail|%v", err)
+	}
+
+	user, err := dao.GetUserByName(v.UserName)
+	if err != nil {
+		return fmt.Errorf("VerifyEmail|%v", err)
+	}
+	if user == nil || user.Status == constant.UserStatusDisabled {
+		return fmt.Errorf("VerifyEmail|%w", ErrInvalidToken)
+	}
+	if err := checkEmailAvailable(v.Email, user.ID); err != nil {
+		return fmt.Errorf("VerifyEmail|%w", err)
+	}
+	if _, err := dao.UpdateUserEmail(user.ID, v.Email, user.Name); err != nil {
+		if errors.Is(err, dao.ErrDuplicateKey) {
+			return fmt.Errorf("VerifyEmail|%w", ErrEmailTaken)
+		}
+		return fmt.Errorf("VerifyEmail|%v", err)
+	}
+	invalidateUser(uuid, user.Name, false)
+
+	// 换了邮箱的，通知一下原来验证过的邮箱，不是本人操作时用户能及时发现
+	if user.EmailVerified && user.Email != "" && user.Email != v.Email {
+		notifyEmailChanged(uuid, user)
+	}
+	log.Infof("%s|VerifyEmail|email verified, user_name=%s", uuid, user.Name)
+	return nil
+}
+
+// 生成验证链接并发到 email，throttle 为 true 时限制发送频率
+func sendEmailVerification(uuid interface{}, userName, email string, throttle bool) error {
+	conf := config.GetGlobalConf().EmailVerify
+	if throttle {
+		interval := time.Duration(orDefault(conf.ResendLimit, defaultEmailVerifyResendLimit)) * time.Second
+		allowed, err := cache.AllowEmailVerifyMail(userName, interval)
+		if err != nil {
+			return err
+		}
+		if !allowed {
+			return ErrEmailThrottled
+		}
+	}
+
+	// 链接里是 token 明文，Redis 里只保存它的哈希
+	token, err := utils.RandomToken(emailVerifyTokenBytes)
+	if err != nil {
+		return fmt.Errorf("generate token err:%v", err)
+	}
+	expired := time.Duration(orDefault(conf.TokenExpired, defaultEmailVerifyExpired)) * time.Second
+	v := &cache.EmailVerification{UserName: userName, Email: email}
+	if err := cache.SetEmailVerifyToken(v, utils.Sha256String(token), expired); err != nil {
+		return err
+	}
+
+	verifyURL := conf.VerifyURL
+	if verifyURL == "" {
+		verifyURL = defaultEmailVerifyURL
+	}
+	msg := &mailer.Message{
+		To:      email,
+		Subject: "验证邮箱",
+		Body: fmt.Sprintf("%s，你好：\n\n请在 %d 小时内打开下面的链接验证你的邮箱，链接只能使用一次：\n\n%s\n\n如果不是你本人的操作，请忽略这封邮件。\n",
+			userName, int(expired.Hours()+0.5), verifyURL+"?token="+url.QueryEscape(token)),
+	}
+	if err := utils.GetMailer().Send(msg); err != nil {
+		log.Errorf("%s|sendEmailVerification|send mail failed, user_name=%s|err=%v", uuid, userName, err)
+		return fmt.Errorf("send mail failed")
+	}
+	log.Infof("%s|sendEmailVerification|verification mail sent, user_name=%s", uuid, userName)
+	return nil
+}
+
+// 通知原来的邮箱已经被换掉，发送失败只记日志
+func notifyEmailChanged(uuid interface{}, user *model.User) {
+	msg := &mailer.Message{
+		To:      user.Email,
+		Subject: "邮箱已修改",
+		Body: fmt.Sprintf("%s，你好：\n\n你的账号绑定的邮箱已经修改，这个邮箱之后不能再用来登录和找回密码。\n\n如果不是你本人的操作，请立即修改密码并联系管理员。\n",
+			user.Name),
+	}
+	if err := utils.GetMailer().Send(msg); err != nil {
+		log.Errorf("%s|notifyEmailChanged|send mail failed, user_name=%s|err=%v", uuid, user.Name, err)
+	}
+}
+
+// 按登录时填写的账号查找用户：先按用户名查，查不到并且像邮箱的，再按验证过的邮箱查
+// 先查用户名是为了兼容以前注册的带 @ 的用户名，现在注册时用户名已经不能带 @ 了
+func findLoginUser(account string) (*model.User, error) {
+	user, err := dao.GetUserByName(account)
+	if err != nil || user != nil || !strings.Contains(account, "@") {
+		return user, err
+	}
+	email, err := normalizeEmail(account)
+	if err != nil {
+		return nil, nil
+	}
+	return dao.GetUserByVerifiedEmail(email)
+}
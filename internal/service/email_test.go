@@ -0,0 +1,90 @@
+package service
+
+import (
+	"errors"
+	"gouse/internal/dao"
+	"gouse/internal/model"
+	"testing"
+)
+
+func TestNormalizeEmail(t *testing.T) {
+	cases := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{in: "alice@example.com", want: "alice@example.com"},
+		{in: "  Alice@Example.COM\t", want: "alice@example.com"},
+		{in: "ALICE@EXAMPLE.COM", want: "alice@example.com"},
+		{in: "", wantErr: true},
+		{in: "alice", wantErr: true},
+		{in: "alice@", wantErr: true},
+		{in: "Alice <alice@example.com>", wantErr: true},
+		{in: "alice@example.com, bob@example.com", wantErr: true},
+	}
+	for _, tc := range cases {
+		got, err := normalizeEmail(tc.in)
+		if tc.wantErr {
+			if !errors.Is(err, ErrEmailInvalid) {
+				t.Errorf("normalizeEmail(%q) err = %v, want ErrEmailInvalid", tc.in, err)
+			}
+			continue
+		}
+		if err != nil || got != tc.want {
+			t.Errorf("normalizeEmail(%q) = %q, %v, want %q", tc.in, got, err, tc.want)
+		}
+	}
+}
+
+func TestFindLoginUser(t *testing.T) {
+	setupTestDB(t)
+	name := testUserName(t)
+	verified := &model.User{Name: name, Email: name + "@example.com", EmailVerified: true}
+	pending := &model.User{Name: name + "_p", PendingEmail: name + "_p@example.com"}
+	for _, user := range []*model.User{verified, pending} {
+		if err := dao.CreateUser(user); err != nil {
+			t.Fatalf("CreateUser err: %v", err)
+		}
+	}
+
+	cases := []struct {
+		account string
+		want    string // 期望找到的用户名，为空表示找不到
+	}{
+		{account: name, want: name},
+		{account: name + "@example.com", want: name},
+		{account: "  " + name + "@EXAMPLE.com ", want: name},
+		// 还没有验证的邮箱不能用来登录
+		{account: name + "_p@example.com"},
+		{account: "nobody_" + name + "@example.com"},
+		{account: "not@an@email"},
+	}
+	for _, tc := range cases {
+		user, err := findLoginUser(tc.account)
+		if err != nil {
+			t.Errorf("findLoginUser(%q) err: %v", tc.account, err)
+			continue
+		}
+		got := ""
+		if user != nil {
+			got = user.Name
+		}
+		if got != tc.want {
+			t.Errorf("findLoginUser(%q) = %q, want %q", tc.account, got, tc.want)
+		}
+	}
+}
+
+func TestPersonalInfoPendingEmail(t *testing.T) {
+	user := &model.User{Name: "alice", NickName: "Ally", Email: "alice.w@example.com", PendingEmail: "wonder@example.com"}
+	want := map[string]bool{"alice": true, "Ally": true, "alice.w": true, "wonder": true}
+	got := personalInfo(user)
+	if len(got) != len(want) {
+		t.Fatalf("personalInfo() = %q, want %d items", got, len(want))
+	}
+	for _, info := range got {
+		if !want[info] {
+			t.Errorf("personalInfo() contains unexpected %q", info)
+		}
+	}
+}
@@ -1,18 +1,103 @@
 package service
 
+import (
+	"gouse/pkg/redact"
+	"time"
+)
+
 // RegisterRequest 注册请求
 type RegisterRequest struct {
 	UserName string `json:"user_name"`
-	Password string `json:"pass_word"`
+	Password string `json:"pass_word" redact:"true"`
 	Age      int    `json:"age"`
 	Gender   string `json:"gender"`
 	NickName string `json:"nick_name"`
+	Email    string `json:"email"` // 选填，验证后可以用来登录和找回密码
+}
+
+func (r RegisterRequest) String() string {
+	return redact.String(r)
 }
 
 // LoginRequest 登陆请求
 type LoginRequest struct {
-	UserName string `json:"user_name"`
-	PassWord string `json:"pass_word"`
+	UserName   string `json:"user_name"` // 用户名，或者验证过的邮箱
+	PassWord   string `json:"pass_word" redact:"true"`
+	RememberMe bool   `json:"remember_me"` // 记住我：会话使用更长的有效期
+}
+
+func (r LoginRequest) String() string {
+	return redact.String(r)
+}
+
+// LoginResult 登录结果
+// 开启了两步验证的用户，密码校验通过后 MFARequired 为 true，需要带着 MFAToken 和验证码调用 /user/login/mfa
+// 开启了 token 模式时，登录成功会同时返回 access token 和 refresh token
+type LoginResult struct {
+	Session        string        `json:"-"`                   // 登录成功时创建的 session，通过 cookie 返回
+	SessionExpired time.Duration `json:"-"`                   // session 当前的有效期，cookie 的有效期和它一致
+	MFARequired    bool          `json:"mfa_required"`        // 是否还需要输入验证码
+	MFAToken       string        `json:"mfa_token,omitempty"` // 输入验证码时使用的一次性 token
+	// 登录成功，但是在修改密码之前只能访问修改密码和登出接口：管理员设置了临时密码，或者密码已经过期
+	PasswordChangeRequired bool `json:"password_change_required,omitempty"`
+	TokenResponse
+}
+
+// TokenResponse 签发的 token
+type TokenResponse struct {
+	TokenType    string `json:"token_type,omitempty"`    // 固定为 Bearer
+	AccessToken  string `json:"access_token,omitempty"`  // 放在 Authorization: Bearer 里访问接口
+	ExpiresIn    int    `json:"expires_in,omitempty"`    // access token 的有效期，单位秒
+	RefreshToken string `json:"refresh_token,omitempty"` // 用来换发新的 token，只能使用一次
+}
+
+// RefreshTokenRequest 刷新、吊销 token 请求
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token" redact:"true"`
+}
+
+func (r RefreshTokenRequest) String() string {
+	return redact.String(r)
+}
+
+// LoginMFARequest 两步验证登录请求，验证码和恢复码二选一
+type LoginMFARequest struct {
+	MFAToken     string `json:"mfa_token" redact:"true"`
+	Code         string `json:"code" redact:"true"`          // 身份验证器 App 上的 6 位验证码
+	RecoveryCode string `json:"recovery_code" redact:"true"` // 恢复码
+}
+
+func (r LoginMFARequest) String() string {
+	return redact.String(r)
+}
+
+// MagicLinkRequest 申请免密码登录，账号可以是用户名或者验证过的邮箱
+type MagicLinkRequest struct {
+	Account    string `json:"account"`
+	Channel    string `json:"channel"`     // 发送渠道：email（默认，发到验证过的邮箱）或者 sms（发到绑定的手机号）
+	RememberMe bool   `json:"remember_me"` // 是否勾选了"记住我"，登录完成时生效
+}
+
+// MagicLoginRequest 免密码登录请求，登录链接里的 token 和账号加验证码二选一
+type MagicLoginRequest struct {
+	Token   string `json:"token" redact:"true"`
+	Account string `json:"account"`
+	Code    string `json:"code" redact:"true"`
+}
+
+func (r MagicLoginRequest) String() string {
+	return redact.String(r)
+}
+
+// PhoneLoginRequest 手机号加短信验证码登录请求，和 LoginRequest 二选一
+type PhoneLoginRequest struct {
+	Phone      string `json:"phone"` // 不带国家码时按 sms.country_code 补上
+	Code       string `json:"code" redact:"true"`
+	RememberMe bool   `json:"remember_me"`
+}
+
+func (r PhoneLoginRequest) String() string {
+	return redact.String(r)
 }
 
 // LogoutRequest 登出请求
@@ -30,7 +115,6 @@ type GetUserInfoResponse struct {
 	UserName string `json:"user_name"`
 	Age      int    `json:"age"`
 	Gender   string `json:"gender"`
-	PassWord string `json:"pass_word"`
 	NickName string `json:"nick_name"`
 }
 
@@ -39,3 +123,337 @@ type UpdateNickNameRequest struct {
 	UserName    string `json:"user_name"`
 	NewNickName string `json:"new_nick_name"`
 }
+
+// ChangePasswordRequest 修改密码请求
+type ChangePasswordRequest struct {
+	OldPassWord string `json:"old_pass_word" redact:"true"`
+	NewPassWord string `json:"new_pass_word" redact:"true"`
+	KeepSession bool   `json:"keep_session"` // 是否保留当前会话，其他会话总是会被踢下线
+}
+
+func (r ChangePasswordRequest) String() string {
+	return redact.String(r)
+}
+
+// EmailInfo 当前用户的邮箱
+type EmailInfo struct {
+	Email    string `json:"email"`
+	Verified bool   `json:"verified"`
+}
+
+// ChangeEmailRequest 修改邮箱请求，需要当前密码
+type ChangeEmailRequest struct {
+	Email    string `json:"email"`
+	PassWord string `json:"pass_word" redact:"true"`
+}
+
+func (r ChangeEmailRequest) String() string {
+	return redact.String(r)
+}
+
+// VerifyEmailRequest 验证邮箱请求，token 来自验证邮件里的链接
+type VerifyEmailRequest struct {
+	Token string `json:"token" redact:"true"`
+}
+
+func (r VerifyEmailRequest) String() string {
+	return redact.String(r)
+}
+
+// PhoneInfo 当前用户绑定的手机号，没有绑定时为空
+type PhoneInfo struct {
+	Phone string `json:"phone"`
+}
+
+// SendPhoneCodeRequest 发送短信验证码请求，绑定手机号和手机号登录共用
+type SendPhoneCodeRequest struct {
+	Phone string `json:"phone"`
+}
+
+// BindPhoneRequest 绑定手机号请求
+type BindPhoneRequest struct {
+	Phone string `json:"phone"`
+	Code  string `json:"code" redact:"true"`
+}
+
+func (r BindPhoneRequest) String() string {
+	return redact.String(r)
+}
+
+// ForgotPasswordRequest 找回密码请求
+type ForgotPasswordRequest struct {
+	UserName string `json:"user_name"`
+}
+
+// ResetPasswordRequest 重置密码请求
+type ResetPasswordRequest struct {
+	Token       string `json:"token" redact:"true"`
+	NewPassWord string `json:"new_pass_word" redact:"true"`
+}
+
+func (r ResetPasswordRequest) String() string {
+	return redact.String(r)
+}
+
+// EnrollTOTPResponse 开始绑定 TOTP 的返回结构
+type EnrollTOTPResponse struct {
+	Secret string `json:"secret"` // base32 编码的密钥，无法扫码时手动输入
+	URI    string `json:"uri"`    // otpauth:// 链接，生成二维码给身份验证器 App 扫描
+}
+
+// ConfirmTOTPRequest 确认绑定 TOTP 请求
+type ConfirmTOTPRequest struct {
+	Code string `json:"code" redact:"true"`
+}
+
+func (r ConfirmTOTPRequest) String() string {
+	return redact.String(r)
+}
+
+// RecoveryCodesResponse 恢复码，只在生成时返回这一次
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// DisableTOTPRequest 关闭两步验证请求，需要当前密码，以及验证码和恢复码二选一
+type DisableTOTPRequest struct {
+	PassWord     string `json:"pass_word" redact:"true"`
+	Code         string `json:"code" redact:"true"`
+	RecoveryCode string `json:"recovery_code" redact:"true"`
+}
+
+func (r DisableTOTPRequest) String() string {
+	return redact.String(r)
+}
+
+// RoleInfo 角色信息
+type RoleInfo struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// AssignRoleRequest 分配、回收角色请求
+type AssignRoleRequest struct {
+	UserID int    `json:"-"`
+	Role   string `json:"role"`
+}
+
+// ListUsersRequest 管理员查询用户列表请求
+type ListUsersRequest struct {
+	NamePrefix    string `form:"name_prefix"`    // 用户名前缀
+	Gender        string `form:"gender"`         // 性别
+	MinAge        int    `form:"min_age"`        // 最小年龄（含）
+	MaxAge        int    `form:"max_age"`        // 最大年龄（含）
+	CreatedAfter  string `form:"created_after"`  // 创建时间下限，RFC3339 格式
+	CreatedBefore string `form:"created_before"` // 创建时间上限，RFC3339 格式
+	Cursor        string `form:"cursor"`         // 上一页返回的 next_cursor，第一页不传
+	Limit         int    `form:"limit"`          // 每页条数，默认 20，最大 100
+}
+
+// AdminUserInfo 管理员看到的用户信息
+type AdminUserInfo struct {
+	ID         int       `json:"id"`
+	UserName   string    `json:"user_name"`
+	NickName   string    `json:"nick_name"`
+	Gender     string    `json:"gender"`
+	Age        int       `json:"age"`
+	Email      string    `json:"email"`
+	Verified   bool      `json:"email_verified"`
+	Phone      string    `json:"phone"`
+	Disabled   bool      `json:"disabled"`
+	CreateTime time.Time `json:"create_time"`
+	ModifyTime time.Time `json:"modify_time"`
+
+	PasswordChangedAt  *time.Time `json:"password_changed_at"`  // 最近一次设置密码的时间
+	MustChangePassword bool       `json:"must_change_password"` // 下次登录后必须先修改密码
+}
+
+// TempPasswordResponse 管理员设置的临时密码，明文只在这一次响应里返回
+type TempPasswordResponse struct {
+	Password  string `json:"password" redact:"true"`
+	ExpiresAt int64  `json:"expires_at"` // 临时密码的失效时间，Unix 时间戳，过期后不能再用来登录
+}
+
+func (r TempPasswordResponse) String() string {
+	return redact.String(r)
+}
+
+// ListUsersResponse 管理员查询用户列表返回结构
+type ListUsersResponse struct {
+	Users      []*AdminUserInfo `json:"users"`
+	NextCursor string           `json:"next_cursor"` // 为空表示没有下一页了
+}
+
+// CreateOAuthClientRequest 注册 OAuth 接入应用请求
+type CreateOAuthClientRequest struct {
+	Name         string   `json:"name"`
+	RedirectURIs []string `json:"redirect_uris"` // 授权码方式必填，回调时必须完全一致
+	GrantTypes   []string `json:"grant_types"`   // authorization_code、client_credentials
+	Scopes       []string `json:"scopes"`        // 允许申请的权限范围
+	Public       bool     `json:"public"`        // 公开应用没有密钥，只能使用授权码 + PKCE
+}
+
+// OAuthClientInfo 接入应用信息
+type OAuthClientInfo struct {
+	ClientID     string    `json:"client_id"`
+	Name         string    `json:"name"`
+	RedirectURIs []string  `json:"redirect_uris"`
+	GrantTypes   []string  `json:"grant_types"`
+	Scopes       []string  `json:"scopes"`
+	Public       bool      `json:"public"`
+	CreateTime   time.Time `json:"create_time"`
+}
+
+// CreateOAuthClientResponse 注册 OAuth 接入应用返回结构，密钥只在这里返回一次
+type CreateOAuthClientResponse struct {
+	OAuthClientInfo
+	ClientSecret string `json:"client_secret,omitempty"`
+}
+
+// RotateOIDCKeyRequest 立即轮换 ID token 签名密钥请求
+type RotateOIDCKeyRequest struct {
+	Revoke bool `json:"revoke"` // 旧密钥已经泄露：不再通过 JWKS 公布，所有实例立即停用
+}
+
+// AuthorizeRequest OAuth 授权请求，参数和 RFC 6749、RFC 7636 一致
+type AuthorizeRequest struct {
+	ResponseType        string `form:"response_type" json:"response_type"`
+	ClientID            string `form:"client_id" json:"client_id"`
+	RedirectURI         string `form:"redirect_uri" json:"redirect_uri"`
+	Scope               string `form:"scope" json:"scope"`
+	State               string `form:"state" json:"state"`
+	CodeChallenge       string `form:"code_challenge" json:"code_challenge"`
+	CodeChallengeMethod string `form:"code_challenge_method" json:"code_challenge_method"`
+	Nonce               string `form:"nonce" json:"nonce"` // OpenID Connect，原样写进 ID token，接入应用用来防重放
+}
+
+// ConsentRequest 用户在授权页上的选择
+type ConsentRequest struct {
+	AuthorizeRequest
+	Approve bool `json:"approve"`
+}
+
+// ConsentInfo 授权页上展示的信息
+type ConsentInfo struct {
+	ClientID   string   `json:"client_id"`
+	ClientName string   `json:"client_name"`
+	Scopes     []string `json:"scopes"`
+}
+
+// ConsentResult 用户确认之后浏览器要跳转的地址，里面带着授权码或者错误
+type ConsentResult struct {
+	RedirectTo string `json:"redirect_to"`
+}
+
+// OAuthTokenRequest /oauth/token 请求，表单格式
+// 应用密钥可以放在 HTTP Basic 认证里，也可以放在表单里
+type OAuthTokenRequest struct {
+	GrantType    string `form:"grant_type"`
+	Code         string `form:"code" redact:"true"`
+	RedirectURI  string `form:"redirect_uri"`
+	CodeVerifier string `form:"code_verifier" redact:"true"`
+	Scope        string `form:"scope"`
+	ClientID     string `form:"client_id"`
+	ClientSecret string `form:"client_secret" redact:"true"`
+}
+
+func (r OAuthTokenRequest) String() string {
+	return redact.String(r)
+}
+
+// OAuthTokenResponse /oauth/token 返回结构
+type OAuthTokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int    `json:"expires_in"`
+	Scope       string `json:"scope,omitempty"`
+	IDToken     string `json:"id_token,omitempty"` // 申请了 openid 时返回
+}
+
+// OAuthTokenActionRequest /oauth/revoke 和 /oauth/introspect 请求，表单格式
+type OAuthTokenActionRequest struct {
+	Token         string `form:"token" redact:"true"`
+	TokenTypeHint string `form:"token_type_hint"`
+	ClientID      string `form:"client_id"`
+	ClientSecret  string `form:"client_secret" redact:"true"`
+}
+
+func (r OAuthTokenActionRequest) String() string {
+	return redact.String(r)
+}
+
+// IntrospectResponse /oauth/introspect 返回结构（RFC 7662），token 无效时只有 active=false
+type IntrospectResponse struct {
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	Username  string `json:"username,omitempty"`
+	Subject   string `json:"sub,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	ExpiresAt int64  `json:"exp,omitempty"`
+	IssuedAt  int64  `json:"iat,omitempty"`
+}
+
+// OIDCDiscovery /.well-known/openid-configuration 返回结构（OpenID Connect Discovery 1.0）
+type OIDCDiscovery struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserInfoEndpoint                  string   `json:"userinfo_endpoint"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	RevocationEndpoint                string   `json:"revocation_endpoint"`
+	IntrospectionEndpoint             string   `json:"introspection_endpoint"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+}
+
+// OIDCUserInfo /userinfo 返回结构，sub 是用户 ID
+type OIDCUserInfo struct {
+	Subject string `json:"sub"`
+	profileClaims
+}
+
+// CreateAPIKeyRequest 创建 API key 请求
+type CreateAPIKeyRequest struct {
+	Name          string   `json:"name"`
+	Scopes        []string `json:"scopes"`          // user:read、user:write、admin
+	ExpiresInDays int      `json:"expires_in_days"` // 有效期，单位天
+}
+
+// APIKeyInfo API key 信息，不包含 key 本身
+type APIKeyInfo struct {
+	ID         int        `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"` // key 的开头几位，用来辨认是哪一个 key
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	Expired    bool       `json:"expired"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	LastUsedIP string     `json:"last_used_ip,omitempty"`
+	Creator    string     `json:"creator"`
+	CreateTime time.Time  `json:"create_time"`
+}
+
+// CreateAPIKeyResponse 创建 API key 返回结构，key 只在这里返回一次
+type CreateAPIKeyResponse struct {
+	APIKeyInfo
+	Key string `json:"key"`
+}
+
+// SessionInfo 一个登录会话，ID 不是 session 本身，不能用来登录
+type SessionInfo struct {
+	ID        string    `json:"id"`
+	Device    string    `json:"device"`
+	UserAgent string    `json:"user_agent"`
+	IP        string    `json:"ip"`      // 登录时的 IP
+	LastIP    string    `json:"last_ip"` // 最近一次访问的 IP
+	CreatedAt time.Time `json:"created_at"`
+	LastSeen  time.Time `json:"last_seen"`
+	Current   bool      `json:"current"` // 是不是发起这次请求的会话
+}
@@ -0,0 +1,82 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"gouse/pkg/password"
+	"strings"
+	"time"
+)
+
+// service 层对外暴露的错误，api 层根据它们选择错误码
+// 使用时用 fmt.Errorf("xxx|%w", ErrXXX) 包一层，保留原来的日志前缀风格
+var (
+	// ErrUserDisabled 账号已被管理员禁用
+	ErrUserDisabled = errors.New("account is disabled")
+	// ErrUserNotFound 用户不存在
+	ErrUserNotFound = errors.New("user not found")
+	// ErrWrongPassword 当前密码不正确
+	ErrWrongPassword = errors.New("password is not correct")
+	// ErrPasswordPolicy 新密码不符合密码策略
+	ErrPasswordPolicy = errors.New("password does not meet the policy")
+	// ErrInvalidToken 一次性 token 不存在、已过期或者已经被使用过
+	ErrInvalidToken = errors.New("token is invalid or expired")
+	// ErrAccountLocked 登录失败次数过多，账号或者 IP 被临时锁定
+	ErrAccountLocked = errors.New("too many failed attempts, temporarily locked")
+	// ErrInvalidMFACode 两步验证的验证码或者恢复码不正确
+	ErrInvalidMFACode = errors.New("verification code is not correct")
+	// ErrMFAAlreadyEnabled 已经开启了两步验证
+	ErrMFAAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+	// ErrMFANotEnabled 还没有开启两步验证，或者还没有开始绑定
+	ErrMFANotEnabled = errors.New("two-factor authentication is not enabled")
+	// ErrSessionNotFound 会话不存在、已经过期或者不属于当前用户
+	ErrSessionNotFound = errors.New("session not found")
+	// ErrAPIKeyNotFound API key 不存在或者已经吊销
+	ErrAPIKeyNotFound = errors.New("api key not found")
+	// ErrAPIKeyInvalid 创建 API key 的参数不合法
+	ErrAPIKeyInvalid = errors.New("invalid api key request")
+	// ErrEmailInvalid 邮箱地址不合法，或者当前状态下不能执行这个邮箱操作
+	ErrEmailInvalid = errors.New("invalid email")
+	// ErrEmailTaken 邮箱已经被其他用户使用
+	ErrEmailTaken = errors.New("email is already in use")
+	// ErrEmailThrottled 验证邮件发送得太频繁
+	ErrEmailThrottled = errors.New("verification mail was sent recently, try again later")
+	// ErrPhoneInvalid 手机号格式不对
+	ErrPhoneInvalid = errors.New("invalid phone number")
+	// ErrPhoneTaken 手机号已经被其他用户绑定
+	ErrPhoneTaken = errors.New("phone number is already in use")
+	// ErrTooManyRequests 同一个 IP 或者同一个号码请求得太频繁
+	ErrTooManyRequests = errors.New("too many requests, try again later")
+	// ErrPasswordExpired 管理员设置的临时密码已经过了有效期
+	ErrPasswordExpired = errors.New("temporary password has expired")
+)
+
+// LockedError 带有剩余锁定时间的锁定错误，errors.Is(err, ErrAccountLocked) 为 true
+type LockedError struct {
+	RetryAfter time.Duration // 多久之后可以重试
+}
+
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("%v, retry after %d seconds", ErrAccountLocked, int(e.RetryAfter.Seconds()+0.5))
+}
+
+func (e *LockedError) Is(target error) bool {
+	return target == ErrAccountLocked
+}
+
+// PasswordPolicyError 新密码不符合密码策略，Violations 是违反的全部规则，errors.Is(err, ErrPasswordPolicy) 为 true
+type PasswordPolicyError struct {
+	Violations []password.Violation
+}
+
+func (e *PasswordPolicyError) Error() string {
+	msgs := make([]string, 0, len(e.Violations))
+	for _, v := range e.Violations {
+		msgs = append(msgs, v.Message)
+	}
+	return fmt.Sprintf("%v: %s", ErrPasswordPolicy, strings.Join(msgs, "; "))
+}
+
+func (e *PasswordPolicyError) Is(target error) bool {
+	return target == ErrPasswordPolicy
+}
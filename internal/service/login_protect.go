@@ -0,0 +1,112 @@
+package service
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+	"gouse/config"
+	"gouse/internal/cache"
+	"gouse/internal/dao"
+	"gouse/pkg/constant"
+	"time"
+)
+
+// 登录保护配置的默认值
+const (
+	defaultUserMaxFailures = 5
+	defaultIPMaxFailures   = 20
+	defaultFailureWindow   = 900   // 秒
+	defaultLockoutBase     = 60    // 秒
+	defaultLockoutMax      = 3600  // 秒
+	defaultLockoutReset    = 86400 // 秒
+)
+
+// 登录保护的统计对象
+type loginSubject struct {
+	key         string // 缓存里使用的统计对象，例如 "user_alice"
+	maxFailures int    // 失败多少次后锁定
+}
+
+// 本次登录需要统计的对象：用户名，以及有 IP 时的客户端 IP
+func loginSubjects(userName, ip string) []loginSubject {
+	conf := config.GetGlobalConf().LoginProtect
+	subjects := []loginSubject{
+		{constant.LoginSubjectUser + userName, orDefault(conf.UserMaxFailures, defaultUserMaxFailures)},
+	}
+	if ip != "" {
+		subjects = append(subjects, loginSubject{constant.LoginSubjectIP + ip, orDefault(conf.IPMaxFailures, defaultIPMaxFailures)})
+	}
+	return subjects
+}
+
+// 检查用户名和 IP 是否处于锁定状态，锁定时返回 *LockedError
+func checkLoginLock(userName, ip string) error {
+	for _, subject := range loginSubjects(userName, ip) {
+		ttl, err := cache.GetLoginProtectStore().GetLockTTL(subject.key)
+		if err != nil {
+			// 缓存出错时放行，不能因为 Redis 抖动让所有人都登录不了
+			log.Errorf("checkLoginLock|GetLockTTL failed, subject=%s|err=%v", subject.key, err)
+			continue
+		}
+		if ttl > 0 {
+			return &LockedError{RetryAfter: ttl}
+		}
+	}
+	return nil
+}
+
+// 记录一次登录失败，达到阈值时锁定对应的用户名或 IP
+func recordLoginFailure(uuid interface{}, userName, ip string) {
+	conf := config.GetGlobalConf().LoginProtect
+	window := time.Duration(orDefault(conf.FailureWindow, defaultFailureWindow)) * time.Second
+	base := time.Duration(orDefault(conf.LockoutBase, defaultLockoutBase)) * time.Second
+	max := time.Duration(orDefault(conf.LockoutMax, defaultLockoutMax)) * time.Second
+	reset := time.Duration(orDefault(conf.LockoutReset, defaultLockoutReset)) * time.Second
+
+	for _, subject := range loginSubjects(userName, ip) {
+		count, err := cache.GetLoginProtectStore().IncrFailure(subject.key, window)
+		if err != nil {
+			log.Errorf("%s|recordLoginFailure|IncrFailure failed, subject=%s|err=%v", uuid, subject.key, err)
+			continue
+		}
+		if count < int64(subject.maxFailures) {
+			continue
+		}
+		duration, err := cache.GetLoginProtectStore().Lock(subject.key, base, max, reset)
+		if err != nil {
+			log.Errorf("%s|recordLoginFailure|Lock failed, subject=%s|err=%v", uuid, subject.key, err)
+			continue
+		}
+		log.Warnf("%s|recordLoginFailure|%s locked for %v after %d failures", uuid, subject.key, duration, count)
+	}
+}
+
+// 登录成功后清零该用户名的失败次数
+// IP 的失败次数不清零，否则攻击者可以用自己的账号穿插登录来绕过 IP 维度的限制
+func recordLoginSuccess(uuid interface{}, userName string) {
+	if err := cache.GetLoginProtectStore().ClearFailures(constant.LoginSubjectUser + userName); err != nil {
+		log.Errorf("%s|recordLoginSuccess|ClearFailures failed, user_name=%s|err=%v", uuid, userName, err)
+	}
+}
+
+// UnlockUser 管理员手动解除用户的登录锁定
+func UnlockUser(ctx context.Context, id int) error {
+	uuid := ctx.Value(constant.ReqUuid)
+	operator, err := currentUser(ctx)
+	if err != nil {
+		return fmt.Errorf("UnlockUser|%v", err)
+	}
+	user, err := dao.GetUserByID(id)
+	if err != nil {
+		return fmt.Errorf("UnlockUser|%v", err)
+	}
+	if user == nil {
+		return fmt.Errorf("UnlockUser|%w", ErrUserNotFound)
+	}
+
+	if err := cache.GetLoginProtectStore().Unlock(constant.LoginSubjectUser + user.Name); err != nil {
+		return fmt.Errorf("UnlockUser|%v", err)
+	}
+	log.Infof("%s|UnlockUser|%s unlocked user %s", uuid, operator.Name, user.Name)
+	return nil
+}
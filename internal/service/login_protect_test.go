@@ -0,0 +1,54 @@
+package service
+
+import (
+	"errors"
+	"gouse/config"
+	"testing"
+	"time"
+)
+
+func TestLoginLock(t *testing.T) {
+	conf := &config.GlobalConfig{}
+	conf.LoginProtect = config.LoginProtectConf{UserMaxFailures: 3, IPMaxFailures: 6, LockoutBase: 60, LockoutMax: 3600}
+	setupTestStores(t, conf)
+
+	// 没到次数不锁定
+	for i := 0; i < 2; i++ {
+		recordLoginFailure("test", "alice", "10.0.0.1")
+	}
+	if err := checkLoginLock("alice", "10.0.0.1"); err != nil {
+		t.Fatalf("checkLoginLock after 2 failures = %v, want nil", err)
+	}
+
+	// 登录成功清零用户名的失败次数，重新计数
+	recordLoginSuccess("test", "alice")
+	for i := 0; i < 2; i++ {
+		recordLoginFailure("test", "alice", "10.0.0.1")
+	}
+	if err := checkLoginLock("alice", ""); err != nil {
+		t.Fatalf("checkLoginLock after success and 2 failures = %v, want nil", err)
+	}
+
+	// 第三次失败锁定用户名，锁定时长是 lockout_base
+	recordLoginFailure("test", "alice", "10.0.0.1")
+	err := checkLoginLock("alice", "")
+	var locked *LockedError
+	if !errors.Is(err, ErrAccountLocked) || !errors.As(err, &locked) {
+		t.Fatalf("checkLoginLock after 3 failures = %v, want LockedError", err)
+	}
+	if locked.RetryAfter <= 59*time.Second || locked.RetryAfter > 60*time.Second {
+		t.Errorf("RetryAfter = %v, want about 60s", locked.RetryAfter)
+	}
+
+	// 别的用户名不受影响；IP 的失败次数登录成功也不清零，累计到 6 次后锁定
+	if err := checkLoginLock("bob", "10.0.0.1"); err != nil {
+		t.Fatalf("checkLoginLock(bob) after 5 ip failures = %v, want nil", err)
+	}
+	recordLoginFailure("test", "bob", "10.0.0.1")
+	if err := checkLoginLock("bob", "10.0.0.1"); !errors.Is(err, ErrAccountLocked) {
+		t.Errorf("checkLoginLock(bob, locked ip) = %v, want ErrAccountLocked", err)
+	}
+	if err := checkLoginLock("bob", "10.0.0.2"); err != nil {
+		t.Errorf("checkLoginLock(bob, other ip) = %v, want nil", err)
+	}
+}
@@ -0,0 +1,290 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+	"gouse/config"
+	"gouse/internal/cache"
+	"gouse/internal/dao"
+	"gouse/internal/model"
+	"gouse/pkg/constant"
+	"gouse/pkg/mailer"
+	"gouse/pkg/phone"
+	"gouse/pkg/sms"
+	"gouse/utils"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// 免密码登录配置的默认值
+const (
+	defaultMagicTokenExpired = 600 // 秒
+	defaultMagicResendLimit  = 60  // 秒
+	defaultMagicIPLimit      = 10  // 每小时
+	defaultMagicPrefixLimit  = 50  // 每小时
+	defaultMagicMaxAttempts  = 5
+	defaultMagicCodeLength   = 6
+	defaultMagicLoginURL     = "http://localhost:8080/static/magic_login.html"
+	magicTokenBytes          = 32
+	magicIPWindow            = time.Hour
+)
+
+// 登录链接和验证码的发送渠道
+const (
+	magicChannelEmail = "email" // 发到验证过的邮箱，默认
+	magicChannelSMS   = "sms"   // 发到绑定的手机号
+)
+
+// RequestMagicLink 申请免密码登录，向用户验证过的邮箱或者绑定的手机号发送一次性的登录链接和验证码
+// 和找回密码一样，不管用户是否存在、有没有验证过的邮箱或者绑定的手机号，都返回成功，避免被用来探测用户名；
+// 只有同一个 IP 或者同一个 IP 网段申请得太频繁时返回 ErrTooManyRequests
+func RequestMagicLink(ctx context.Context, req *MagicLinkRequest) error {
+	uuid := ctx.Value(constant.ReqUuid)
+	ip, _ := ctx.Value(constant.ClientIPKey).(string)
+	log.Infof("%s|RequestMagicLink access from,account=%s|channel=%s|ip=%s", uuid, req.Account, req.Channel, ip)
+	channel := req.Channel
+	if channel == "" {
+		channel = magicChannelEmail
+	}
+	if req.Account == "" || (channel != magicChannelEmail && channel != magicChannelSMS) {
+		return fmt.Errorf("RequestMagicLink|request params invalid")
+	}
+
+	conf := config.GetGlobalConf().MagicLink
+	if err := checkMagicLinkIPLimit(uuid, ip); err != nil {
+		return fmt.Errorf("RequestMagicLink|%w", err)
+	}
+
+	user, err := findLoginUser(req.Account)
+	if err != nil {
+		return fmt.Errorf("RequestMagicLink|%v", err)
+	}
+	if user == nil || user.Status == constant.UserStatusDisabled {
+		log.Warnf("%s|RequestMagicLink|user %s not found or disabled, skip", uuid, req.Account)
+		return nil
+	}
+	switch {
+	case channel == magicChannelEmail && (user.Email == "" || !user.EmailVerified):
+		log.Warnf("%s|RequestMagicLink|user %s has no verified email, skip", uuid, user.Name)
+		return nil
+	case channel == magicChannelSMS && user.Phone == "":
+		log.Warnf("%s|RequestMagicLink|user %s has no bound phone, skip", uuid, user.Name)
+		return nil
+	}
+
+	interval := time.Duration(orDefault(conf.ResendLimit, defaultMagicResendLimit)) * time.Second
+	allowed, err := cache.AllowMagicLink(user.Name, interval)
+	if err != nil {
+		return fmt.Errorf("RequestMagicLink|%v", err)
+	}
+	if !allowed {
+		log.Warnf("%s|RequestMagicLink|too frequent, user_name=%s", uuid, user.Name)
+		return nil
+	}
+	// 短信要花钱，和短信验证码一样受号码、IP 网段和全局条数的限制；超过限制时同样返回成功，不暴露账号绑定了手机号
+	if channel == magicChannelSMS {
+		err := checkSMSLimit(uuid, ip, user.Phone)
+		if err == nil {
+			err = takeSMSBudget(uuid)
+		}
+		if err != nil {
+			if errors.Is(err, ErrTooManyRequests) {
+				log.Warnf("%s|RequestMagicLink|sms limited, user_name=%s", uuid, user.Name)
+				return nil
+			}
+			return fmt.Errorf("RequestMagicLink|%v", err)
+		}
+	}
+
+	// 链接里是 token 明文，验证码发给用户手动输入，Redis 里都只保存哈希
+	token, err := utils.RandomToken(magicTokenBytes)
+	if err != nil {
+		return fmt.Errorf("RequestMagicLink|generate token err:%v", err)
+	}
+	code, err := utils.RandomDigits(orDefault(conf.CodeLength, defaultMagicCodeLength))
+	if err != nil {
+		return fmt.Errorf("RequestMagicLink|generate code err:%v", err)
+	}
+	expired := time.Duration(orDefault(conf.TokenExpired, defaultMagicTokenExpired)) * time.Second
+	if err := cache.SetMagicLogin(user.Name, utils.Sha256String(token), magicCodeHash(user.Name, code), req.RememberMe, expired); err != nil {
+		return fmt.Errorf("RequestMagicLink|%v", err)
+	}
+
+	loginURL := conf.LoginURL
+	if loginURL == "" {
+		loginURL = defaultMagicLoginURL
+	}
+	link := loginURL + "?token=" + url.QueryEscape(token)
+	if channel == magicChannelSMS {
+		msg := &sms.Message{
+			To:   user.Phone,
+			Text: fmt.Sprintf("登录验证码：%s，%d 分钟内有效，也可以打开链接登录：%s。如果不是你本人的操作，请忽略这条短信。", code, int(expired.Minutes()), link),
+		}
+		if err := utils.GetSmsSender().Send(msg); err != nil {
+			log.Errorf("%s|RequestMagicLink|send sms failed, user_name=%s|phone=%s|err=%v", uuid, user.Name, phone.Mask(user.Phone), err)
+			return fmt.Errorf("RequestMagicLink|send sms failed")
+		}
+		log.Infof("%s|RequestMagicLink|magic link sent by sms, user_name=%s|phone=%s", uuid, user.Name, phone.Mask(user.Phone))
+		return nil
+	}
+
+	msg := &mailer.Message{
+		To:      user.Email,
+		Subject: "登录链接",
+		Body: fmt.Sprintf("%s，你好：\n\n请在 %d 分钟内打开下面的链接登录，链接只能使用一次：\n\n%s\n\n也可以在登录页面输入验证码：%s\n\n如果不是你本人的操作，请忽略这封邮件。\n",
+			user.Name, int(expired.Minutes()), link, code),
+	}
+	if err := utils.GetMailer().Send(msg); err != nil {
+		log.Errorf("%s|RequestMagicLink|send mail failed, user_name=%s|err=%v", uuid, user.Name, err)
+		return fmt.Errorf("RequestMagicLink|send mail failed")
+	}
+	log.Infof("%s|RequestMagicLink|magic link sent, user_name=%s", uuid, user.Name)
+	return nil
+}
+
+// 检查同一个 IP 和同一个 IP 网段（IPv4 /24，IPv6 /64）每小时申请的次数，超过时返回 ErrTooManyRequests
+// 单个 IP 的限制低一些，网段的上限高一些，同一个网段里的多个正常用户不会互相影响，换着网段里的地址刷也有上限
+func checkMagicLinkIPLimit(uuid interface{}, ip string) error {
+	if ip == "" {
+		return nil
+	}
+	conf := config.GetGlobalConf().MagicLink
+	limits := []struct {
+		subject string
+		max     int
+	}{
+		{"ip:" + ip, orDefault(conf.IPLimit, defaultMagicIPLimit)},
+		{"net:" + utils.IPPrefix(ip), orDefault(conf.IPPrefixLimit, defaultMagicPrefixLimit)},
+	}
+	for _, limit := range limits {
+		count, err := cache.IncrMagicLinkIPRequests(limit.subject, magicIPWindow)
+		if err != nil {
+			return err
+		}
+		if count > int64(limit.max) {
+			log.Warnf("%s|checkMagicLinkIPLimit|too many requests from %s", uuid, limit.subject)
+			return ErrTooManyRequests
+		}
+	}
+	return nil
+}
+
+// MagicLogin 使用登录链接里的 token，或者账号加验证码完成免密码登录，成功后和 Login 一样创建会话
+// 链接和验证码只能使用一次，用掉其中一个另一个也随之作废；开启了两步验证的用户仍然需要输入两步验证的验证码
+// 账号加验证码登录时，账号不存在、没有申请过登录、验证码不对都返回 ErrInvalidMFACode，不能用来探测用户名
+func MagicLogin(ctx context.Context, req *MagicLoginRequest) (*LoginResult, error) {
+	uuid := ctx.Value(constant.ReqUuid)
+	ip, _ := ctx.Value(constant.ClientIPKey).(string)
+
+	var (
+		userName, field, hash string
+		err                   error
+	)
+	switch {
+	case req.Token != "":
+		tokenHash := utils.Sha256String(req.Token)
+		userName, err = cache.GetMagicTokenUser(tokenHash)
+		if errors.Is(err, cache.ErrTokenNotFound) {
+			return nil, fmt.Errorf("MagicLogin|%w", ErrInvalidToken)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("MagicLogin|%v", err)
+		}
+		field, hash = "token", tokenHash
+	case req.Account != "" && req.Code != "":
+		user, err := findLoginUser(req.Account)
+		if err != nil {
+			return nil, fmt.Errorf("MagicLogin|%v", err)
+		}
+		if user == nil {
+			if err := checkLoginLock(req.Account, ip); err != nil {
+				return nil, fmt.Errorf("MagicLogin|%w", err)
+			}
+			recordLoginFailure(uuid, req.Account, ip)
+			return nil, fmt.Errorf("MagicLogin|%w", ErrInvalidMFACode)
+		}
+		userName = user.Name
+		field, hash = "code", magicCodeHash(user.Name, strings.TrimSpace(req.Code))
+	default:
+		return nil, fmt.Errorf("MagicLogin|request params invalid")
+	}
+
+	// 验证码只有几位，同样受登录保护限制
+	if err := checkLoginLock(userName, ip); err != nil {
+		log.Warnf("%s|MagicLogin|locked, user_name=%s|ip=%s|err=%v", uuid, userName, ip, err)
+		return nil, fmt.Errorf("MagicLogin|%w", err)
+	}
+
+	maxAttempts := orDefault(config.GetGlobalConf().MagicLink.MaxAttempts, defaultMagicMaxAttempts)
+	remember, err := cache.TakeMagicLogin(userName, field, hash, maxAttempts)
+	switch {
+	case errors.Is(err, cache.ErrTokenNotFound) && field == "token":
+		return nil, fmt.Errorf("MagicLogin|%w", ErrInvalidToken)
+	case errors.Is(err, cache.ErrCodeMismatch) && field == "token":
+		// 用户又申请了新的登录，旧链接已经作废
+		return nil, fmt.Errorf("MagicLogin|%w", ErrInvalidToken)
+	case errors.Is(err, cache.ErrTokenNotFound), errors.Is(err, cache.ErrCodeMismatch):
+		// 没有申请过登录的和验证码不对的一样处理，同样计入失败次数
+		log.Errorf("%s|MagicLogin|code not match or no pending login, user_name=%s", uuid, userName)
+		recordLoginFailure(uuid, userName, ip)
+		return nil, fmt.Errorf("MagicLogin|%w", ErrInvalidMFACode)
+	case err != nil:
+		return nil, fmt.Errorf("MagicLogin|%v", err)
+	}
+
+	user, err := dao.GetUserByName(userName)
+	if err != nil {
+		return nil, fmt.Errorf("MagicLogin|%v", err)
+	}
+	if user == nil {
+		return nil, fmt.Errorf("MagicLogin|%w", ErrInvalidToken)
+	}
+	if user.Status == constant.UserStatusDisabled {
+		return nil, fmt.Errorf("MagicLogin|%w", ErrUserDisabled)
+	}
+	// 和密码登录一样拒绝临时密码已经过期的用户：登录后只能修改密码，而过期的临时密码通不过修改密码的校验
+	if tempPasswordExpired(user) {
+		log.Errorf("%s|MagicLogin|temporary password expired, user_name=%s", uuid, user.Name)
+		return nil, fmt.Errorf("MagicLogin|%w", ErrPasswordExpired)
+	}
+
+	result, err := finishFirstFactor(ctx, user, remember)
+	if err != nil {
+		return nil, fmt.Errorf("MagicLogin|%v", err)
+	}
+	log.Infof("%s|MagicLogin|first factor passed, user_name=%s|mfa_required=%v", uuid, user.Name, result.MFARequired)
+	return result, nil
+}
+
+// 验证码的哈希带上用户名，不同用户碰巧收到相同的验证码时哈希也不同
+func magicCodeHash(userName, code string) string {
+	return utils.Sha256String(userName + ":" + code)
+}
+
+// 第一步验证（密码、登录链接或者验证码）通过之后的共同流程：
+// 开启了两步验证的返回 mfa_token 等待输入验证码，否则清零失败次数并创建会话
+func finishFirstFactor(ctx context.Context, user *model.User, remember bool) (*LoginResult, error) {
+	uuid := ctx.Value(constant.ReqUuid)
+
+	// 开启了两步验证，先不创建会话，等用户输入验证码
+	// 这时候还不能清零失败次数，否则拿到密码的人可以反复登录来暴力猜验证码
+	mfa, err := dao.GetUserTOTP(user.ID)
+	if err != nil {
+		log.Errorf("%s|finishFirstFactor|GetUserTOTP err:%v", uuid, err)
+		return nil, err
+	}
+	if mfa != nil && mfa.Enabled {
+		token, err := beginMFALogin(uuid, user, remember)
+		if err != nil {
+			log.Errorf("%s|finishFirstFactor|beginMFALogin failed, user_name=%s|err=%v", uuid, user.Name, err)
+			return nil, err
+		}
+		return &LoginResult{MFARequired: true, MFAToken: token}, nil
+	}
+
+	recordLoginSuccess(uuid, user.Name)
+	return completeLogin(ctx, user, remember)
+}
@@ -0,0 +1,329 @@
+package service
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+	"gouse/config"
+	"gouse/internal/cache"
+	"gouse/internal/dao"
+	"gouse/internal/model"
+	"gouse/pkg/constant"
+	"gouse/pkg/totp"
+	"gouse/utils"
+	"strings"
+	"time"
+)
+
+// 两步验证配置的默认值
+const (
+	defaultMFAIssuer         = "gouse"
+	defaultMFAPendingExpired = 300 // 秒
+	defaultMFAMaxAttempts    = 5
+	defaultRecoveryCodes     = 10
+
+	mfaTokenBytes     = 32
+	recoveryCodeBytes = 10 // base32 编码后 16 个字符
+	totpSkew          = 1  // 允许前后各一个时间步（30 秒）的时钟偏差
+)
+
+// EnrollTOTP 开始绑定 TOTP，生成新的密钥
+// 此时还没有生效，用户用身份验证器 App 扫码之后调用 ConfirmTOTP 输入一次验证码才算绑定成功
+func EnrollTOTP(ctx context.Context) (*EnrollTOTPResponse, error) {
+	uuid := ctx.Value(constant.ReqUuid)
+	user, err := currentUser(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("EnrollTOTP|%v", err)
+	}
+
+	existing, err := dao.GetUserTOTP(user.ID)
+	if err != nil {
+		return nil, fmt.Errorf("EnrollTOTP|%v", err)
+	}
+	if existing != nil && existing.Enabled {
+		return nil, fmt.Errorf("EnrollTOTP|%w", ErrMFAAlreadyEnabled)
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return nil, fmt.Errorf("EnrollTOTP|generate secret err:%v", err)
+	}
+	err = dao.ResetUserTOTP(&model.UserTOTP{
+		UserID:      user.ID,
+		Secret:      secret,
+		CreateModel: model.CreateModel{Creator: user.Name},
+		ModifyModel: model.ModifyModel{Modifier: user.Name},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("EnrollTOTP|%v", err)
+	}
+
+	issuer := config.GetGlobalConf().MFA.Issuer
+	if issuer == "" {
+		issuer = defaultMFAIssuer
+	}
+	log.Infof("%s|EnrollTOTP|new secret generated, user_name=%s", uuid, user.Name)
+	return &EnrollTOTPResponse{Secret: secret, URI: totp.URI(issuer, user.Name, secret)}, nil
+}
+
+// ConfirmTOTP 输入一次验证码确认绑定，绑定成功后返回恢复码
+func ConfirmTOTP(ctx context.Context, req *ConfirmTOTPRequest) (*RecoveryCodesResponse, error) {
+	uuid := ctx.Value(constant.ReqUuid)
+	user, err := currentUser(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("ConfirmTOTP|%v", err)
+	}
+
+	existing, err := dao.GetUserTOTP(user.ID)
+	if err != nil {
+		return nil, fmt.Errorf("ConfirmTOTP|%v", err)
+	}
+	if existing == nil {
+		return nil, fmt.Errorf("ConfirmTOTP|%w", ErrMFANotEnabled)
+	}
+	if existing.Enabled {
+		return nil, fmt.Errorf("ConfirmTOTP|%w", ErrMFAAlreadyEnabled)
+	}
+
+	step, ok := totp.Validate(existing.Secret, req.Code, time.Now(), totpSkew)
+	if !ok {
+		log.Errorf("%s|ConfirmTOTP|code not match, user_name=%s", uuid, user.Name)
+		return nil, fmt.Errorf("ConfirmTOTP|%w", ErrInvalidMFACode)
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, fmt.Errorf("ConfirmTOTP|generate recovery codes err:%v", err)
+	}
+	if err := dao.EnableUserTOTP(user.ID, step, hashes); err != nil {
+		return nil, fmt.Errorf("ConfirmTOTP|%v", err)
+	}
+	log.Infof("%s|ConfirmTOTP|two-factor authentication enabled, user_name=%s", uuid, user.Name)
+	return &RecoveryCodesResponse{RecoveryCodes: codes}, nil
+}
+
+// DisableTOTP 关闭两步验证，需要当前密码和验证码（或者恢复码）
+func DisableTOTP(ctx context.Context, req *DisableTOTPRequest) error {
+	uuid := ctx.Value(constant.ReqUuid)
+	sessionUser, err := currentUser(ctx)
+	if err != nil {
+		return fmt.Errorf("DisableTOTP|%v", err)
+	}
+
+	// 会话里的用户信息不包含密码哈希，需要从数据库读
+	user, err := dao.GetUserByName(sessionUser.Name)
+	if err != nil {
+		return fmt.Errorf("DisableTOTP|%v", err)
+	}
+	if user == nil {
+		return fmt.Errorf("DisableTOTP|%w", ErrUserNotFound)
+	}
+	// 密码和验证码输错都计入登录失败次数，拿到会话的人不能在这里暴力猜
+	if err := checkLoginLock(user.Name, ""); err != nil {
+		return fmt.Errorf("DisableTOTP|%w", err)
+	}
+	match, _, err := verifyPassword(req.PassWord, user.PassWord)
+	if err != nil {
+		return fmt.Errorf("DisableTOTP|verify password err:%v", err)
+	}
+	if !match {
+		recordLoginFailure(uuid, user.Name, "")
+		return fmt.Errorf("DisableTOTP|%w", ErrWrongPassword)
+	}
+
+	ok, err := verifySecondFactor(uuid, user, req.Code, req.RecoveryCode, "")
+	if err != nil {
+		return fmt.Errorf("DisableTOTP|%w", err)
+	}
+	if !ok {
+		return fmt.Errorf("DisableTOTP|%w", ErrInvalidMFACode)
+	}
+
+	if err := dao.DeleteUserTOTP(user.ID); err != nil {
+		return fmt.Errorf("DisableTOTP|%v", err)
+	}
+	log.Infof("%s|DisableTOTP|two-factor authentication disabled, user_name=%s", uuid, user.Name)
+	return nil
+}
+
+// RegenerateRecoveryCodes 重新生成恢复码，之前的恢复码全部作废，需要输入一次验证码
+func RegenerateRecoveryCodes(ctx context.Context, req *ConfirmTOTPRequest) (*RecoveryCodesResponse, error) {
+	uuid := ctx.Value(constant.ReqUuid)
+	user, err := currentUser(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("RegenerateRecoveryCodes|%v", err)
+	}
+
+	ok, err := verifySecondFactor(uuid, user, req.Code, "", "")
+	if err != nil {
+		return nil, fmt.Errorf("RegenerateRecoveryCodes|%w", err)
+	}
+	if !ok {
+		return nil, fmt.Errorf("RegenerateRecoveryCodes|%w", ErrInvalidMFACode)
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, fmt.Errorf("RegenerateRecoveryCodes|generate recovery codes err:%v", err)
+	}
+	if err := dao.ReplaceRecoveryCodes(user.ID, hashes); err != nil {
+		return nil, fmt.Errorf("RegenerateRecoveryCodes|%v", err)
+	}
+	log.Infof("%s|RegenerateRecoveryCodes|recovery codes regenerated, user_name=%s", uuid, user.Name)
+	return &RecoveryCodesResponse{RecoveryCodes: codes}, nil
+}
+
+// LoginMFA 两步验证登录的第二步：校验验证码或者恢复码，通过后创建会话
+func LoginMFA(ctx context.Context, req *LoginMFARequest) (*LoginResult, error) {
+	uuid := ctx.Value(constant.ReqUuid)
+	ip, _ := ctx.Value(constant.ClientIPKey).(string)
+	if req.MFAToken == "" || (req.Code == "" && req.RecoveryCode == "") {
+		return nil, fmt.Errorf("LoginMFA|request params invalid")
+	}
+
+	tokenHash := utils.Sha256String(req.MFAToken)
+	userName, remember, err := cache.GetMFAPending(tokenHash)
+	if errors.Is(err, cache.ErrTokenNotFound) {
+		return nil, fmt.Errorf("LoginMFA|%w", ErrInvalidToken)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("LoginMFA|%v", err)
+	}
+
+	// 验证码同样受登录保护限制，否则拿到密码的人可以反复登录来暴力猜验证码
+	if err := checkLoginLock(userName, ip); err != nil {
+		log.Warnf("%s|LoginMFA|locked, user_name=%s|ip=%s|err=%v", uuid, userName, ip, err)
+		return nil, fmt.Errorf("LoginMFA|%w", err)
+	}
+
+	user, err := dao.GetUserByName(userName)
+	if err != nil {
+		return nil, fmt.Errorf("LoginMFA|%v", err)
+	}
+	if user == nil {
+		return nil, fmt.Errorf("LoginMFA|%w", ErrInvalidToken)
+	}
+	if user.Status == constant.UserStatusDisabled {
+		return nil, fmt.Errorf("LoginMFA|%w", ErrUserDisabled)
+	}
+
+	ok, err := verifySecondFactor(uuid, user, req.Code, req.RecoveryCode, ip)
+	if err != nil {
+		return nil, fmt.Errorf("LoginMFA|%w", err)
+	}
+	if !ok {
+		log.Errorf("%s|LoginMFA|code not match, user_name=%s", uuid, user.Name)
+		rejectMFAAttempt(uuid, tokenHash)
+		return nil, fmt.Errorf("LoginMFA|%w", ErrInvalidMFACode)
+	}
+
+	// 同一个 token 只能换一次会话，并发提交时只有删除成功的请求能继续
+	deleted, err := cache.DelMFAPending(tokenHash)
+	if err != nil {
+		return nil, fmt.Errorf("LoginMFA|%v", err)
+	}
+	if !deleted {
+		return nil, fmt.Errorf("LoginMFA|%w", ErrInvalidToken)
+	}
+
+	recordLoginSuccess(uuid, user.Name)
+	result, err := completeLogin(ctx, user, remember)
+	if err != nil {
+		return nil, fmt.Errorf("LoginMFA|%v", err)
+	}
+	log.Infof("%s|LoginMFA|Login successfully, user_name=%s", uuid, user.Name)
+	return result, nil
+}
+
+// 密码校验通过，但用户开启了两步验证：生成一次性 token，等待用户输入验证码
+func beginMFALogin(uuid interface{}, user *model.User, remember bool) (string, error) {
+	token, err := utils.RandomToken(mfaTokenBytes)
+	if err != nil {
+		return "", fmt.Errorf("generate mfa token err:%v", err)
+	}
+	expired := time.Duration(orDefault(config.GetGlobalConf().MFA.PendingExpired, defaultMFAPendingExpired)) * time.Second
+	if err := cache.SetMFAPending(utils.Sha256String(token), user.Name, remember, expired); err != nil {
+		return "", err
+	}
+	log.Infof("%s|beginMFALogin|waiting for verification code, user_name=%s", uuid, user.Name)
+	return token, nil
+}
+
+// 验证码输错一次，输错次数达到上限时作废这次登录，需要重新输入密码
+func rejectMFAAttempt(uuid interface{}, tokenHash string) {
+	attempts, err := cache.IncrMFAPendingAttempts(tokenHash)
+	if err != nil {
+		if !errors.Is(err, cache.ErrTokenNotFound) {
+			log.Errorf("%s|rejectMFAAttempt|IncrMFAPendingAttempts failed, err=%v", uuid, err)
+		}
+		return
+	}
+	if attempts < int64(orDefault(config.GetGlobalConf().MFA.MaxAttempts, defaultMFAMaxAttempts)) {
+		return
+	}
+	if _, err := cache.DelMFAPending(tokenHash); err != nil {
+		log.Errorf("%s|rejectMFAAttempt|DelMFAPending failed, err=%v", uuid, err)
+	}
+}
+
+// 校验验证码或者恢复码，两个都填时以验证码为准
+// 验证码通过后记录它的时间步，同一个验证码不能用第二次；恢复码用过一次就失效
+// 和登录共用失败次数：用户被锁定时直接返回锁定错误，校验不通过时记一次失败，ip 为空时只统计用户
+func verifySecondFactor(uuid interface{}, user *model.User, code, recoveryCode, ip string) (bool, error) {
+	if err := checkLoginLock(user.Name, ip); err != nil {
+		log.Warnf("%s|verifySecondFactor|locked, user_name=%s|ip=%s|err=%v", uuid, user.Name, ip, err)
+		return false, err
+	}
+	ok, err := checkSecondFactor(user, code, recoveryCode)
+	if err != nil {
+		return false, err
+	}
+	if !ok {
+		recordLoginFailure(uuid, user.Name, ip)
+	}
+	return ok, nil
+}
+
+func checkSecondFactor(user *model.User, code, recoveryCode string) (bool, error) {
+	t, err := dao.GetUserTOTP(user.ID)
+	if err != nil {
+		return false, err
+	}
+	if t == nil || !t.Enabled {
+		return false, ErrMFANotEnabled
+	}
+
+	if code != "" {
+		step, ok := totp.Validate(t.Secret, code, time.Now(), totpSkew)
+		return ok && dao.UseTOTPStep(user.ID, step), nil
+	}
+	if recoveryCode != "" {
+		return dao.UseRecoveryCode(user.ID, utils.Sha256String(normalizeRecoveryCode(recoveryCode))), nil
+	}
+	return false, nil
+}
+
+// 生成一批恢复码，返回明文（只给用户看一次）和入库用的哈希
+// 恢复码形如 abcd-efgh-ijkl-mnop，输入时不区分大小写，分隔符可以省略
+func newRecoveryCodes() ([]string, []string, error) {
+	n := orDefault(config.GetGlobalConf().MFA.RecoveryCodes, defaultRecoveryCodes)
+	codes := make([]string, 0, n)
+	hashes := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		b := make([]byte, recoveryCodeBytes)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		raw := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b))
+		codes = append(codes, raw[0:4]+"-"+raw[4:8]+"-"+raw[8:12]+"-"+raw[12:16])
+		hashes = append(hashes, utils.Sha256String(raw))
+	}
+	return codes, hashes, nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
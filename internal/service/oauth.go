@@ -0,0 +1,570 @@
+package service
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+	"gouse/config"
+	"gouse/internal/cache"
+	"gouse/internal/dao"
+	"gouse/internal/model"
+	"gouse/pkg/constant"
+	"gouse/utils"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// OAuth 2.0 支持的授权方式
+const (
+	GrantAuthorizationCode = "authorization_code"
+	GrantClientCredentials = "client_credentials"
+)
+
+// OAuth 配置的默认值
+const (
+	defaultOAuthCodeExpired  = 60   // 秒
+	defaultOAuthTokenExpired = 3600 // 秒
+	defaultOAuthLoginURL     = "/static/login.html"
+	defaultOAuthConsentURL   = "/static/consent.html"
+
+	oauthClientIDBytes     = 16
+	oauthClientSecretBytes = 32
+	oauthCodeBytes         = 32
+	oauthTokenBytes        = 32
+)
+
+// OAuthError RFC 6749 定义的错误，api 层按规范的格式返回给接入应用
+type OAuthError struct {
+	Code        string // invalid_request、invalid_client 等
+	Description string
+	RedirectURI string // 不为空时，错误要通过重定向带回给接入应用，否则直接展示给用户
+	State       string
+}
+
+func (e *OAuthError) Error() string {
+	return e.Code + ": " + e.Description
+}
+
+func oauthError(code, description string) *OAuthError {
+	return &OAuthError{Code: code, Description: description}
+}
+
+// CreateOAuthClient 管理员注册接入应用，密钥只在返回结果里出现这一次
+func CreateOAuthClient(ctx context.Context, req *CreateOAuthClientRequest) (*CreateOAuthClientResponse, error) {
+	uuid := ctx.Value(constant.ReqUuid)
+	operator, err := currentUser(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("CreateOAuthClient|%v", err)
+	}
+	if err := checkOAuthClientRequest(req); err != nil {
+		return nil, fmt.Errorf("CreateOAuthClient|%v", err)
+	}
+
+	clientID, err := utils.RandomToken(oauthClientIDBytes)
+	if err != nil {
+		return nil, fmt.Errorf("CreateOAuthClient|generate client id err:%v", err)
+	}
+	client := &model.OAuthClient{
+		ClientID:     clientID,
+		Name:         req.Name,
+		RedirectURIs: strings.Join(req.RedirectURIs, " "),
+		GrantTypes:   strings.Join(req.GrantTypes, " "),
+		Scopes:       strings.Join(req.Scopes, " "),
+		Public:       req.Public,
+		CreateModel:  model.CreateModel{Creator: operator.Name},
+		ModifyModel:  model.ModifyModel{Modifier: operator.Name},
+	}
+
+	// 公开应用没有密钥；其他应用的密钥只保存哈希
+	secret := ""
+	if !req.Public {
+		secret, err = utils.RandomToken(oauthClientSecretBytes)
+		if err != nil {
+			return nil, fmt.Errorf("CreateOAuthClient|generate client secret err:%v", err)
+		}
+		client.SecretHash = utils.Sha256String(secret)
+	}
+
+	if err := dao.CreateOAuthClient(client); err != nil {
+		return nil, fmt.Errorf("CreateOAuthClient|%v", err)
+	}
+	log.Infof("%s|CreateOAuthClient|%s registered client %s(%s)", uuid, operator.Name, client.Name, client.ClientID)
+	return &CreateOAuthClientResponse{OAuthClientInfo: *toOAuthClientInfo(client), ClientSecret: secret}, nil
+}
+
+// ListOAuthClients 获取全部接入应用
+func ListOAuthClients(ctx context.Context) ([]*OAuthClientInfo, error) {
+	clients, err := dao.ListOAuthClients()
+	if err != nil {
+		return nil, fmt.Errorf("ListOAuthClients|%v", err)
+	}
+	infos := make([]*OAuthClientInfo, 0, len(clients))
+	for _, client := range clients {
+		infos = append(infos, toOAuthClientInfo(client))
+	}
+	return infos, nil
+}
+
+// DeleteOAuthClient 删除接入应用，已经签发给它的 access token 一并吊销，资源服务器校验时返回 active=false
+func DeleteOAuthClient(ctx context.Context, clientID string) error {
+	uuid := ctx.Value(constant.ReqUuid)
+	operator, err := currentUser(ctx)
+	if err != nil {
+		return fmt.Errorf("DeleteOAuthClient|%v", err)
+	}
+	affected, err := dao.DeleteOAuthClient(clientID)
+	if err != nil {
+		return fmt.Errorf("DeleteOAuthClient|%v", err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("DeleteOAuthClient|client %s not found", clientID)
+	}
+	revoked, err := cache.DelOAuthClientTokens(clientID)
+	if err != nil {
+		return fmt.Errorf("DeleteOAuthClient|revoke tokens err:%v", err)
+	}
+	log.Infof("%s|DeleteOAuthClient|%s deleted client %s, %d tokens revoked", uuid, operator.Name, clientID, revoked)
+	return nil
+}
+
+// 检查注册参数：授权方式只支持授权码和客户端凭证，公开应用不能使用客户端凭证，
+// 重定向地址必须是不带 fragment 的绝对地址
+func checkOAuthClientRequest(req *CreateOAuthClientRequest) error {
+	if req.Name == "" || len(req.GrantTypes) == 0 {
+		return fmt.Errorf("request params invalid")
+	}
+	for _, grant := range req.GrantTypes {
+		switch grant {
+		case GrantAuthorizationCode:
+			if len(req.RedirectURIs) == 0 {
+				return fmt.Errorf("redirect_uris is required for %s", grant)
+			}
+		case GrantClientCredentials:
+			if req.Public {
+				return fmt.Errorf("public client can not use %s", grant)
+			}
+		default:
+			return fmt.Errorf("unsupported grant type %q", grant)
+		}
+	}
+	for _, uri := range req.RedirectURIs {
+		u, err := url.Parse(uri)
+		if err != nil || !u.IsAbs() || u.Host == "" || u.Fragment != "" || strings.ContainsAny(uri, " ") {
+			return fmt.Errorf("invalid redirect uri %q", uri)
+		}
+	}
+	for _, scope := range req.Scopes {
+		if !validScopeToken(scope) {
+			return fmt.Errorf("invalid scope %q", scope)
+		}
+	}
+	return nil
+}
+
+// RFC 6749 3.3：scope-token = 1*( %x21 / %x23-5B / %x5D-7E )
+func validScopeToken(scope string) bool {
+	if scope == "" {
+		return false
+	}
+	for i := 0; i < len(scope); i++ {
+		c := scope[i]
+		if c < 0x21 || c > 0x7e || c == '"' || c == '\\' {
+			return false
+		}
+	}
+	return true
+}
+
+// CheckAuthorizeRequest 校验授权请求
+// 接入应用和重定向地址确认无误之前发现的错误不能重定向（防止被当成开放重定向利用），之后的错误带上 RedirectURI
+func CheckAuthorizeRequest(req *AuthorizeRequest) (*model.OAuthClient, []string, error) {
+	if req.ClientID == "" {
+		return nil, nil, oauthError("invalid_request", "client_id is required")
+	}
+	client, err := dao.GetOAuthClient(req.ClientID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("CheckAuthorizeRequest|%v", err)
+	}
+	if client == nil {
+		return nil, nil, oauthError("invalid_client", "unknown client")
+	}
+	// redirect_uri 必须带上，并且和注册的地址完全一致；换取 token 时还要再带一次
+	if !utils.Contains(strings.Fields(client.RedirectURIs), req.RedirectURI) {
+		return nil, nil, oauthError("invalid_request", "redirect_uri is not registered")
+	}
+
+	// 从这里开始，错误通过重定向告诉接入应用
+	redirectErr := func(code, description string) error {
+		return &OAuthError{Code: code, Description: description, RedirectURI: req.RedirectURI, State: req.State}
+	}
+	if req.ResponseType != "code" {
+		return nil, nil, redirectErr("unsupported_response_type", "only response_type=code is supported")
+	}
+	if !utils.Contains(strings.Fields(client.GrantTypes), GrantAuthorizationCode) {
+		return nil, nil, redirectErr("unauthorized_client", "client is not allowed to use authorization code")
+	}
+	// 所有应用都必须使用 PKCE，并且只接受 S256
+	if req.CodeChallenge == "" || req.CodeChallengeMethod != "S256" {
+		return nil, nil, redirectErr("invalid_request", "code_challenge with code_challenge_method=S256 is required")
+	}
+	scopes, ok := grantedScopes(client, req.Scope)
+	if !ok {
+		return nil, nil, redirectErr("invalid_scope", "requested scope is not allowed")
+	}
+	return client, scopes, nil
+}
+
+// GetConsentInfo 授权页上展示的接入应用名称和申请的权限范围
+func GetConsentInfo(ctx context.Context, req *AuthorizeRequest) (*ConsentInfo, error) {
+	client, scopes, err := CheckAuthorizeRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	return &ConsentInfo{ClientID: client.ClientID, ClientName: client.Name, Scopes: scopes}, nil
+}
+
+// Authorize 用户在授权页上做出选择，返回浏览器要跳转的地址
+// 同意时地址里带着授权码，拒绝时带着 access_denied
+func Authorize(ctx context.Context, req *ConsentRequest) (*ConsentResult, error) {
+	uuid := ctx.Value(constant.ReqUuid)
+	user, err := currentUser(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Authorize|%v", err)
+	}
+
+	client, scopes, err := CheckAuthorizeRequest(&req.AuthorizeRequest)
+	var oauthErr *OAuthError
+	if errors.As(err, &oauthErr) && oauthErr.RedirectURI != "" {
+		return &ConsentResult{RedirectTo: oauthErr.RedirectURL()}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if !req.Approve {
+		log.Infof("%s|Authorize|%s denied client %s", uuid, user.Name, client.ClientID)
+		denied := &OAuthError{
+			Code:        "access_denied",
+			Description: "the user denied the request",
+			RedirectURI: req.RedirectURI,
+			State:       req.State,
+		}
+		return &ConsentResult{RedirectTo: denied.RedirectURL()}, nil
+	}
+
+	code, err := utils.RandomToken(oauthCodeBytes)
+	if err != nil {
+		return nil, fmt.Errorf("Authorize|generate code err:%v", err)
+	}
+	conf := config.GetGlobalConf().OAuth
+	expired := time.Duration(orDefault(conf.CodeExpired, defaultOAuthCodeExpired)) * time.Second
+	now := time.Now()
+	grant := &cache.OAuthGrant{
+		ClientID:      client.ClientID,
+		UserID:        user.ID,
+		UserName:      user.Name,
+		Scope:         strings.Join(scopes, " "),
+		RedirectURI:   req.RedirectURI,
+		CodeChallenge: req.CodeChallenge,
+		Nonce:         req.Nonce,
+		IssuedAt:      now.Unix(),
+		ExpiresAt:     now.Add(expired).Unix(),
+	}
+	if err := cache.SetOAuthCode(utils.Sha256String(code), grant, expired); err != nil {
+		return nil, fmt.Errorf("Authorize|%v", err)
+	}
+
+	log.Infof("%s|Authorize|%s authorized client %s, scope=%s", uuid, user.Name, client.ClientID, grant.Scope)
+	params := url.Values{"code": {code}}
+	if req.State != "" {
+		params.Set("state", req.State)
+	}
+	return &ConsentResult{RedirectTo: appendQuery(req.RedirectURI, params)}, nil
+}
+
+// IssueOAuthToken /oauth/token，用授权码或者客户端凭证换取 access token
+func IssueOAuthToken(ctx context.Context, req *OAuthTokenRequest) (*OAuthTokenResponse, error) {
+	uuid := ctx.Value(constant.ReqUuid)
+	client, err := authenticateOAuthClient(req.ClientID, req.ClientSecret)
+	if err != nil {
+		return nil, err
+	}
+	if !utils.Contains(strings.Fields(client.GrantTypes), req.GrantType) {
+		if req.GrantType != GrantAuthorizationCode && req.GrantType != GrantClientCredentials {
+			return nil, oauthError("unsupported_grant_type", "unsupported grant_type")
+		}
+		return nil, oauthError("unauthorized_client", "client is not allowed to use "+req.GrantType)
+	}
+
+	var grant *cache.OAuthGrant
+	switch req.GrantType {
+	case GrantAuthorizationCode:
+		grant, err = exchangeAuthorizationCode(client, req)
+	case GrantClientCredentials:
+		grant, err = clientCredentialsGrant(client, req)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// 授权码方式申请了 openid 的，同时签发 ID token
+	idToken := ""
+	if grant.UserName != "" && utils.Contains(strings.Fields(grant.Scope), ScopeOpenID) {
+		user, err := getUserInfo(grant.UserName)
+		if err != nil {
+			return nil, fmt.Errorf("IssueOAuthToken|%v", err)
+		}
+		if idToken, err = issueIDToken(grant, user); err != nil {
+			return nil, fmt.Errorf("IssueOAuthToken|issue id token err:%v", err)
+		}
+	}
+
+	token, err := utils.RandomToken(oauthTokenBytes)
+	if err != nil {
+		return nil, fmt.Errorf("IssueOAuthToken|generate token err:%v", err)
+	}
+	expired := time.Duration(orDefault(config.GetGlobalConf().OAuth.AccessTokenExpired, defaultOAuthTokenExpired)) * time.Second
+	now := time.Now()
+	grant.RedirectURI, grant.CodeChallenge, grant.Nonce = "", "", ""
+	grant.IssuedAt = now.Unix()
+	grant.ExpiresAt = now.Add(expired).Unix()
+	if err := cache.SetOAuthToken(utils.Sha256String(token), grant, expired); err != nil {
+		return nil, fmt.Errorf("IssueOAuthToken|%v", err)
+	}
+
+	log.Infof("%s|IssueOAuthToken|token issued, client_id=%s|grant_type=%s|user_name=%s", uuid, client.ClientID, req.GrantType, grant.UserName)
+	return &OAuthTokenResponse{
+		AccessToken: token,
+		TokenType:   "Bearer",
+		ExpiresIn:   int(expired.Seconds()),
+		Scope:       grant.Scope,
+		IDToken:     idToken,
+	}, nil
+}
+
+// 授权码换 token：授权码只能用一次，必须是发给这个应用的，重定向地址和 PKCE 都要对得上
+func exchangeAuthorizationCode(client *model.OAuthClient, req *OAuthTokenRequest) (*cache.OAuthGrant, error) {
+	if req.Code == "" || req.CodeVerifier == "" {
+		return nil, oauthError("invalid_request", "code and code_verifier are required")
+	}
+	grant, err := cache.TakeOAuthCode(utils.Sha256String(req.Code))
+	if errors.Is(err, cache.ErrTokenNotFound) {
+		return nil, oauthError("invalid_grant", "authorization code is invalid or expired")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("exchangeAuthorizationCode|%v", err)
+	}
+	if grant.ClientID != client.ClientID {
+		return nil, oauthError("invalid_grant", "authorization code was issued to another client")
+	}
+	if req.RedirectURI != grant.RedirectURI {
+		return nil, oauthError("invalid_grant", "redirect_uri does not match")
+	}
+	if !verifyCodeChallenge(req.CodeVerifier, grant.CodeChallenge) {
+		return nil, oauthError("invalid_grant", "code_verifier does not match")
+	}
+
+	// 授权之后用户被禁用或者删除了，不再发放 token
+	user, err := dao.GetUserByID(grant.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("exchangeAuthorizationCode|%v", err)
+	}
+	if user == nil || user.Status == constant.UserStatusDisabled {
+		return nil, oauthError("invalid_grant", "user is not available")
+	}
+	return grant, nil
+}
+
+// 客户端凭证：应用以自己的身份申请 token，没有用户
+func clientCredentialsGrant(client *model.OAuthClient, req *OAuthTokenRequest) (*cache.OAuthGrant, error) {
+	if client.Public {
+		return nil, oauthError("unauthorized_client", "public client can not use client_credentials")
+	}
+	scopes, ok := grantedScopes(client, req.Scope)
+	if !ok {
+		return nil, oauthError("invalid_scope", "requested scope is not allowed")
+	}
+	return &cache.OAuthGrant{ClientID: client.ClientID, Scope: strings.Join(scopes, " ")}, nil
+}
+
+// RevokeOAuthToken /oauth/revoke（RFC 7009），只能吊销发给自己的 token，token 无效时同样返回成功
+func RevokeOAuthToken(ctx context.Context, req *OAuthTokenActionRequest) error {
+	uuid := ctx.Value(constant.ReqUuid)
+	client, err := authenticateOAuthClient(req.ClientID, req.ClientSecret)
+	if err != nil {
+		return err
+	}
+	if req.Token == "" {
+		return oauthError("invalid_request", "token is required")
+	}
+
+	tokenHash := utils.Sha256String(req.Token)
+	grant, err := cache.GetOAuthToken(tokenHash)
+	if errors.Is(err, cache.ErrTokenNotFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("RevokeOAuthToken|%v", err)
+	}
+	if grant.ClientID != client.ClientID {
+		log.Warnf("%s|RevokeOAuthToken|client %s tried to revoke token of client %s", uuid, client.ClientID, grant.ClientID)
+		return nil
+	}
+	if err := cache.DelOAuthToken(client.ClientID, tokenHash); err != nil {
+		return fmt.Errorf("RevokeOAuthToken|%v", err)
+	}
+	log.Infof("%s|RevokeOAuthToken|token revoked, client_id=%s", uuid, client.ClientID)
+	return nil
+}
+
+// IntrospectOAuthToken /oauth/introspect（RFC 7662），供资源服务器校验 token
+// 只有有密钥的应用可以调用；token 对应的用户已经被禁用或者删除时也返回 active=false
+func IntrospectOAuthToken(ctx context.Context, req *OAuthTokenActionRequest) (*IntrospectResponse, error) {
+	client, err := authenticateOAuthClient(req.ClientID, req.ClientSecret)
+	if err != nil {
+		return nil, err
+	}
+	if client.Public {
+		return nil, oauthError("invalid_client", "public client can not introspect tokens")
+	}
+
+	grant, err := cache.GetOAuthToken(utils.Sha256String(req.Token))
+	if errors.Is(err, cache.ErrTokenNotFound) || req.Token == "" {
+		return &IntrospectResponse{Active: false}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("IntrospectOAuthToken|%v", err)
+	}
+
+	rsp := &IntrospectResponse{
+		Active:    true,
+		Scope:     grant.Scope,
+		ClientID:  grant.ClientID,
+		TokenType: "Bearer",
+		ExpiresAt: grant.ExpiresAt,
+		IssuedAt:  grant.IssuedAt,
+	}
+	if grant.UserName != "" {
+		user, err := getUserInfo(grant.UserName)
+		if err != nil || user.Status == constant.UserStatusDisabled {
+			return &IntrospectResponse{Active: false}, nil
+		}
+		rsp.Username = user.Name
+		rsp.Subject = strconv.Itoa(user.ID)
+	}
+	return rsp, nil
+}
+
+// 认证接入应用：有密钥的应用必须提供正确的密钥，公开应用不能提供密钥
+func authenticateOAuthClient(clientID, secret string) (*model.OAuthClient, error) {
+	if clientID == "" {
+		return nil, oauthError("invalid_client", "client authentication failed")
+	}
+	client, err := dao.GetOAuthClient(clientID)
+	if err != nil {
+		return nil, fmt.Errorf("authenticateOAuthClient|%v", err)
+	}
+	if client == nil {
+		return nil, oauthError("invalid_client", "client authentication failed")
+	}
+	if client.Public {
+		if secret != "" {
+			return nil, oauthError("invalid_client", "client authentication failed")
+		}
+		return client, nil
+	}
+	if subtle.ConstantTimeCompare([]byte(utils.Sha256String(secret)), []byte(client.SecretHash)) != 1 {
+		return nil, oauthError("invalid_client", "client authentication failed")
+	}
+	return client, nil
+}
+
+// 计算最终授予的权限范围：没有申请时授予应用允许的全部范围，申请了不允许的范围时返回 false
+func grantedScopes(client *model.OAuthClient, requested string) ([]string, bool) {
+	allowed := strings.Fields(client.Scopes)
+	if strings.TrimSpace(requested) == "" {
+		return allowed, true
+	}
+	scopes := make([]string, 0)
+	for _, scope := range strings.Fields(requested) {
+		if !utils.Contains(allowed, scope) {
+			return nil, false
+		}
+		if !utils.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes, true
+}
+
+// PKCE S256：BASE64URL(SHA256(code_verifier)) == code_challenge
+func verifyCodeChallenge(verifier, challenge string) bool {
+	// RFC 7636 4.1：code_verifier 长度在 43 到 128 之间
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	expected := base64.RawURLEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(challenge)) == 1
+}
+
+// RedirectURL 把错误拼到接入应用的重定向地址上
+func (e *OAuthError) RedirectURL() string {
+	params := url.Values{"error": {e.Code}, "error_description": {e.Description}}
+	if e.State != "" {
+		params.Set("state", e.State)
+	}
+	return appendQuery(e.RedirectURI, params)
+}
+
+// 在地址原有的查询参数后面追加参数
+func appendQuery(rawURL string, params url.Values) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	q := u.Query()
+	for k, vs := range params {
+		for _, v := range vs {
+			q.Add(k, v)
+		}
+	}
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// OAuthLoginURL 没有登录时跳转的登录页，登录之后回到 next
+func OAuthLoginURL(next string) string {
+	loginURL := config.GetGlobalConf().OAuth.LoginURL
+	if loginURL == "" {
+		loginURL = defaultOAuthLoginURL
+	}
+	return appendQuery(loginURL, url.Values{"next": {next}})
+}
+
+// OAuthConsentURL 授权确认页，授权请求的参数原样带过去
+func OAuthConsentURL(rawQuery string) string {
+	consentURL := config.GetGlobalConf().OAuth.ConsentURL
+	if consentURL == "" {
+		consentURL = defaultOAuthConsentURL
+	}
+	return consentURL + "?" + rawQuery
+}
+
+func toOAuthClientInfo(client *model.OAuthClient) *OAuthClientInfo {
+	return &OAuthClientInfo{
+		ClientID:     client.ClientID,
+		Name:         client.Name,
+		RedirectURIs: strings.Fields(client.RedirectURIs),
+		GrantTypes:   strings.Fields(client.GrantTypes),
+		Scopes:       strings.Fields(client.Scopes),
+		Public:       client.Public,
+		CreateTime:   client.CreateTime,
+	}
+}
@@ -0,0 +1,387 @@
+package service
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+	"gouse/config"
+	"gouse/internal/cache"
+	"gouse/internal/dao"
+	"gouse/internal/model"
+	"gouse/pkg/constant"
+	"gouse/pkg/jwtauth"
+	"gouse/utils"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OpenID Connect 使用的 scope
+const (
+	ScopeOpenID  = "openid"
+	ScopeProfile = "profile"
+)
+
+// OIDC 配置的默认值
+const (
+	defaultOIDCIssuer       = "http://localhost:8080"
+	defaultIDTokenExpired   = 3600           // 秒
+	defaultOIDCKeyRotation  = 90 * 24 * 3600 // 秒
+	defaultOIDCKeyRetention = 2 * 24 * 3600  // 秒
+
+	oidcKeyBits  = 2048
+	oidcKidBytes = 8
+	// 内存里的密钥每隔这么久从数据库重新加载一次，其他实例轮换出来的新密钥最迟这么久之后生效
+	oidcKeyReload = time.Minute
+	// 轮换密钥的锁的有效期，生成 RSA 密钥和写库都在这个时间之内完成
+	oidcRotationLockTTL = 30 * time.Second
+	// 数据库里还没有密钥、其他实例正在生成时，每隔 oidcRotationWait 重新查一次，最多等 oidcRotationWaitTimes 次
+	oidcRotationWait      = 500 * time.Millisecond
+	oidcRotationWaitTimes = 20
+)
+
+// ID token 和 /userinfo 里按 profile scope 返回的用户信息
+// 用户名同时作为 name 和 preferred_username 返回，age 不是标准声明，按原样返回
+type profileClaims struct {
+	Name              string `json:"name,omitempty"`
+	PreferredUsername string `json:"preferred_username,omitempty"`
+	NickName          string `json:"nickname,omitempty"`
+	Gender            string `json:"gender,omitempty"`
+	Age               int    `json:"age,omitempty"`
+}
+
+// ID token 的声明
+type idTokenClaims struct {
+	Nonce string `json:"nonce,omitempty"`
+	profileClaims
+	jwt.RegisteredClaims
+}
+
+// 一把可以用来签名的密钥
+type oidcKey struct {
+	kid       string
+	key       *rsa.PrivateKey
+	createdAt time.Time
+}
+
+// 内存里缓存的密钥，避免每次签名和查询 JWKS 都读数据库
+type oidcKeyring struct {
+	mu       sync.Mutex
+	loadedAt time.Time
+	version  int64 // 加载时的密钥版本号，和 Redis 里的不一致说明有密钥被吊销了
+	active   *oidcKey
+	keys     []*oidcKey // 全部对外公布的密钥，最新的在前面
+}
+
+var keyring = &oidcKeyring{}
+
+// InitOIDC 加载 ID token 的签名密钥，数据库里还没有密钥或者密钥到了轮换时间时生成新密钥
+func InitOIDC() error {
+	if _, _, err := keyring.get(true); err != nil {
+		return fmt.Errorf("InitOIDC|%v", err)
+	}
+	return nil
+}
+
+// 返回当前用于签名的密钥和全部对外公布的密钥
+// 有密钥被吊销时不等重新加载的周期，立即重新加载
+func (r *oidcKeyring) get(force bool) (*oidcKey, []*oidcKey, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	version, err := cache.GetOIDCKeysVersion()
+	if err != nil {
+		// 缓存出错时继续按重新加载的周期处理
+		log.Errorf("oidcKeyring|GetOIDCKeysVersion failed, err=%v", err)
+		version = r.version
+	}
+	if !force && r.active != nil && version == r.version && time.Since(r.loadedAt) < oidcKeyReload {
+		return r.active, r.keys, nil
+	}
+	if err := r.load(); err != nil {
+		return nil, nil, err
+	}
+	r.version = version
+	return r.active, r.keys, nil
+}
+
+// 从数据库加载密钥，需要轮换时先轮换
+func (r *oidcKeyring) load() error {
+	records, err := dao.ListOIDCKeys()
+	if err != nil {
+		return err
+	}
+	conf := config.GetGlobalConf().OIDC
+	rotation := time.Duration(orDefault(conf.KeyRotation, defaultOIDCKeyRotation)) * time.Second
+	if len(records) == 0 || time.Since(records[0].CreateTime) >= rotation {
+		if records, err = autoRotateOIDCKey(rotation); err != nil {
+			return err
+		}
+	}
+
+	retention := time.Duration(orDefault(conf.KeyRetention, defaultOIDCKeyRetention)) * time.Second
+	keys := make([]*oidcKey, 0, len(records))
+	for _, record := range records {
+		if record.RetiredAt != nil && time.Since(*record.RetiredAt) > retention {
+			continue
+		}
+		key, err := parseOIDCKey(record)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return fmt.Errorf("no oidc signing key available")
+	}
+
+	// 新密钥先在 JWKS 里公布一个加载周期再用来签名，
+	// 这样其他实例缓存的 JWKS 还没刷新时，接入应用拿到的 ID token 也一定能在 JWKS 里找到公钥
+	active := keys[len(keys)-1]
+	for _, key := range keys {
+		if time.Since(key.createdAt) >= oidcKeyReload {
+			active = key
+			break
+		}
+	}
+	r.active, r.keys, r.loadedAt = active, keys, time.Now()
+	return nil
+}
+
+// 到了轮换时间时自动轮换，返回轮换之后的全部密钥
+// 多个实例可能同时发现需要轮换，只有拿到锁的实例轮换，并且拿到锁之后再检查一次，别的实例刚轮换过就不再轮换；
+// 没拿到锁时已经有密钥的先继续使用，下次加载时再看，还没有任何密钥时等拿到锁的实例生成
+func autoRotateOIDCKey(rotation time.Duration) ([]*model.OIDCKey, error) {
+	for i := 0; ; i++ {
+		locked, err := withOIDCRotationLock(func() error {
+			records, err := dao.ListOIDCKeys()
+			if err != nil {
+				return err
+			}
+			if len(records) > 0 && time.Since(records[0].CreateTime) < rotation {
+				return nil
+			}
+			return rotateOIDCKey(false)
+		})
+		if err != nil {
+			return nil, err
+		}
+		records, err := dao.ListOIDCKeys()
+		if err != nil {
+			return nil, err
+		}
+		if locked || len(records) > 0 || i >= oidcRotationWaitTimes {
+			return records, nil
+		}
+		time.Sleep(oidcRotationWait)
+	}
+}
+
+// 拿到轮换密钥的锁之后执行 fn，返回 false 表示其他实例正在轮换，fn 没有执行
+func withOIDCRotationLock(fn func() error) (bool, error) {
+	owner, err := utils.RandomToken(oidcKidBytes)
+	if err != nil {
+		return false, fmt.Errorf("generate lock owner err:%v", err)
+	}
+	locked, err := cache.LockOIDCKeyRotation(owner, oidcRotationLockTTL)
+	if err != nil {
+		return false, err
+	}
+	if !locked {
+		return false, nil
+	}
+	defer func() {
+		if err := cache.UnlockOIDCKeyRotation(owner); err != nil {
+			log.Errorf("withOIDCRotationLock|UnlockOIDCKeyRotation failed, err=%v", err)
+		}
+	}()
+	return true, fn()
+}
+
+// 生成新的签名密钥并替换当前密钥，revoke 为 true 时当前密钥直接删除，不再通过 JWKS 公布
+func rotateOIDCKey(revoke bool) error {
+	key, err := rsa.GenerateKey(rand.Reader, oidcKeyBits)
+	if err != nil {
+		return fmt.Errorf("generate rsa key err:%v", err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return fmt.Errorf("marshal rsa key err:%v", err)
+	}
+	kid, err := utils.RandomToken(oidcKidBytes)
+	if err != nil {
+		return fmt.Errorf("generate kid err:%v", err)
+	}
+
+	record := &model.OIDCKey{
+		Kid:        kid,
+		PrivateKey: string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+	}
+	retention := time.Duration(orDefault(config.GetGlobalConf().OIDC.KeyRetention, defaultOIDCKeyRetention)) * time.Second
+	if err := dao.RotateOIDCKey(record, time.Now().Add(-retention), revoke); err != nil {
+		return err
+	}
+	log.Infof("rotateOIDCKey|new oidc signing key created, kid=%s|revoke=%v", kid, revoke)
+	return nil
+}
+
+func parseOIDCKey(record *model.OIDCKey) (*oidcKey, error) {
+	block, _ := pem.Decode([]byte(record.PrivateKey))
+	if block == nil {
+		return nil, fmt.Errorf("invalid oidc key %s", record.Kid)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parse oidc key %s err:%v", record.Kid, err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("oidc key %s is not a rsa key", record.Kid)
+	}
+	return &oidcKey{kid: record.Kid, key: key, createdAt: record.CreateTime}, nil
+}
+
+// RotateOIDCKey 管理员立即轮换 ID token 签名密钥
+// 旧密钥在保留期内仍然会在 JWKS 里公布；私钥泄露时传 revoke，旧密钥直接删除，
+// 所有实例立即丢掉缓存的密钥，旧密钥签发的 ID token 从此无法通过校验
+func RotateOIDCKey(ctx context.Context, req *RotateOIDCKeyRequest) error {
+	uuid := ctx.Value(constant.ReqUuid)
+	operator, err := currentUser(ctx)
+	if err != nil {
+		return fmt.Errorf("RotateOIDCKey|%v", err)
+	}
+	locked, err := withOIDCRotationLock(func() error {
+		return rotateOIDCKey(req.Revoke)
+	})
+	if err != nil {
+		return fmt.Errorf("RotateOIDCKey|%v", err)
+	}
+	if !locked {
+		return fmt.Errorf("RotateOIDCKey|%w: oidc key rotation in progress", ErrTooManyRequests)
+	}
+	if req.Revoke {
+		if err := cache.IncrOIDCKeysVersion(); err != nil {
+			// 其他实例最迟在下一个重新加载周期丢掉旧密钥
+			log.Errorf("%s|RotateOIDCKey|IncrOIDCKeysVersion failed, err=%v", uuid, err)
+		}
+	}
+	if _, _, err := keyring.get(true); err != nil {
+		return fmt.Errorf("RotateOIDCKey|%v", err)
+	}
+	log.Infof("%s|RotateOIDCKey|%s rotated oidc signing key, revoke=%v", uuid, operator.Name, req.Revoke)
+	return nil
+}
+
+// GetJWKS /.well-known/jwks.json，公布校验 ID token 的公钥
+func GetJWKS(ctx context.Context) (*jwtauth.JWKS, error) {
+	_, keys, err := keyring.get(false)
+	if err != nil {
+		return nil, fmt.Errorf("GetJWKS|%v", err)
+	}
+	jwks := &jwtauth.JWKS{Keys: make([]jwtauth.JWK, 0, len(keys))}
+	for _, key := range keys {
+		jwks.Keys = append(jwks.Keys, jwtauth.RSAPublicJWK(key.kid, &key.key.PublicKey))
+	}
+	return jwks, nil
+}
+
+// GetOIDCDiscovery /.well-known/openid-configuration，接入应用据此找到各个接口的地址
+func GetOIDCDiscovery(ctx context.Context) *OIDCDiscovery {
+	issuer := oidcIssuer()
+	return &OIDCDiscovery{
+		Issuer:                            issuer,
+		AuthorizationEndpoint:             issuer + "/oauth/authorize",
+		TokenEndpoint:                     issuer + "/oauth/token",
+		UserInfoEndpoint:                  issuer + "/userinfo",
+		JWKSURI:                           issuer + "/.well-known/jwks.json",
+		RevocationEndpoint:                issuer + "/oauth/revoke",
+		IntrospectionEndpoint:             issuer + "/oauth/introspect",
+		ResponseTypesSupported:            []string{"code"},
+		GrantTypesSupported:               []string{GrantAuthorizationCode, GrantClientCredentials},
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  []string{jwt.SigningMethodRS256.Alg()},
+		ScopesSupported:                   []string{ScopeOpenID, ScopeProfile},
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+		ClaimsSupported:                   []string{"iss", "sub", "aud", "exp", "iat", "nonce", "name", "preferred_username", "nickname", "gender", "age"},
+		CodeChallengeMethodsSupported:     []string{"S256"},
+	}
+}
+
+// 配置的签发方，去掉末尾的 /，discovery 里的地址都在它后面拼接
+func oidcIssuer() string {
+	issuer := config.GetGlobalConf().OIDC.Issuer
+	if issuer == "" {
+		issuer = defaultOIDCIssuer
+	}
+	return strings.TrimRight(issuer, "/")
+}
+
+// 授权码换 token 时，申请了 openid 的签发 ID token
+func issueIDToken(grant *cache.OAuthGrant, user *model.User) (string, error) {
+	active, _, err := keyring.get(false)
+	if err != nil {
+		return "", err
+	}
+	scopes := strings.Fields(grant.Scope)
+	expired := time.Duration(orDefault(config.GetGlobalConf().OIDC.IDTokenExpired, defaultIDTokenExpired)) * time.Second
+	now := time.Now()
+	claims := &idTokenClaims{
+		Nonce: grant.Nonce,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    oidcIssuer(),
+			Subject:   strconv.Itoa(user.ID),
+			Audience:  jwt.ClaimStrings{grant.ClientID},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(expired)),
+		},
+	}
+	if utils.Contains(scopes, ScopeProfile) {
+		claims.profileClaims = userProfileClaims(user)
+	}
+	return jwtauth.SignRS256(active.key, active.kid, claims)
+}
+
+// GetOIDCUserInfo /userinfo，用 OAuth access token 查询授权用户的信息
+// token 必须是授权码方式发放并且包含 openid；包含 profile 时才返回用户资料
+func GetOIDCUserInfo(ctx context.Context, accessToken string) (*OIDCUserInfo, error) {
+	if accessToken == "" {
+		return nil, oauthError("invalid_token", "access token is required")
+	}
+	grant, err := cache.GetOAuthToken(utils.Sha256String(accessToken))
+	if errors.Is(err, cache.ErrTokenNotFound) {
+		return nil, oauthError("invalid_token", "access token is invalid or expired")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("GetOIDCUserInfo|%v", err)
+	}
+	scopes := strings.Fields(grant.Scope)
+	if grant.UserName == "" || !utils.Contains(scopes, ScopeOpenID) {
+		return nil, oauthError("insufficient_scope", "access token does not have the openid scope")
+	}
+
+	user, err := getUserInfo(grant.UserName)
+	if err != nil || user.Status == constant.UserStatusDisabled {
+		return nil, oauthError("invalid_token", "user is not available")
+	}
+	info := &OIDCUserInfo{Subject: strconv.Itoa(user.ID)}
+	if utils.Contains(scopes, ScopeProfile) {
+		info.profileClaims = userProfileClaims(user)
+	}
+	return info, nil
+}
+
+func userProfileClaims(user *model.User) profileClaims {
+	return profileClaims{
+		Name:              user.Name,
+		PreferredUsername: user.Name,
+		NickName:          user.NickName,
+		Gender:            user.Gender,
+		Age:               user.Age,
+	}
+}
@@ -0,0 +1,139 @@
+package service
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+	"gouse/config"
+	"gouse/internal/cache"
+	"gouse/internal/dao"
+	"gouse/internal/model"
+	"gouse/pkg/constant"
+	"gouse/pkg/password"
+)
+
+// 根据配置文件组装密码哈希参数
+func passwordParams() password.Params {
+	conf := config.GetGlobalConf().PasswordConfig
+	return password.Params{
+		Algorithm:     conf.Algorithm,
+		BcryptCost:    conf.BcryptCost,
+		Argon2Memory:  conf.Argon2Memory,
+		Argon2Time:    conf.Argon2Time,
+		Argon2Threads: conf.Argon2Threads,
+	}
+}
+
+// 计算密码哈希，入库的只能是这个函数的返回值
+func hashPassword(plain string) (string, error) {
+	return password.Hash(plain, passwordParams())
+}
+
+// 校验密码，返回是否匹配以及是否需要重新计算哈希
+func verifyPassword(plain, encoded string) (bool, bool, error) {
+	return password.Verify(plain, encoded, passwordParams())
+}
+
+// 登录校验通过后，把明文或者参数过弱的密码升级成当前配置的哈希
+// 升级失败不影响本次登录，下一次登录时会再试
+func upgradePasswordHash(uuid interface{}, user *model.User, plain string) {
+	encoded, err := hashPassword(plain)
+	if err != nil {
+		log.Errorf("%s|upgradePasswordHash|hash failed, user_name=%s|err=%v", uuid, user.Name, err)
+		return
+	}
+
+	if affectedRows := dao.UpdateUserInfo(user.Name, &model.User{PassWord: encoded}); affectedRows != 1 {
+		log.Errorf("%s|upgradePasswordHash|update failed, user_name=%s|affected=%d", uuid, user.Name, affectedRows)
+		return
+	}
+
+	// 缓存里还是旧的值，这里一并刷新
+	user.PassWord = encoded
+	if err := cache.UpdateCachedUserInfo(user); err != nil {
+		log.Errorf("%s|upgradePasswordHash|update cache failed, user_name=%s|err=%v", uuid, user.Name, err)
+	}
+	log.Infof("%s|upgradePasswordHash|password hash upgraded, user_name=%s", uuid, user.Name)
+}
+
+// ChangePassword 登录用户修改自己的密码
+// 修改成功后清理用户信息缓存，并让该用户的其他会话全部失效；
+// KeepSession 为 false 时当前会话也会失效，返回值表示当前会话是否还有效
+func ChangePassword(ctx context.Context, req *ChangePasswordRequest) (bool, error) {
+	uuid := ctx.Value(constant.ReqUuid)
+	session, _ := ctx.Value(constant.SessionKey).(string)
+	sessionUser, err := currentUser(ctx)
+	if err != nil {
+		return false, fmt.Errorf("ChangePassword|%v", err)
+	}
+	log.Infof("%s|ChangePassword access from,user_name=%s", uuid, sessionUser.Name)
+
+	// 会话里的用户信息不包含密码哈希，需要从数据库读
+	user, err := dao.GetUserByName(sessionUser.Name)
+	if err != nil {
+		return false, fmt.Errorf("ChangePassword|%v", err)
+	}
+	if user == nil {
+		return false, fmt.Errorf("ChangePassword|%w", ErrUserNotFound)
+	}
+
+	// 校验当前密码
+	match, _, err := verifyPassword(req.OldPassWord, user.PassWord)
+	if err != nil {
+		return false, fmt.Errorf("ChangePassword|verify password err:%v", err)
+	}
+	if !match {
+		log.Errorf("%s|ChangePassword|current password not match, user_name=%s", uuid, user.Name)
+		return false, fmt.Errorf("ChangePassword|%w", ErrWrongPassword)
+	}
+	if tempPasswordExpired(user) {
+		log.Errorf("%s|ChangePassword|temporary password expired, user_name=%s", uuid, user.Name)
+		return false, fmt.Errorf("ChangePassword|%w", ErrPasswordExpired)
+	}
+
+	// 新密码不能和当前密码、最近用过的密码相同
+	if err := checkPasswordPolicy(req.NewPassWord, user); err != nil {
+		return false, fmt.Errorf("ChangePassword|%w", err)
+	}
+
+	encoded, err := hashPassword(req.NewPassWord)
+	if err != nil {
+		return false, fmt.Errorf("ChangePassword|hash password err:%v", err)
+	}
+	// 同时清除必须修改密码的标记，重新开始计算密码的使用时间
+	affected, err := dao.UpdateUserPassword(user.ID, encoded, user.Name, passwordHistoryKeep())
+	if err != nil {
+		return false, fmt.Errorf("ChangePassword|%v", err)
+	}
+	if affected != 1 {
+		return false, fmt.Errorf("ChangePassword|update password failed")
+	}
+
+	// 缓存里的用户信息作废；其他设备上的会话全部踢下线，按需保留当前会话
+	if err := cache.GetUserCache().DelUser(user.Name); err != nil {
+		log.Errorf("%s|ChangePassword|DelUser failed, user_name=%s|err=%v", uuid, user.Name, err)
+	}
+	// 通过 Bearer token 修改的，保留的是当前 token 所在的家族
+	keep, keepFamily := "", ""
+	if req.KeepSession {
+		keep = session
+		keepFamily, _ = ctx.Value(constant.TokenFamilyKey).(string)
+	}
+	// 密码已经改掉了，踢会话失败也不能跳过后面的吊销 token，只记录日志
+	if err := cache.GetSessionStore().DeleteUserSessions(user.Name, keep); err != nil {
+		log.Errorf("%s|ChangePassword|DelUserSessionsExcept failed, user_name=%s|err=%v", uuid, user.Name, err)
+	}
+	revokeUserTokens(uuid, user.Name, keepFamily)
+
+	// 保留的会话里存着登录时的用户信息，还带着必须修改密码的标记，这里一并清除
+	if keep != "" && sessionUser.MustChangePassword {
+		sessionUser.MustChangePassword = false
+		sessionUser.PasswordExpiresAt = nil
+		if err := cache.GetSessionStore().Update(sessionUser, keep); err != nil {
+			log.Errorf("%s|ChangePassword|update session failed, user_name=%s|err=%v", uuid, user.Name, err)
+		}
+	}
+
+	log.Infof("%s|ChangePassword|password changed, user_name=%s|keep_session=%v", uuid, user.Name, req.KeepSession)
+	return req.KeepSession, nil
+}
@@ -0,0 +1,173 @@
+package service
+
+import (
+	"crypto/rand"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+	"gouse/config"
+	"gouse/internal/cache"
+	"gouse/internal/dao"
+	"gouse/internal/model"
+	"gouse/pkg/constant"
+	"math/big"
+	"time"
+)
+
+// 密码过期：管理员设置的临时密码，以及超过了角色要求的最长使用时间的密码，
+// 登录后都要先修改密码。登录时把 must_change_password 标记保存在用户信息里，
+// 会话和 token 认证时据此只放行修改密码和登出接口，修改密码后标记清除
+
+// 密码过期配置的默认值
+const (
+	defaultTempPasswordExpired = 259200 // 秒
+	tempPasswordLength         = 16
+)
+
+// 临时密码的字符集，去掉了容易看错的 0、O、1、l、I
+const (
+	tempPasswordLower  = "abcdefghijkmnopqrstuvwxyz"
+	tempPasswordUpper  = "ABCDEFGHJKLMNPQRSTUVWXYZ"
+	tempPasswordDigit  = "23456789"
+	tempPasswordSymbol = "!@#$%^&*-_=+?"
+)
+
+// IssueTempPassword 管理员给用户设置一个随机的临时密码，明文只在这次响应里返回一次
+// 用户的全部会话和 token 立即失效；用临时密码登录后必须先修改密码，临时密码过期后不能再用来登录
+func IssueTempPassword(ctx context.Context, id int) (*TempPasswordResponse, error) {
+	uuid := ctx.Value(constant.ReqUuid)
+	operator, user, err := adminTarget(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("IssueTempPassword|%w", err)
+	}
+
+	plain, err := generateTempPassword()
+	if err != nil {
+		return nil, fmt.Errorf("IssueTempPassword|generate password err:%v", err)
+	}
+	encoded, err := hashPassword(plain)
+	if err != nil {
+		return nil, fmt.Errorf("IssueTempPassword|hash password err:%v", err)
+	}
+	expired := time.Duration(orDefault(config.GetGlobalConf().PasswordPolicy.TempPasswordExpired, defaultTempPasswordExpired)) * time.Second
+	expiresAt := time.Now().Add(expired)
+	affected, err := dao.SetTempPassword(user.ID, encoded, expiresAt, operator.Name)
+	if err != nil {
+		return nil, fmt.Errorf("IssueTempPassword|%v", err)
+	}
+	if affected != 1 {
+		return nil, fmt.Errorf("IssueTempPassword|update password failed, user_id=%d", id)
+	}
+
+	// 旧密码已经不能用了，之前的会话和 token 也一并作废
+	invalidateUser(uuid, user.Name, true)
+	log.Infof("%s|IssueTempPassword|%s issued temporary password for user %s, expires_at=%s",
+		uuid, operator.Name, user.Name, expiresAt.Format(time.RFC3339))
+	return &TempPasswordResponse{Password: plain, ExpiresAt: expiresAt.Unix()}, nil
+}
+
+// 生成随机的临时密码，小写字母、大写字母、数字、符号每一类至少有一个，能通过默认的密码策略
+func generateTempPassword() (string, error) {
+	classes := []string{tempPasswordLower, tempPasswordUpper, tempPasswordDigit, tempPasswordSymbol}
+	all := tempPasswordLower + tempPasswordUpper + tempPasswordDigit + tempPasswordSymbol
+
+	b := make([]byte, tempPasswordLength)
+	for i := range b {
+		charset := all
+		if i < len(classes) {
+			charset = classes[i]
+		}
+		c, err := randomChar(charset)
+		if err != nil {
+			return "", err
+		}
+		b[i] = c
+	}
+
+	// 打乱顺序，前四位不再固定是哪一类字符
+	for i := len(b) - 1; i > 0; i-- {
+		j, err := rand.Int(rand.Reader, big.NewInt(int64(i+1)))
+		if err != nil {
+			return "", err
+		}
+		b[i], b[j.Int64()] = b[j.Int64()], b[i]
+	}
+	return string(b), nil
+}
+
+func randomChar(charset string) (byte, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(int64(len(charset))))
+	if err != nil {
+		return 0, err
+	}
+	return charset[n.Int64()], nil
+}
+
+// 当前密码是不是已经过期的临时密码，过期的临时密码不能再用来登录和修改密码
+func tempPasswordExpired(user *model.User) bool {
+	return user.MustChangePassword && user.PasswordExpiresAt != nil && time.Now().After(*user.PasswordExpiresAt)
+}
+
+// 判断是否需要先修改密码：已经被标记为必须修改，或者密码超过了角色要求的最长使用时间
+// 登录、刷新 token、Bearer token 和 API key 认证时都会检查，长期有效的凭证在密码到期之后同样只能用来修改密码。
+// 超过最长使用时间的在数据库里打上标记，之后用别的方式登录、刷新 token 都同样受限，直到修改密码
+func passwordChangeRequired(uuid interface{}, user *model.User) (bool, error) {
+	if user.MustChangePassword {
+		return true, nil
+	}
+	if user.PasswordChangedAt == nil {
+		return false, nil
+	}
+	// 比配置里最短的天数还新的密码不可能过期，不用查用户的角色，绝大多数请求在这里就返回了
+	if minAge := minPasswordMaxAge(); minAge <= 0 || time.Since(*user.PasswordChangedAt) < minAge {
+		return false, nil
+	}
+	maxAge, err := passwordMaxAge(user)
+	if err != nil {
+		return false, err
+	}
+	if maxAge <= 0 || time.Since(*user.PasswordChangedAt) < maxAge {
+		return false, nil
+	}
+
+	if err := dao.MarkMustChangePassword(user.ID); err != nil {
+		return false, err
+	}
+	if err := cache.GetUserCache().DelUser(user.Name); err != nil {
+		log.Errorf("%s|passwordChangeRequired|DelUser failed, user_name=%s|err=%v", uuid, user.Name, err)
+	}
+	user.MustChangePassword = true
+	log.Infof("%s|passwordChangeRequired|password expired, user_name=%s|changed_at=%s",
+		uuid, user.Name, user.PasswordChangedAt.Format(time.RFC3339))
+	return true, nil
+}
+
+// 用户的密码最长可以使用多久：按 password_policy.max_age_days 配置，用户有多个角色时取最短的，0 表示不过期
+func passwordMaxAge(user *model.User) (time.Duration, error) {
+	maxAgeDays := config.GetGlobalConf().PasswordPolicy.MaxAgeDays
+	if len(maxAgeDays) == 0 {
+		return 0, nil
+	}
+	roles, err := userRoleNames(user)
+	if err != nil {
+		return 0, err
+	}
+	days := 0
+	for _, role := range roles {
+		if d := maxAgeDays[role]; d > 0 && (days == 0 || d < days) {
+			days = d
+		}
+	}
+	return time.Duration(days) * 24 * time.Hour, nil
+}
+
+// 配置里所有角色中最短的密码使用时间，0 表示没有角色的密码会过期
+func minPasswordMaxAge() time.Duration {
+	days := 0
+	for _, d := range config.GetGlobalConf().PasswordPolicy.MaxAgeDays {
+		if d > 0 && (days == 0 || d < days) {
+			days = d
+		}
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
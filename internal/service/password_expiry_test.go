@@ -0,0 +1,160 @@
+package service
+
+import (
+	"gouse/config"
+	"gouse/internal/cache"
+	"gouse/internal/model"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGenerateTempPassword(t *testing.T) {
+	classes := []string{tempPasswordLower, tempPasswordUpper, tempPasswordDigit, tempPasswordSymbol}
+	all := strings.Join(classes, "")
+	seen := map[string]bool{}
+	for i := 0; i < 200; i++ {
+		plain, err := generateTempPassword()
+		if err != nil {
+			t.Fatalf("generateTempPassword err: %v", err)
+		}
+		if len(plain) != tempPasswordLength {
+			t.Fatalf("len(%q) = %d, want %d", plain, len(plain), tempPasswordLength)
+		}
+		for _, c := range plain {
+			if !strings.ContainsRune(all, c) {
+				t.Fatalf("%q contains %q outside the charset", plain, c)
+			}
+		}
+		// 每一类字符至少有一个
+		for _, class := range classes {
+			if !strings.ContainsAny(plain, class) {
+				t.Fatalf("%q has no character from %q", plain, class)
+			}
+		}
+		if seen[plain] {
+			t.Fatalf("generateTempPassword returned %q twice", plain)
+		}
+		seen[plain] = true
+	}
+}
+
+func TestTempPasswordExpired(t *testing.T) {
+	past, future := time.Now().Add(-time.Minute), time.Now().Add(time.Hour)
+	cases := []struct {
+		name string
+		user *model.User
+		want bool
+	}{
+		{name: "normal password", user: &model.User{}, want: false},
+		{name: "temporary password valid", user: &model.User{MustChangePassword: true, PasswordExpiresAt: &future}, want: false},
+		{name: "temporary password expired", user: &model.User{MustChangePassword: true, PasswordExpiresAt: &past}, want: true},
+		// 按最长使用时间过期的没有失效时间，仍然可以登录去修改密码
+		{name: "aged password", user: &model.User{MustChangePassword: true}, want: false},
+		// 已经修改过密码，标记清除了，残留的失效时间不生效
+		{name: "changed after temporary", user: &model.User{PasswordExpiresAt: &past}, want: false},
+	}
+	for _, tc := range cases {
+		if got := tempPasswordExpired(tc.user); got != tc.want {
+			t.Errorf("%s: tempPasswordExpired() = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+// 用户的角色提前放进内存缓存，不会去查数据库
+func setupPasswordAgeTest(t *testing.T, maxAgeDays map[string]int) {
+	t.Helper()
+	conf := &config.GlobalConfig{}
+	conf.PasswordPolicy.MaxAgeDays = maxAgeDays
+	setupTestStores(t, conf)
+}
+
+func cacheTestRoles(t *testing.T, userID int, roles ...string) {
+	t.Helper()
+	if err := cache.GetUserCache().SetRoles(userID, roles); err != nil {
+		t.Fatalf("SetRoles err: %v", err)
+	}
+}
+
+func TestPasswordMaxAge(t *testing.T) {
+	day := 24 * time.Hour
+	cases := []struct {
+		name   string
+		config map[string]int
+		roles  []string
+		want   time.Duration
+	}{
+		{name: "not configured", roles: []string{"admin"}, want: 0},
+		{name: "single role", config: map[string]int{"admin": 90}, roles: []string{"admin"}, want: 90 * day},
+		{name: "shortest of roles", config: map[string]int{"admin": 90, "auditor": 30}, roles: []string{"admin", "auditor"}, want: 30 * day},
+		{name: "role without max age", config: map[string]int{"admin": 90}, roles: []string{"viewer"}, want: 0},
+		{name: "mixed roles", config: map[string]int{"admin": 90, "viewer": 0}, roles: []string{"viewer", "admin"}, want: 90 * day},
+		{name: "no roles", config: map[string]int{"admin": 90}, want: 0},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			setupPasswordAgeTest(t, tc.config)
+			user := &model.User{ID: 1, Name: "alice"}
+			cacheTestRoles(t, user.ID, tc.roles...)
+			got, err := passwordMaxAge(user)
+			if err != nil {
+				t.Fatalf("passwordMaxAge err: %v", err)
+			}
+			if got != tc.want {
+				t.Errorf("passwordMaxAge() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestPasswordChangeRequired(t *testing.T) {
+	setupPasswordAgeTest(t, map[string]int{"admin": 90, "auditor": 30})
+	ago := func(days int) *time.Time {
+		at := time.Now().Add(-time.Duration(days) * 24 * time.Hour)
+		return &at
+	}
+
+	// 已经有标记的直接返回
+	if required, err := passwordChangeRequired("test", &model.User{ID: 1, MustChangePassword: true}); err != nil || !required {
+		t.Errorf("marked user: passwordChangeRequired() = %v, %v, want true", required, err)
+	}
+	// 比最短的天数还新的密码不查角色：没有缓存角色，查了就会去连数据库
+	if required, err := passwordChangeRequired("test", &model.User{ID: 2, PasswordChangedAt: ago(10)}); err != nil || required {
+		t.Errorf("recent password: passwordChangeRequired() = %v, %v, want false", required, err)
+	}
+	// 超过了最短的天数，但是用户的角色没有要求，或者还没到角色要求的天数
+	cacheTestRoles(t, 3, "viewer")
+	if required, err := passwordChangeRequired("test", &model.User{ID: 3, PasswordChangedAt: ago(400)}); err != nil || required {
+		t.Errorf("role without max age: passwordChangeRequired() = %v, %v, want false", required, err)
+	}
+	cacheTestRoles(t, 4, "admin")
+	if required, err := passwordChangeRequired("test", &model.User{ID: 4, PasswordChangedAt: ago(60)}); err != nil || required {
+		t.Errorf("admin within max age: passwordChangeRequired() = %v, %v, want false", required, err)
+	}
+}
+
+// Bearer token 认证时同样检查密码的使用时间：还没到期的照常通过，已经标记过的带着标记返回，由认证中间件限制能访问的接口
+func TestAuthenticateAccessTokenPasswordAge(t *testing.T) {
+	setupTestTokenAuth(t)
+	config.GetGlobalConf().PasswordPolicy.MaxAgeDays = map[string]int{"admin": 30}
+	changedAt := time.Now().Add(-10 * 24 * time.Hour)
+	user := &model.User{ID: 1, Name: "alice", PasswordChangedAt: &changedAt}
+	cacheTestUser(t, user)
+	cacheTestRoles(t, user.ID, "admin")
+	tokens, err := issueTokens(user, "family1")
+	if err != nil {
+		t.Fatalf("issueTokens err: %v", err)
+	}
+
+	got, _, err := AuthenticateAccessToken(tokens.AccessToken)
+	if err != nil || got.MustChangePassword {
+		t.Fatalf("AuthenticateAccessToken = %v, %v, want a user without must_change_password", got, err)
+	}
+
+	user.MustChangePassword = true
+	cacheTestUser(t, user)
+	got, _, err = AuthenticateAccessToken(tokens.AccessToken)
+	if err != nil || !got.MustChangePassword {
+		t.Errorf("AuthenticateAccessToken = %v, %v, want a user with must_change_password", got, err)
+	}
+}
@@ -0,0 +1,139 @@
+package service
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"gouse/config"
+	"gouse/internal/dao"
+	"gouse/internal/model"
+	"gouse/pkg/password"
+	"strings"
+)
+
+// 密码策略配置的默认值
+const (
+	defaultPasswordMinLength = 8
+	defaultPasswordMaxLength = 128
+)
+
+// 泄露密码库，没有配置时为 nil；InitPasswordPolicy 之后才可用
+var breachList password.BreachList
+
+// InitPasswordPolicy 加载配置的泄露密码文件，配置了但是加载不了时返回错误，服务不应该继续启动
+// 否则泄露密码检查会悄悄失效，只能从日志里发现
+func InitPasswordPolicy() error {
+	path := config.GetGlobalConf().PasswordPolicy.BreachList
+	if path == "" {
+		return nil
+	}
+	list, err := password.LoadBreachFile(path)
+	if err != nil {
+		return fmt.Errorf("InitPasswordPolicy|load breach list %s err:%v", path, err)
+	}
+	breachList = list
+	log.Infof("InitPasswordPolicy|%d entries loaded from %s", list.Len(), path)
+	return nil
+}
+
+// 根据配置文件组装密码策略
+func passwordPolicy() *password.Policy {
+	conf := config.GetGlobalConf().PasswordPolicy
+	// 兼容旧配置：没有配置 password_policy.min_length 时使用 password.min_length
+	minLength := orDefault(conf.MinLength, orDefault(config.GetGlobalConf().PasswordConfig.MinLength, defaultPasswordMinLength))
+	return &password.Policy{
+		MinLength:       minLength,
+		MaxLength:       orDefault(conf.MaxLength, defaultPasswordMaxLength),
+		MinClasses:      conf.MinClasses,
+		RequiredClasses: conf.RequiredClasses,
+		UserSimilarity:  conf.UserSimilarity,
+		Breached:        breachList,
+	}
+}
+
+// 检查新密码是否符合密码策略，不符合时返回 *PasswordPolicyError，里面是违反的全部规则
+// user 是要设置密码的用户，注册时还没有入库，ID 和 PassWord 都是零值，不检查密码历史
+func checkPasswordPolicy(plain string, user *model.User) error {
+	violations, err := passwordPolicy().Check(plain, personalInfo(user)...)
+	if err != nil {
+		// 查询泄露密码库出错时放行，其他规则照常生效
+		log.Errorf("checkPasswordPolicy|breach list lookup failed, user_name=%s|err=%v", user.Name, err)
+	}
+
+	reused, err := reusedPassword(plain, user)
+	if err != nil {
+		return err
+	}
+	if reused {
+		violations = append(violations, password.Violation{Rule: password.RuleReused, Message: "must be different from recently used passwords"})
+	}
+
+	if len(violations) > 0 {
+		return &PasswordPolicyError{Violations: violations}
+	}
+	return nil
+}
+
+// 不能出现在密码里的个人信息：用户名、邮箱 @ 前面的部分（包括还没有验证的邮箱）、昵称
+func personalInfo(user *model.User) []string {
+	info := []string{user.Name, user.NickName}
+	for _, email := range []string{user.Email, user.PendingEmail} {
+		if i := strings.IndexByte(email, '@'); i > 0 {
+			info = append(info, email[:i])
+		}
+	}
+	return info
+}
+
+// 新密码是否和当前密码或者最近用过的密码相同
+// 历史里保存的是哈希，只能逐个校验，password_policy.history 不宜配得太大
+func reusedPassword(plain string, user *model.User) (bool, error) {
+	hashes := make([]string, 0)
+	if user.PassWord != "" {
+		hashes = append(hashes, user.PassWord)
+	}
+	if history := config.GetGlobalConf().PasswordPolicy.History; history > 0 && user.ID > 0 {
+		old, err := dao.ListPasswordHistory(user.ID, history)
+		if err != nil {
+			return false, err
+		}
+		hashes = append(hashes, old...)
+	}
+
+	for _, encoded := range hashes {
+		match, _, err := verifyPassword(plain, encoded)
+		if err != nil {
+			// 历史里个别格式不对的哈希不影响其他的比对
+			log.Errorf("reusedPassword|verify password err, user_name=%s|err=%v", user.Name, err)
+			continue
+		}
+		if match {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// 修改、重置密码时要保留的密码历史个数，0 表示不记录
+func passwordHistoryKeep() int {
+	if history := config.GetGlobalConf().PasswordPolicy.History; history > 0 {
+		return history
+	}
+	return 0
+}
+
+// 记录注册时设置的密码哈希，失败只记日志，不影响注册
+// 修改、重置密码时由 dao.UpdateUserPassword 在同一个事务里记录
+func recordPasswordHistory(uuid interface{}, user *model.User, encoded string) {
+	history := passwordHistoryKeep()
+	if history == 0 {
+		return
+	}
+	record := &model.PasswordHistory{
+		UserID:      user.ID,
+		PassWord:    encoded,
+		CreateModel: model.CreateModel{Creator: user.Name},
+	}
+	if err := dao.AddPasswordHistory(record, history); err != nil {
+		log.Errorf("%s|recordPasswordHistory|AddPasswordHistory failed, user_name=%s|err=%v", uuid, user.Name, err)
+	}
+}
@@ -0,0 +1,140 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+	"gouse/config"
+	"gouse/internal/cache"
+	"gouse/internal/dao"
+	"gouse/pkg/constant"
+	"gouse/pkg/mailer"
+	"gouse/utils"
+	"net/url"
+	"time"
+)
+
+// 找回密码配置的默认值
+const (
+	defaultResetTokenExpired = 1800 // 秒
+	defaultResetResendLimit  = 60   // 秒
+	resetTokenBytes          = 32
+)
+
+// ForgotPassword 申请找回密码，向用户的邮箱发送一次性的重置链接
+// 不管用户是否存在、有没有邮箱，都返回成功，避免被用来探测用户名
+func ForgotPassword(ctx context.Context, req *ForgotPasswordRequest) error {
+	uuid := ctx.Value(constant.ReqUuid)
+	log.Infof("%s|ForgotPassword access from,user_name=%s", uuid, req.UserName)
+	if req.UserName == "" {
+		return fmt.Errorf("ForgotPassword|request params invalid")
+	}
+
+	user, err := dao.GetUserByName(req.UserName)
+	if err != nil {
+		return fmt.Errorf("ForgotPassword|%v", err)
+	}
+	// 没有验证过的邮箱不一定属于用户本人，不能往里面发重置链接
+	if user == nil || user.Email == "" || !user.EmailVerified || user.Status == constant.UserStatusDisabled {
+		log.Warnf("%s|ForgotPassword|user %s not found, disabled or has no verified email, skip", uuid, req.UserName)
+		return nil
+	}
+
+	conf := config.GetGlobalConf().PasswordReset
+	interval := time.Duration(orDefault(conf.ResendLimit, defaultResetResendLimit)) * time.Second
+	allowed, err := cache.AllowPasswordResetMail(user.Name, interval)
+	if err != nil {
+		return fmt.Errorf("ForgotPassword|%v", err)
+	}
+	if !allowed {
+		log.Warnf("%s|ForgotPassword|too frequent, user_name=%s", uuid, user.Name)
+		return nil
+	}
+
+	// 链接里是 token 明文，Redis 里只保存它的哈希
+	token, err := utils.RandomToken(resetTokenBytes)
+	if err != nil {
+		return fmt.Errorf("ForgotPassword|generate token err:%v", err)
+	}
+	expired := time.Duration(orDefault(conf.TokenExpired, defaultResetTokenExpired)) * time.Second
+	if err := cache.SetPasswordResetToken(user.Name, utils.Sha256String(token), expired); err != nil {
+		return fmt.Errorf("ForgotPassword|%v", err)
+	}
+
+	link := conf.ResetURL + "?token=" + url.QueryEscape(token)
+	msg := &mailer.Message{
+		To:      user.Email,
+		Subject: "重置密码",
+		Body: fmt.Sprintf("%s，你好：\n\n请在 %d 分钟内打开下面的链接重置密码，链接只能使用一次：\n\n%s\n\n如果不是你本人的操作，请忽略这封邮件。\n",
+			user.Name, int(expired.Minutes()), link),
+	}
+	if err := utils.GetMailer().Send(msg); err != nil {
+		log.Errorf("%s|ForgotPassword|send mail failed, user_name=%s|err=%v", uuid, user.Name, err)
+		return fmt.Errorf("ForgotPassword|send mail failed")
+	}
+	log.Infof("%s|ForgotPassword|reset mail sent, user_name=%s", uuid, user.Name)
+	return nil
+}
+
+// ResetPassword 使用重置链接里的 token 设置新密码
+// token 只能使用一次，重置成功后该用户的全部会话都会失效
+func ResetPassword(ctx context.Context, req *ResetPasswordRequest) error {
+	uuid := ctx.Value(constant.ReqUuid)
+	if req.Token == "" {
+		return fmt.Errorf("ResetPassword|request params invalid")
+	}
+
+	// 先检查密码策略，不符合时不消耗 token，用户可以换个密码重试
+	tokenHash := utils.Sha256String(req.Token)
+	userName, err := cache.PeekPasswordResetToken(tokenHash)
+	if errors.Is(err, cache.ErrTokenNotFound) {
+		return fmt.Errorf("ResetPassword|%w", ErrInvalidToken)
+	}
+	if err != nil {
+		return fmt.Errorf("ResetPassword|%v", err)
+	}
+	user, err := dao.GetUserByName(userName)
+	if err != nil {
+		return fmt.Errorf("ResetPassword|%v", err)
+	}
+	if user == nil {
+		return fmt.Errorf("ResetPassword|%w", ErrInvalidToken)
+	}
+	if err := checkPasswordPolicy(req.NewPassWord, user); err != nil {
+		return fmt.Errorf("ResetPassword|%w", err)
+	}
+
+	// 检查通过才消耗 token，并发使用同一个 token 时只有一个请求能拿到
+	if _, err := cache.TakePasswordResetToken(tokenHash); errors.Is(err, cache.ErrTokenNotFound) {
+		return fmt.Errorf("ResetPassword|%w", ErrInvalidToken)
+	} else if err != nil {
+		return fmt.Errorf("ResetPassword|%v", err)
+	}
+
+	encoded, err := hashPassword(req.NewPassWord)
+	if err != nil {
+		return fmt.Errorf("ResetPassword|hash password err:%v", err)
+	}
+	// 临时密码、过期的密码通过找回密码重置之后同样不再需要修改
+	affected, err := dao.UpdateUserPassword(user.ID, encoded, userName, passwordHistoryKeep())
+	if err != nil {
+		return fmt.Errorf("ResetPassword|%v", err)
+	}
+	if affected != 1 {
+		return fmt.Errorf("ResetPassword|update password failed")
+	}
+
+	// 密码已经变了，之前的会话全部作废
+	invalidateUser(uuid, userName, true)
+	log.Infof("%s|ResetPassword|password reset, user_name=%s", uuid, userName)
+	return nil
+}
+
+// 配置没有填写（零值）时使用默认值
+func orDefault(v, def int) int {
+	if v <= 0 {
+		return def
+	}
+	return v
+}
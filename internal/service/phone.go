@@ -0,0 +1,334 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+	"gouse/config"
+	"gouse/internal/cache"
+	"gouse/internal/dao"
+	"gouse/pkg/constant"
+	"gouse/pkg/phone"
+	"gouse/pkg/sms"
+	"gouse/utils"
+	"strings"
+	"time"
+)
+
+// 短信验证码配置的默认值
+const (
+	defaultSMSCodeLength  = 6
+	defaultSMSCodeExpired = 300 // 秒
+	defaultSMSResendLimit = 60  // 秒
+	defaultSMSHourlyLimit = 5
+	defaultSMSIPLimit     = 20
+	defaultSMSGlobalLimit = 1000
+	defaultSMSMaxAttempts = 5
+	smsCountWindow        = time.Hour
+	smsBudgetWarnPercent  = 80 // 全局条数用到百分之多少时报警
+)
+
+// 短信验证码的用途，不同用途的验证码互不通用
+const (
+	smsPurposeBind  = "bind"  // 绑定手机号
+	smsPurposeLogin = "login" // 手机号登录
+)
+
+// 检查并规范化手机号，不带国家码的按 sms.country_code 补上
+func normalizePhone(raw string) (string, error) {
+	p, err := phone.Normalize(raw, config.GetGlobalConf().SMS.CountryCode)
+	if err != nil {
+		return "", fmt.Errorf("%w: %q", ErrPhoneInvalid, raw)
+	}
+	return p, nil
+}
+
+// 手机号没有被其他用户绑定时返回 nil
+func checkPhoneAvailable(p string, userID int) error {
+	exists, err := dao.PhoneExists(p, userID)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return ErrPhoneTaken
+	}
+	return nil
+}
+
+// GetPhone 查看当前用户绑定的手机号
+func GetPhone(ctx context.Context) (*PhoneInfo, error) {
+	user, err := currentUser(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("GetPhone|%v", err)
+	}
+	// 会话里保存的是登录时的用户信息，手机号可能已经换过了，从数据库取最新的
+	dbUser, err := dao.GetUserByID(user.ID)
+	if err != nil {
+		return nil, fmt.Errorf("GetPhone|%v", err)
+	}
+	if dbUser == nil {
+		return nil, fmt.Errorf("GetPhone|%w", ErrUserNotFound)
+	}
+	return &PhoneInfo{Phone: dbUser.Phone}, nil
+}
+
+// SendBindPhoneCode 向要绑定的手机号发送验证码，已经绑定了手机号的用户再次绑定就是换号
+func SendBindPhoneCode(ctx context.Context, req *SendPhoneCodeRequest) error {
+	uuid := ctx.Value(constant.ReqUuid)
+	ip, _ := ctx.Value(constant.ClientIPKey).(string)
+	user, err := currentUser(ctx)
+	if err != nil {
+		return fmt.Errorf("SendBindPhoneCode|%v", err)
+	}
+	p, err := normalizePhone(req.Phone)
+	if err != nil {
+		return fmt.Errorf("SendBindPhoneCode|%w", err)
+	}
+	if err := checkPhoneAvailable(p, user.ID); err != nil {
+		return fmt.Errorf("SendBindPhoneCode|%w", err)
+	}
+	if err := sendSMSCode(uuid, ip, smsPurposeBind, p, user.Name); err != nil {
+		return fmt.Errorf("SendBindPhoneCode|%w", err)
+	}
+	return nil
+}
+
+// BindPhone 校验验证码，通过后把手机号绑定到当前用户，原来绑定的号码随之解绑
+func BindPhone(ctx context.Context, req *BindPhoneRequest) error {
+	uuid := ctx.Value(constant.ReqUuid)
+	user, err := currentUser(ctx)
+	if err != nil {
+		return fmt.Errorf("BindPhone|%v", err)
+	}
+	p, err := normalizePhone(req.Phone)
+	if err != nil {
+		return fmt.Errorf("BindPhone|%w", err)
+	}
+	if req.Code == "" {
+		return fmt.Errorf("BindPhone|request params invalid")
+	}
+
+	owner, err := checkSMSCode(smsPurposeBind, p, req.Code)
+	if err != nil {
+		return fmt.Errorf("BindPhone|%w", err)
+	}
+	// 验证码是发给另一个用户绑定用的，不能拿来给自己绑定
+	if owner != user.Name {
+		return fmt.Errorf("BindPhone|%w", ErrInvalidToken)
+	}
+	// 发验证码到输入验证码之间，号码可能已经被别人绑定了
+	if err := checkPhoneAvailable(p, user.ID); err != nil {
+		return fmt.Errorf("BindPhone|%w", err)
+	}
+	if _, err := dao.UpdateUserPhone(user.ID, p, user.Name); err != nil {
+		// 检查之后、写入之前被别人抢先绑定，由唯一索引兜底
+		if errors.Is(err, dao.ErrDuplicateKey) {
+			return fmt.Errorf("BindPhone|%w", ErrPhoneTaken)
+		}
+		return fmt.Errorf("BindPhone|%v", err)
+	}
+	invalidateUser(uuid, user.Name, false)
+	log.Infof("%s|BindPhone|phone %s bound, user_name=%s", uuid, phone.Mask(p), user.Name)
+	return nil
+}
+
+// SendLoginSMSCode 申请手机号登录的验证码
+// 号码没有绑定用户时不发送，但是同样返回成功，避免被用来探测哪些号码注册过；发送频率限制对所有号码一视同仁
+func SendLoginSMSCode(ctx context.Context, req *SendPhoneCodeRequest) error {
+	uuid := ctx.Value(constant.ReqUuid)
+	ip, _ := ctx.Value(constant.ClientIPKey).(string)
+	p, err := normalizePhone(req.Phone)
+	if err != nil {
+		return fmt.Errorf("SendLoginSMSCode|%w", err)
+	}
+
+	if err := checkSMSLimit(uuid, ip, p); err != nil {
+		return fmt.Errorf("SendLoginSMSCode|%w", err)
+	}
+	user, err := dao.GetUserByPhone(p)
+	if err != nil {
+		return fmt.Errorf("SendLoginSMSCode|%v", err)
+	}
+	if user == nil || user.Status == constant.UserStatusDisabled {
+		log.Warnf("%s|SendLoginSMSCode|phone %s not bound or user disabled, skip", uuid, phone.Mask(p))
+		return nil
+	}
+	// 全局条数用完时和号码没有绑定一样返回成功，只有绑定了的号码返回 ErrTooManyRequests 会暴露号码注册过
+	err = issueSMSCode(uuid, smsPurposeLogin, p, user.Name)
+	if errors.Is(err, ErrTooManyRequests) {
+		log.Warnf("%s|SendLoginSMSCode|sms budget exhausted, phone=%s", uuid, phone.Mask(p))
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("SendLoginSMSCode|%v", err)
+	}
+	return nil
+}
+
+// PhoneLogin 手机号加验证码登录，成功后和 Login 一样创建会话；开启了两步验证的用户仍然需要输入两步验证的验证码
+func PhoneLogin(ctx context.Context, req *PhoneLoginRequest) (*LoginResult, error) {
+	uuid := ctx.Value(constant.ReqUuid)
+	ip, _ := ctx.Value(constant.ClientIPKey).(string)
+	p, err := normalizePhone(req.Phone)
+	if err != nil {
+		return nil, fmt.Errorf("PhoneLogin|%w", err)
+	}
+	if req.Code == "" {
+		return nil, fmt.Errorf("PhoneLogin|request params invalid")
+	}
+
+	user, err := dao.GetUserByPhone(p)
+	if err != nil {
+		return nil, fmt.Errorf("PhoneLogin|%v", err)
+	}
+	// 失败次数按用户名统计，和密码登录共用一个计数；号码没有绑定用户的按号码统计
+	subject := p
+	if user != nil {
+		subject = user.Name
+	}
+	if err := checkLoginLock(subject, ip); err != nil {
+		log.Warnf("%s|PhoneLogin|locked, user_name=%s|ip=%s|err=%v", uuid, subject, ip, err)
+		return nil, fmt.Errorf("PhoneLogin|%w", err)
+	}
+	if user == nil {
+		recordLoginFailure(uuid, subject, ip)
+		return nil, fmt.Errorf("PhoneLogin|%w", ErrInvalidToken)
+	}
+
+	owner, err := checkSMSCode(smsPurposeLogin, p, req.Code)
+	if errors.Is(err, ErrInvalidMFACode) {
+		log.Errorf("%s|PhoneLogin|code not match, user_name=%s", uuid, user.Name)
+		recordLoginFailure(uuid, user.Name, ip)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("PhoneLogin|%w", err)
+	}
+	// 发验证码之后号码换绑给了别人，之前的验证码作废
+	if owner != user.Name {
+		return nil, fmt.Errorf("PhoneLogin|%w", ErrInvalidToken)
+	}
+	if user.Status == constant.UserStatusDisabled {
+		return nil, fmt.Errorf("PhoneLogin|%w", ErrUserDisabled)
+	}
+	// 和密码登录一样拒绝临时密码已经过期的用户：登录后只能修改密码，而过期的临时密码通不过修改密码的校验
+	if tempPasswordExpired(user) {
+		log.Errorf("%s|PhoneLogin|temporary password expired, user_name=%s", uuid, user.Name)
+		return nil, fmt.Errorf("PhoneLogin|%w", ErrPasswordExpired)
+	}
+
+	result, err := finishFirstFactor(ctx, user, req.RememberMe)
+	if err != nil {
+		return nil, fmt.Errorf("PhoneLogin|%v", err)
+	}
+	log.Infof("%s|PhoneLogin|first factor passed, user_name=%s|mfa_required=%v", uuid, user.Name, result.MFARequired)
+	return result, nil
+}
+
+// 检查发送频率再发送验证码
+func sendSMSCode(uuid interface{}, ip, purpose, p, owner string) error {
+	if err := checkSMSLimit(uuid, ip, p); err != nil {
+		return err
+	}
+	return issueSMSCode(uuid, purpose, p, owner)
+}
+
+// 检查发送频率：同一个号码两次发送之间的间隔、同一个号码和同一个 IP 网段每小时的条数，超过时返回 ErrTooManyRequests
+// 对所有号码一视同仁，号码有没有绑定用户都要计数；全局的条数不在这里扣，真正发送时才由 takeSMSBudget 扣
+func checkSMSLimit(uuid interface{}, ip, p string) error {
+	conf := config.GetGlobalConf().SMS
+	if ip != "" {
+		prefix := utils.IPPrefix(ip)
+		count, err := cache.IncrSMSCount("ip:"+prefix, smsCountWindow)
+		if err != nil {
+			return err
+		}
+		if count > int64(orDefault(conf.IPLimit, defaultSMSIPLimit)) {
+			log.Warnf("%s|checkSMSLimit|too many sms from ip=%s", uuid, prefix)
+			return ErrTooManyRequests
+		}
+	}
+
+	interval := time.Duration(orDefault(conf.ResendLimit, defaultSMSResendLimit)) * time.Second
+	allowed, err := cache.AllowSMSCode(p, interval)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return ErrTooManyRequests
+	}
+	count, err := cache.IncrSMSCount("phone:"+p, smsCountWindow)
+	if err != nil {
+		return err
+	}
+	if count > int64(orDefault(conf.HourlyLimit, defaultSMSHourlyLimit)) {
+		log.Warnf("%s|checkSMSLimit|too many sms to phone=%s", uuid, phone.Mask(p))
+		return ErrTooManyRequests
+	}
+	return nil
+}
+
+// 从全部号码共用的每小时条数里扣一条，用完时返回 ErrTooManyRequests
+// 按 IP 统计挡不住手里有大量 IP 的人，全局的条数是兜底，保证短信费用有上限；
+// 只在确实要发短信时调用，没有绑定用户的号码不会消耗它，用到 smsBudgetWarnPercent 时打 error 日志报警，方便及早发现有人在刷
+func takeSMSBudget(uuid interface{}) error {
+	limit := int64(orDefault(config.GetGlobalConf().SMS.GlobalHourlyLimit, defaultSMSGlobalLimit))
+	count, err := cache.IncrSMSCount("global", smsCountWindow)
+	if err != nil {
+		return err
+	}
+	switch {
+	case count > limit:
+		log.Errorf("%s|takeSMSBudget|global sms budget exhausted, count=%d|limit=%d", uuid, count, limit)
+		return ErrTooManyRequests
+	case count == limit*smsBudgetWarnPercent/100:
+		log.Errorf("%s|takeSMSBudget|global sms budget running low, count=%d|limit=%d", uuid, count, limit)
+	}
+	return nil
+}
+
+// 扣除全局条数，生成验证码并发送，Redis 里只保存验证码的哈希
+func issueSMSCode(uuid interface{}, purpose, p, owner string) error {
+	if err := takeSMSBudget(uuid); err != nil {
+		return err
+	}
+	conf := config.GetGlobalConf().SMS
+	code, err := utils.RandomDigits(orDefault(conf.CodeLength, defaultSMSCodeLength))
+	if err != nil {
+		return fmt.Errorf("generate code err:%v", err)
+	}
+	expired := time.Duration(orDefault(conf.CodeExpired, defaultSMSCodeExpired)) * time.Second
+	if err := cache.SetSMSCode(purpose, p, smsCodeHash(p, code), owner, expired); err != nil {
+		return err
+	}
+
+	msg := &sms.Message{
+		To:   p,
+		Text: fmt.Sprintf("验证码：%s，%d 分钟内有效。如果不是你本人的操作，请忽略这条短信。", code, int(expired.Minutes())),
+	}
+	if err := utils.GetSmsSender().Send(msg); err != nil {
+		log.Errorf("%s|issueSMSCode|send sms failed, phone=%s|err=%v", uuid, phone.Mask(p), err)
+		return fmt.Errorf("send sms failed")
+	}
+	log.Infof("%s|issueSMSCode|%s code sent, phone=%s|user_name=%s", uuid, purpose, phone.Mask(p), owner)
+	return nil
+}
+
+// 校验验证码，返回验证码所属的用户名
+// 验证码不存在或者已经过期返回 ErrInvalidToken，不正确返回 ErrInvalidMFACode
+func checkSMSCode(purpose, p, code string) (string, error) {
+	maxAttempts := orDefault(config.GetGlobalConf().SMS.MaxAttempts, defaultSMSMaxAttempts)
+	owner, err := cache.CheckSMSCode(purpose, p, smsCodeHash(p, strings.TrimSpace(code)), maxAttempts)
+	switch {
+	case errors.Is(err, cache.ErrTokenNotFound):
+		return "", ErrInvalidToken
+	case errors.Is(err, cache.ErrCodeMismatch):
+		return "", ErrInvalidMFACode
+	}
+	return owner, err
+}
+
+// 验证码的哈希带上手机号，不同号码碰巧收到相同的验证码时哈希也不同
+func smsCodeHash(p, code string) string {
+	return utils.Sha256String(p + ":" + code)
+}
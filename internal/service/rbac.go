@@ -0,0 +1,200 @@
+package service
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+	"gouse/config"
+	"gouse/internal/authz"
+	"gouse/internal/cache"
+	"gouse/internal/dao"
+	"gouse/internal/model"
+	"gouse/pkg/constant"
+)
+
+// InitRBAC 初始化角色权限
+// 1）把内置权限同步到数据库，并全部授予管理员角色（新增的内置权限重启后管理员自动拥有）
+// 2）如果还没有任何管理员，按配置把指定的用户设为第一个管理员
+// 3）把基于角色的权限查询注册给 authz
+func InitRBAC() error {
+	adminRole, err := dao.EnsureRole(authz.RoleAdmin, "管理员，拥有全部内置权限")
+	if err != nil {
+		return err
+	}
+	for _, def := range authz.BuiltinPermissions {
+		perm, err := dao.EnsurePermission(def.Code, def.Description)
+		if err != nil {
+			return err
+		}
+		if err := dao.GrantPermission(adminRole.ID, perm.ID); err != nil {
+			return err
+		}
+	}
+
+	if name := config.GetGlobalConf().RBACConfig.BootstrapAdmin; name != "" {
+		if err := bootstrapAdmin(name, adminRole); err != nil {
+			return err
+		}
+	}
+
+	authz.SetPermissionResolver(userPermissions)
+	return nil
+}
+
+// 只有在系统里还没有任何管理员的时候，才把配置的用户设为管理员
+// 这样即使忘了删掉配置，也不会在之后的重启中把权限重新授予这个用户
+func bootstrapAdmin(name string, adminRole *model.Role) error {
+	count, err := dao.CountRoleUsers(adminRole.ID)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		log.Infof("bootstrapAdmin|admin already exists, skip bootstrap of %s", name)
+		return nil
+	}
+
+	user, err := dao.GetUserByName(name)
+	if err != nil {
+		return err
+	}
+	// 用户还没注册时只打日志，注册之后重启即可
+	if user == nil {
+		log.Warnf("bootstrapAdmin|user %s not registered, skip", name)
+		return nil
+	}
+
+	if err := dao.AssignRole(user.ID, adminRole.ID, "bootstrap"); err != nil {
+		return err
+	}
+	cache.GetUserCache().DelPermissions(user.ID)
+	log.Infof("bootstrapAdmin|user %s is now admin", name)
+	return nil
+}
+
+// 查询用户拥有的权限，优先从缓存取
+func userPermissions(user *model.User) ([]string, error) {
+	if perms, err := cache.GetUserCache().GetPermissions(user.ID); err == nil {
+		return perms, nil
+	}
+
+	perms, err := dao.GetUserPermissions(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if err := cache.GetUserCache().SetPermissions(user.ID, perms); err != nil {
+		log.Errorf("userPermissions|cache permissions failed, user_name=%s|err=%v", user.Name, err)
+	}
+	return perms, nil
+}
+
+// 查询用户拥有的角色名，优先从缓存取
+func userRoleNames(user *model.User) ([]string, error) {
+	if roles, err := cache.GetUserCache().GetRoles(user.ID); err == nil {
+		return roles, nil
+	}
+
+	roles, err := dao.GetUserRoles(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(roles))
+	for _, role := range roles {
+		names = append(names, role.Name)
+	}
+	if err := cache.GetUserCache().SetRoles(user.ID, names); err != nil {
+		log.Errorf("userRoleNames|cache roles failed, user_name=%s|err=%v", user.Name, err)
+	}
+	return names, nil
+}
+
+// ListRoles 获取全部角色
+func ListRoles(ctx context.Context) ([]*RoleInfo, error) {
+	roles, err := dao.ListRoles()
+	if err != nil {
+		return nil, fmt.Errorf("ListRoles|%v", err)
+	}
+	infos := make([]*RoleInfo, 0, len(roles))
+	for _, role := range roles {
+		infos = append(infos, &RoleInfo{Name: role.Name, Description: role.Description})
+	}
+	return infos, nil
+}
+
+// AssignUserRole 给用户分配角色
+func AssignUserRole(ctx context.Context, req *AssignRoleRequest) error {
+	uuid := ctx.Value(constant.ReqUuid)
+	operator, err := currentUser(ctx)
+	if err != nil {
+		return fmt.Errorf("AssignUserRole|%v", err)
+	}
+
+	user, role, err := getUserAndRole(req.UserID, req.Role)
+	if err != nil {
+		return fmt.Errorf("AssignUserRole|%v", err)
+	}
+
+	if err := dao.AssignRole(user.ID, role.ID, operator.Name); err != nil {
+		return fmt.Errorf("AssignUserRole|%v", err)
+	}
+
+	// 权限缓存失效，下一次鉴权时重新查询
+	cache.GetUserCache().DelPermissions(user.ID)
+	log.Infof("%s|AssignUserRole|%s assigned role %s to %s", uuid, operator.Name, role.Name, user.Name)
+	return nil
+}
+
+// RemoveUserRole 回收用户的角色，不允许回收最后一个管理员的管理员角色
+func RemoveUserRole(ctx context.Context, req *AssignRoleRequest) error {
+	uuid := ctx.Value(constant.ReqUuid)
+	operator, err := currentUser(ctx)
+	if err != nil {
+		return fmt.Errorf("RemoveUserRole|%v", err)
+	}
+
+	user, role, err := getUserAndRole(req.UserID, req.Role)
+	if err != nil {
+		return fmt.Errorf("RemoveUserRole|%v", err)
+	}
+
+	if role.Name == authz.RoleAdmin {
+		count, err := dao.CountRoleUsers(role.ID)
+		if err != nil {
+			return fmt.Errorf("RemoveUserRole|%v", err)
+		}
+		if count <= 1 {
+			return fmt.Errorf("RemoveUserRole|can not remove the last admin")
+		}
+	}
+
+	affected, err := dao.RemoveRole(user.ID, role.ID)
+	if err != nil {
+		return fmt.Errorf("RemoveUserRole|%v", err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("RemoveUserRole|user %s does not have role %s", user.Name, role.Name)
+	}
+
+	cache.GetUserCache().DelPermissions(user.ID)
+	log.Infof("%s|RemoveUserRole|%s removed role %s from %s", uuid, operator.Name, role.Name, user.Name)
+	return nil
+}
+
+// 根据用户 ID 和角色名查出用户和角色，任何一个不存在都返回错误
+func getUserAndRole(userID int, roleName string) (*model.User, *model.Role, error) {
+	user, err := dao.GetUserByID(userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if user == nil {
+		return nil, nil, fmt.Errorf("user %d not found", userID)
+	}
+
+	role, err := dao.GetRoleByName(roleName)
+	if err != nil {
+		return nil, nil, err
+	}
+	if role == nil {
+		return nil, nil, fmt.Errorf("role %s not found", roleName)
+	}
+	return user, role, nil
+}
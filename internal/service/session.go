@@ -0,0 +1,105 @@
+package service
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+	"gouse/config"
+	"gouse/internal/cache"
+	"gouse/pkg/constant"
+	"time"
+)
+
+// 会话有效期的默认值，配置文件没有填写时使用
+const (
+	defaultSessionIdleTimeout             = 7200           // 秒
+	defaultSessionAbsoluteTimeout         = 24 * 3600      // 秒
+	defaultSessionRememberIdleTimeout     = 14 * 24 * 3600 // 秒
+	defaultSessionRememberAbsoluteTimeout = 30 * 24 * 3600 // 秒
+)
+
+// 按是否勾选"记住我"返回会话多久不访问就过期，以及从登录算起的最长有效期
+// 没有配置 session.idle_timeout 时沿用旧的 cache.session_expired
+func sessionTimeouts(remember bool) (time.Duration, time.Duration) {
+	conf := config.GetGlobalConf()
+	idle := orDefault(conf.Session.IdleTimeout, orDefault(conf.Cache.SessionExpired, defaultSessionIdleTimeout))
+	absolute := orDefault(conf.Session.AbsoluteTimeout, defaultSessionAbsoluteTimeout)
+	if remember {
+		idle = orDefault(conf.Session.RememberIdleTimeout, defaultSessionRememberIdleTimeout)
+		absolute = orDefault(conf.Session.RememberAbsoluteTimeout, defaultSessionRememberAbsoluteTimeout)
+	}
+	// 最长有效期比续期时长还短时，以最长有效期为准
+	if absolute < idle {
+		idle = absolute
+	}
+	return time.Duration(idle) * time.Second, time.Duration(absolute) * time.Second
+}
+
+// ListSessions 列出当前用户在各个设备上的登录会话
+func ListSessions(ctx context.Context) ([]*SessionInfo, error) {
+	session, _ := ctx.Value(constant.SessionKey).(string)
+	user, err := currentUser(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("ListSessions|%v", err)
+	}
+
+	metas, err := cache.GetSessionStore().List(user.Name)
+	if err != nil {
+		return nil, fmt.Errorf("ListSessions|%v", err)
+	}
+	current := ""
+	if session != "" {
+		current = cache.SessionID(session)
+	}
+	sessions := make([]*SessionInfo, 0, len(metas))
+	for _, meta := range metas {
+		sessions = append(sessions, &SessionInfo{
+			ID:        meta.ID,
+			Device:    meta.Device,
+			UserAgent: meta.UserAgent,
+			IP:        meta.IP,
+			LastIP:    meta.LastIP,
+			CreatedAt: time.Unix(meta.CreatedAt, 0),
+			LastSeen:  time.Unix(meta.LastSeen, 0),
+			Current:   meta.ID == current,
+		})
+	}
+	return sessions, nil
+}
+
+// RevokeSession 让当前用户的某个会话下线，返回被下线的是不是当前会话
+func RevokeSession(ctx context.Context, id string) (bool, error) {
+	uuid := ctx.Value(constant.ReqUuid)
+	session, _ := ctx.Value(constant.SessionKey).(string)
+	user, err := currentUser(ctx)
+	if err != nil {
+		return false, fmt.Errorf("RevokeSession|%v", err)
+	}
+
+	deleted, err := cache.GetSessionStore().DeleteByID(user.Name, id)
+	if err != nil {
+		return false, fmt.Errorf("RevokeSession|%v", err)
+	}
+	if !deleted {
+		return false, fmt.Errorf("RevokeSession|%w", ErrSessionNotFound)
+	}
+	log.Infof("%s|RevokeSession|session revoked, user_name=%s|session_id=%s", uuid, user.Name, id)
+	return session != "" && cache.SessionID(session) == id, nil
+}
+
+// LogoutEverywhere 让当前用户在所有设备上下线，包括当前会话和全部 token
+func LogoutEverywhere(ctx context.Context) error {
+	uuid := ctx.Value(constant.ReqUuid)
+	user, err := currentUser(ctx)
+	if err != nil {
+		return fmt.Errorf("LogoutEverywhere|%v", err)
+	}
+
+	if err := cache.GetSessionStore().DeleteUserSessions(user.Name, ""); err != nil {
+		log.Errorf("%s|LogoutEverywhere|DeleteUserSessions failed, user_name=%s|err=%v", uuid, user.Name, err)
+		return fmt.Errorf("LogoutEverywhere|%v", err)
+	}
+	revokeUserTokens(uuid, user.Name, "")
+	log.Infof("%s|LogoutEverywhere|all sessions revoked, user_name=%s", uuid, user.Name)
+	return nil
+}
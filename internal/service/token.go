@@ -0,0 +1,289 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+	"gouse/config"
+	"gouse/internal/cache"
+	"gouse/internal/model"
+	"gouse/pkg/constant"
+	"gouse/pkg/jwtauth"
+	"gouse/utils"
+	"strconv"
+	"time"
+)
+
+// 登录凭证模式
+const (
+	authModeSession = "session" // 只使用 cookie 会话
+	authModeJWT     = "jwt"     // 只使用 access token + refresh token
+	authModeBoth    = "both"    // 两种都发放
+)
+
+// token 配置的默认值
+const (
+	defaultTokenIssuer         = "gouse"
+	defaultAccessTokenExpired  = 900     // 秒
+	defaultRefreshTokenExpired = 2592000 // 秒
+
+	refreshTokenBytes = 32
+	tokenFamilyBytes  = 16
+)
+
+// 早期版本 conf/app.yml 里附带的示例密钥，所有人都知道它，用它签名等于没有签名
+const placeholderTokenSecret = "change-me-to-a-random-string-of-32-bytes-or-more"
+
+// 签发、校验 access token，InitTokenAuth 之后才可用
+var tokenSigner *jwtauth.Signer
+
+// InitTokenAuth 按配置初始化 token 签名密钥，配置不合法时返回错误，服务不应该继续启动
+func InitTokenAuth() error {
+	conf := config.GetGlobalConf().Auth
+	switch authMode() {
+	case authModeSession:
+		return nil
+	case authModeJWT, authModeBoth:
+	default:
+		return fmt.Errorf("InitTokenAuth|unknown auth mode %q", conf.Mode)
+	}
+
+	keys := make([]jwtauth.Key, 0, len(conf.SigningKeys))
+	for _, k := range conf.SigningKeys {
+		if k.Secret == placeholderTokenSecret {
+			return fmt.Errorf("InitTokenAuth|signing key %q still uses the example secret, replace it with a random string", k.Kid)
+		}
+		keys = append(keys, jwtauth.Key{ID: k.Kid, Secret: []byte(k.Secret)})
+	}
+	issuer := conf.Issuer
+	if issuer == "" {
+		issuer = defaultTokenIssuer
+	}
+	signer, err := jwtauth.NewSigner(issuer, conf.ActiveKey, keys)
+	if err != nil {
+		return fmt.Errorf("InitTokenAuth|%v", err)
+	}
+	tokenSigner = signer
+	log.Infof("InitTokenAuth|token auth enabled, mode=%s|active_key=%s", authMode(), conf.ActiveKey)
+	return nil
+}
+
+// 配置的登录凭证模式，没有配置时是 session
+func authMode() string {
+	if mode := config.GetGlobalConf().Auth.Mode; mode != "" {
+		return mode
+	}
+	return authModeSession
+}
+
+// TokenAuthEnabled 是否发放和接受 Bearer token
+func TokenAuthEnabled() bool {
+	return tokenSigner != nil && authMode() != authModeSession
+}
+
+// 是否创建 cookie 会话
+func sessionAuthEnabled() bool {
+	return authMode() != authModeJWT
+}
+
+// 所有校验都通过之后完成登录：按配置创建 cookie 会话、签发 token
+// remember 为 true 时会话使用"记住我"的有效期
+func completeLogin(ctx context.Context, user *model.User, remember bool) (*LoginResult, error) {
+	uuid := ctx.Value(constant.ReqUuid)
+	// 先确定是否需要修改密码，标记会随用户信息一起保存到会话里
+	required, err := passwordChangeRequired(uuid, user)
+	if err != nil {
+		log.Errorf("%s|completeLogin|check password expiry failed, user_name=%s|err=%v", uuid, user.Name, err)
+		return nil, err
+	}
+	result := &LoginResult{PasswordChangeRequired: required}
+	if sessionAuthEnabled() {
+		session, expired, err := createSession(ctx, user, remember)
+		if err != nil {
+			return nil, err
+		}
+		result.Session, result.SessionExpired = session, expired
+	}
+	if TokenAuthEnabled() {
+		family, err := utils.RandomToken(tokenFamilyBytes)
+		if err != nil {
+			return nil, fmt.Errorf("generate token family err:%v", err)
+		}
+		tokens, err := issueTokens(user, family)
+		if err != nil {
+			log.Errorf("%s|completeLogin|issueTokens failed, user_name=%s|err=%v", uuid, user.Name, err)
+			return nil, err
+		}
+		result.TokenResponse = *tokens
+	}
+	return result, nil
+}
+
+// 签发一对 access token 和 refresh token，refresh token 属于 family 这个家族
+func issueTokens(user *model.User, family string) (*TokenResponse, error) {
+	conf := config.GetGlobalConf().Auth
+	accessExpired := time.Duration(orDefault(conf.AccessTokenExpired, defaultAccessTokenExpired)) * time.Second
+	refreshExpired := time.Duration(orDefault(conf.RefreshTokenExpired, defaultRefreshTokenExpired)) * time.Second
+
+	jti, err := utils.RandomToken(tokenFamilyBytes)
+	if err != nil {
+		return nil, fmt.Errorf("generate jti err:%v", err)
+	}
+	now := time.Now()
+	accessToken, err := tokenSigner.Sign(&jwtauth.Claims{
+		Name:      user.Name,
+		SessionID: family,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   strconv.Itoa(user.ID),
+			ID:        jti,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(accessExpired)),
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("sign access token err:%v", err)
+	}
+
+	// refresh token 是不透明的随机串，Redis 里只保存它的哈希
+	refreshToken, err := utils.RandomToken(refreshTokenBytes)
+	if err != nil {
+		return nil, fmt.Errorf("generate refresh token err:%v", err)
+	}
+	if err := cache.GetRefreshTokenStore().Add(user.Name, family, utils.Sha256String(refreshToken), refreshExpired); err != nil {
+		return nil, err
+	}
+
+	return &TokenResponse{
+		TokenType:    "Bearer",
+		AccessToken:  accessToken,
+		ExpiresIn:    int(accessExpired.Seconds()),
+		RefreshToken: refreshToken,
+	}, nil
+}
+
+// RefreshToken 用 refresh token 换发新的 access token 和 refresh token
+// 旧的 refresh token 立即作废；已经作废的 refresh token 再次出现时，吊销它所在的整个家族
+func RefreshToken(ctx context.Context, req *RefreshTokenRequest) (*TokenResponse, error) {
+	uuid := ctx.Value(constant.ReqUuid)
+	if !TokenAuthEnabled() {
+		return nil, fmt.Errorf("RefreshToken|token auth is not enabled")
+	}
+	if req.RefreshToken == "" {
+		return nil, fmt.Errorf("RefreshToken|request params invalid")
+	}
+
+	tokenHash := utils.Sha256String(req.RefreshToken)
+	record, err := cache.GetRefreshTokenStore().Get(tokenHash)
+	if errors.Is(err, cache.ErrTokenNotFound) {
+		return nil, fmt.Errorf("RefreshToken|%w", ErrInvalidToken)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("RefreshToken|%v", err)
+	}
+
+	first, err := cache.GetRefreshTokenStore().MarkUsed(tokenHash)
+	if errors.Is(err, cache.ErrTokenNotFound) {
+		return nil, fmt.Errorf("RefreshToken|%w", ErrInvalidToken)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("RefreshToken|%v", err)
+	}
+	if !first {
+		log.Warnf("%s|RefreshToken|refresh token reused, revoke family, user_name=%s", uuid, record.Name)
+		if err := cache.GetRefreshTokenStore().RevokeFamily(record.Name, record.Family); err != nil {
+			log.Errorf("%s|RefreshToken|RevokeFamily failed, user_name=%s|err=%v", uuid, record.Name, err)
+		}
+		return nil, fmt.Errorf("RefreshToken|%w", ErrInvalidToken)
+	}
+
+	// 家族已经被吊销（登出、修改密码、被禁用等），旧的 refresh token 不能再换发
+	if _, err := cache.GetRefreshTokenStore().GetFamily(record.Family); err != nil {
+		if errors.Is(err, cache.ErrTokenNotFound) {
+			return nil, fmt.Errorf("RefreshToken|%w", ErrInvalidToken)
+		}
+		return nil, fmt.Errorf("RefreshToken|%v", err)
+	}
+
+	user, err := getUserInfo(record.Name)
+	if err != nil {
+		return nil, fmt.Errorf("RefreshToken|%v", err)
+	}
+	if user.Status == constant.UserStatusDisabled {
+		return nil, fmt.Errorf("RefreshToken|%w", ErrUserDisabled)
+	}
+	// 密码在 token 有效期内到期的，打上必须修改密码的标记，换发出来的 access token 只能用来修改密码
+	if _, err := passwordChangeRequired(uuid, user); err != nil {
+		return nil, fmt.Errorf("RefreshToken|%v", err)
+	}
+
+	tokens, err := issueTokens(user, record.Family)
+	if err != nil {
+		return nil, fmt.Errorf("RefreshToken|%v", err)
+	}
+	log.Infof("%s|RefreshToken|token refreshed, user_name=%s", uuid, user.Name)
+	return tokens, nil
+}
+
+// RevokeToken 吊销 refresh token 所在的家族，用于不带 cookie 的客户端登出
+// token 不存在时也返回成功，和 RFC 7009 的行为一致
+func RevokeToken(ctx context.Context, req *RefreshTokenRequest) error {
+	uuid := ctx.Value(constant.ReqUuid)
+	if req.RefreshToken == "" {
+		return fmt.Errorf("RevokeToken|request params invalid")
+	}
+
+	record, err := cache.GetRefreshTokenStore().Get(utils.Sha256String(req.RefreshToken))
+	if errors.Is(err, cache.ErrTokenNotFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("RevokeToken|%v", err)
+	}
+	if err := cache.GetRefreshTokenStore().RevokeFamily(record.Name, record.Family); err != nil {
+		return fmt.Errorf("RevokeToken|%v", err)
+	}
+	log.Infof("%s|RevokeToken|token revoked, user_name=%s", uuid, record.Name)
+	return nil
+}
+
+// AuthenticateAccessToken 校验 Bearer token，返回 token 对应的用户和 token 所属的家族
+// 除了签名和有效期，还要求家族没有被吊销，这样登出、修改密码之后 access token 立即失效；
+// 密码超过了最长使用时间的，返回的用户带上必须修改密码的标记
+func AuthenticateAccessToken(accessToken string) (*model.User, string, error) {
+	if !TokenAuthEnabled() {
+		return nil, "", ErrInvalidToken
+	}
+	claims, err := tokenSigner.Parse(accessToken)
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	name, err := cache.GetRefreshTokenStore().GetFamily(claims.SessionID)
+	if errors.Is(err, cache.ErrTokenNotFound) || (err == nil && name != claims.Name) {
+		return nil, "", fmt.Errorf("%w: token revoked", ErrInvalidToken)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	user, err := getUserInfo(claims.Name)
+	if err != nil {
+		return nil, "", err
+	}
+	if user.Status == constant.UserStatusDisabled {
+		return nil, "", ErrUserDisabled
+	}
+	if _, err := passwordChangeRequired("", user); err != nil {
+		return nil, "", err
+	}
+	return user, claims.SessionID, nil
+}
+
+// 吊销用户除 keep 以外的全部 token 家族
+func revokeUserTokens(uuid interface{}, userName, keep string) {
+	if err := cache.GetRefreshTokenStore().RevokeUserFamilies(userName, keep); err != nil {
+		log.Errorf("%s|revokeUserTokens|RevokeUserFamilies failed, user_name=%s|err=%v", uuid, userName, err)
+	}
+}
@@ -0,0 +1,72 @@
+package service
+
+import (
+	"errors"
+	"gouse/config"
+	"gouse/internal/model"
+	"testing"
+)
+
+func setupTestTokenAuth(t *testing.T) {
+	t.Helper()
+	conf := &config.GlobalConfig{}
+	conf.Auth = config.AuthConf{
+		Mode:        authModeJWT,
+		ActiveKey:   "k1",
+		SigningKeys: []config.SigningKey{{Kid: "k1", Secret: "0123456789abcdef0123456789abcdef"}},
+	}
+	setupTestStores(t, conf)
+	if err := InitTokenAuth(); err != nil {
+		t.Fatalf("InitTokenAuth err: %v", err)
+	}
+}
+
+func TestRefreshToken(t *testing.T) {
+	setupTestTokenAuth(t)
+	user := &model.User{ID: 1, Name: "alice"}
+	cacheTestUser(t, user)
+
+	first, err := issueTokens(user, "family1")
+	if err != nil {
+		t.Fatalf("issueTokens err: %v", err)
+	}
+	if got, family, err := AuthenticateAccessToken(first.AccessToken); err != nil || got.Name != "alice" || family != "family1" {
+		t.Fatalf("AuthenticateAccessToken = %v, %q, %v", got, family, err)
+	}
+
+	// 换发之后旧的 refresh token 作废，新的可以继续用
+	second, err := RefreshToken(authedContext(user, "", ""), &RefreshTokenRequest{RefreshToken: first.RefreshToken})
+	if err != nil {
+		t.Fatalf("RefreshToken err: %v", err)
+	}
+	if second.RefreshToken == first.RefreshToken {
+		t.Fatalf("RefreshToken returned the same refresh token")
+	}
+
+	// 旧的 refresh token 再次出现说明被盗用了，整个家族吊销，新签发的 token 也失效
+	if _, err := RefreshToken(authedContext(user, "", ""), &RefreshTokenRequest{RefreshToken: first.RefreshToken}); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("RefreshToken(reused) err = %v, want ErrInvalidToken", err)
+	}
+	if _, err := RefreshToken(authedContext(user, "", ""), &RefreshTokenRequest{RefreshToken: second.RefreshToken}); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("RefreshToken(after reuse) err = %v, want ErrInvalidToken", err)
+	}
+	if _, _, err := AuthenticateAccessToken(second.AccessToken); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("AuthenticateAccessToken(after reuse) err = %v, want ErrInvalidToken", err)
+	}
+}
+
+func TestRefreshTokenDisabledUser(t *testing.T) {
+	setupTestTokenAuth(t)
+	user := &model.User{ID: 1, Name: "alice"}
+	cacheTestUser(t, user)
+	tokens, err := issueTokens(user, "family1")
+	if err != nil {
+		t.Fatalf("issueTokens err: %v", err)
+	}
+
+	user.Status = 1
+	cacheTestUser(t, user)
+	if _, err := RefreshToken(authedContext(user, "", ""), &RefreshTokenRequest{RefreshToken: tokens.RefreshToken}); !errors.Is(err, ErrUserDisabled) {
+		t.Errorf("RefreshToken(disabled user) err = %v, want ErrUserDisabled", err)
+	}
+}
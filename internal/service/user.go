@@ -4,15 +4,21 @@ import (
 	"fmt"
 	log "github.com/sirupsen/logrus"
 	"golang.org/x/net/context"
+	"gouse/internal/authz"
 	"gouse/internal/cache"
 	"gouse/internal/dao"
 	"gouse/internal/model"
 	"gouse/pkg/constant"
+	"gouse/pkg/useragent"
 	"gouse/utils"
+	"strings"
+	"time"
 )
 
 // Register 用户注册
-func Register(req *RegisterRequest) error {
+// 填了邮箱的，注册成功后向邮箱发送验证邮件，验证通过之后邮箱才能用来登录和找回密码
+func Register(ctx context.Context, req *RegisterRequest) error {
+	uuid := ctx.Value(constant.ReqUuid)
 	// 对接收到的请求参数 req 进行检查，
 	// 用户名，密码不能为空，年龄不能 <= 0 岁，判断性别是否输入正确（暂时只支持男和女）
 	if req.UserName == "" || req.Password == "" || req.Age <= 0 || !utils.Contains([]string{constant.GenderMale, constant.GenderFeMale}, req.Gender) {
@@ -20,26 +26,48 @@ func Register(req *RegisterRequest) error {
 		return fmt.Errorf("register param invalid")
 	}
 
-	// 调用 dao.GetUserByName(req.UserName) 方法，根据用户名查询数据库，判断用户是否已经存在
-	existedUser, err := dao.GetUserByName(req.UserName)
+	// 用户名不能带 @，否则登录时和邮箱分不清
+	if strings.Contains(req.UserName, "@") {
+		log.Errorf("register user name invalid: %s", req.UserName)
+		return fmt.Errorf("register param invalid: user_name can not contain @")
+	}
+
+	// 邮箱选填，填了就要是合法的地址，并且没有被其他用户验证占用
+	// 注册时的邮箱先放在 pending_email 里，验证通过后才写入唯一的 email 列，填了别人的邮箱也占不住这个地址
+	email := ""
+	if req.Email != "" {
+		var err error
+		if email, err = normalizeEmail(req.Email); err != nil {
+			log.Errorf("register email invalid: %s", req.Email)
+			return fmt.Errorf("register|%w", err)
+		}
+		if err := checkEmailAvailable(email, 0); err != nil {
+			log.Errorf("Register|%v", err)
+			return fmt.Errorf("register|%w", err)
+		}
+	}
+
+	// 调用 dao.UserNameExists(req.UserName) 方法，根据用户名查询数据库，判断用户是否已经存在
+	// 已经被删除的用户也算，删除后的用户名不能被重新注册
+	existed, err := dao.UserNameExists(req.UserName)
 	// 如果查询时出现错误:
 	if err != nil {
 		log.Errorf("Register|%v", err)
 		return fmt.Errorf("register|%v", err)
 	}
 	// 如果该用户已经存在:
-	if existedUser != nil {
+	if existed {
 		log.Errorf("用户已经注册,user_name==%s", req.UserName)
 		return fmt.Errorf("用户已经注册，不能重复注册")
 	}
 
 	// 创建一个用户对象，包含相应的属性
 	user := &model.User{
-		Name:     req.UserName,
-		Age:      req.Age,
-		Gender:   req.Gender,
-		PassWord: req.Password,
-		NickName: req.NickName,
+		Name:         req.UserName,
+		Age:          req.Age,
+		Gender:       req.Gender,
+		NickName:     req.NickName,
+		PendingEmail: email,
 
 		CreateModel: model.CreateModel{
 			Creator: req.UserName,
@@ -49,6 +77,22 @@ func Register(req *RegisterRequest) error {
 		},
 	}
 
+	// 密码要符合密码策略，不符合时返回违反的全部规则
+	if err := checkPasswordPolicy(req.Password, user); err != nil {
+		log.Errorf("Register|%v", err)
+		return fmt.Errorf("register|%w", err)
+	}
+
+	// 密码只保存哈希，不保存明文
+	encoded, err := hashPassword(req.Password)
+	if err != nil {
+		log.Errorf("Register|hash password err:%v", err)
+		return fmt.Errorf("register|hash password err:%v", err)
+	}
+	user.PassWord = encoded
+	now := time.Now()
+	user.PasswordChangedAt = &now
+
 	// 打印日志信息
 	log.Infof("user ====== %+v", user)
 
@@ -58,73 +102,182 @@ func Register(req *RegisterRequest) error {
 		log.Errorf("Register|%v", err)
 		return fmt.Errorf("register|%v", err)
 	}
+	recordPasswordHistory(uuid, user, encoded)
+
+	// 验证邮件发送失败不影响注册，用户登录后可以重新发送
+	if email != "" {
+		if err := sendEmailVerification(uuid, user.Name, email, false); err != nil {
+			log.Errorf("%s|Register|send verification mail failed, user_name=%s|err=%v", uuid, user.Name, err)
+		}
+	}
 
 	// 注册成功，返回 nil
 	return nil
 }
 
 // Login 用户登陆
-func Login(ctx context.Context, req *LoginRequest) (string, error) {
+// 开启了两步验证的用户，密码校验通过后不会马上创建会话，而是返回一个短时间有效的 mfa_token，
+// 客户端带着它和验证码调用 LoginMFA 才算登录成功。
+// 使用临时密码或者密码已经过期的，登录成功后 PasswordChangeRequired 为 true，修改密码之前只能访问修改密码和登出接口
+func Login(ctx context.Context, req *LoginRequest) (*LoginResult, error) {
 	// 从上下文对象中获取请求的唯一标识符(uuid)，并使用 log.Debugf 打印日志表明有用户访问登录功能
 	uuid := ctx.Value(constant.ReqUuid)
-	log.Debugf(" %s| Login access from:%s,@,%s", uuid, req.UserName, req.PassWord)
+	ip, _ := ctx.Value(constant.ClientIPKey).(string)
+	log.Debugf(" %s| Login access from:%s,ip=%s", uuid, req.UserName, ip)
 
-	// 调用 getUserInfo 函数，根据 req.UserName 请求中的用户名获取用户信息（user）
-	user, err := getUserInfo(req.UserName)
+	// 校验密码必须从数据库取用户信息，缓存里的用户信息不包含密码哈希
+	// 登录账号可以是用户名，也可以是验证过的邮箱
+	user, err := findLoginUser(req.UserName)
 	if err != nil {
 		log.Errorf("Login|%v", err)
-		return "", fmt.Errorf("login|%v", err)
+		return nil, fmt.Errorf("login|%v", err)
 	}
 
-	// 用户存在，比对输入的密码和用户密码是否一致
-	if req.PassWord != user.PassWord {
-		log.Errorf("Login|password err: req.password=%s|user.password=%s", req.PassWord, user.PassWord)
-		return "", fmt.Errorf("password is not correct")
+	// 失败次数按用户名统计，用邮箱登录和用用户名登录共用一个计数
+	subject := req.UserName
+	if user != nil {
+		subject = user.Name
 	}
 
-	// 如果密码匹配成功，调用 utils.GenerateSession 函数生成一个新的 session 字符串
-	session := utils.GenerateSession(user.Name)
+	// 用户名或者 IP 因为连续失败被锁定时，直接拒绝，不再校验密码
+	if err := checkLoginLock(subject, ip); err != nil {
+		log.Warnf("%s|Login|locked, user_name=%s|ip=%s|err=%v", uuid, subject, ip, err)
+		return nil, fmt.Errorf("login|%w", err)
+	}
+	if user == nil {
+		log.Errorf("Login|user %s not registered", req.UserName)
+		recordLoginFailure(uuid, subject, ip)
+		return nil, fmt.Errorf("login|用户尚未注册")
+	}
 
-	// 并调用 cache.SetSessionInfo 函数将用户信息和 session 存储到缓存中
-	err = cache.SetSessionInfo(user, session)
+	// 用户存在，校验输入的密码和存储的密码哈希是否匹配
+	match, needsRehash, err := verifyPassword(req.PassWord, user.PassWord)
 	if err != nil {
-		log.Errorf(" Login|Failed to SetSessionInfo, uuid=%s|user_name=%s|session=%s|err=%v", uuid, user.Name, session, err)
-		return "", fmt.Errorf("login|SetSessionInfo fail:%v", err)
+		log.Errorf("Login|verify password err:%v", err)
+		return nil, fmt.Errorf("login|verify password err:%v", err)
+	}
+	if !match {
+		log.Errorf("%s|Login|password not match, user_name=%s", uuid, user.Name)
+		recordLoginFailure(uuid, user.Name, ip)
+		return nil, fmt.Errorf("password is not correct")
+	}
+
+	// 被管理员禁用的账号不能登录
+	if user.Status == constant.UserStatusDisabled {
+		log.Errorf("%s|Login|user %s is disabled", uuid, user.Name)
+		return nil, fmt.Errorf("login|%w", ErrUserDisabled)
+	}
+
+	// 管理员设置的临时密码过了有效期就不能再用了，只能让管理员重新设置或者通过找回密码重置
+	if tempPasswordExpired(user) {
+		log.Errorf("%s|Login|temporary password expired, user_name=%s", uuid, user.Name)
+		return nil, fmt.Errorf("login|%w", ErrPasswordExpired)
 	}
 
-	// 最后，使用 log.Infof 打印登录成功的日志，并返回生成的 session 字符串作为登录成功的标识
-	log.Infof("Login successfully, %s@%s with redis_session session_%s", req.UserName, req.PassWord, session)
-	return session, nil
+	// 库里存的还是明文或者弱哈希，趁着拿到明文密码的机会升级一下
+	if needsRehash {
+		upgradePasswordHash(uuid, user, req.PassWord)
+	}
+
+	// 开启了两步验证的，先不创建会话，返回 mfa_token 等用户输入验证码
+	result, err := finishFirstFactor(ctx, user, req.RememberMe)
+	if err != nil {
+		return nil, fmt.Errorf("login|%v", err)
+	}
+	if result.MFARequired {
+		return result, nil
+	}
+
+	// 最后，使用 log.Infof 打印登录成功的日志，并返回生成的 session 和 token 作为登录成功的标识
+	log.Infof("Login successfully, %s with session_id=%s", user.Name, sessionLogID(result.Session))
+	return result, nil
+}
+
+// 登录校验全部通过后创建会话
+func createSession(ctx context.Context, user *model.User, remember bool) (string, time.Duration, error) {
+	uuid := ctx.Value(constant.ReqUuid)
+	// 调用 utils.GenerateSession 函数生成一个新的随机 session 字符串
+	session, err := utils.GenerateSession()
+	if err != nil {
+		log.Errorf("%s|createSession|Failed to GenerateSession, user_name=%s|err=%v", uuid, user.Name, err)
+		return "", 0, fmt.Errorf("GenerateSession fail:%v", err)
+	}
+
+	// 并把用户信息和 session 存到会话存储里，同时记下登录的设备和 IP
+	ip, _ := ctx.Value(constant.ClientIPKey).(string)
+	ua, _ := ctx.Value(constant.UserAgentKey).(string)
+	// 会话先按续期时长保存，之后每次访问再续期，但不超过从现在算起的最长有效期
+	idle, absolute := sessionTimeouts(remember)
+	meta := &cache.SessionMeta{
+		Device:      useragent.Describe(ua),
+		UserAgent:   ua,
+		IP:          ip,
+		Remember:    remember,
+		IdleTimeout: int64(idle.Seconds()),
+		ExpiresAt:   time.Now().Add(absolute).Unix(),
+	}
+	err = cache.GetSessionStore().Create(user, session, meta, idle)
+	if err != nil {
+		log.Errorf("%s|createSession|Failed to CreateSession|user_name=%s|session_id=%s|err=%v", uuid, user.Name, sessionLogID(session), err)
+		return "", 0, fmt.Errorf("CreateSession fail:%v", err)
+	}
+	return session, idle, nil
 }
 
 // Logout 退出登陆
 func Logout(ctx context.Context, req *LogoutRequest) error {
-	// 从上下文中获取请求的唯一标识 uuid 和会话标识 session，以及解析请求的 UserName
+	// 从上下文中获取请求的唯一标识 uuid 和会话标识 session，以及认证中间件校验过的当前用户
 	uuid := ctx.Value(constant.ReqUuid)
-	session := ctx.Value(constant.SessionKey).(string)
-	log.Infof("%s|Logout access from,user_name=%s|session=%s", uuid, req.UserName, session)
-
-	// 从缓存中获取会话信息，用于验证用户是否处于登录状态
-	_, err := cache.GetSessionInfo(session)
+	session, _ := ctx.Value(constant.SessionKey).(string)
+	user, err := currentUser(ctx)
 	if err != nil {
-		log.Errorf("%s|Failed to get with session=%s|err =%v", uuid, session, err)
-		return fmt.Errorf("Logout|GetSessionInfo err:%v", err)
+		return fmt.Errorf("Logout|%v", err)
+	}
+	log.Infof("%s|Logout access from,user_name=%s|session_id=%s", uuid, user.Name, sessionLogID(session))
+
+	// 通过 Bearer token 登录的，吊销 token 所在的家族
+	if family, _ := ctx.Value(constant.TokenFamilyKey).(string); family != "" {
+		if err := cache.GetRefreshTokenStore().RevokeFamily(user.Name, family); err != nil {
+			log.Errorf("%s|Logout|RevokeFamily failed, user_name=%s|err=%v", uuid, user.Name, err)
+			return fmt.Errorf("revoke token err:%v", err)
+		}
+		log.Infof("%s|Logout|token revoked, user_name=%s", uuid, user.Name)
+		return nil
 	}
 
-	// 从缓存中删除会话信息，表示用户已退出登录
-	err = cache.DelSessionInfo(session)
+	// 从会话存储中删除会话信息，表示用户已退出登录
+	err = cache.GetSessionStore().Delete(session)
 	if err != nil {
-		log.Errorf("%s|Failed to delSessionInfo :%s", uuid, session)
+		log.Errorf("%s|Failed to delSessionInfo, session_id=%s|err=%v", uuid, sessionLogID(session), err)
 		return fmt.Errorf("del session err:%v", err)
 	}
-	log.Infof("%s|Success to delSessionInfo :%s", uuid, session)
+	log.Infof("%s|Success to delSessionInfo, session_id=%s", uuid, sessionLogID(session))
 	return nil
 }
 
+// session 本身就是登录凭证，不能写进日志；日志里记录它的会话 ID（哈希），和会话管理接口返回的 ID 一致
+// 通过 Bearer token、API key 访问时没有 session，返回空串
+func sessionLogID(session string) string {
+	if session == "" {
+		return ""
+	}
+	return cache.SessionID(session)
+}
+
+// 从上下文中取出认证中间件校验过的当前登录用户
+// 需要登录的接口都应该用这里拿到的用户作为调用者身份，而不是请求参数里的用户名
+func currentUser(ctx context.Context) (*model.User, error) {
+	user, ok := ctx.Value(constant.AuthUserKey).(*model.User)
+	if !ok || user == nil {
+		return nil, fmt.Errorf("unauthenticated request")
+	}
+	return user, nil
+}
+
 // 根据 req.UserName 请求中的用户名获取用户信息
 func getUserInfo(userName string) (*model.User, error) {
 	// 通过用户名从缓存中获取用户信息，如果找到就直接返回
-	user, err := cache.GetUserInfoFromCache(userName)
+	user, err := cache.GetUserCache().GetUser(userName)
 	if err == nil && user.Name == userName {
 		log.Infof("cache_user ======= %v", user)
 		return user, nil
@@ -143,7 +296,7 @@ func getUserInfo(userName string) (*model.User, error) {
 	log.Infof("user === %+v", user)
 
 	// 将用户信息存入缓存，这样下一次就能直接从缓存取信息
-	err = cache.SetUserCacheInfo(user)
+	err = cache.GetUserCache().SetUser(user)
 	// 如果存入缓存出错就打印错误日志
 	if err != nil {
 		log.Error("cache userinfo failed for user:", user.Name, " with err:", err.Error())
@@ -156,63 +309,68 @@ func getUserInfo(userName string) (*model.User, error) {
 
 // 从缓存中获取用户信息，只能在用户登陆的情况下使用
 func GetUserInfo(ctx context.Context, req *GetUserInfoRequest) (*GetUserInfoResponse, error) {
-	// 从上下文取到 uuid 和 session 信息
+	// 从上下文取到 uuid、session 和认证中间件校验过的当前用户
 	uuid := ctx.Value(constant.ReqUuid)
-	session := ctx.Value(constant.SessionKey).(string)
-	log.Infof("%s|GetUserInfo access from,user_name=%s|session=%s", uuid, req.UserName, session)
+	session, _ := ctx.Value(constant.SessionKey).(string)
+	user, err := currentUser(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("GetUserInfo|%v", err)
+	}
+	log.Infof("%s|GetUserInfo access from,user_name=%s|session_id=%s", uuid, user.Name, sessionLogID(session))
 
-	// uuid 和 session 不能为空，也就是需要处于登录状态
-	if session == "" || req.UserName == "" {
-		return nil, fmt.Errorf("GetUserInfo|request params invalid")
+	// 请求里没带用户名就是查自己
+	if req.UserName == "" {
+		req.UserName = user.Name
 	}
 
-	// 根据 session 从缓存中获取用户信息
-	user, err := cache.GetSessionInfo(session)
-	if err != nil {
-		log.Errorf("%s|Failed to get with session=%s|err =%v", uuid, session, err)
-		return nil, fmt.Errorf("getUserInfo|GetSessionInfo err:%v", err)
+	// 查别人的资料需要 user:read:any 权限
+	if err := authz.CheckUserAccess(user, req.UserName, authz.PermUserReadAny); err != nil {
+		log.Errorf("%s|GetUserInfo|%s is not allowed to read username=%s", uuid, user.Name, req.UserName)
+		return nil, fmt.Errorf("GetUserInfo|%w", err)
 	}
 
-	// 验证获取到的用户信息和请求的用户名是否相同
-	if user.Name != req.UserName {
-		log.Errorf("%s|session info not match with username=%s", uuid, req.UserName)
+	// 查自己直接用会话里的用户，查别人再去缓存或者数据库取
+	target := user
+	if req.UserName != user.Name {
+		target, err = getUserInfo(req.UserName)
+		if err != nil {
+			log.Errorf("%s|GetUserInfo|getUserInfo err:%v", uuid, err)
+			return nil, fmt.Errorf("GetUserInfo|%v", err)
+		}
 	}
 
-	log.Infof("%s|Succ to GetUserInfo|user_name=%s|session=%s", uuid, req.UserName, session)
+	log.Infof("%s|Succ to GetUserInfo|user_name=%s|session_id=%s", uuid, target.Name, sessionLogID(session))
 
 	// 填好用户信息的返回结构，然后返回
 	return &GetUserInfoResponse{
-		UserName: user.Name,
-		Age:      user.Age,
-		Gender:   user.Gender,
-		PassWord: user.PassWord,
-		NickName: user.NickName,
+		UserName: target.Name,
+		Age:      target.Age,
+		Gender:   target.Gender,
+		NickName: target.NickName,
 	}, nil
 }
 
 // 更改用户信息
 func UpdateUserNickName(ctx context.Context, req *UpdateNickNameRequest) error {
-	// 从上下文取出 uuid 和 session
+	// 从上下文取出 uuid、session 和认证中间件校验过的当前用户
 	uuid := ctx.Value(constant.ReqUuid)
-	session := ctx.Value(constant.SessionKey).(string)
-	log.Infof("%s|UpdateUserNickName access from,user_name=%s|session=%s", uuid, req.UserName, session)
+	session, _ := ctx.Value(constant.SessionKey).(string)
+	user, err := currentUser(ctx)
+	if err != nil {
+		return fmt.Errorf("UpdateUserNickName|%v", err)
+	}
+	log.Infof("%s|UpdateUserNickName access from,user_name=%s|session_id=%s", uuid, user.Name, sessionLogID(session))
 	log.Infof("UpdateUserNickName|req==%v", req)
 
-	// session 和 用户名不能为空，保证处于登录状态
-	if session == "" || req.UserName == "" {
-		return fmt.Errorf("UpdateUserNickName|request params invalid")
+	// 请求里没带用户名就是改自己的
+	if req.UserName == "" {
+		req.UserName = user.Name
 	}
 
-	// 从缓存中获取用户信息
-	user, err := cache.GetSessionInfo(session)
-	if err != nil {
-		log.Errorf("%s|Failed to get with session=%s|err =%v", uuid, session, err)
-		return fmt.Errorf("UpdateUserNickName|GetSessionInfo err:%v", err)
-	}
-
-	// 验证获取到的用户信息和请求的用户名是否相同
-	if user.Name != req.UserName {
-		log.Errorf("UpdateUserNickName|%s|session info not match with username=%s", uuid, req.UserName)
+	// 改别人的资料需要 user:write:any 权限
+	if err := authz.CheckUserAccess(user, req.UserName, authz.PermUserWriteAny); err != nil {
+		log.Errorf("UpdateUserNickName|%s|%s is not allowed to update username=%s", uuid, user.Name, req.UserName)
+		return fmt.Errorf("UpdateUserNickName|%w", err)
 	}
 
 	// 根据请求中的 NickName 构建一个新的用户信息对象
@@ -220,6 +378,11 @@ func UpdateUserNickName(ctx context.Context, req *UpdateNickNameRequest) error {
 		NickName: req.NewNickName,
 	}
 
+	// 改的是别人的资料时，当前的 session 属于调用者，不能用目标用户的信息覆盖它
+	if req.UserName != user.Name {
+		session = ""
+	}
+
 	// 返回这个用户信息更新函数的结果
 	return updateUserInfo(updateUser, req.UserName, session)
 }
@@ -241,18 +404,18 @@ func updateUserInfo(user *model.User, userName, session string) error {
 			// 再将新的用户信息更新到缓存
 			cache.UpdateCachedUserInfo(user)
 
-			// 再把将用户信息与会话字符串存入 Redis 缓存中
+			// 再把会话里保存的用户信息也更新掉
 			if session != "" {
-				err = cache.SetSessionInfo(user, session)
+				err = cache.GetSessionStore().Update(user, session)
 
 				// 如果出错就删除缓存中的会话信息
 				if err != nil {
 					log.Error("update session failed:", err.Error())
-					cache.DelSessionInfo(session)
+					cache.GetSessionStore().Delete(session)
 				}
 			}
 		} else {
-			log.Error("Failed to get dbUserInfo for cache, username=%s with err:", userName, err.Error())
+			log.Errorf("Failed to get dbUserInfo for cache, username=%s with err:%v", userName, err)
 		}
 	}
 	return nil
@@ -0,0 +1,93 @@
+package service
+
+import (
+	"errors"
+	"gouse/internal/authz"
+	"gouse/internal/cache"
+	"gouse/internal/model"
+	"gouse/utils"
+	"testing"
+	"time"
+)
+
+func TestLogoutSession(t *testing.T) {
+	setupTestStores(t, nil)
+	user := &model.User{ID: 1, Name: "alice"}
+	session, err := utils.GenerateSession()
+	if err != nil {
+		t.Fatalf("GenerateSession err: %v", err)
+	}
+	meta := &cache.SessionMeta{IdleTimeout: 3600, ExpiresAt: time.Now().Add(time.Hour).Unix()}
+	if err := cache.GetSessionStore().Create(user, session, meta, time.Hour); err != nil {
+		t.Fatalf("Create err: %v", err)
+	}
+
+	if err := Logout(authedContext(user, session, ""), &LogoutRequest{}); err != nil {
+		t.Fatalf("Logout err: %v", err)
+	}
+	if _, err := cache.GetSessionStore().Get(session); !errors.Is(err, cache.ErrSessionNotFound) {
+		t.Errorf("Get after Logout err = %v, want ErrSessionNotFound", err)
+	}
+}
+
+func TestLogoutToken(t *testing.T) {
+	setupTestStores(t, nil)
+	user := &model.User{ID: 1, Name: "alice"}
+	tokens := cache.GetRefreshTokenStore()
+	if err := tokens.Add(user.Name, "family1", "hash1", time.Hour); err != nil {
+		t.Fatalf("Add err: %v", err)
+	}
+	if err := tokens.Add(user.Name, "family2", "hash2", time.Hour); err != nil {
+		t.Fatalf("Add err: %v", err)
+	}
+
+	// 只吊销当前 token 所在的家族，其他设备上的登录不受影响
+	if err := Logout(authedContext(user, "", "family1"), &LogoutRequest{}); err != nil {
+		t.Fatalf("Logout err: %v", err)
+	}
+	if _, err := tokens.GetFamily("family1"); !errors.Is(err, cache.ErrTokenNotFound) {
+		t.Errorf("GetFamily(family1) after Logout err = %v, want ErrTokenNotFound", err)
+	}
+	if name, err := tokens.GetFamily("family2"); err != nil || name != user.Name {
+		t.Errorf("GetFamily(family2) = %q, %v, want %q, nil", name, err, user.Name)
+	}
+}
+
+func TestGetUserInfo(t *testing.T) {
+	setupTestStores(t, nil)
+	alice := &model.User{ID: 1, Name: "alice", NickName: "Alice", Age: 20}
+	bob := &model.User{ID: 2, Name: "bob", NickName: "Bob", Age: 30}
+	admin := &model.User{ID: 3, Name: "admin"}
+	cacheTestUser(t, alice)
+	cacheTestUser(t, bob)
+	cacheTestUser(t, admin, authz.PermUserReadAny)
+
+	// 不带用户名查自己
+	info, err := GetUserInfo(authedContext(alice, "", ""), &GetUserInfoRequest{})
+	if err != nil || info.UserName != "alice" || info.NickName != "Alice" {
+		t.Errorf("GetUserInfo(self) = %+v, %v", info, err)
+	}
+
+	// 没有权限不能查别人
+	if _, err := GetUserInfo(authedContext(alice, "", ""), &GetUserInfoRequest{UserName: "bob"}); !errors.Is(err, authz.ErrForbidden) {
+		t.Errorf("GetUserInfo(other) err = %v, want ErrForbidden", err)
+	}
+
+	// 有 user:read:any 权限的可以查别人，结果从缓存取
+	info, err = GetUserInfo(authedContext(admin, "", ""), &GetUserInfoRequest{UserName: "bob"})
+	if err != nil || info.UserName != "bob" || info.Age != 30 {
+		t.Errorf("GetUserInfo(other with permission) = %+v, %v", info, err)
+	}
+}
+
+func TestUpdateUserNickNameForbidden(t *testing.T) {
+	setupTestStores(t, nil)
+	alice := &model.User{ID: 1, Name: "alice"}
+	cacheTestUser(t, alice, authz.PermUserReadAny)
+
+	// 只能读不能写，改别人的昵称在访问数据库之前就被拒绝
+	req := &UpdateNickNameRequest{UserName: "bob", NewNickName: "Robert"}
+	if err := UpdateUserNickName(authedContext(alice, "", ""), req); !errors.Is(err, authz.ErrForbidden) {
+		t.Errorf("UpdateUserNickName(other) err = %v, want ErrForbidden", err)
+	}
+}
@@ -7,13 +7,72 @@ const (
 	GenderFeMale = "female"
 )
 
+// 账号状态
+const (
+	UserStatusNormal   = 0 // 正常
+	UserStatusDisabled = 1 // 已被管理员禁用
+)
+
 const (
 	ReqUuid          = "uuid"
 	UserInfoPrefix   = "userinfo_"
 	SessionKeyPrefix = "session_"
+	AuthUserKey      = "auth_user" // 认证中间件把当前登录用户存入上下文时使用的键
+	UserPermPrefix   = "userperm_"
+	UserRolePrefix   = "userrole_"           // 用户 ID -> 用户的角色名列表，和权限列表一起缓存、一起删除
+	UserSessionsKey  = "user_session_index_" // 用户名 -> 该用户全部会话的索引（hash，会话 ID -> 设备、IP、登录时间等）
+
+	PwdResetTokenPrefix    = "pwdreset_token_"    // 重置密码 token 的哈希 -> 用户名
+	PwdResetUserPrefix     = "pwdreset_user_"     // 用户名 -> 当前有效的重置密码 token 的哈希
+	PwdResetThrottlePrefix = "pwdreset_throttle_" // 限制同一个用户申请重置密码的频率
+
+	EmailVerifyTokenPrefix    = "emailverify_token_"    // 验证邮箱 token 的哈希 -> 用户名和待验证的邮箱
+	EmailVerifyUserPrefix     = "emailverify_user_"     // 用户名 -> 当前有效的验证邮箱 token 的哈希
+	EmailVerifyThrottlePrefix = "emailverify_throttle_" // 限制同一个用户发送验证邮件的频率
+
+	MagicLoginPrefix      = "magic_login_"       // 用户名 -> 当前有效的免密码登录（登录链接 token 的哈希、验证码的哈希、输错次数）
+	MagicTokenPrefix      = "magic_token_"       // 登录链接 token 的哈希 -> 用户名
+	MagicThrottlePrefix   = "magic_throttle_"    // 限制同一个用户申请免密码登录的频率
+	MagicIPThrottlePrefix = "magic_ip_throttle_" // 统计同一个 IP、同一个 IP 网段一小时内申请免密码登录的次数
+
+	SMSCodePrefix     = "sms_code_"     // 用途 + 手机号 -> 当前有效的短信验证码的哈希、所属用户、输错次数
+	SMSThrottlePrefix = "sms_throttle_" // 限制同一个号码发送验证码的频率
+	SMSCountPrefix    = "sms_count_"    // 统计同一个号码、同一个 IP 一小时内发送的验证码条数
+
+	LoginFailPrefix      = "loginfail_"      // 统计窗口内的登录失败次数
+	LoginLockPrefix      = "loginlock_"      // 登录锁定标记，过期即解锁
+	LoginLockLevelPrefix = "loginlocklevel_" // 已经被锁定的次数，决定下一次锁定的时长
+
+	MFAPendingPrefix = "mfa_pending_" // 密码已经校验通过、等待输入验证码的登录，token 的哈希 -> 用户名和输错次数
+
+	RefreshTokenPrefix     = "refresh_token_"         // refresh token 的哈希 -> 用户名、所属家族、是否已经用过
+	RefreshFamilyPrefix    = "refresh_family_"        // refresh token 家族（一次登录）-> 用户名，删除即吊销
+	UserRefreshFamiliesKey = "user_refresh_families_" // 用户名 -> 该用户全部 refresh token 家族的索引
+
+	OAuthCodePrefix         = "oauth_code_"          // OAuth 授权码的哈希 -> 授权信息
+	OAuthTokenPrefix        = "oauth_token_"         // OAuth access token 的哈希 -> 授权信息
+	OAuthClientTokensPrefix = "oauth_client_tokens_" // client_id -> 发给这个应用的全部 access token 哈希的索引，删除应用时据此吊销
+
+	OIDCKeyRotationLockKey = "oidc_key_rotation_lock" // 轮换 ID token 签名密钥的锁，同一时间只有一个实例轮换
+	OIDCKeysVersionKey     = "oidc_keys_version"      // 签名密钥的版本号，吊销密钥时加一，各实例据此丢掉内存里缓存的密钥
+)
+
+// 登录保护的统计对象
+const (
+	LoginSubjectUser = "user_"
+	LoginSubjectIP   = "ip_"
 )
 
 const (
-	SessionKey   = "user_session"
-	CookieExpire = 3600
+	ClientIPKey    = "client_ip"    // 客户端 IP 在上下文中的键
+	TokenFamilyKey = "token_family" // 通过 Bearer token 认证时，token 所属的 refresh token 家族在上下文中的键
+	APIKeyIDKey    = "api_key_id"   // 通过 API key 认证时，key 的 ID 在上下文中的键
+	UserAgentKey   = "user_agent"   // 客户端 User-Agent 在上下文中的键
+)
+
+const (
+	SessionKey = "user_session"
+
+	CSRFCookie = "csrf_token"   // 防 CSRF 的 token 所在的 cookie，页面脚本读取它放进请求头
+	CSRFHeader = "X-CSRF-Token" // 修改数据的请求带回 token 的请求头
 )
@@ -0,0 +1,43 @@
+package jwtauth
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"math/big"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// JWK RSA 公钥的 JSON Web Key 表示（RFC 7517），用于对外公布校验 ID token 的公钥
+type JWK struct {
+	Kty string `json:"kty"` // 固定为 RSA
+	Use string `json:"use"` // 固定为 sig
+	Alg string `json:"alg"` // 固定为 RS256
+	Kid string `json:"kid"`
+	N   string `json:"n"` // 模数，base64url 编码
+	E   string `json:"e"` // 指数，base64url 编码
+}
+
+// JWKS 公钥集合
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// RSAPublicJWK 把 RSA 公钥转换成 JWK
+func RSAPublicJWK(kid string, pub *rsa.PublicKey) JWK {
+	return JWK{
+		Kty: "RSA",
+		Use: "sig",
+		Alg: jwt.SigningMethodRS256.Alg(),
+		Kid: kid,
+		N:   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+	}
+}
+
+// SignRS256 用 RSA 私钥签发 token，kid 写进 header，接收方据此在 JWKS 里找到对应的公钥
+func SignRS256(key *rsa.PrivateKey, kid string, claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = kid
+	return token.SignedString(key)
+}
@@ -0,0 +1,101 @@
+package jwtauth
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// 签发和校验 HS256 签名的 JWT access token
+//
+// 支持同时配置多把密钥：签发时使用当前密钥，并把它的 kid 写进 header；校验时按 header 里的 kid 选择密钥。
+// 轮换密钥时先加入新密钥并设为当前密钥，等旧 token 全部过期后再删除旧密钥即可，已经签发的 token 不受影响。
+
+// 密钥至少 32 字节，和 HS256 的输出长度一致
+const minSecretLen = 32
+
+var (
+	// ErrInvalidToken 签名不对、格式错误、签发方不对或者 kid 不认识
+	ErrInvalidToken = errors.New("invalid token")
+	// ErrExpiredToken token 已经过期
+	ErrExpiredToken = errors.New("token is expired")
+)
+
+// Key 签名密钥
+type Key struct {
+	ID     string // kid
+	Secret []byte
+}
+
+// Claims access token 里的声明
+type Claims struct {
+	Name      string `json:"name"` // 用户名
+	SessionID string `json:"sid"`  // 所属的登录（refresh token 家族），服务端据此判断 token 是否已被吊销
+	jwt.RegisteredClaims
+}
+
+// Signer 签发、校验 token
+type Signer struct {
+	issuer string
+	active string
+	keys   map[string][]byte
+}
+
+// NewSigner 创建 Signer，active 是签发新 token 使用的 kid
+func NewSigner(issuer, active string, keys []Key) (*Signer, error) {
+	s := &Signer{issuer: issuer, active: active, keys: make(map[string][]byte, len(keys))}
+	for _, k := range keys {
+		if k.ID == "" {
+			return nil, fmt.Errorf("signing key without kid")
+		}
+		if len(k.Secret) < minSecretLen {
+			return nil, fmt.Errorf("signing key %s is shorter than %d bytes", k.ID, minSecretLen)
+		}
+		if _, ok := s.keys[k.ID]; ok {
+			return nil, fmt.Errorf("duplicate signing key %s", k.ID)
+		}
+		s.keys[k.ID] = k.Secret
+	}
+	if _, ok := s.keys[active]; !ok {
+		return nil, fmt.Errorf("active signing key %q not configured", active)
+	}
+	return s, nil
+}
+
+// Sign 签发 token，Issuer 由 Signer 填写
+func (s *Signer) Sign(claims *Claims) (string, error) {
+	claims.Issuer = s.issuer
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	token.Header["kid"] = s.active
+	return token.SignedString(s.keys[s.active])
+}
+
+// Parse 校验 token 并返回其中的声明
+// 只接受 HS256，必须带有过期时间，签发方必须是自己
+func (s *Signer) Parse(tokenString string) (*Claims, error) {
+	claims := &Claims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, s.keyFunc,
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithIssuer(s.issuer),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(5*time.Second),
+	)
+	if errors.Is(err, jwt.ErrTokenExpired) {
+		return nil, ErrExpiredToken
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	return claims, nil
+}
+
+func (s *Signer) keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	secret, ok := s.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown kid %q", kid)
+	}
+	return secret, nil
+}
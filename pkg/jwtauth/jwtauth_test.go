@@ -0,0 +1,168 @@
+package jwtauth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	secretA = []byte(strings.Repeat("a", minSecretLen))
+	secretB = []byte(strings.Repeat("b", minSecretLen))
+)
+
+func newClaims(expiresIn time.Duration) *Claims {
+	now := time.Now()
+	return &Claims{
+		Name:      "alice",
+		SessionID: "family-1",
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   "1",
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(expiresIn)),
+		},
+	}
+}
+
+func mustSigner(t *testing.T, issuer, active string, keys ...Key) *Signer {
+	t.Helper()
+	s, err := NewSigner(issuer, active, keys)
+	if err != nil {
+		t.Fatalf("NewSigner err: %v", err)
+	}
+	return s
+}
+
+func TestSignAndParse(t *testing.T) {
+	s := mustSigner(t, "gouse", "k1", Key{ID: "k1", Secret: secretA})
+	token, err := s.Sign(newClaims(time.Minute))
+	if err != nil {
+		t.Fatalf("Sign err: %v", err)
+	}
+	claims, err := s.Parse(token)
+	if err != nil {
+		t.Fatalf("Parse err: %v", err)
+	}
+	if claims.Name != "alice" || claims.SessionID != "family-1" || claims.Subject != "1" || claims.Issuer != "gouse" {
+		t.Errorf("claims = %+v", claims)
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	old := mustSigner(t, "gouse", "k1", Key{ID: "k1", Secret: secretA})
+	oldToken, _ := old.Sign(newClaims(time.Minute))
+
+	// 新密钥设为当前密钥，旧密钥还留着：旧 token 仍然有效，新 token 用新密钥签发
+	rotated := mustSigner(t, "gouse", "k2", Key{ID: "k1", Secret: secretA}, Key{ID: "k2", Secret: secretB})
+	if _, err := rotated.Parse(oldToken); err != nil {
+		t.Errorf("Parse(old token) after rotation err: %v", err)
+	}
+	newToken, _ := rotated.Sign(newClaims(time.Minute))
+	if _, err := old.Parse(newToken); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("old signer Parse(new token) err = %v, want ErrInvalidToken", err)
+	}
+
+	// 旧密钥删除之后，旧 token 不再有效
+	dropped := mustSigner(t, "gouse", "k2", Key{ID: "k2", Secret: secretB})
+	if _, err := dropped.Parse(oldToken); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Parse(old token) after drop err = %v, want ErrInvalidToken", err)
+	}
+}
+
+func TestParseRejects(t *testing.T) {
+	s := mustSigner(t, "gouse", "k1", Key{ID: "k1", Secret: secretA})
+	other := mustSigner(t, "other", "k1", Key{ID: "k1", Secret: secretA})
+	forged := mustSigner(t, "gouse", "k1", Key{ID: "k1", Secret: secretB})
+
+	valid, _ := s.Sign(newClaims(time.Minute))
+	expired, _ := s.Sign(newClaims(-time.Minute))
+	wrongIssuer, _ := other.Sign(newClaims(time.Minute))
+	wrongKey, _ := forged.Sign(newClaims(time.Minute))
+
+	noExp := newClaims(time.Minute)
+	noExp.ExpiresAt = nil
+	withoutExp, _ := s.Sign(noExp)
+
+	none := jwt.NewWithClaims(jwt.SigningMethodNone, newClaims(time.Minute))
+	none.Header["kid"] = "k1"
+	algNone, _ := none.SignedString(jwt.UnsafeAllowNoneSignatureType)
+
+	tests := []struct {
+		name  string
+		token string
+		want  error
+	}{
+		{"expired", expired, ErrExpiredToken},
+		{"wrong issuer", wrongIssuer, ErrInvalidToken},
+		{"wrong secret", wrongKey, ErrInvalidToken},
+		{"missing exp", withoutExp, ErrInvalidToken},
+		{"alg none", algNone, ErrInvalidToken},
+		{"tampered", valid[:len(valid)-2] + "xx", ErrInvalidToken},
+		{"garbage", "not.a.token", ErrInvalidToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := s.Parse(tt.token); !errors.Is(err, tt.want) {
+				t.Errorf("Parse err = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewSignerValidatesKeys(t *testing.T) {
+	tests := []struct {
+		name   string
+		active string
+		keys   []Key
+	}{
+		{"missing kid", "k1", []Key{{ID: "", Secret: secretA}}},
+		{"short secret", "k1", []Key{{ID: "k1", Secret: []byte("short")}}},
+		{"duplicate kid", "k1", []Key{{ID: "k1", Secret: secretA}, {ID: "k1", Secret: secretB}}},
+		{"active not configured", "k2", []Key{{ID: "k1", Secret: secretA}}},
+		{"no keys", "k1", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewSigner("gouse", tt.active, tt.keys); err == nil {
+				t.Errorf("NewSigner err = nil")
+			}
+		})
+	}
+}
+
+func TestRS256AndJWK(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey err: %v", err)
+	}
+	token, err := SignRS256(key, "rsa-1", jwt.RegisteredClaims{Subject: "1"})
+	if err != nil {
+		t.Fatalf("SignRS256 err: %v", err)
+	}
+
+	// 按 JWK 还原公钥，能校验签发的 token
+	jwk := RSAPublicJWK("rsa-1", &key.PublicKey)
+	if jwk.Kty != "RSA" || jwk.Alg != "RS256" || jwk.Use != "sig" || jwk.Kid != "rsa-1" {
+		t.Errorf("jwk = %+v", jwk)
+	}
+	n, _ := base64.RawURLEncoding.DecodeString(jwk.N)
+	e, _ := base64.RawURLEncoding.DecodeString(jwk.E)
+	pub := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+
+	parsed, err := jwt.Parse(token, func(tk *jwt.Token) (interface{}, error) {
+		if tk.Header["kid"] != jwk.Kid {
+			t.Errorf("kid = %v, want %s", tk.Header["kid"], jwk.Kid)
+		}
+		return pub, nil
+	}, jwt.WithValidMethods([]string{"RS256"}))
+	if err != nil || !parsed.Valid {
+		t.Errorf("Parse RS256 token err: %v", err)
+	}
+}
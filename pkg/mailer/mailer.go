@@ -0,0 +1,35 @@
+package mailer
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"time"
+)
+
+// Message 一封纯文本邮件
+type Message struct {
+	To      string // 收件人地址
+	Subject string // 主题
+	Body    string // 正文，纯文本
+}
+
+// Mailer 发送邮件的接口，业务代码只依赖这个接口，具体用 SMTP 还是写本地文件由配置决定
+type Mailer interface {
+	Send(msg *Message) error
+}
+
+// 按 RFC 5322 组装邮件内容，主题用 RFC 2047 编码，正文使用 UTF-8
+func buildMessage(from string, msg *Message) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: 8bit\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(msg.Body)
+	return b.Bytes()
+}
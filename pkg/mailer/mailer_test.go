@@ -0,0 +1,82 @@
+package mailer
+
+import (
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBuildMessage(t *testing.T) {
+	msg := &Message{To: "alice@example.com", Subject: "重置密码", Body: "点击链接重置密码"}
+	raw := string(buildMessage("noreply@example.com", msg))
+
+	head, body, ok := strings.Cut(raw, "\r\n\r\n")
+	if !ok {
+		t.Fatalf("message has no header/body separator: %q", raw)
+	}
+	if body != msg.Body {
+		t.Errorf("body = %q, want %q", body, msg.Body)
+	}
+
+	headers := map[string]string{}
+	for _, line := range strings.Split(head, "\r\n") {
+		k, v, _ := strings.Cut(line, ": ")
+		headers[k] = v
+	}
+	for k, want := range map[string]string{
+		"From":         "noreply@example.com",
+		"To":           "alice@example.com",
+		"MIME-Version": "1.0",
+		"Content-Type": "text/plain; charset=UTF-8",
+	} {
+		if headers[k] != want {
+			t.Errorf("header %s = %q, want %q", k, headers[k], want)
+		}
+	}
+	if headers["Date"] == "" {
+		t.Errorf("header Date is missing")
+	}
+	// 非 ASCII 的主题按 RFC 2047 编码，解码后和原文一致
+	if !strings.HasPrefix(headers["Subject"], "=?utf-8?q?") {
+		t.Errorf("Subject = %q, want RFC 2047 encoded", headers["Subject"])
+	}
+	if subject, err := new(mime.WordDecoder).DecodeHeader(headers["Subject"]); err != nil || subject != msg.Subject {
+		t.Errorf("decoded Subject = %q, %v, want %q", subject, err, msg.Subject)
+	}
+}
+
+func TestOutboxMailer(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "outbox")
+	m := NewOutboxMailer(dir, "noreply@example.com")
+
+	for _, to := range []string{"alice@example.com", "bob/../evil@example.com"} {
+		if err := m.Send(&Message{To: to, Subject: "hi", Body: "hello " + to}); err != nil {
+			t.Fatalf("Send(%s) err: %v", to, err)
+		}
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("ReadDir err: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("outbox has %d files, want 2", len(entries))
+	}
+	for _, e := range entries {
+		name := e.Name()
+		// 收件人里的路径字符被替换掉，邮件不会写到 outbox 目录之外
+		if !strings.HasSuffix(name, ".eml") || strings.ContainsAny(name, `/\`) {
+			t.Errorf("file name = %q", name)
+		}
+		info, _ := e.Info()
+		if perm := info.Mode().Perm(); perm != 0o600 {
+			t.Errorf("%s mode = %v, want 0600", name, perm)
+		}
+		data, _ := os.ReadFile(filepath.Join(dir, name))
+		if !strings.Contains(string(data), "From: noreply@example.com\r\n") || !strings.Contains(string(data), "hello ") {
+			t.Errorf("%s content = %q", name, data)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package mailer
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync/atomic"
+	"time"
+)
+
+// OutboxMailer 不真正发送邮件，而是把邮件写到本地目录里，用于本地开发和测试
+// 每封邮件一个 .eml 文件，可以直接用邮件客户端打开
+type OutboxMailer struct {
+	dir  string
+	from string
+	seq  uint64
+}
+
+// 文件名里只保留安全的字符
+var unsafeFileChars = regexp.MustCompile(`[^a-zA-Z0-9@._-]`)
+
+// NewOutboxMailer 创建一个写本地目录的发件器
+func NewOutboxMailer(dir, from string) *OutboxMailer {
+	return &OutboxMailer{dir: dir, from: from}
+}
+
+// Send 把邮件写到 outbox 目录
+func (m *OutboxMailer) Send(msg *Message) error {
+	if err := os.MkdirAll(m.dir, 0o755); err != nil {
+		return fmt.Errorf("create outbox dir err: %v", err)
+	}
+
+	seq := atomic.AddUint64(&m.seq, 1)
+	name := fmt.Sprintf("%s_%d_%s.eml", time.Now().Format("20060102150405"), seq, unsafeFileChars.ReplaceAllString(msg.To, "_"))
+	path := filepath.Join(m.dir, name)
+	if err := os.WriteFile(path, buildMessage(m.from, msg), 0o600); err != nil {
+		return fmt.Errorf("write outbox err: %v", err)
+	}
+	log.Infof("OutboxMailer|mail to %s written to %s", msg.To, path)
+	return nil
+}
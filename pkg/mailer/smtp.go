@@ -0,0 +1,46 @@
+package mailer
+
+import (
+	"fmt"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+)
+
+// SMTPOptions SMTP 服务器配置
+type SMTPOptions struct {
+	Host     string // 服务器地址
+	Port     int    // 端口，一般是 25 或 587，服务器支持时会自动升级 STARTTLS
+	UserName string // 登录用户名，为空时不做认证
+	Password string // 登录密码
+	From     string // 发件人，例如 "gouse <no-reply@example.com>"
+}
+
+// SMTPMailer 通过 SMTP 服务器发送邮件
+type SMTPMailer struct {
+	opts SMTPOptions
+}
+
+// NewSMTPMailer 创建一个 SMTP 发件器
+func NewSMTPMailer(opts SMTPOptions) *SMTPMailer {
+	return &SMTPMailer{opts: opts}
+}
+
+// Send 发送邮件
+func (m *SMTPMailer) Send(msg *Message) error {
+	from, err := mail.ParseAddress(m.opts.From)
+	if err != nil {
+		return fmt.Errorf("invalid from address: %v", err)
+	}
+	to, err := mail.ParseAddress(msg.To)
+	if err != nil {
+		return fmt.Errorf("invalid to address: %v", err)
+	}
+
+	var auth smtp.Auth
+	if m.opts.UserName != "" {
+		auth = smtp.PlainAuth("", m.opts.UserName, m.opts.Password, m.opts.Host)
+	}
+	addr := m.opts.Host + ":" + strconv.Itoa(m.opts.Port)
+	return smtp.SendMail(addr, auth, from.Address, []string{to.Address}, buildMessage(m.opts.From, msg))
+}
@@ -0,0 +1,122 @@
+package password
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// 泄露密码库按 k-匿名的方式查询：调用方只提交密码 SHA-1 的前 5 位十六进制，
+// 拿回同一个前缀下全部哈希剩下的 35 位，自己在本地比对。
+// 本地文件和远程服务（例如 Have I Been Pwned 的 range API）用的是同一套接口，
+// 换成远程服务时，密码和完整的哈希都不会离开本机
+
+// 前缀的长度，和 Have I Been Pwned 的 range API 一致
+const breachPrefixLength = 5
+
+// BreachList 泄露密码库
+type BreachList interface {
+	// Range 返回 SHA-1（十六进制大写）以 prefix 开头的全部哈希去掉前缀之后的部分
+	Range(prefix string) ([]string, error)
+}
+
+// FileBreachList 从本地文件加载的泄露密码库，加载之后按前缀分组放在内存里
+type FileBreachList struct {
+	ranges map[string]map[string]bool // 前缀 -> 后缀集合
+	count  int
+}
+
+// LoadBreachFile 加载本地的泄露密码、常用密码文件
+// 每行一条，可以是 40 位十六进制的 SHA-1（可以带 Have I Been Pwned 下载文件里的 ":出现次数"），
+// 也可以是密码明文；空行和 # 开头的行忽略
+func LoadBreachFile(path string) (*FileBreachList, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open breach list err: %v", err)
+	}
+	defer f.Close()
+
+	list := &FileBreachList{ranges: make(map[string]map[string]bool)}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		list.add(lineHash(line))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read breach list err: %v", err)
+	}
+	return list, nil
+}
+
+// Range 返回以 prefix 开头的全部哈希的后缀
+func (l *FileBreachList) Range(prefix string) ([]string, error) {
+	suffixes := make([]string, 0, len(l.ranges[prefix]))
+	for suffix := range l.ranges[prefix] {
+		suffixes = append(suffixes, suffix)
+	}
+	return suffixes, nil
+}
+
+// Len 文件里一共有多少条不重复的记录
+func (l *FileBreachList) Len() int {
+	return l.count
+}
+
+func (l *FileBreachList) add(hash string) {
+	prefix, suffix := hash[:breachPrefixLength], hash[breachPrefixLength:]
+	if l.ranges[prefix] == nil {
+		l.ranges[prefix] = make(map[string]bool)
+	}
+	if !l.ranges[prefix][suffix] {
+		l.ranges[prefix][suffix] = true
+		l.count++
+	}
+}
+
+// 文件里的一行转换成十六进制大写的 SHA-1：已经是 SHA-1 的直接用，否则当作明文计算
+func lineHash(line string) string {
+	if i := strings.IndexByte(line, ':'); i == 40 {
+		if _, err := hex.DecodeString(line[:40]); err == nil {
+			return strings.ToUpper(line[:40])
+		}
+	}
+	if len(line) == 40 {
+		if _, err := hex.DecodeString(line); err == nil {
+			return strings.ToUpper(line)
+		}
+	}
+	return sha1Hex(line)
+}
+
+func sha1Hex(s string) string {
+	sum := sha1.Sum([]byte(s))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+// IsBreached 判断密码是否在泄露密码库里
+// 常用密码表大多是小写的，密码原样和转成小写之后各查一次，Password1 和 password1 都算常用密码
+func IsBreached(list BreachList, plain string) (bool, error) {
+	candidates := []string{plain}
+	if lower := strings.ToLower(plain); lower != plain {
+		candidates = append(candidates, lower)
+	}
+	for _, candidate := range candidates {
+		hash := sha1Hex(candidate)
+		suffixes, err := list.Range(hash[:breachPrefixLength])
+		if err != nil {
+			return false, err
+		}
+		for _, suffix := range suffixes {
+			if strings.EqualFold(suffix, hash[breachPrefixLength:]) {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,80 @@
+package password
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeBreachFile(t *testing.T, lines ...string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "breach.txt")
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o600); err != nil {
+		t.Fatalf("write breach file err: %v", err)
+	}
+	return path
+}
+
+func TestLoadBreachFile(t *testing.T) {
+	path := writeBreachFile(t,
+		"# 常用密码",
+		"",
+		"123456",
+		"qwerty\r",
+		// Have I Been Pwned 下载文件的格式：SHA-1:出现次数，大小写都可以
+		strings.ToLower(sha1Hex("letmein"))+":42",
+		sha1Hex("dragon"),
+		// 同一个密码写成明文和哈希只算一条
+		"123456",
+		sha1Hex("123456"),
+	)
+	list, err := LoadBreachFile(path)
+	if err != nil {
+		t.Fatalf("LoadBreachFile err: %v", err)
+	}
+	if list.Len() != 4 {
+		t.Errorf("Len = %d, want 4", list.Len())
+	}
+
+	tests := []struct {
+		plain string
+		want  bool
+	}{
+		{"123456", true},
+		{"qwerty", true},
+		{"letmein", true},
+		{"LetMeIn", true},
+		{"dragon", true},
+		{"# 常用密码", false},
+		{"correct horse battery staple", false},
+	}
+	for _, tt := range tests {
+		got, err := IsBreached(list, tt.plain)
+		if err != nil || got != tt.want {
+			t.Errorf("IsBreached(%q) = %v, %v, want %v", tt.plain, got, err, tt.want)
+		}
+	}
+}
+
+func TestLoadBreachFileMissing(t *testing.T) {
+	if _, err := LoadBreachFile(filepath.Join(t.TempDir(), "missing.txt")); err == nil {
+		t.Errorf("LoadBreachFile(missing) err = nil")
+	}
+}
+
+func TestBreachRangeIsKAnonymous(t *testing.T) {
+	list, err := LoadBreachFile(writeBreachFile(t, "123456"))
+	if err != nil {
+		t.Fatalf("LoadBreachFile err: %v", err)
+	}
+	hash := sha1Hex("123456")
+	suffixes, _ := list.Range(hash[:breachPrefixLength])
+	// 按前缀查询，返回的是去掉前缀的 35 位后缀
+	if len(suffixes) != 1 || suffixes[0] != hash[breachPrefixLength:] {
+		t.Errorf("Range(%s) = %v, want [%s]", hash[:breachPrefixLength], suffixes, hash[breachPrefixLength:])
+	}
+	if suffixes, _ := list.Range("00000"); len(suffixes) != 0 {
+		t.Errorf("Range(00000) = %v, want empty", suffixes)
+	}
+}
@@ -0,0 +1,182 @@
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// 提供密码哈希的计算与校验
+//
+// 存入数据库的是"编码后的哈希串"，算法和参数都编码在串里，这样调整参数之后，老的哈希依然可以校验：
+// 1）bcrypt：$2a$10$<salt+hash>，即 bcrypt 自带的格式
+// 2）argon2id：$argon2id$v=19$m=65536,t=3,p=2$<salt>$<hash>，即 PHC 字符串格式
+// 不以上面这些前缀开头的值，都被当作历史遗留的明文密码。
+
+// 支持的哈希算法
+const (
+	AlgoBcrypt   = "bcrypt"
+	AlgoArgon2id = "argon2id"
+)
+
+const argon2idPrefix = "$argon2id$"
+
+var (
+	// ErrInvalidHash 存储的哈希串格式不合法
+	ErrInvalidHash = errors.New("password: invalid encoded hash")
+	// ErrUnknownAlgorithm 配置了不支持的哈希算法
+	ErrUnknownAlgorithm = errors.New("password: unknown algorithm")
+)
+
+// Params 哈希参数
+type Params struct {
+	Algorithm     string // 新密码使用的算法，bcrypt 或 argon2id
+	BcryptCost    int    // bcrypt 的计算成本
+	Argon2Memory  uint32 // argon2id 使用的内存，单位 KiB
+	Argon2Time    uint32 // argon2id 的迭代次数
+	Argon2Threads uint8  // argon2id 的并行度
+	Argon2KeyLen  uint32 // argon2id 输出的哈希长度
+	SaltLen       uint32 // argon2id 的盐长度
+}
+
+// DefaultParams 默认参数，配置文件没有填写的字段会用这里的值补齐
+var DefaultParams = Params{
+	Algorithm:     AlgoArgon2id,
+	BcryptCost:    12,
+	Argon2Memory:  64 * 1024,
+	Argon2Time:    3,
+	Argon2Threads: 2,
+	Argon2KeyLen:  32,
+	SaltLen:       16,
+}
+
+// withDefaults 用默认值补齐没有设置的参数
+func (p Params) withDefaults() Params {
+	if p.Algorithm == "" {
+		p.Algorithm = DefaultParams.Algorithm
+	}
+	if p.BcryptCost == 0 {
+		p.BcryptCost = DefaultParams.BcryptCost
+	}
+	if p.Argon2Memory == 0 {
+		p.Argon2Memory = DefaultParams.Argon2Memory
+	}
+	if p.Argon2Time == 0 {
+		p.Argon2Time = DefaultParams.Argon2Time
+	}
+	if p.Argon2Threads == 0 {
+		p.Argon2Threads = DefaultParams.Argon2Threads
+	}
+	if p.Argon2KeyLen == 0 {
+		p.Argon2KeyLen = DefaultParams.Argon2KeyLen
+	}
+	if p.SaltLen == 0 {
+		p.SaltLen = DefaultParams.SaltLen
+	}
+	return p
+}
+
+// Hash 按参数中指定的算法计算密码哈希，返回编码后的哈希串
+func Hash(plain string, p Params) (string, error) {
+	p = p.withDefaults()
+	switch p.Algorithm {
+	case AlgoBcrypt:
+		h, err := bcrypt.GenerateFromPassword([]byte(plain), p.BcryptCost)
+		if err != nil {
+			return "", err
+		}
+		return string(h), nil
+	case AlgoArgon2id:
+		salt := make([]byte, p.SaltLen)
+		if _, err := rand.Read(salt); err != nil {
+			return "", err
+		}
+		key := argon2.IDKey([]byte(plain), salt, p.Argon2Time, p.Argon2Memory, p.Argon2Threads, p.Argon2KeyLen)
+		return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s", argon2idPrefix, argon2.Version,
+			p.Argon2Memory, p.Argon2Time, p.Argon2Threads,
+			base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+	}
+	return "", ErrUnknownAlgorithm
+}
+
+// Verify 校验明文密码和存储的哈希串是否匹配
+// needsRehash 为 true 表示存储的值是明文、算法和当前配置不一致，或者参数比当前配置弱，
+// 调用方应该在校验通过后用 Hash 重新计算并保存
+func Verify(plain, encoded string, p Params) (match bool, needsRehash bool, err error) {
+	p = p.withDefaults()
+	switch {
+	case isBcrypt(encoded):
+		err = bcrypt.CompareHashAndPassword([]byte(encoded), []byte(plain))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, false, nil
+		}
+		if err != nil {
+			return false, false, err
+		}
+		cost, err := bcrypt.Cost([]byte(encoded))
+		if err != nil {
+			return true, true, nil
+		}
+		return true, p.Algorithm != AlgoBcrypt || cost < p.BcryptCost, nil
+	case strings.HasPrefix(encoded, argon2idPrefix):
+		stored, salt, key, err := decodeArgon2id(encoded)
+		if err != nil {
+			return false, false, err
+		}
+		other := argon2.IDKey([]byte(plain), salt, stored.Argon2Time, stored.Argon2Memory, stored.Argon2Threads, uint32(len(key)))
+		if subtle.ConstantTimeCompare(key, other) != 1 {
+			return false, false, nil
+		}
+		weaker := stored.Argon2Memory < p.Argon2Memory || stored.Argon2Time < p.Argon2Time ||
+			stored.Argon2Threads < p.Argon2Threads || uint32(len(key)) < p.Argon2KeyLen || uint32(len(salt)) < p.SaltLen
+		return true, p.Algorithm != AlgoArgon2id || weaker, nil
+	}
+
+	// 历史遗留的明文密码，用常量时间比较，匹配后必须重新计算哈希
+	match = encoded != "" && subtle.ConstantTimeCompare([]byte(plain), []byte(encoded)) == 1
+	return match, match, nil
+}
+
+// IsHashed 判断存储的值是否已经是支持的哈希格式
+func IsHashed(encoded string) bool {
+	return isBcrypt(encoded) || strings.HasPrefix(encoded, argon2idPrefix)
+}
+
+func isBcrypt(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") || strings.HasPrefix(encoded, "$2b$") || strings.HasPrefix(encoded, "$2y$")
+}
+
+// decodeArgon2id 解析 PHC 格式的 argon2id 哈希串
+func decodeArgon2id(encoded string) (Params, []byte, []byte, error) {
+	// 切分之后是 ["", "argon2id", "v=19", "m=65536,t=3,p=2", salt, hash]
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 {
+		return Params{}, nil, nil, ErrInvalidHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return Params{}, nil, nil, ErrInvalidHash
+	}
+
+	p := Params{Algorithm: AlgoArgon2id}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Argon2Memory, &p.Argon2Time, &p.Argon2Threads); err != nil {
+		return Params{}, nil, nil, ErrInvalidHash
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return Params{}, nil, nil, ErrInvalidHash
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return Params{}, nil, nil, ErrInvalidHash
+	}
+	return p, salt, key, nil
+}
@@ -0,0 +1,139 @@
+package password
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// 测试里用很小的参数，避免每个用例都要花上百毫秒
+var testParams = Params{
+	Algorithm:     AlgoArgon2id,
+	BcryptCost:    4,
+	Argon2Memory:  1024,
+	Argon2Time:    1,
+	Argon2Threads: 1,
+	Argon2KeyLen:  16,
+	SaltLen:       8,
+}
+
+func TestHashAndVerify(t *testing.T) {
+	bcryptParams := testParams
+	bcryptParams.Algorithm = AlgoBcrypt
+
+	tests := []struct {
+		name   string
+		params Params
+		prefix string
+	}{
+		{"argon2id", testParams, "$argon2id$v=19$m=1024,t=1,p=1$"},
+		{"bcrypt", bcryptParams, "$2a$04$"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoded, err := Hash("correct horse", tt.params)
+			if err != nil {
+				t.Fatalf("Hash err: %v", err)
+			}
+			if !strings.HasPrefix(encoded, tt.prefix) {
+				t.Fatalf("Hash = %q, want prefix %q", encoded, tt.prefix)
+			}
+			if !IsHashed(encoded) {
+				t.Errorf("IsHashed(%q) = false", encoded)
+			}
+
+			match, rehash, err := Verify("correct horse", encoded, tt.params)
+			if err != nil || !match || rehash {
+				t.Errorf("Verify(correct) = %v, %v, %v, want true, false, nil", match, rehash, err)
+			}
+			match, rehash, err = Verify("wrong horse", encoded, tt.params)
+			if err != nil || match || rehash {
+				t.Errorf("Verify(wrong) = %v, %v, %v, want false, false, nil", match, rehash, err)
+			}
+		})
+	}
+}
+
+func TestHashSalted(t *testing.T) {
+	a, _ := Hash("same", testParams)
+	b, _ := Hash("same", testParams)
+	if a == b {
+		t.Errorf("two hashes of the same password are equal: %q", a)
+	}
+}
+
+func TestHashUnknownAlgorithm(t *testing.T) {
+	if _, err := Hash("x", Params{Algorithm: "md5"}); !errors.Is(err, ErrUnknownAlgorithm) {
+		t.Errorf("Hash err = %v, want ErrUnknownAlgorithm", err)
+	}
+}
+
+func TestVerifyNeedsRehash(t *testing.T) {
+	bcryptParams := testParams
+	bcryptParams.Algorithm = AlgoBcrypt
+	stronger := testParams
+	stronger.Argon2Time = 2
+
+	argon, _ := Hash("secret", testParams)
+	bcrypted, _ := Hash("secret", bcryptParams)
+
+	tests := []struct {
+		name    string
+		encoded string
+		params  Params
+		rehash  bool
+	}{
+		{"same params", argon, testParams, false},
+		{"stronger argon2id params", argon, stronger, true},
+		{"algorithm changed to bcrypt", argon, bcryptParams, true},
+		{"bcrypt to argon2id", bcrypted, testParams, true},
+		{"bcrypt cost raised", bcrypted, Params{Algorithm: AlgoBcrypt, BcryptCost: 5}, true},
+		{"legacy plaintext", "secret", testParams, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			match, rehash, err := Verify("secret", tt.encoded, tt.params)
+			if err != nil || !match {
+				t.Fatalf("Verify = %v, %v, %v, want match", match, rehash, err)
+			}
+			if rehash != tt.rehash {
+				t.Errorf("needsRehash = %v, want %v", rehash, tt.rehash)
+			}
+		})
+	}
+}
+
+func TestVerifyLegacyPlaintext(t *testing.T) {
+	tests := []struct {
+		plain, encoded string
+		match          bool
+	}{
+		{"secret", "secret", true},
+		{"secret", "Secret", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		match, _, err := Verify(tt.plain, tt.encoded, testParams)
+		if err != nil || match != tt.match {
+			t.Errorf("Verify(%q, %q) = %v, %v, want %v", tt.plain, tt.encoded, match, err, tt.match)
+		}
+	}
+	if IsHashed("secret") {
+		t.Errorf("IsHashed(plaintext) = true")
+	}
+}
+
+func TestVerifyInvalidArgon2id(t *testing.T) {
+	tests := []string{
+		"$argon2id$v=19$m=1024,t=1,p=1$c2FsdA",
+		"$argon2id$v=18$m=1024,t=1,p=1$c2FsdA$aGFzaA",
+		"$argon2id$v=19$m=x,t=1,p=1$c2FsdA$aGFzaA",
+		"$argon2id$v=19$m=1024,t=1,p=1$!!$aGFzaA",
+		"$argon2id$v=19$m=1024,t=1,p=1$c2FsdA$",
+	}
+	for _, encoded := range tests {
+		if _, _, err := Verify("x", encoded, testParams); !errors.Is(err, ErrInvalidHash) {
+			t.Errorf("Verify(%q) err = %v, want ErrInvalidHash", encoded, err)
+		}
+	}
+}
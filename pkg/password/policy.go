@@ -0,0 +1,133 @@
+package password
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// 密码策略：长度、字符类别、是否包含用户名等个人信息、是否在泄露密码库里
+// 这里只做和用户无关的、纯粹针对密码本身的检查，密码历史需要查数据库，由调用方自己检查
+
+// 违反的规则，写在 Violation.Rule 里，客户端可以据此提示用户
+const (
+	RuleMinLength      = "min_length"      // 太短
+	RuleMaxLength      = "max_length"      // 太长
+	RuleCharClasses    = "char_classes"    // 包含的字符类别太少
+	RuleRequiredClass  = "required_class"  // 缺少必须包含的字符类别
+	RuleUserSimilarity = "user_similarity" // 包含用户名、邮箱等个人信息
+	RuleBreached       = "breached"        // 出现在泄露密码库或者常用密码表里
+	RuleReused         = "reused"          // 和最近用过的密码相同
+)
+
+// 字符类别
+const (
+	ClassLower  = "lower"
+	ClassUpper  = "upper"
+	ClassDigit  = "digit"
+	ClassSymbol = "symbol"
+)
+
+// 个人信息少于这个长度时不做相似度检查，太短的用户名几乎会出现在任何密码里
+const minSimilarityLength = 3
+
+// Policy 密码策略，零值的规则不检查
+type Policy struct {
+	MinLength       int        // 最小长度，按字符计算
+	MaxLength       int        // 最大长度，按字符计算
+	MinClasses      int        // 小写字母、大写字母、数字、符号四类里至少包含几类
+	RequiredClasses []string   // 必须包含的字符类别，取值见 ClassXXX
+	UserSimilarity  bool       // 是否检查密码里包含用户名、邮箱等个人信息
+	Breached        BreachList // 泄露密码库，nil 时不检查
+}
+
+// Violation 密码违反的一条规则
+type Violation struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// Check 按策略检查密码，返回违反的全部规则，全部通过时返回空
+// personal 是不能出现在密码里的个人信息，例如用户名、邮箱的 @ 前面部分、昵称
+// 查询泄露密码库出错时返回 error，已经检查出来的规则仍然一并返回
+func (p *Policy) Check(plain string, personal ...string) ([]Violation, error) {
+	var violations []Violation
+	length := utf8.RuneCountInString(plain)
+	if p.MinLength > 0 && length < p.MinLength {
+		violations = append(violations, Violation{RuleMinLength, fmt.Sprintf("at least %d characters", p.MinLength)})
+	}
+	if p.MaxLength > 0 && length > p.MaxLength {
+		violations = append(violations, Violation{RuleMaxLength, fmt.Sprintf("at most %d characters", p.MaxLength)})
+	}
+
+	classes := charClasses(plain)
+	if p.MinClasses > 0 && len(classes) < p.MinClasses {
+		violations = append(violations, Violation{RuleCharClasses,
+			fmt.Sprintf("use at least %d of lowercase letters, uppercase letters, digits and symbols", p.MinClasses)})
+	}
+	for _, class := range p.RequiredClasses {
+		if !classes[class] {
+			violations = append(violations, Violation{RuleRequiredClass, fmt.Sprintf("must contain %s characters", class)})
+		}
+	}
+
+	if p.UserSimilarity && similarToAny(plain, personal) {
+		violations = append(violations, Violation{RuleUserSimilarity, "must not contain the user name, email or nickname"})
+	}
+
+	if p.Breached != nil {
+		breached, err := IsBreached(p.Breached, plain)
+		if err != nil {
+			return violations, err
+		}
+		if breached {
+			violations = append(violations, Violation{RuleBreached, "this password is too common or has appeared in a data breach"})
+		}
+	}
+	return violations, nil
+}
+
+// 统计密码包含的字符类别，非 ASCII 的字母按大小写归类，其他字符都算符号
+func charClasses(plain string) map[string]bool {
+	classes := make(map[string]bool, 4)
+	for _, r := range plain {
+		switch {
+		case unicode.IsLower(r):
+			classes[ClassLower] = true
+		case unicode.IsUpper(r):
+			classes[ClassUpper] = true
+		case unicode.IsDigit(r):
+			classes[ClassDigit] = true
+		default:
+			classes[ClassSymbol] = true
+		}
+	}
+	return classes
+}
+
+// 密码（忽略大小写）包含某一项个人信息或者它倒过来写的样子，或者密码本身就是个人信息的一部分
+func similarToAny(plain string, personal []string) bool {
+	pw := strings.ToLower(plain)
+	for _, info := range personal {
+		info = strings.ToLower(strings.TrimSpace(info))
+		if utf8.RuneCountInString(info) < minSimilarityLength {
+			continue
+		}
+		if strings.Contains(pw, info) || strings.Contains(pw, reverse(info)) {
+			return true
+		}
+		if utf8.RuneCountInString(pw) >= minSimilarityLength && strings.Contains(info, pw) {
+			return true
+		}
+	}
+	return false
+}
+
+func reverse(s string) string {
+	r := []rune(s)
+	for i, j := 0, len(r)-1; i < j; i, j = i+1, j-1 {
+		r[i], r[j] = r[j], r[i]
+	}
+	return string(r)
+}
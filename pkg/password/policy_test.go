@@ -0,0 +1,89 @@
+package password
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// 固定返回的泄露密码库，用来测试 Policy 和 IsBreached，不用读文件
+type stubBreachList struct {
+	hashes []string // 十六进制大写的 SHA-1
+	err    error
+}
+
+func (l stubBreachList) Range(prefix string) ([]string, error) {
+	if l.err != nil {
+		return nil, l.err
+	}
+	var suffixes []string
+	for _, h := range l.hashes {
+		if h[:breachPrefixLength] == prefix {
+			suffixes = append(suffixes, h[breachPrefixLength:])
+		}
+	}
+	return suffixes, nil
+}
+
+// 只取违反的规则名，方便比较
+func rules(violations []Violation) []string {
+	var out []string
+	for _, v := range violations {
+		out = append(out, v.Rule)
+	}
+	return out
+}
+
+func TestPolicyCheck(t *testing.T) {
+	breached := stubBreachList{hashes: []string{sha1Hex("password1")}}
+	tests := []struct {
+		name     string
+		policy   Policy
+		plain    string
+		personal []string
+		want     []string
+	}{
+		{"zero policy accepts anything", Policy{}, "a", nil, nil},
+		{"too short", Policy{MinLength: 8}, "abc", nil, []string{RuleMinLength}},
+		{"length counts characters not bytes", Policy{MinLength: 4}, "密码密码", nil, nil},
+		{"too long", Policy{MaxLength: 4}, "abcde", nil, []string{RuleMaxLength}},
+		{"too few classes", Policy{MinClasses: 3}, "abcdEFGH", nil, []string{RuleCharClasses}},
+		{"enough classes", Policy{MinClasses: 3}, "abcdEF12", nil, nil},
+		{"non ascii letters have case", Policy{MinClasses: 2}, "ßÄ", nil, nil},
+		{"missing required classes", Policy{RequiredClasses: []string{ClassDigit, ClassSymbol}}, "abcd1234", nil, []string{RuleRequiredClass}},
+		{"contains user name", Policy{UserSimilarity: true}, "xxAlice2024", []string{"alice"}, []string{RuleUserSimilarity}},
+		{"contains reversed user name", Policy{UserSimilarity: true}, "ecila!!", []string{"alice"}, []string{RuleUserSimilarity}},
+		{"part of personal info", Policy{UserSimilarity: true}, "xander", []string{"alexander"}, []string{RuleUserSimilarity}},
+		{"short personal info ignored", Policy{UserSimilarity: true}, "bo-strong-pass", []string{"bo", ""}, nil},
+		{"similarity disabled", Policy{}, "alice", []string{"alice"}, nil},
+		{"breached", Policy{Breached: breached}, "password1", nil, []string{RuleBreached}},
+		{"breached ignoring case", Policy{Breached: breached}, "PassWord1", nil, []string{RuleBreached}},
+		{"not breached", Policy{Breached: breached}, "correct horse", nil, nil},
+		{"several rules at once", Policy{MinLength: 12, MinClasses: 3, UserSimilarity: true}, "alice", []string{"alice"},
+			[]string{RuleMinLength, RuleCharClasses, RuleUserSimilarity}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			violations, err := tt.policy.Check(tt.plain, tt.personal...)
+			if err != nil {
+				t.Fatalf("Check err: %v", err)
+			}
+			if got := rules(violations); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Check(%q) = %v, want %v", tt.plain, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPolicyCheckBreachError(t *testing.T) {
+	lookupErr := errors.New("lookup failed")
+	p := Policy{MinLength: 8, Breached: stubBreachList{err: lookupErr}}
+	violations, err := p.Check("short")
+	if !errors.Is(err, lookupErr) {
+		t.Errorf("Check err = %v, want %v", err, lookupErr)
+	}
+	// 泄露密码库出错时，已经检查出来的规则仍然返回
+	if got := rules(violations); !reflect.DeepEqual(got, []string{RuleMinLength}) {
+		t.Errorf("Check violations = %v, want [%s]", got, RuleMinLength)
+	}
+}
@@ -0,0 +1,61 @@
+package phone
+
+import (
+	"errors"
+	"strings"
+)
+
+// 手机号统一保存成 E.164 格式：+ 国家码 + 号码，一共不超过 15 位数字，例如 +8613812345678
+// 这里只做格式上的规范化，不判断号码段是否真实存在，号码是不是用户本人的靠短信验证码确认
+
+// ErrInvalid 手机号格式不对
+var ErrInvalid = errors.New("invalid phone number")
+
+// E.164 号码的位数范围（不含 +），最短的国家码加号码也有 8 位
+const (
+	minDigits = 8
+	maxDigits = 15
+)
+
+// Normalize 把用户输入的手机号规范化成 E.164 格式
+// 允许带空格、横线、点和括号；以 00 开头的按国际前缀处理；
+// 不带国家码的号码加上 defaultCode（例如 "86"），并去掉国内长途前缀 0。defaultCode 为空时必须带国家码
+func Normalize(raw, defaultCode string) (string, error) {
+	var b strings.Builder
+	for i, r := range strings.TrimSpace(raw) {
+		switch {
+		case r >= '0' && r <= '9':
+			b.WriteRune(r)
+		case r == '+' && i == 0:
+			b.WriteRune(r)
+		case r == ' ' || r == '-' || r == '.' || r == '(' || r == ')':
+		default:
+			return "", ErrInvalid
+		}
+	}
+
+	s := b.String()
+	switch {
+	case strings.HasPrefix(s, "+"):
+		s = s[1:]
+	case strings.HasPrefix(s, "00"):
+		s = s[2:]
+	case defaultCode != "":
+		s = strings.TrimPrefix(defaultCode, "+") + strings.TrimLeft(s, "0")
+	default:
+		return "", ErrInvalid
+	}
+
+	if len(s) < minDigits || len(s) > maxDigits || s[0] == '0' {
+		return "", ErrInvalid
+	}
+	return "+" + s, nil
+}
+
+// Mask 隐藏号码中间的数字，只保留国家码附近的前几位和最后 4 位，用于日志和展示
+func Mask(phone string) string {
+	if len(phone) <= 8 {
+		return strings.Repeat("*", len(phone))
+	}
+	return phone[:len(phone)-8] + "****" + phone[len(phone)-4:]
+}
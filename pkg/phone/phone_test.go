@@ -0,0 +1,63 @@
+package phone
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		raw, defaultCode, want string
+	}{
+		{"+8613812345678", "86", "+8613812345678"},
+		{"13812345678", "86", "+8613812345678"},
+		{" 138-1234-5678 ", "86", "+8613812345678"},
+		{"(138) 1234.5678", "+86", "+8613812345678"},
+		{"008613812345678", "86", "+8613812345678"},
+		{"+1 415 555 2671", "86", "+14155552671"},
+		// 不带国家码的去掉国内长途前缀 0
+		{"020 1234 5678", "86", "+862012345678"},
+		{"+14155552671", "", "+14155552671"},
+	}
+	for _, tt := range tests {
+		got, err := Normalize(tt.raw, tt.defaultCode)
+		if err != nil || got != tt.want {
+			t.Errorf("Normalize(%q, %q) = %q, %v, want %q", tt.raw, tt.defaultCode, got, err, tt.want)
+		}
+	}
+}
+
+func TestNormalizeInvalid(t *testing.T) {
+	tests := []struct {
+		raw, defaultCode string
+	}{
+		{"", "86"},
+		{"13812345678", ""},     // 没有国家码也没有默认国家码
+		{"138 1234 567a", "86"}, // 非法字符
+		{"86+13812345678", "86"},
+		{"+123", "86"},              // 太短
+		{"+1234567890123456", "86"}, // 超过 15 位
+		{"+0123456789", "86"},       // 国家码不能以 0 开头
+	}
+	for _, tt := range tests {
+		if got, err := Normalize(tt.raw, tt.defaultCode); !errors.Is(err, ErrInvalid) {
+			t.Errorf("Normalize(%q, %q) = %q, %v, want ErrInvalid", tt.raw, tt.defaultCode, got, err)
+		}
+	}
+}
+
+func TestMask(t *testing.T) {
+	tests := []struct {
+		phone, want string
+	}{
+		{"+8613812345678", "+86138****5678"},
+		{"+14155552671", "+141****2671"},
+		{"+1234567", "********"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := Mask(tt.phone); got != tt.want {
+			t.Errorf("Mask(%q) = %q, want %q", tt.phone, got, tt.want)
+		}
+	}
+}
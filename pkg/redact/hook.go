@@ -0,0 +1,57 @@
+package redact
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// 日志里常见的敏感字段名，统一转成小写、去掉下划线和中划线后比较
+var sensitiveKeys = []string{"password", "passwd", "pwd", "secret", "token", "apikey", "authorization", "recoverycode"}
+
+// 匹配日志正文里 "password=xxx"、"pass_word:xxx"、"\"token\":\"xxx\""、"session=xxx" 之类的键值对
+// session 本身就是登录凭证；session_id 是它的哈希，可以记录，不在匹配范围内
+var sensitivePair = regexp.MustCompile(`(?i)("?\b(?:pass_?word|passwd|pwd|secret|[a-z_]*token|api_?key|otp|recovery_?code|session)"?\s*[:=]\s*"?)([^"\s,|&@}]+)`)
+
+// IsSensitiveKey 判断字段名是否属于敏感字段
+func IsSensitiveKey(key string) bool {
+	k := strings.ToLower(strings.NewReplacer("_", "", "-", "").Replace(key))
+	for _, s := range sensitiveKeys {
+		if strings.Contains(k, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// MaskMessage 把日志正文里敏感键值对的值替换为 Mask
+func MaskMessage(msg string) string {
+	return sensitivePair.ReplaceAllString(msg, "${1}"+Mask)
+}
+
+// Hook logrus 钩子，在日志输出之前脱敏：
+// 1）字段名是敏感字段的，值替换为 Mask
+// 2）字段值的类型包含 redact 标签的，按 String 格式化
+// 3）日志正文里的敏感键值对，值替换为 Mask
+type Hook struct{}
+
+// Levels 对所有级别的日志生效
+func (Hook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Fire 在日志输出之前被调用，entry 是本条日志独享的副本，可以直接修改
+func (Hook) Fire(entry *logrus.Entry) error {
+	for k, v := range entry.Data {
+		if IsSensitiveKey(k) {
+			entry.Data[k] = Mask
+			continue
+		}
+		if HasSensitive(v) {
+			entry.Data[k] = String(v)
+		}
+	}
+	entry.Message = MaskMessage(entry.Message)
+	return nil
+}
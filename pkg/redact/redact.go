@@ -0,0 +1,227 @@
+package redact
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// 提供敏感字段的脱敏能力
+//
+// 在结构体字段上加 `redact:"true"` 标签即可把它标记为敏感字段，例如：
+//
+//	PassWord string `json:"-" redact:"true"`
+//
+// 被标记的字段：
+// 1）通过 String 格式化（用于日志）时输出为 Mask
+// 2）通过 Sanitize 处理后再序列化成 JSON（用于接口响应）时输出为 Mask
+// 带有敏感字段的类型应该实现 String() 方法并调用 String，这样 %v、%+v 打日志时也不会泄露。
+
+// Mask 敏感字段脱敏后的值
+const Mask = "******"
+
+const tagName = "redact"
+
+var marshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+
+// 记录每个类型是否（直接或间接）包含敏感字段，避免每次都反射遍历
+var sensitiveTypes sync.Map
+
+// HasSensitive 判断值的类型是否包含敏感字段
+func HasSensitive(v interface{}) bool {
+	if v == nil {
+		return false
+	}
+	return hasSensitive(reflect.TypeOf(v), map[reflect.Type]bool{})
+}
+
+func hasSensitive(t reflect.Type, visiting map[reflect.Type]bool) bool {
+	if cached, ok := sensitiveTypes.Load(t); ok {
+		return cached.(bool)
+	}
+	// 自引用的类型（例如链表）在遍历过程中再次遇到时先按不包含处理
+	if visiting[t] {
+		return false
+	}
+	visiting[t] = true
+
+	result := false
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Array:
+		result = hasSensitive(t.Elem(), visiting)
+	case reflect.Map:
+		result = hasSensitive(t.Elem(), visiting)
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if isSensitiveField(f) || hasSensitive(f.Type, visiting) {
+				result = true
+				break
+			}
+		}
+	}
+	sensitiveTypes.Store(t, result)
+	return result
+}
+
+func isSensitiveField(f reflect.StructField) bool {
+	return f.Tag.Get(tagName) == "true"
+}
+
+// String 按 %+v 的格式输出值，敏感字段替换为 Mask
+// 注意：不要在不包含敏感字段的类型的 String() 方法里调用它，否则会无限递归
+func String(v interface{}) string {
+	var b strings.Builder
+	writeValue(&b, reflect.ValueOf(v))
+	return b.String()
+}
+
+func writeValue(b *strings.Builder, rv reflect.Value) {
+	if !rv.IsValid() {
+		b.WriteString("<nil>")
+		return
+	}
+	if rv.Kind() == reflect.Ptr || rv.Kind() == reflect.Interface {
+		if rv.IsNil() {
+			b.WriteString("<nil>")
+			return
+		}
+		if rv.Kind() == reflect.Ptr {
+			b.WriteString("&")
+		}
+		writeValue(b, rv.Elem())
+		return
+	}
+	if rv.Kind() != reflect.Struct || !hasSensitive(rv.Type(), map[reflect.Type]bool{}) {
+		fmt.Fprintf(b, "%+v", rv)
+		return
+	}
+
+	b.WriteString("{")
+	t := rv.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if i > 0 {
+			b.WriteString(" ")
+		}
+		f := t.Field(i)
+		b.WriteString(f.Name)
+		b.WriteString(":")
+		if isSensitiveField(f) {
+			b.WriteString(Mask)
+			continue
+		}
+		writeValue(b, rv.Field(i))
+	}
+	b.WriteString("}")
+}
+
+// Sanitize 返回一个可以安全地序列化成 JSON 的值
+// 不包含敏感字段的值原样返回；包含敏感字段的值会按 json 标签转换成 map/slice，并把敏感字段替换为 Mask
+func Sanitize(v interface{}) interface{} {
+	if !HasSensitive(v) {
+		return v
+	}
+	return sanitize(reflect.ValueOf(v))
+}
+
+func sanitize(rv reflect.Value) interface{} {
+	// 通过未导出的内嵌字段拿到的值不能调用 Interface()，encoding/json 也不会输出它们
+	if !rv.CanInterface() {
+		return nil
+	}
+	switch rv.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if rv.IsNil() {
+			return nil
+		}
+		return sanitize(rv.Elem())
+	case reflect.Struct:
+		if !hasSensitive(rv.Type(), map[reflect.Type]bool{}) {
+			return rv.Interface()
+		}
+		m := make(map[string]interface{})
+		sanitizeStruct(rv, m)
+		return m
+	case reflect.Slice, reflect.Array:
+		if rv.Kind() == reflect.Slice && rv.IsNil() {
+			return nil
+		}
+		if !hasSensitive(rv.Type().Elem(), map[reflect.Type]bool{}) {
+			return rv.Interface()
+		}
+		items := make([]interface{}, rv.Len())
+		for i := range items {
+			items[i] = sanitize(rv.Index(i))
+		}
+		return items
+	case reflect.Map:
+		if rv.IsNil() {
+			return nil
+		}
+		if !hasSensitive(rv.Type().Elem(), map[reflect.Type]bool{}) {
+			return rv.Interface()
+		}
+		m := make(map[string]interface{}, rv.Len())
+		iter := rv.MapRange()
+		for iter.Next() {
+			m[fmt.Sprint(iter.Key().Interface())] = sanitize(iter.Value())
+		}
+		return m
+	}
+	return rv.Interface()
+}
+
+// 按 encoding/json 的规则展开结构体字段：跳过未导出字段和 json:"-"，处理字段重命名、omitempty 和匿名内嵌
+func sanitizeStruct(rv reflect.Value, m map[string]interface{}) {
+	t := rv.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		fv := rv.Field(i)
+		if f.PkgPath != "" && !f.Anonymous {
+			continue
+		}
+
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+
+		// 没有 json 名字的匿名内嵌结构体，字段提升到外层
+		if f.Anonymous && name == "" {
+			inner := fv
+			if inner.Kind() == reflect.Ptr {
+				if inner.IsNil() {
+					continue
+				}
+				inner = inner.Elem()
+			}
+			if inner.Kind() == reflect.Struct && !isMarshaler(inner.Type()) {
+				sanitizeStruct(inner, m)
+				continue
+			}
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+
+		if name == "" {
+			name = f.Name
+		}
+		if strings.Contains(opts, "omitempty") && fv.IsZero() {
+			continue
+		}
+		if isSensitiveField(f) {
+			m[name] = Mask
+			continue
+		}
+		m[name] = sanitize(fv)
+	}
+}
+
+// 实现了 json.Marshaler 的类型由它自己决定输出，不展开
+func isMarshaler(t reflect.Type) bool {
+	return t.Implements(marshalerType) || reflect.PtrTo(t).Implements(marshalerType)
+}
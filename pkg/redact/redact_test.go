@@ -0,0 +1,181 @@
+package redact
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+type credential struct {
+	Name     string `json:"name"`
+	Password string `json:"password" redact:"true"`
+}
+
+func (c credential) String() string { return String(c) }
+
+type plain struct {
+	Name string `json:"name"`
+	Age  int    `json:"age"`
+}
+
+type wrapper struct {
+	credential
+	Items  []credential          `json:"items"`
+	ByName map[string]credential `json:"by_name"`
+	Ptr    *credential           `json:"ptr,omitempty"`
+	Hidden string                `json:"-" redact:"true"`
+}
+
+func TestHasSensitive(t *testing.T) {
+	tests := []struct {
+		name string
+		v    interface{}
+		want bool
+	}{
+		{"nil", nil, false},
+		{"plain struct", plain{}, false},
+		{"tagged field", credential{}, true},
+		{"pointer", &credential{}, true},
+		{"slice", []credential{}, true},
+		{"map value", map[string]*credential{}, true},
+		{"embedded", wrapper{}, true},
+		{"string", "password=x", false},
+	}
+	for _, tt := range tests {
+		if got := HasSensitive(tt.v); got != tt.want {
+			t.Errorf("HasSensitive(%s) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestString(t *testing.T) {
+	c := credential{Name: "alice", Password: "hunter2"}
+	tests := []struct {
+		name string
+		v    interface{}
+		want string
+	}{
+		{"struct", c, "{Name:alice Password:" + Mask + "}"},
+		{"pointer", &c, "&{Name:alice Password:" + Mask + "}"},
+		{"plain struct", plain{Name: "bob", Age: 3}, "{Name:bob Age:3}"},
+		{"nil pointer", (*credential)(nil), "<nil>"},
+	}
+	for _, tt := range tests {
+		if got := String(tt.v); got != tt.want {
+			t.Errorf("String(%s) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	// 实现了 String() 的类型用 %v、%+v 格式化时同样不会泄露
+	for _, format := range []string{"%v", "%+v", "%s"} {
+		if out := fmt.Sprintf(format, c); strings.Contains(out, "hunter2") {
+			t.Errorf("Sprintf(%s) = %q leaks the password", format, out)
+		}
+	}
+}
+
+func TestSanitize(t *testing.T) {
+	w := wrapper{
+		credential: credential{Name: "alice", Password: "p1"},
+		Items:      []credential{{Name: "bob", Password: "p2"}},
+		ByName:     map[string]credential{"carol": {Name: "carol", Password: "p3"}},
+		Hidden:     "p4",
+	}
+	out, err := json.Marshal(Sanitize(w))
+	if err != nil {
+		t.Fatalf("Marshal err: %v", err)
+	}
+	got := string(out)
+	for _, leaked := range []string{"p1", "p2", "p3", "p4"} {
+		if strings.Contains(got, `"`+leaked+`"`) {
+			t.Errorf("Sanitize output %s leaks %s", got, leaked)
+		}
+	}
+	for _, want := range []string{`"name":"alice"`, `"password":"` + Mask + `"`, `"name":"bob"`, `"carol":{`} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Sanitize output %s does not contain %s", got, want)
+		}
+	}
+	// omitempty 和 json:"-" 按 encoding/json 的规则处理
+	if strings.Contains(got, `"ptr"`) || strings.Contains(got, "Hidden") {
+		t.Errorf("Sanitize output %s contains omitted fields", got)
+	}
+
+	// 不包含敏感字段的值原样返回
+	p := plain{Name: "bob"}
+	if Sanitize(p) != p {
+		t.Errorf("Sanitize(plain) changed the value")
+	}
+}
+
+func TestIsSensitiveKey(t *testing.T) {
+	tests := []struct {
+		key  string
+		want bool
+	}{
+		{"password", true},
+		{"Pass_Word", true},
+		{"new_password", true},
+		{"refresh_token", true},
+		{"api-key", true},
+		{"client_secret", true},
+		{"user_name", false},
+		{"session_id", false},
+	}
+	for _, tt := range tests {
+		if got := IsSensitiveKey(tt.key); got != tt.want {
+			t.Errorf("IsSensitiveKey(%q) = %v, want %v", tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestMaskMessage(t *testing.T) {
+	tests := []struct {
+		msg, want string
+	}{
+		{"login user_name=alice|password=hunter2", "login user_name=alice|password=" + Mask},
+		{"pass_word: hunter2, next", "pass_word: " + Mask + ", next"},
+		{`{"refresh_token":"abc.def"}`, `{"refresh_token":"` + Mask + `"}`},
+		{"api_key=gk_123&x=1", "api_key=" + Mask + "&x=1"},
+		{"recovery_code=aaaa-bbbb", "recovery_code=" + Mask},
+		{"logout user_name=alice|session=Zm9vYmFy", "logout user_name=alice|session=" + Mask},
+		{`"session":"Zm9vYmFy"`, `"session":"` + Mask + `"`},
+		// 会话 ID 是 session 的哈希，keep_session 是开关，都可以记录
+		{"session_id=0a1b2c|keep_session=true", "session_id=0a1b2c|keep_session=true"},
+		{"user_name=alice", "user_name=alice"},
+	}
+	for _, tt := range tests {
+		if got := MaskMessage(tt.msg); got != tt.want {
+			t.Errorf("MaskMessage(%q) = %q, want %q", tt.msg, got, tt.want)
+		}
+	}
+}
+
+func TestHook(t *testing.T) {
+	entry := &logrus.Entry{
+		Message: "reset password=hunter2",
+		Data: logrus.Fields{
+			"token":  "abc",
+			"user":   credential{Name: "alice", Password: "hunter2"},
+			"status": 200,
+		},
+	}
+	if err := (Hook{}).Fire(entry); err != nil {
+		t.Fatalf("Fire err: %v", err)
+	}
+	if entry.Message != "reset password="+Mask {
+		t.Errorf("Message = %q", entry.Message)
+	}
+	if entry.Data["token"] != Mask {
+		t.Errorf("token field = %v", entry.Data["token"])
+	}
+	if s, _ := entry.Data["user"].(string); strings.Contains(s, "hunter2") || !strings.Contains(s, "alice") {
+		t.Errorf("user field = %v", entry.Data["user"])
+	}
+	if entry.Data["status"] != 200 {
+		t.Errorf("status field = %v", entry.Data["status"])
+	}
+}
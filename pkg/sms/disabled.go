@@ -0,0 +1,20 @@
+package sms
+
+import "errors"
+
+// ErrDisabled 没有配置短信发送方式
+var ErrDisabled = errors.New("sms sending is disabled")
+
+// DisabledSender 不发送短信，每次发送都返回 ErrDisabled，用于还没有接入短信服务商的生产环境
+// 手机号登录、绑定手机号这些需要短信的功能不可用，其他功能不受影响
+type DisabledSender struct{}
+
+// NewDisabledSender 创建一个不发送短信的发送器
+func NewDisabledSender() *DisabledSender {
+	return &DisabledSender{}
+}
+
+// Send 直接返回 ErrDisabled
+func (s *DisabledSender) Send(msg *Message) error {
+	return ErrDisabled
+}
@@ -0,0 +1,49 @@
+package sms
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"gouse/pkg/phone"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FileSender 不真正发送短信，而是追加写到本地文件里，一行一条，用于本地开发和测试
+// 文件里有明文验证码，只有当前用户可以读写；号码打码，只在日志和文件里留下后四位
+// 生产环境不能使用，release 模式下启动时会拒绝这个发送方式
+type FileSender struct {
+	mu   sync.Mutex
+	path string
+}
+
+// NewFileSender 创建一个写本地文件的短信发送器
+func NewFileSender(path string) *FileSender {
+	return &FileSender{path: path}
+}
+
+// Send 把短信追加写到文件末尾
+func (s *FileSender) Send(msg *Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+		return fmt.Errorf("create sms outbox dir err: %v", err)
+	}
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("open sms outbox err: %v", err)
+	}
+	defer f.Close()
+
+	// 短信内容里的换行换成空格，保证一条短信占一行
+	text := strings.ReplaceAll(msg.Text, "\n", " ")
+	to := phone.Mask(msg.To)
+	if _, err := fmt.Fprintf(f, "%s\t%s\t%s\n", time.Now().Format(time.RFC3339), to, text); err != nil {
+		return fmt.Errorf("write sms outbox err: %v", err)
+	}
+	log.Infof("FileSender|sms to %s written to %s", to, s.path)
+	return nil
+}
@@ -0,0 +1,22 @@
+package sms
+
+import (
+	log "github.com/sirupsen/logrus"
+	"gouse/pkg/phone"
+)
+
+// LogSender 不真正发送短信，只在日志里记一笔，用于本地开发时确认发过短信
+// 短信内容里是能直接登录的验证码，不会打到日志里，号码也打码；需要看到验证码时用 FileSender
+// 生产环境不能使用，release 模式下启动时会拒绝这个发送方式
+type LogSender struct{}
+
+// NewLogSender 创建一个打日志的短信发送器
+func NewLogSender() *LogSender {
+	return &LogSender{}
+}
+
+// Send 在日志里记录给哪个号码发了短信，不记录短信内容
+func (s *LogSender) Send(msg *Message) error {
+	log.Infof("LogSender|sms to %s, %d chars", phone.Mask(msg.To), len([]rune(msg.Text)))
+	return nil
+}
@@ -0,0 +1,13 @@
+package sms
+
+// Message 一条短信
+type Message struct {
+	To   string // 收信人手机号，E.164 格式
+	Text string // 短信内容
+}
+
+// SmsSender 发送短信的接口，业务代码只依赖这个接口
+// 接入短信服务商时实现这个接口即可，本地开发可以用只打日志或者写文件的实现
+type SmsSender interface {
+	Send(msg *Message) error
+}
@@ -0,0 +1,73 @@
+package sms
+
+import (
+	"bytes"
+	"errors"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFileSender(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "outbox", "sms.log")
+	s := NewFileSender(path)
+
+	msgs := []*Message{
+		{To: "+8613812345678", Text: "验证码 123456"},
+		{To: "+14155552671", Text: "code\n654321"},
+	}
+	for _, msg := range msgs {
+		if err := s.Send(msg); err != nil {
+			t.Fatalf("Send err: %v", err)
+		}
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Stat err: %v", err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o600 {
+		t.Errorf("mode = %v, want 0600", perm)
+	}
+
+	data, _ := os.ReadFile(path)
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if len(lines) != len(msgs) {
+		t.Fatalf("outbox has %d lines, want %d: %q", len(lines), len(msgs), data)
+	}
+	// 每行是 时间、打码后的号码、内容，内容里的换行换成了空格
+	for i, want := range []string{"+86138****5678\t验证码 123456", "+141****2671\tcode 654321"} {
+		fields := strings.SplitN(lines[i], "\t", 2)
+		if len(fields) != 2 || fields[1] != want {
+			t.Errorf("line %d = %q, want suffix %q", i, lines[i], want)
+		}
+	}
+}
+
+func TestLogSender(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	var s SmsSender = NewLogSender()
+	if err := s.Send(&Message{To: "+8613812345678", Text: "验证码：123456"}); err != nil {
+		t.Fatalf("Send err: %v", err)
+	}
+	// 日志里不能有验证码和完整的号码
+	out := buf.String()
+	if strings.Contains(out, "123456") || strings.Contains(out, "13812345678") {
+		t.Errorf("log leaks the message or the phone number: %q", out)
+	}
+	if !strings.Contains(out, "+86138****5678") {
+		t.Errorf("log = %q, want the masked phone number", out)
+	}
+}
+
+func TestDisabledSender(t *testing.T) {
+	var s SmsSender = NewDisabledSender()
+	if err := s.Send(&Message{To: "+8613812345678", Text: "hello"}); !errors.Is(err, ErrDisabled) {
+		t.Errorf("Send err = %v, want ErrDisabled", err)
+	}
+}
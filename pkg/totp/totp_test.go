@@ -0,0 +1,112 @@
+package totp
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// RFC 6238 附录 B 的测试密钥 "12345678901234567890" 的 base32 编码
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCodeAtRFC6238(t *testing.T) {
+	// RFC 6238 附录 B 里 SHA1 的 8 位结果取后 6 位
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		code, err := CodeAt(rfcSecret, Step(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatalf("CodeAt err: %v", err)
+		}
+		if code != tt.code {
+			t.Errorf("CodeAt(T=%d) = %s, want %s", tt.unix, code, tt.code)
+		}
+	}
+}
+
+func TestCodeAtNormalizesSecret(t *testing.T) {
+	want, _ := CodeAt(rfcSecret, 1)
+	got, err := CodeAt(" "+strings.ToLower(rfcSecret)+" ", 1)
+	if err != nil || got != want {
+		t.Errorf("CodeAt(lowercase) = %s, %v, want %s", got, err, want)
+	}
+	if _, err := CodeAt("not base32!", 1); err == nil {
+		t.Errorf("CodeAt(invalid secret) err = nil")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	step := Step(now)
+	prev, _ := CodeAt(rfcSecret, step-1)
+	next, _ := CodeAt(rfcSecret, step+1)
+	far, _ := CodeAt(rfcSecret, step+2)
+
+	tests := []struct {
+		name     string
+		code     string
+		skew     int
+		wantStep int64
+		ok       bool
+	}{
+		{"current step", "005924", 1, step, true},
+		{"surrounding spaces", " 005924 ", 1, step, true},
+		{"previous step within skew", prev, 1, step - 1, true},
+		{"next step within skew", next, 1, step + 1, true},
+		{"previous step without skew", prev, 0, 0, false},
+		{"outside skew", far, 1, 0, false},
+		{"wrong code", "000000", 1, 0, false},
+		{"wrong length", "05924", 1, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := Validate(rfcSecret, tt.code, now, tt.skew)
+			if ok != tt.ok || got != tt.wantStep {
+				t.Errorf("Validate = %d, %v, want %d, %v", got, ok, tt.wantStep, tt.ok)
+			}
+		})
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	a, err := GenerateSecret()
+	if err != nil {
+		t.Fatalf("GenerateSecret err: %v", err)
+	}
+	b, _ := GenerateSecret()
+	if a == b {
+		t.Errorf("GenerateSecret returned the same secret twice")
+	}
+	// 20 字节不带填充的 base32 是 32 个字符
+	if len(a) != 32 {
+		t.Errorf("len(secret) = %d, want 32", len(a))
+	}
+	if _, err := CodeAt(a, 0); err != nil {
+		t.Errorf("CodeAt(generated secret) err: %v", err)
+	}
+}
+
+func TestURI(t *testing.T) {
+	uri := URI("gouse", "alice", rfcSecret)
+	u, err := url.Parse(uri)
+	if err != nil {
+		t.Fatalf("parse %q err: %v", uri, err)
+	}
+	if u.Scheme != "otpauth" || u.Host != "totp" || u.Path != "/gouse:alice" {
+		t.Errorf("URI = %q", uri)
+	}
+	q := u.Query()
+	if q.Get("secret") != rfcSecret || q.Get("issuer") != "gouse" || q.Get("digits") != "6" || q.Get("period") != "30" {
+		t.Errorf("URI query = %v", q)
+	}
+}
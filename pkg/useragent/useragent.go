@@ -0,0 +1,55 @@
+package useragent
+
+import "strings"
+
+// 从 User-Agent 里粗略识别浏览器和操作系统，用于会话列表里展示"在哪台设备上登录"
+// 只求用户能认出自己的设备，不追求准确，识别不出来的原样归为 Unknown
+
+// 顺序有讲究：Edge 和 Opera 的 UA 里也带 Chrome，Chrome 的 UA 里也带 Safari，要先匹配前面的
+var browsers = []struct{ token, name string }{
+	{"Edg/", "Edge"},
+	{"OPR/", "Opera"},
+	{"Firefox/", "Firefox"},
+	{"Chrome/", "Chrome"},
+	{"CriOS/", "Chrome"},
+	{"Safari/", "Safari"},
+	{"curl/", "curl"},
+	{"Go-http-client/", "Go"},
+	{"python-requests/", "Python"},
+	{"okhttp/", "OkHttp"},
+}
+
+// iPhone、iPad 的 UA 里也带 Mac OS X，Android 的 UA 里也带 Linux
+var systems = []struct{ token, name string }{
+	{"iPhone", "iOS"},
+	{"iPad", "iPadOS"},
+	{"Android", "Android"},
+	{"Windows", "Windows"},
+	{"Mac OS X", "macOS"},
+	{"CrOS", "ChromeOS"},
+	{"Linux", "Linux"},
+}
+
+// Describe 返回 "Chrome on Windows" 这样的设备描述
+func Describe(ua string) string {
+	browser := match(ua, browsers)
+	system := match(ua, systems)
+	switch {
+	case browser != "" && system != "":
+		return browser + " on " + system
+	case browser != "":
+		return browser
+	case system != "":
+		return system
+	}
+	return "Unknown"
+}
+
+func match(ua string, rules []struct{ token, name string }) string {
+	for _, r := range rules {
+		if strings.Contains(ua, r.token) {
+			return r.name
+		}
+	}
+	return ""
+}
@@ -0,0 +1,41 @@
+-- 角色、权限以及它们和用户的关联表
+use camps_user;
+
+create table if not exists roles(
+   `id` int not null auto_increment,
+   `name` varchar(64) not null,
+   `description` varchar(255) not null default '',
+   `create_time` timestamp null default current_timestamp comment '创建时间',
+   `creator` varchar(100) not null default '',
+   `modify_time` timestamp null default current_timestamp on update current_timestamp comment '最后一次修改时间',
+   `modifier` varchar(100) not null default '',
+   primary key ( id ),
+   unique key `uk_name` ( name )
+);
+
+create table if not exists permissions(
+   `id` int not null auto_increment,
+   `code` varchar(128) not null,
+   `description` varchar(255) not null default '',
+   `create_time` timestamp null default current_timestamp comment '创建时间',
+   `creator` varchar(100) not null default '',
+   primary key ( id ),
+   unique key `uk_code` ( code )
+);
+
+create table if not exists role_permissions(
+   `role_id` int not null,
+   `permission_id` int not null,
+   `create_time` timestamp null default current_timestamp comment '创建时间',
+   `creator` varchar(100) not null default '',
+   primary key ( role_id, permission_id )
+);
+
+create table if not exists user_roles(
+   `user_id` int not null,
+   `role_id` int not null,
+   `create_time` timestamp null default current_timestamp comment '创建时间',
+   `creator` varchar(100) not null default '',
+   primary key ( user_id, role_id ),
+   key `idx_role_id` ( role_id )
+);
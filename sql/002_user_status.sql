@@ -0,0 +1,8 @@
+-- 账号状态和软删除
+use camps_user;
+
+alter table users
+   add column `status` tinyint not null default 0 comment '0 正常，1 已禁用',
+   add column `deleted_at` datetime null default null comment '软删除时间',
+   add key `idx_deleted_at` ( deleted_at ),
+   add key `idx_name` ( name );
@@ -0,0 +1,5 @@
+-- 用户邮箱，用于找回密码
+use camps_user;
+
+alter table users
+   add column `email` varchar(255) not null default '' comment '邮箱';
@@ -0,0 +1,26 @@
+-- TOTP 两步验证和恢复码
+use camps_user;
+
+create table if not exists user_totps(
+   `user_id` int not null,
+   `secret` varchar(64) not null,
+   `enabled` tinyint(1) not null default 0,
+   `last_used_step` bigint not null default 0,
+   `confirmed_at` datetime null default null,
+   `create_time` timestamp null default current_timestamp comment '创建时间',
+   `creator` varchar(100) not null default '',
+   `modify_time` timestamp null default current_timestamp on update current_timestamp comment '最后一次修改时间',
+   `modifier` varchar(100) not null default '',
+   primary key ( user_id )
+);
+
+create table if not exists recovery_codes(
+   `id` int not null auto_increment,
+   `user_id` int not null,
+   `code_hash` char(64) not null,
+   `used_at` datetime null default null,
+   `create_time` timestamp null default current_timestamp comment '创建时间',
+   `creator` varchar(100) not null default '',
+   primary key ( id ),
+   key `idx_user_id` ( user_id )
+);
@@ -0,0 +1,19 @@
+-- OAuth 2.0 接入应用
+use camps_user;
+
+create table if not exists oauth_clients(
+   `id` int not null auto_increment,
+   `client_id` varchar(64) not null,
+   `secret_hash` char(64) not null default '',
+   `name` varchar(100) not null default '',
+   `redirect_uris` text not null,
+   `grant_types` varchar(255) not null default '',
+   `scopes` varchar(255) not null default '',
+   `public` tinyint(1) not null default 0,
+   `create_time` timestamp null default current_timestamp comment '创建时间',
+   `creator` varchar(100) not null default '',
+   `modify_time` timestamp null default current_timestamp on update current_timestamp comment '最后一次修改时间',
+   `modifier` varchar(100) not null default '',
+   primary key ( id ),
+   unique key `uk_client_id` ( client_id )
+);
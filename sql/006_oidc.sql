@@ -0,0 +1,13 @@
+-- OpenID Connect 签发 ID token 的密钥
+use camps_user;
+
+create table if not exists oidc_keys(
+   `id` int not null auto_increment,
+   `kid` varchar(64) not null,
+   `private_key` text not null,
+   `retired_at` datetime null default null,
+   `create_time` timestamp null default current_timestamp comment '创建时间',
+   `creator` varchar(100) not null default '',
+   primary key ( id ),
+   unique key `uk_kid` ( kid )
+);
@@ -0,0 +1,20 @@
+-- 个人访问令牌（API key）
+use camps_user;
+
+create table if not exists api_keys(
+   `id` int not null auto_increment,
+   `user_id` int not null,
+   `name` varchar(64) not null default '',
+   `prefix` varchar(16) not null default '',
+   `key_hash` char(64) not null,
+   `scopes` varchar(255) not null default '',
+   `expires_at` datetime not null,
+   `last_used_at` datetime null default null,
+   `last_used_ip` varchar(64) not null default '',
+   `revoked_at` datetime null default null,
+   `create_time` timestamp null default current_timestamp comment '创建时间',
+   `creator` varchar(100) not null default '',
+   primary key ( id ),
+   unique key `uk_key_hash` ( key_hash ),
+   key `idx_user_id` ( user_id )
+);
@@ -0,0 +1,21 @@
+-- 登录会话，配置 session.store 为 sql 时使用
+use camps_user;
+
+create table if not exists sessions(
+   `id` char(32) not null comment '会话 ID，session 的哈希',
+   `user_name` varchar(100) not null default '',
+   `user_info` text not null,
+   `device` varchar(128) not null default '',
+   `user_agent` varchar(512) not null default '',
+   `ip` varchar(64) not null default '',
+   `last_ip` varchar(64) not null default '',
+   `remember` tinyint(1) not null default 0,
+   `idle_timeout` bigint not null default 0 comment '续期时长，单位秒',
+   `login_time` datetime not null,
+   `last_seen` datetime not null,
+   `max_expire_at` datetime not null comment '绝对过期时间',
+   `expire_at` datetime not null comment '当前的过期时间',
+   primary key ( id ),
+   key `idx_user_name` ( user_name ),
+   key `idx_expire_at` ( expire_at )
+);
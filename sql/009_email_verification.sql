@@ -0,0 +1,14 @@
+-- 邮箱验证：邮箱全局唯一，验证过的邮箱可以用来登录
+use camps_user;
+
+-- 没有填写邮箱的用户改成 NULL，唯一索引允许多个 NULL
+alter table users
+   modify column `email` varchar(255) null default null comment '邮箱';
+update users set email = null where email = '';
+update users set email = lower(trim(email)) where email is not null;
+
+-- 已有重复的邮箱时建唯一索引会失败，需要先人工处理：
+-- select email, count(*) from users where email is not null group by email having count(*) > 1;
+alter table users
+   add column `email_verified` tinyint(1) not null default 0 comment '邮箱是否已验证',
+   add unique key `uk_email` ( email );
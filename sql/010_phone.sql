@@ -0,0 +1,6 @@
+-- 手机号：E.164 格式，全局唯一，通过短信验证码绑定后可以用手机号加验证码登录
+use camps_user;
+
+alter table users
+   add column `phone` varchar(16) null default null comment '手机号，E.164 格式',
+   add unique key `uk_phone` ( phone );
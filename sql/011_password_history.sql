@@ -0,0 +1,12 @@
+-- 密码历史：修改密码、重置密码时不能和最近几次用过的密码相同
+use camps_user;
+
+create table if not exists password_histories(
+   `id` int not null auto_increment,
+   `user_id` int not null,
+   `password` varchar(255) not null comment '密码哈希',
+   `create_time` timestamp null default current_timestamp comment '创建时间',
+   `creator` varchar(100) not null default '',
+   primary key ( id ),
+   key `idx_user_id` ( user_id )
+);
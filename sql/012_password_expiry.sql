@@ -0,0 +1,10 @@
+-- 密码过期：管理员设置的临时密码、按角色配置的密码最长使用时间，都要求用户登录后先修改密码
+use camps_user;
+
+alter table users
+   add column `password_changed_at` timestamp null default null comment '最近一次设置密码的时间',
+   add column `must_change_password` tinyint(1) not null default 0 comment '下次登录后必须先修改密码',
+   add column `password_expires_at` timestamp null default null comment '临时密码的失效时间';
+
+-- 已有用户从上线这一刻开始计算密码的使用时间，避免上线当天所有人的密码同时过期
+update users set `password_changed_at` = current_timestamp where `password_changed_at` is null;
@@ -0,0 +1,9 @@
+-- 待验证的邮箱单独保存：email 列只保存验证过的邮箱，唯一索引 uk_email 也只约束验证过的邮箱，
+-- 注册时随便填一个别人的邮箱不会占住这个地址，邮箱的主人仍然可以注册、验证
+use camps_user;
+
+alter table users
+   add column `pending_email` varchar(255) null default null comment '注册时填写、还没有验证的邮箱' after `email_verified`;
+
+-- 已有的未验证邮箱挪到 pending_email，从唯一索引里释放出来
+update users set `pending_email` = `email`, `email` = null where `email` is not null and `email_verified` = 0;
@@ -6,6 +6,7 @@ import (
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
 	"gouse/config"
+	"gouse/pkg/redact"
 	"sync"
 	"time"
 )
@@ -23,7 +24,8 @@ func openDB() {
 	// 使用 fmt.Sprintf 格式化连接参数 connArgs，拼接用户名、密码、主机、端口和数据库名称等连接数据库所需的信息。
 	connArgs := fmt.Sprintf("%s:%s@(%s:%s)/%s?charset=utf8&parseTime=True&loc=Local", mysqlConf.User,
 		mysqlConf.Password, mysqlConf.Host, mysqlConf.Port, mysqlConf.Dbname)
-	log.Info("mdb addr:" + connArgs) // 打个日志，输出拼接后的信息
+	// 打个日志，输出拼接后的信息（密码用掩码代替）
+	log.Infof("mdb addr:%s:%s@(%s:%s)/%s", mysqlConf.User, redact.Mask, mysqlConf.Host, mysqlConf.Port, mysqlConf.Dbname)
 
 	var err error
 	// 调用 gorm.Open() 方法打开与 MySQL 数据库的连接，并将连接结果赋值给全局变量 db（gorm 库的使用）
@@ -48,3 +50,9 @@ func GetDB() *gorm.DB {
 	dbOnce.Do(openDB)
 	return db
 }
+
+// SetDB 替换全局的数据库连接，单元测试里连接测试库时使用
+func SetDB(conn *gorm.DB) {
+	dbOnce.Do(func() {})
+	db = conn
+}
@@ -0,0 +1,52 @@
+package utils
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"gouse/config"
+	"gouse/pkg/mailer"
+	"sync"
+)
+
+var (
+	mailSender mailer.Mailer // 全局的发件器
+	mailErr    error         // 按配置创建发件器时的错误
+	mailOnce   sync.Once
+)
+
+// 根据配置创建发件器
+func initMailer() {
+	mailConf := config.GetGlobalConf().MailConfig
+	switch mailConf.Driver {
+	case "smtp":
+		mailSender = mailer.NewSMTPMailer(mailer.SMTPOptions{
+			Host:     mailConf.SMTPHost,
+			Port:     mailConf.SMTPPort,
+			UserName: mailConf.SMTPUser,
+			Password: mailConf.SMTPPassword,
+			From:     mailConf.From,
+		})
+	case "outbox", "":
+		dir := mailConf.OutboxDir
+		if dir == "" {
+			dir = "./outbox"
+		}
+		mailSender = mailer.NewOutboxMailer(dir, mailConf.From)
+	default:
+		mailErr = fmt.Errorf("unknown mail driver %q", mailConf.Driver)
+		return
+	}
+	log.Infof("mailer driver=%s", mailConf.Driver)
+}
+
+// InitMailer 启动时按配置创建发件器，mail.driver 配置不对时返回错误，服务不启动
+func InitMailer() error {
+	mailOnce.Do(initMailer)
+	return mailErr
+}
+
+// GetMailer 获取发件器，启动时已经通过 InitMailer 校验过配置
+func GetMailer() mailer.Mailer {
+	mailOnce.Do(initMailer)
+	return mailSender
+}
@@ -0,0 +1,59 @@
+package utils
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"gouse/config"
+	"gouse/pkg/sms"
+	"sync"
+)
+
+var (
+	smsSender sms.SmsSender // 全局的短信发送器
+	smsErr    error         // 按配置创建短信发送器时的错误
+	smsOnce   sync.Once
+)
+
+// 根据配置创建短信发送器
+func initSmsSender() {
+	conf := config.GetGlobalConf()
+	smsSender, smsErr = newSmsSender(conf.SMS, conf.AppConfig.RunMode)
+	if smsErr == nil {
+		log.Infof("sms driver=%s", conf.SMS.Driver)
+	}
+}
+
+// 按 sms.driver 创建短信发送器
+// log 和 file 只用于本地开发，file 会把能直接登录的验证码写进文件，release 模式下拒绝启动
+func newSmsSender(smsConf config.SMSConf, runMode string) (sms.SmsSender, error) {
+	switch smsConf.Driver {
+	case "none":
+		return sms.NewDisabledSender(), nil
+	case "log", "", "file":
+		if runMode == "release" {
+			return nil, fmt.Errorf("sms driver %q is for development only and not allowed in release mode, use none or a real provider", smsConf.Driver)
+		}
+	default:
+		return nil, fmt.Errorf("unknown sms driver %q", smsConf.Driver)
+	}
+	if smsConf.Driver == "file" {
+		path := smsConf.OutboxFile
+		if path == "" {
+			path = "./outbox/sms.log"
+		}
+		return sms.NewFileSender(path), nil
+	}
+	return sms.NewLogSender(), nil
+}
+
+// InitSmsSender 启动时按配置创建短信发送器，sms.driver 配置不对时返回错误，服务不启动
+func InitSmsSender() error {
+	smsOnce.Do(initSmsSender)
+	return smsErr
+}
+
+// GetSmsSender 获取短信发送器，启动时已经通过 InitSmsSender 校验过配置
+func GetSmsSender() sms.SmsSender {
+	smsOnce.Do(initSmsSender)
+	return smsSender
+}
@@ -0,0 +1,44 @@
+package utils
+
+import (
+	"fmt"
+	"gouse/config"
+	"gouse/pkg/sms"
+	"testing"
+)
+
+func TestNewSmsSender(t *testing.T) {
+	cases := []struct {
+		driver  string
+		runMode string
+		want    interface{}
+		wantErr bool
+	}{
+		{driver: "", runMode: "dev", want: &sms.LogSender{}},
+		{driver: "log", runMode: "dev", want: &sms.LogSender{}},
+		{driver: "file", runMode: "dev", want: &sms.FileSender{}},
+		{driver: "none", runMode: "dev", want: &sms.DisabledSender{}},
+		{driver: "none", runMode: "release", want: &sms.DisabledSender{}},
+		// 开发用的发送方式在 release 模式下拒绝启动
+		{driver: "", runMode: "release", wantErr: true},
+		{driver: "log", runMode: "release", wantErr: true},
+		{driver: "file", runMode: "release", wantErr: true},
+		{driver: "aliyun", runMode: "dev", wantErr: true},
+	}
+	for _, tc := range cases {
+		sender, err := newSmsSender(config.SMSConf{Driver: tc.driver}, tc.runMode)
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("newSmsSender(%q, %q) = %T, want error", tc.driver, tc.runMode, sender)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("newSmsSender(%q, %q) err: %v", tc.driver, tc.runMode, err)
+			continue
+		}
+		if got, want := fmt.Sprintf("%T", sender), fmt.Sprintf("%T", tc.want); got != want {
+			t.Errorf("newSmsSender(%q, %q) = %s, want %s", tc.driver, tc.runMode, got, want)
+		}
+	}
+}
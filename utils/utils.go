@@ -2,10 +2,17 @@ package utils
 
 import (
 	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/hex"
-	"fmt"
+	"math/big"
+	"net/netip"
 )
 
+// session 随机数的字节数，32 字节即 256 位熵
+const sessionBytes = 32
+
 // 判断字符串 tg 是否能在字符串类型的切片 source 中找到
 func Contains(source []string, tg string) bool {
 	// 使用 range 关键字获取每个元素的索引和值，
@@ -38,8 +45,67 @@ func Md5String(s string) string {
 }
 
 // 生成一个新的 session 字符串
-func GenerateSession(userName string) string {
-	// 使用 fmt.Sprintf() 函数将用户名和字符串 "session" 拼接为一个新的字符串
-	// 将拼接得到的新字符串作为参数传递给 Md5String() 函数，通过哈希算法生成 MD5 值的字符串表示
-	return Md5String(fmt.Sprintf("%s:%s", userName, "session"))
+// 使用 crypto/rand 这个密码学安全的随机数生成器生成 32 字节随机数，再编码成 base64url 字符串，
+// 每次登录都会得到一个新的、无法根据用户名推算出来的 session
+func GenerateSession() (string, error) {
+	return RandomToken(sessionBytes)
+}
+
+// 生成 n 字节的密码学安全随机数，编码成 base64url 字符串，用于 session、重置密码链接等一次性凭证
+func RandomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// 生成 n 位密码学安全的随机数字，用于发给用户手动输入的一次性验证码
+func RandomDigits(n int) (string, error) {
+	b := make([]byte, n)
+	ten := big.NewInt(10)
+	for i := range b {
+		d, err := rand.Int(rand.Reader, ten)
+		if err != nil {
+			return "", err
+		}
+		b[i] = byte('0' + d.Int64())
+	}
+	return string(b), nil
+}
+
+// 计算字符串的 SHA-256 并以十六进制返回
+// 一次性凭证在 Redis 和数据库里只保存它的 SHA-256，即使存储泄露也拿不到可用的凭证
+func Sha256String(s string) string {
+	h := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(h[:])
+}
+
+// 判断 session 字符串格式是否合法
+// 以前用 md5(用户名:session) 生成的 32 位十六进制 session 长度对不上，会被直接拒绝
+func IsValidSession(session string) bool {
+	if len(session) != base64.RawURLEncoding.EncodedLen(sessionBytes) {
+		return false
+	}
+	_, err := base64.RawURLEncoding.DecodeString(session)
+	return err == nil
+}
+
+// IPPrefix 返回 IP 所在的网段，IPv4 取 /24，IPv6 取 /64，用于按网段限流：
+// 一个人手里的 IP 通常在同一个网段里，按单个 IP 统计很容易换着 IP 绕过。解析不了的原样返回
+func IPPrefix(ip string) string {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ip
+	}
+	addr = addr.Unmap()
+	bits := 64
+	if addr.Is4() {
+		bits = 24
+	}
+	prefix, err := addr.Prefix(bits)
+	if err != nil {
+		return ip
+	}
+	return prefix.String()
 }
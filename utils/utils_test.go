@@ -0,0 +1,31 @@
+package utils
+
+import "testing"
+
+func TestIPPrefix(t *testing.T) {
+	cases := []struct {
+		ip   string
+		want string
+	}{
+		{"192.168.1.23", "192.168.1.0/24"},
+		{"192.168.1.254", "192.168.1.0/24"},
+		{"10.0.0.1", "10.0.0.0/24"},
+		{"2001:db8:1:2:3:4:5:6", "2001:db8:1:2::/64"},
+		{"2001:db8:1:2::", "2001:db8:1:2::/64"},
+		{"::1", "::/64"},
+		// IPv4-mapped IPv6 地址和对应的 IPv4 地址算同一个网段
+		{"::ffff:192.168.1.23", "192.168.1.0/24"},
+		{"::ffff:c0a8:0117", "192.168.1.0/24"},
+		// 解析不了的原样返回
+		{"", ""},
+		{"not-an-ip", "not-an-ip"},
+		{"192.168.1.256", "192.168.1.256"},
+		{"192.168.1.23:8080", "192.168.1.23:8080"},
+		{"192.168.1.0/24", "192.168.1.0/24"},
+	}
+	for _, tc := range cases {
+		if got := IPPrefix(tc.ip); got != tc.want {
+			t.Errorf("IPPrefix(%q) = %q, want %q", tc.ip, got, tc.want)
+		}
+	}
+}
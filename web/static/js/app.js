@@ -1 +1,9 @@
-let urlPrefix = "http://localhost:8080"
\ No newline at end of file
+let urlPrefix = "http://localhost:8080"
+
+// 登录成功后跳转到 next；临时密码或者密码已经过期的，要先去修改密码
+function afterLogin(result, next) {
+    if (result.data && result.data.password_change_required) {
+        next = urlPrefix + "/static/change_password.html"
+    }
+    window.location.href = next
+}
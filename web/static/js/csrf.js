@@ -0,0 +1,14 @@
+// 防 CSRF：服务端把 token 放在 csrf_token 这个 cookie 里（开启了 cookie.host_prefix 时是 __Host-csrf_token），
+// 修改数据的请求（POST、DELETE 等）要把它放在 X-CSRF-Token 请求头里带回去，需要在 jQuery 之后引入
+function csrfToken() {
+    var match = document.cookie.match(/(?:^|;\s*)(?:__Host-)?csrf_token=([^;]*)/)
+    return match ? decodeURIComponent(match[1]) : ""
+}
+
+$.ajaxSetup({
+    beforeSend: function (xhr, settings) {
+        if (!/^(GET|HEAD|OPTIONS)$/i.test(settings.type)) {
+            xhr.setRequestHeader("X-CSRF-Token", csrfToken())
+        }
+    }
+})